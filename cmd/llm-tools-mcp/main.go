@@ -13,7 +13,9 @@ import (
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
 	"github.com/xhd2015/less-gen/flags"
+	"github.com/xhd2015/llm-tools/tools/ast_search"
 	"github.com/xhd2015/llm-tools/tools/batch_read_file"
+	"github.com/xhd2015/llm-tools/tools/codebase_search"
 	"github.com/xhd2015/llm-tools/tools/create_file"
 	"github.com/xhd2015/llm-tools/tools/create_file_with_content"
 	"github.com/xhd2015/llm-tools/tools/defs"
@@ -31,6 +33,8 @@ import (
 	"github.com/xhd2015/llm-tools/tools/send_answer"
 	"github.com/xhd2015/llm-tools/tools/todo_write"
 	"github.com/xhd2015/llm-tools/tools/tree"
+	"github.com/xhd2015/llm-tools/tools/walk"
+	"github.com/xhd2015/llm-tools/tools/watch"
 	"github.com/xhd2015/llm-tools/tools/web_search"
 	"github.com/xhd2015/llm-tools/tools/whats_next"
 )
@@ -44,11 +48,14 @@ Options:
   -h, --help                       show help
   -v,--verbose                     show verbose info
   --port PORT                      serve via HTTP/SSE on specified port (default: stdio)
+  --watch DIR                      keep the search indexes of DIR up to date while serving,
+                                   files written by the tools are indexed before they return
 
 Examples:
   llm-tools-mcp help               show help message
   llm-tools-mcp                    serve via stdio (default)
   llm-tools-mcp --port 8080        serve via HTTP/SSE on port 8080
+  llm-tools-mcp --watch .          serve via stdio, indexing the current directory
 
   # inspect mcp via stdio
   echo '{"jsonrpc": "2.0", "id": 2, "method": "tools/list"}' | llm-tools-mcp
@@ -88,6 +95,14 @@ var toolRegistry = map[string]ToolRegistry{
 		GetDefinition:   grep_search.GetToolDefinition,
 		ExecuteFromJSON: grep_search.ExecuteFromJSON,
 	},
+	"codebase_search": {
+		GetDefinition:   codebase_search.GetToolDefinition,
+		ExecuteFromJSON: codebase_search.ExecuteFromJSON,
+	},
+	"ast_search": {
+		GetDefinition:   ast_search.GetToolDefinition,
+		ExecuteFromJSON: ast_search.ExecuteFromJSON,
+	},
 	"list_dir": {
 		GetDefinition:   list_dir.GetToolDefinition,
 		ExecuteFromJSON: list_dir.ExecuteFromJSON,
@@ -109,8 +124,11 @@ var toolRegistry = map[string]ToolRegistry{
 		ExecuteFromJSON: edit_file.ExecuteFromJSON,
 	},
 	"search_replace": {
-		GetDefinition:   search_replace.GetToolDefinition,
-		ExecuteFromJSON: search_replace.ExecuteFromJSON,
+		GetDefinition: search_replace.GetToolDefinition,
+		ExecuteFromJSON: func(jsonInput string) (string, error) {
+			// relative paths resolve against the working directory, like the other tools
+			return search_replace.ExecuteFromJSON(jsonInput, "")
+		},
 	},
 	"send_answer": {
 		GetDefinition:   send_answer.GetToolDefinition,
@@ -140,6 +158,10 @@ var toolRegistry = map[string]ToolRegistry{
 		GetDefinition:   tree.GetToolDefinition,
 		ExecuteFromJSON: tree.ExecuteFromJSON,
 	},
+	"tree_diff": {
+		GetDefinition:   tree.GetDiffToolDefinition,
+		ExecuteFromJSON: tree.ExecuteDiffFromJSON,
+	},
 	"whats_next": {
 		GetDefinition:   whats_next.GetToolDefinition,
 		ExecuteFromJSON: whats_next.ExecuteFromJSON,
@@ -147,12 +169,32 @@ var toolRegistry = map[string]ToolRegistry{
 }
 
 func main() {
-	port, err := handle(os.Args[1:])
+	port, watchDir, err := handle(os.Args[1:])
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 
+	// the server lives long enough for directory listings to be worth
+	// reusing across file_search, tree and list_dir calls
+	walk.EnableCache(true)
+
+	if watchDir != "" {
+		// stdout carries the protocol in stdio mode, log to stderr
+		logger := log.New(os.Stderr, "watch: ", log.LstdFlags)
+		w, err := watch.New(watchDir, watch.Options{Logf: logger.Printf})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer w.Close()
+		go func() {
+			if err := w.Refresh(); err != nil {
+				logger.Printf("initial index failed: %v", err)
+			}
+		}()
+	}
+
 	// Create a new MCP server
 	s := server.NewMCPServer(
 		"LLM Tools MCP Server",
@@ -181,13 +223,15 @@ func main() {
 	}
 }
 
-func handle(args []string) (int, error) {
+func handle(args []string) (int, string, error) {
 	var port int
+	var watchDir string
 	args, err := flags.New().Help("-h,--help", help).
 		Int("--port", &port).
+		String("--watch", &watchDir).
 		Parse(args)
 	if err != nil {
-		return 0, err
+		return 0, "", err
 	}
 	if len(args) > 0 {
 		cmd := args[0]
@@ -195,13 +239,13 @@ func handle(args []string) (int, error) {
 		if cmd == "--help" || cmd == "help" {
 			fmt.Print(strings.TrimPrefix(help, "\n"))
 			os.Exit(0)
-			return 0, nil
+			return 0, "", nil
 		}
 		if len(args) > 0 {
-			return 0, fmt.Errorf("unrecognized extra arguments: %s", strings.Join(args, ","))
+			return 0, "", fmt.Errorf("unrecognized extra arguments: %s", strings.Join(args, ","))
 		}
 	}
-	return port, nil
+	return port, watchDir, nil
 }
 
 // serveHTTP starts an HTTP server with SSE support
@@ -5,7 +5,9 @@ import (
 	"os"
 	"strings"
 
+	"github.com/xhd2015/llm-tools/tools/ast_search"
 	"github.com/xhd2015/llm-tools/tools/batch_read_file"
+	"github.com/xhd2015/llm-tools/tools/codebase_search"
 	"github.com/xhd2015/llm-tools/tools/create_file"
 	"github.com/xhd2015/llm-tools/tools/create_file_with_content"
 	"github.com/xhd2015/llm-tools/tools/delete_file"
@@ -13,6 +15,7 @@ import (
 	"github.com/xhd2015/llm-tools/tools/file_search"
 	"github.com/xhd2015/llm-tools/tools/get_workspace_root"
 	"github.com/xhd2015/llm-tools/tools/grep_search"
+	"github.com/xhd2015/llm-tools/tools/grep_search/index_search"
 	"github.com/xhd2015/llm-tools/tools/list_dir"
 	"github.com/xhd2015/llm-tools/tools/mcp_client"
 	"github.com/xhd2015/llm-tools/tools/read_file"
@@ -22,6 +25,7 @@ import (
 	"github.com/xhd2015/llm-tools/tools/send_answer"
 	"github.com/xhd2015/llm-tools/tools/todo_write"
 	"github.com/xhd2015/llm-tools/tools/tree"
+	"github.com/xhd2015/llm-tools/tools/watch"
 	"github.com/xhd2015/llm-tools/tools/web_search"
 	"github.com/xhd2015/llm-tools/tools/whats_next"
 	"github.com/xhd2015/llm-tools/tools/write_file"
@@ -38,6 +42,10 @@ Available commands:
   read_file                        read the contents of a file
   tree                             display directory tree structure
   grep_search                      search for text patterns using regex
+  index                            build the trigram index used by grep_search
+  watch                            keep the search indexes up to date while files change
+  ast_search                       search code structurally with syntax tree patterns
+  codebase_search                  find the code chunks that best match a question
   list_dir                         list the contents of a directory
   run_terminal_cmd                 execute terminal commands
   create_file                      create a new empty file with optional directory creation
@@ -99,6 +107,14 @@ func Handle(args []string) error {
 		return tree.HandleCli(args)
 	case "grep_search":
 		return grep_search.HandleCli(args)
+	case "index":
+		return index_search.HandleCli(args)
+	case "watch":
+		return watch.HandleCli(args)
+	case "ast_search":
+		return ast_search.HandleCli(args)
+	case "codebase_search":
+		return codebase_search.HandleCli(args)
 	case "list_dir":
 		return list_dir.HandleCli(args)
 	case "run_terminal_cmd":
@@ -0,0 +1,465 @@
+package ast_search
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/xhd2015/llm-tools/jsonschema"
+	"github.com/xhd2015/llm-tools/tools/defs"
+	"github.com/xhd2015/llm-tools/tools/dirs"
+	"github.com/xhd2015/llm-tools/tools/glob"
+	"github.com/xhd2015/llm-tools/tools/grep_search/model"
+	"github.com/xhd2015/llm-tools/tools/tokenizer"
+	"github.com/xhd2015/llm-tools/tools/walk"
+)
+
+// MAX_CONTENT_LINES is the number of lines of a match returned in its content,
+// a longer match such as a whole function is cut and its end_line tells the full span
+const MAX_CONTENT_LINES = 20
+
+// AstSearchRequest represents the input parameters for the ast_search tool
+type AstSearchRequest struct {
+	WorkspaceRoot        string `json:"workspace_root"`
+	RelativePathToSearch string `json:"relative_path_to_search"`
+	// Pattern is code of the searched language with metavariables: $x matches
+	// any node, $*x any sequence of nodes, and $_ and $*_ do so without binding
+	Pattern string `json:"pattern"`
+	// Language selects the parser, go if empty
+	Language string `json:"language,omitempty"`
+	// NotContaining drops matches that contain a match of any of these patterns
+	NotContaining []string `json:"not_containing,omitempty"`
+	// Where maps metavariables to regexes their source text must match, a leading ! negates the regex
+	Where           map[string]string `json:"where,omitempty"`
+	IncludePatterns []string          `json:"include_patterns,omitempty"`
+	ExcludePatterns []string          `json:"exclude_patterns,omitempty"`
+	// Limit is the maximum number of matches returned, model.DEFAULT_LIMIT if not positive
+	Limit int `json:"limit,omitempty"`
+	// MaxTokens caps the tokens of the returned matches, 0 means no limit
+	MaxTokens   int    `json:"max_tokens,omitempty"`
+	Explanation string `json:"explanation"`
+}
+
+// AstSearchMatch is a grep_search match spanning a whole syntax node, with
+// the source text bound to each named metavariable
+type AstSearchMatch struct {
+	model.GrepSearchMatch
+	// EndColumn is the byte column just after the match on its last line
+	EndColumn int               `json:"end_column"`
+	Bindings  map[string]string `json:"bindings,omitempty"`
+}
+
+// AstSearchResponse represents the output of the ast_search tool
+type AstSearchResponse struct {
+	Matches      []AstSearchMatch `json:"matches"`
+	TotalMatches int              `json:"total_matches"`
+	TotalFiles   int              `json:"total_files"`
+	Truncated    bool             `json:"truncated"`
+	// ParseErrors lists the files skipped because they could not be parsed
+	ParseErrors []string `json:"parse_errors,omitempty"`
+	// Tokens is the token count of the returned matches rendered by FormatMatch
+	Tokens int `json:"tokens"`
+}
+
+// Query is what a Language compiles into a Matcher
+type Query struct {
+	Pattern       string
+	NotContaining []string
+	Where         Where
+}
+
+// Language parses the files of one programming language
+type Language interface {
+	Name() string
+	// Extensions are the file extensions of the language, with the leading dot
+	Extensions() []string
+	Compile(q Query) (Matcher, error)
+}
+
+// Matcher finds the matches of a compiled query in a source file. The File of
+// the returned matches is left empty. It must be safe for concurrent use.
+type Matcher interface {
+	Find(src []byte) ([]AstSearchMatch, error)
+}
+
+var (
+	languagesMutex sync.RWMutex
+	languages      = map[string]Language{
+		"go": GoLanguage{},
+	}
+)
+
+// Register adds a language, replacing any with the same name. It may be
+// called while searches run.
+func Register(lang Language) {
+	languagesMutex.Lock()
+	defer languagesMutex.Unlock()
+	languages[lang.Name()] = lang
+}
+
+// getLanguage returns the registered language with the given name
+func getLanguage(name string) (Language, bool) {
+	languagesMutex.RLock()
+	defer languagesMutex.RUnlock()
+	lang, ok := languages[name]
+	return lang, ok
+}
+
+// Languages returns the names of the supported languages, sorted
+func Languages() []string {
+	languagesMutex.RLock()
+	names := make([]string, 0, len(languages))
+	for name := range languages {
+		names = append(names, name)
+	}
+	languagesMutex.RUnlock()
+	sort.Strings(names)
+	return names
+}
+
+// Where constrains the source text bound to metavariables
+type Where map[string]whereRule
+
+type whereRule struct {
+	regex  *regexp.Regexp
+	negate bool
+}
+
+// ParseWhere compiles a metavariable to regex map, names may be given with or without the $
+func ParseWhere(where map[string]string) (Where, error) {
+	result := make(Where, len(where))
+	for name, expr := range where {
+		rule := whereRule{}
+		if strings.HasPrefix(expr, "!") {
+			rule.negate = true
+			expr = expr[1:]
+		}
+		regex, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid where regex for %s: %w", name, err)
+		}
+		rule.regex = regex
+		result[strings.TrimPrefix(name, "$")] = rule
+	}
+	return result, nil
+}
+
+// Allow reports whether the bindings satisfy the constraints of the metavariables they bind
+func (w Where) Allow(bindings map[string]string) bool {
+	for name, rule := range w {
+		text, ok := bindings[name]
+		if !ok {
+			continue
+		}
+		if rule.regex.MatchString(text) == rule.negate {
+			return false
+		}
+	}
+	return true
+}
+
+// GetToolDefinition returns the JSON schema definition for the ast_search tool
+func GetToolDefinition() defs.ToolDefinition {
+	return defs.ToolDefinition{
+		Name: "ast_search",
+		Description: `Structural code search: find code by its syntax tree instead of its text, like gogrep or semgrep.
+Use it for questions regex cannot answer, such as calls with a given argument or functions of a given shape.
+
+The pattern is code with metavariables:
+- $x matches any expression, statement, type or identifier and binds it; the same name used twice must match the same code
+- $*x matches any sequence, such as arguments, parameters or statements
+- $_ and $*_ match without binding
+
+A pattern may be an expression, a statement, a sequence of statements or a declaration.
+A function pattern without a receiver also matches methods, and a parameter or result without a name matches named ones too.
+
+### Examples (Go):
+| Goal                                            | pattern                                   | other parameters                                          |
+|-------------------------------------------------|-------------------------------------------|-----------------------------------------------------------|
+| calls to dirs.GetPath whose 4th argument is true| dirs.GetPath($_, $_, $_, true)            |                                                           |
+| functions returning (*X, error) that never fail | func $f($*_) (*$T, error) { $*_ }         | not_containing: ["return $*_, $err"], where: {"err": "!^nil$"} |
+| x == x comparisons                              | $x == $x                                  |                                                           |
+| errors checked but dropped                      | if err != nil { return nil }              |                                                           |`,
+		Parameters: &jsonschema.JsonSchema{
+			Type: jsonschema.ParamTypeObject,
+			Properties: map[string]*jsonschema.JsonSchema{
+				"workspace_root": {
+					Type:        jsonschema.ParamTypeString,
+					Description: "The root directory of the workspace",
+				},
+				"relative_path_to_search": {
+					Type:        jsonschema.ParamTypeString,
+					Description: "The relative path to the workspace root to search in",
+				},
+				"pattern": {
+					Type:        jsonschema.ParamTypeString,
+					Description: "The code pattern to search for, with $x, $*x, $_ and $*_ metavariables",
+				},
+				"language": {
+					Type:        jsonschema.ParamTypeString,
+					Description: "The language of the pattern and the searched files. Defaults to go.",
+				},
+				"not_containing": {
+					Type:        jsonschema.ParamTypeArray,
+					Description: "Patterns a match must not contain anywhere inside it.",
+					Items: &jsonschema.JsonSchema{
+						Type: jsonschema.ParamTypeString,
+					},
+				},
+				"where": {
+					Type:        jsonschema.ParamTypeObject,
+					Description: "Maps metavariable names to regexes that their source text must match, a leading ! negates the regex. Applies to the metavariables of pattern and not_containing.",
+				},
+				"include_patterns": {
+					Type:        jsonschema.ParamTypeArray,
+					Description: "Globs of files to search, e.g. 'pkg/**'",
+					Items: &jsonschema.JsonSchema{
+						Type: jsonschema.ParamTypeString,
+					},
+				},
+				"exclude_patterns": {
+					Type:        jsonschema.ParamTypeArray,
+					Description: "Globs of files to skip, e.g. '*_test.go'. A glob matching a directory excludes everything below it.",
+					Items: &jsonschema.JsonSchema{
+						Type: jsonschema.ParamTypeString,
+					},
+				},
+				"limit": {
+					Type:        jsonschema.ParamTypeNumber,
+					Description: "Maximum number of matches to return. Defaults to 50.",
+				},
+				"max_tokens": {
+					Type:        jsonschema.ParamTypeNumber,
+					Description: "Maximum number of tokens of the returned matches. Defaults to no limit.",
+				},
+				"explanation": {
+					Type:        jsonschema.ParamTypeString,
+					Description: "One sentence explanation as to why this tool is being used, and how it contributes to the goal.",
+				},
+			},
+			Required: []string{"pattern"},
+		},
+	}
+}
+
+// AstSearch executes the ast_search tool with the given parameters
+func AstSearch(req AstSearchRequest) (*AstSearchResponse, error) {
+	if req.Pattern == "" {
+		return nil, fmt.Errorf("pattern is required")
+	}
+	langName := req.Language
+	if langName == "" {
+		langName = "go"
+	}
+	lang, ok := getLanguage(langName)
+	if !ok {
+		return nil, fmt.Errorf("unsupported language: %s, supported: %s", langName, strings.Join(Languages(), ", "))
+	}
+	where, err := ParseWhere(req.Where)
+	if err != nil {
+		return nil, err
+	}
+	matcher, err := lang.Compile(Query{Pattern: req.Pattern, NotContaining: req.NotContaining, Where: where})
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := dirs.GetPath(req.WorkspaceRoot, req.RelativePathToSearch, "relative_path_to_search", true)
+	if err != nil {
+		return nil, err
+	}
+	include, err := glob.CompileAll(req.IncludePatterns)
+	if err != nil {
+		return nil, err
+	}
+	exclude, err := glob.CompileAll(req.ExcludePatterns)
+	if err != nil {
+		return nil, err
+	}
+	extensions := make(map[string]bool)
+	for _, ext := range lang.Extensions() {
+		extensions[ext] = true
+	}
+
+	var mutex sync.Mutex
+	var matches []AstSearchMatch
+	var parseErrors []string
+	err = walk.WalkParallel(dir, walk.Options{SkipHidden: true}, 0, func(path string, d fs.DirEntry) error {
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			relPath = path
+		}
+		slashPath := filepath.ToSlash(relPath)
+		if d.IsDir() {
+			if glob.MatchAny(exclude, slashPath) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !extensions[filepath.Ext(path)] || glob.MatchAny(exclude, slashPath) {
+			return nil
+		}
+		if len(include) > 0 && !glob.MatchAny(include, slashPath) {
+			return nil
+		}
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+		found, err := matcher.Find(src)
+		mutex.Lock()
+		defer mutex.Unlock()
+		if err != nil {
+			parseErrors = append(parseErrors, fmt.Sprintf("%s: %v", relPath, err))
+			return nil
+		}
+		for _, match := range found {
+			match.File = relPath
+			matches = append(matches, match)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk directory: %w", err)
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	sort.Strings(parseErrors)
+
+	response := &AstSearchResponse{
+		Matches:      []AstSearchMatch{},
+		TotalMatches: len(matches),
+		ParseErrors:  parseErrors,
+	}
+	files := make(map[string]bool)
+	for _, match := range matches {
+		files[match.File] = true
+	}
+	response.TotalFiles = len(files)
+
+	limit := req.Limit
+	if limit <= 0 {
+		limit = model.DEFAULT_LIMIT
+	}
+	if len(matches) > limit {
+		matches = matches[:limit]
+		response.Truncated = true
+	}
+	lines := make([]string, len(matches))
+	for i, match := range matches {
+		lines[i] = FormatMatch(match)
+	}
+	n := tokenizer.FitLines(lines, req.MaxTokens)
+	if n < len(matches) {
+		matches = matches[:n]
+		response.Truncated = true
+	}
+	response.Matches = append(response.Matches, matches...)
+	response.Tokens = tokenizer.CountLines(lines[:n])
+	return response, nil
+}
+
+// FormatMatch renders a match as file:line:column-end_line:end_column, its
+// bindings as name=text and then its content
+func FormatMatch(match AstSearchMatch) string {
+	var b strings.Builder
+	endLine := match.EndLine
+	if endLine == 0 {
+		endLine = match.Line
+	}
+	fmt.Fprintf(&b, "%s:%d:%d-%d:%d", match.File, match.Line, match.Column, endLine, match.EndColumn)
+	names := make([]string, 0, len(match.Bindings))
+	for name := range match.Bindings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, " $%s=%s", name, oneLine(match.Bindings[name]))
+	}
+	b.WriteString("\n")
+	b.WriteString(match.Content)
+	return b.String()
+}
+
+// oneLine collapses a multi-line binding for display
+func oneLine(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// newMatch builds the match of the source between byte offsets start and end
+func newMatch(src []byte, start int, end int) AstSearchMatch {
+	lineStart := bytes.LastIndexByte(src[:start], '\n') + 1
+	lineEnd := len(src)
+	if i := bytes.IndexByte(src[end:], '\n'); i >= 0 {
+		lineEnd = end + i
+	}
+	line := 1 + bytes.Count(src[:start], []byte("\n"))
+	endLine := line + bytes.Count(src[start:end], []byte("\n"))
+	endLineStart := bytes.LastIndexByte(src[:end], '\n') + 1
+
+	content := strings.ReplaceAll(string(src[lineStart:lineEnd]), "\r\n", "\n")
+	matchEnd := end - lineStart - bytes.Count(src[lineStart:end], []byte("\r\n"))
+	if lines := strings.SplitAfter(content, "\n"); len(lines) > MAX_CONTENT_LINES {
+		content = strings.TrimSuffix(strings.Join(lines[:MAX_CONTENT_LINES], ""), "\n")
+		matchEnd = len(content)
+		content += fmt.Sprintf("\n... (%d more lines)", len(lines)-MAX_CONTENT_LINES)
+	}
+
+	match := AstSearchMatch{
+		GrepSearchMatch: model.GrepSearchMatch{
+			Line:       line,
+			Column:     start - lineStart + 1,
+			Content:    content,
+			MatchStart: start - lineStart,
+			MatchEnd:   matchEnd,
+		},
+		EndColumn: end - endLineStart + 1,
+	}
+	if endLine > line {
+		match.EndLine = endLine
+	}
+	return match
+}
+
+func ParseJSONRequest(jsonInput string) (AstSearchRequest, error) {
+	var req AstSearchRequest
+	if err := json.Unmarshal([]byte(jsonInput), &req); err != nil {
+		return AstSearchRequest{}, fmt.Errorf("failed to parse JSON input: %w", err)
+	}
+	return req, nil
+}
+
+// ExecuteFromJSON executes the ast_search tool from JSON input
+func ExecuteFromJSON(jsonInput string) (string, error) {
+	req, err := ParseJSONRequest(jsonInput)
+	if err != nil {
+		return "", err
+	}
+
+	response, err := AstSearch(req)
+	if err != nil {
+		return "", err
+	}
+
+	jsonOutput, err := json.Marshal(response)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal response: %w", err)
+	}
+	return string(jsonOutput), nil
+}
@@ -0,0 +1,179 @@
+package ast_search
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testSource = `package demo
+
+import "example.com/dirs"
+
+type Config struct{}
+
+func Load(path string) (*Config, error) {
+	if path == "" {
+		return nil, errEmpty
+	}
+	return &Config{}, nil
+}
+
+func Default() (*Config, error) {
+	return &Config{}, nil
+}
+
+func (c *Config) Clone() (cfg *Config, err error) {
+	return c, nil
+}
+
+func paths(root string, x int) {
+	dirs.GetPath(root, "a", "name", true)
+	dirs.GetPath(root, "b", "name", false)
+	if x == x {
+		dirs.GetPath(root,
+			"c", "name", true)
+	}
+	a := 1
+	b := 2
+	_ = a + b
+}
+`
+
+type result struct {
+	Line      int
+	EndLine   int
+	Column    int
+	EndColumn int
+	Bindings  map[string]string
+}
+
+func search(t *testing.T, req AstSearchRequest) []result {
+	t.Helper()
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "demo.go"), []byte(testSource), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "broken.go"), []byte("package demo\nfunc {"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	req.WorkspaceRoot = root
+	response, err := AstSearch(req)
+	if err != nil {
+		t.Fatalf("AstSearch failed: %v", err)
+	}
+	if len(response.ParseErrors) != 1 {
+		t.Errorf("got parse errors %v, want broken.go", response.ParseErrors)
+	}
+	results := []result{}
+	for _, m := range response.Matches {
+		if m.File != "demo.go" {
+			t.Errorf("got file %s, want demo.go", m.File)
+		}
+		results = append(results, result{Line: m.Line, EndLine: m.EndLine, Column: m.Column, EndColumn: m.EndColumn, Bindings: m.Bindings})
+	}
+	return results
+}
+
+func TestAstSearch(t *testing.T) {
+	tests := []struct {
+		name string
+		req  AstSearchRequest
+		want []result
+	}{
+		{
+			name: "call with literal argument",
+			req:  AstSearchRequest{Pattern: "dirs.GetPath($_, $_, $_, true)"},
+			want: []result{
+				{Line: 23, Column: 2, EndColumn: 39},
+				{Line: 26, EndLine: 27, Column: 3, EndColumn: 22},
+			},
+		},
+		{
+			name: "repeated metavariable",
+			req:  AstSearchRequest{Pattern: "$x == $x"},
+			want: []result{{Line: 25, Column: 5, EndColumn: 11, Bindings: map[string]string{"x": "x"}}},
+		},
+		{
+			name: "functions and methods that never return an error",
+			req: AstSearchRequest{
+				Pattern:       "func $f($*_) (*$T, error) { $*_ }",
+				NotContaining: []string{"return $*_, $err"},
+				Where:         map[string]string{"err": "!^nil$"},
+			},
+			want: []result{
+				{Line: 14, EndLine: 16, Column: 1, EndColumn: 2, Bindings: map[string]string{"f": "Default", "T": "Config"}},
+				{Line: 18, EndLine: 20, Column: 1, EndColumn: 2, Bindings: map[string]string{"f": "Clone", "T": "Config"}},
+			},
+		},
+		{
+			name: "where on the pattern",
+			req:  AstSearchRequest{Pattern: "dirs.GetPath($_, $dir, $*_)", Where: map[string]string{"$dir": `^"[ab]"$`}},
+			want: []result{
+				{Line: 23, Column: 2, EndColumn: 39, Bindings: map[string]string{"dir": `"a"`}},
+				{Line: 24, Column: 2, EndColumn: 40, Bindings: map[string]string{"dir": `"b"`}},
+			},
+		},
+		{
+			name: "statement sequence",
+			req:  AstSearchRequest{Pattern: "$a := 1; $b := 2"},
+			want: []result{{Line: 29, EndLine: 30, Column: 2, EndColumn: 8, Bindings: map[string]string{"a": "a", "b": "b"}}},
+		},
+		{
+			name: "limit",
+			req:  AstSearchRequest{Pattern: "dirs.GetPath($*_)", Limit: 1},
+			want: []result{{Line: 23, Column: 2, EndColumn: 39}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := search(t, tt.req)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAstSearchErrors(t *testing.T) {
+	for _, req := range []AstSearchRequest{
+		{Pattern: ""},
+		{Pattern: "func {"},
+		{Pattern: "$x", Language: "cobol"},
+		{Pattern: "$x", Where: map[string]string{"x": "("}},
+	} {
+		if _, err := AstSearch(req); err == nil {
+			t.Errorf("AstSearch(%+v) succeeded, want error", req)
+		}
+	}
+}
+
+// aliasLanguage registers Go under another name
+type aliasLanguage struct {
+	GoLanguage
+	name string
+}
+
+func (l aliasLanguage) Name() string {
+	return l.name
+}
+
+func TestRegisterWhileSearching(t *testing.T) {
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			Register(aliasLanguage{name: fmt.Sprintf("go%d", i)})
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		AstSearch(AstSearchRequest{Pattern: "$x", Language: "cobol"})
+		Languages()
+	}
+	<-done
+	if _, err := AstSearch(AstSearchRequest{WorkspaceRoot: t.TempDir(), Pattern: "fmt.Println($x)", Language: "go99"}); err != nil {
+		t.Errorf("search with a registered language failed: %v", err)
+	}
+}
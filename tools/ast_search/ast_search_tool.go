@@ -0,0 +1,115 @@
+package ast_search
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/xhd2015/less-gen/flags"
+)
+
+const help = `
+llm-tools ast_search finds code by its syntax tree, like gogrep or semgrep
+
+Usage: llm-tools ast_search <pattern> [OPTIONS]
+
+Patterns are code with metavariables: $x matches any expression, statement,
+type or identifier and binds it, $*x matches any sequence of arguments,
+parameters or statements, $_ and $*_ match without binding. The same
+metavariable used twice must match the same code.
+
+Options:
+  --dir <dir>                  directory to search in (default: current directory)
+  --lang <language>            language of the pattern and files (default: go)
+  --not-containing <pattern>   drop matches containing this pattern (can be specified multiple times)
+  --where <name=regex>         require the code bound to $name to match regex, !regex negates (can be specified multiple times)
+  --include <pattern>          only search files matching glob (can be specified multiple times)
+  --exclude <pattern>          skip files matching glob (can be specified multiple times)
+  --limit <num>                maximum number of matches to return (default 50)
+  --max-tokens <num>           maximum tokens of returned matches (0 = no limit)
+  --explanation <text>         explanation for the operation
+
+Examples:
+  llm-tools ast_search 'dirs.GetPath($_, $_, $_, true)'
+  llm-tools ast_search '$x == $x'
+  llm-tools ast_search 'func $f($*_) (*$T, error) { $*_ }' --not-containing 'return $*_, $err' --where 'err=!^nil$'
+  llm-tools ast_search 'fmt.Errorf($msg, $*_)' --where 'msg=^"[A-Z]' --exclude '*_test.go'
+`
+
+func HandleCli(args []string) error {
+	var dir string
+	var lang string
+	var notContaining []string
+	var where []string
+	var includePatterns []string
+	var excludePatterns []string
+	var limit int
+	var maxTokens int
+	var explanation string
+
+	args, err := flags.String("--dir", &dir).
+		String("--lang", &lang).
+		StringSlice("--not-containing", &notContaining).
+		StringSlice("--where", &where).
+		StringSlice("--include", &includePatterns).
+		StringSlice("--exclude", &excludePatterns).
+		Int("--limit", &limit).
+		Int("--max-tokens", &maxTokens).
+		String("--explanation", &explanation).
+		Help("-h,--help", help).
+		Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		return fmt.Errorf("pattern is required")
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("unrecognized extra arguments: %v", strings.Join(args[1:], ","))
+	}
+
+	whereMap := make(map[string]string, len(where))
+	for _, w := range where {
+		name, regex, ok := strings.Cut(w, "=")
+		if !ok {
+			return fmt.Errorf("--where must be name=regex: %s", w)
+		}
+		whereMap[name] = regex
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	response, err := AstSearch(AstSearchRequest{
+		WorkspaceRoot:        cwd,
+		RelativePathToSearch: dir,
+		Pattern:              args[0],
+		Language:             lang,
+		NotContaining:        notContaining,
+		Where:                whereMap,
+		IncludePatterns:      includePatterns,
+		ExcludePatterns:      excludePatterns,
+		Limit:                limit,
+		MaxTokens:            maxTokens,
+		Explanation:          explanation,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, parseError := range response.ParseErrors {
+		fmt.Fprintf(os.Stderr, "skipped %s\n", parseError)
+	}
+	fmt.Printf("Total matches: %d in %d files\n", response.TotalMatches, response.TotalFiles)
+	for _, match := range response.Matches {
+		fmt.Println()
+		fmt.Println(FormatMatch(match))
+	}
+	if response.Truncated {
+		fmt.Printf("\n(showing %d of %d matches, raise --limit or narrow the pattern)\n", len(response.Matches), response.TotalMatches)
+	}
+	return nil
+}
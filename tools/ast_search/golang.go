@@ -0,0 +1,391 @@
+package ast_search
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// metavariables are rewritten to these identifier prefixes so patterns parse as Go
+const (
+	goMetavarPrefix     = "__ast_mv_"
+	goListMetavarPrefix = "__ast_mvs_"
+)
+
+var goMetavarRegex = regexp.MustCompile(`\$(\*?)([A-Za-z_][A-Za-z0-9_]*)`)
+
+var (
+	posType          = reflect.TypeOf(token.NoPos)
+	objectType       = reflect.TypeOf((*ast.Object)(nil))
+	scopeType        = reflect.TypeOf((*ast.Scope)(nil))
+	commentGroupType = reflect.TypeOf((*ast.CommentGroup)(nil))
+	commentsType     = reflect.TypeOf([]*ast.CommentGroup(nil))
+	fieldType        = reflect.TypeOf(ast.Field{})
+	funcDeclType     = reflect.TypeOf(ast.FuncDecl{})
+	funcTypeType     = reflect.TypeOf(ast.FuncType{})
+)
+
+// GoLanguage matches Go code with go/ast, like gogrep
+type GoLanguage struct{}
+
+func (GoLanguage) Name() string {
+	return "go"
+}
+
+func (GoLanguage) Extensions() []string {
+	return []string{".go"}
+}
+
+func (GoLanguage) Compile(q Query) (Matcher, error) {
+	pattern, err := parseGoPattern(q.Pattern)
+	if err != nil {
+		return nil, err
+	}
+	m := &goMatcher{pattern: pattern, where: q.Where}
+	for _, p := range q.NotContaining {
+		notContaining, err := parseGoPattern(p)
+		if err != nil {
+			return nil, fmt.Errorf("not_containing: %w", err)
+		}
+		m.notContaining = append(m.notContaining, notContaining)
+	}
+	return m, nil
+}
+
+// goPattern is a parsed pattern, either a single node or a sequence of statements
+type goPattern struct {
+	node  ast.Node
+	stmts []ast.Stmt
+}
+
+// parseGoPattern parses the pattern as an expression, a declaration or statements, in that order
+func parseGoPattern(pattern string) (*goPattern, error) {
+	src := goMetavarRegex.ReplaceAllStringFunc(pattern, func(s string) string {
+		sub := goMetavarRegex.FindStringSubmatch(s)
+		if sub[1] == "*" {
+			return goListMetavarPrefix + sub[2]
+		}
+		return goMetavarPrefix + sub[2]
+	})
+	if strings.TrimSpace(src) == "" {
+		return nil, fmt.Errorf("pattern is empty")
+	}
+	if expr, err := parser.ParseExpr(src); err == nil {
+		return &goPattern{node: expr}, nil
+	}
+	fset := token.NewFileSet()
+	if file, err := parser.ParseFile(fset, "", "package p\n"+src, parser.SkipObjectResolution); err == nil && len(file.Decls) == 1 {
+		return &goPattern{node: file.Decls[0]}, nil
+	}
+	file, err := parser.ParseFile(fset, "", "package p\nfunc _() {\n"+src+"\n}", parser.SkipObjectResolution)
+	if err != nil {
+		return nil, fmt.Errorf("pattern is not a Go expression, declaration or statement list: %s", pattern)
+	}
+	stmts := file.Decls[0].(*ast.FuncDecl).Body.List
+	if len(stmts) == 1 {
+		return &goPattern{node: stmts[0]}, nil
+	}
+	return &goPattern{stmts: stmts}, nil
+}
+
+type goMatcher struct {
+	pattern       *goPattern
+	notContaining []*goPattern
+	where         Where
+}
+
+func (m *goMatcher) Find(src []byte) ([]AstSearchMatch, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.SkipObjectResolution)
+	if err != nil {
+		return nil, err
+	}
+	tokenFile := fset.File(file.Pos())
+
+	var matches []AstSearchMatch
+	m.pattern.find(file, src, tokenFile, func(nodes []ast.Node, bound map[string]string) bool {
+		if !m.where.Allow(bound) || m.contains(nodes, src, tokenFile) {
+			return true
+		}
+		start := tokenFile.Offset(nodes[0].Pos())
+		end := tokenFile.Offset(nodes[len(nodes)-1].End())
+		match := newMatch(src, start, end)
+		if len(bound) > 0 {
+			match.Bindings = bound
+		}
+		matches = append(matches, match)
+		return true
+	})
+	return matches, nil
+}
+
+// contains reports whether a not_containing pattern matches within nodes
+func (m *goMatcher) contains(nodes []ast.Node, src []byte, tokenFile *token.File) bool {
+	found := false
+	for _, p := range m.notContaining {
+		for _, node := range nodes {
+			p.find(node, src, tokenFile, func(_ []ast.Node, bound map[string]string) bool {
+				found = m.where.Allow(bound)
+				return !found
+			})
+			if found {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// find calls fn with the nodes and bindings of every match under root, until fn returns false.
+// A statement sequence matches consecutive statements of a block, matches do not overlap.
+func (p *goPattern) find(root ast.Node, src []byte, tokenFile *token.File, fn func(nodes []ast.Node, bound map[string]string) bool) {
+	stop := false
+	ast.Inspect(root, func(n ast.Node) bool {
+		if stop || n == nil {
+			return false
+		}
+		if p.stmts == nil {
+			s := &goState{src: src, tokenFile: tokenFile}
+			if s.match(reflect.ValueOf(p.node), reflect.ValueOf(n)) {
+				stop = !fn([]ast.Node{n}, s.bound)
+			}
+			return !stop
+		}
+		list := blockStmts(n)
+		patterns := sliceValues(reflect.ValueOf(p.stmts))
+		for i := 0; i < len(list) && !stop; i++ {
+			for j := i + 1; j <= len(list); j++ {
+				s := &goState{src: src, tokenFile: tokenFile}
+				if !s.matchSeq(patterns, sliceValues(reflect.ValueOf(list[i:j]))) {
+					continue
+				}
+				nodes := make([]ast.Node, 0, j-i)
+				for _, stmt := range list[i:j] {
+					nodes = append(nodes, stmt)
+				}
+				stop = !fn(nodes, s.bound)
+				i = j - 1
+				break
+			}
+		}
+		return !stop
+	})
+}
+
+// blockStmts returns the statement list of a block or case clause
+func blockStmts(n ast.Node) []ast.Stmt {
+	switch n := n.(type) {
+	case *ast.BlockStmt:
+		return n.List
+	case *ast.CaseClause:
+		return n.Body
+	case *ast.CommClause:
+		return n.Body
+	}
+	return nil
+}
+
+// goState holds the bindings of one match attempt
+type goState struct {
+	src       []byte
+	tokenFile *token.File
+	bound     map[string]string
+}
+
+// match compares a pattern value with a syntax tree value, ignoring positions, comments and objects
+func (s *goState) match(p, n reflect.Value) bool {
+	p, n = elem(p), elem(n)
+	if p.Kind() == reflect.Slice {
+		return n.Kind() == reflect.Slice && s.matchSeq(sliceValues(p), sliceValues(n))
+	}
+	if isNil(p) {
+		return isNil(n)
+	}
+	if name, ok := goMetavar(p); ok {
+		node, ok := nodeOf(n)
+		return ok && s.bind(name, node.Pos(), node.End())
+	}
+	if isNil(n) || p.Type() != n.Type() {
+		return false
+	}
+	switch p.Kind() {
+	case reflect.Ptr:
+		return s.match(p.Elem(), n.Elem())
+	case reflect.Struct:
+		t := p.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			switch {
+			case f.Type == posType:
+				// positions never matter, except whether a call has a trailing ...
+				if f.Name == "Ellipsis" && p.Field(i).Interface().(token.Pos).IsValid() != n.Field(i).Interface().(token.Pos).IsValid() {
+					return false
+				}
+				continue
+			case f.Type == objectType || f.Type == scopeType || f.Type == commentGroupType || f.Type == commentsType:
+				continue
+			case t == fieldType && f.Name == "Names" && p.Field(i).Len() == 0:
+				// an unnamed parameter or result matches named ones too
+				continue
+			case t == funcDeclType && f.Name == "Recv" && p.Field(i).IsNil():
+				// a function pattern matches methods too
+				continue
+			case t == funcTypeType && f.Name == "TypeParams" && p.Field(i).IsNil():
+				// and generic functions
+				continue
+			}
+			if !s.match(p.Field(i), n.Field(i)) {
+				return false
+			}
+		}
+		return true
+	default:
+		return p.Interface() == n.Interface()
+	}
+}
+
+// matchSeq matches a list of pattern values, in which $*x matches any run of values
+func (s *goState) matchSeq(ps, ns []reflect.Value) bool {
+	if len(ps) == 0 {
+		return len(ns) == 0
+	}
+	if name, ok := goListMetavar(ps[0]); ok {
+		for i := 0; i <= len(ns); i++ {
+			saved := s.save()
+			if s.bindList(name, ns[:i]) && s.matchSeq(ps[1:], ns[i:]) {
+				return true
+			}
+			s.bound = saved
+		}
+		return false
+	}
+	if len(ns) == 0 {
+		return false
+	}
+	saved := s.save()
+	if s.match(ps[0], ns[0]) && s.matchSeq(ps[1:], ns[1:]) {
+		return true
+	}
+	s.bound = saved
+	return false
+}
+
+func (s *goState) save() map[string]string {
+	if s.bound == nil {
+		return nil
+	}
+	saved := make(map[string]string, len(s.bound))
+	for k, v := range s.bound {
+		saved[k] = v
+	}
+	return saved
+}
+
+// bind binds name to the source between pos and end, a name bound before must bind the same source
+func (s *goState) bind(name string, pos token.Pos, end token.Pos) bool {
+	if name == "_" {
+		return true
+	}
+	text := ""
+	if pos.IsValid() && end.IsValid() {
+		text = string(s.src[s.tokenFile.Offset(pos):s.tokenFile.Offset(end)])
+	}
+	if prev, ok := s.bound[name]; ok {
+		return prev == text
+	}
+	if s.bound == nil {
+		s.bound = make(map[string]string)
+	}
+	s.bound[name] = text
+	return true
+}
+
+func (s *goState) bindList(name string, ns []reflect.Value) bool {
+	if len(ns) == 0 {
+		return s.bind(name, token.NoPos, token.NoPos)
+	}
+	first, ok := nodeOf(elem(ns[0]))
+	if !ok {
+		return false
+	}
+	last, ok := nodeOf(elem(ns[len(ns)-1]))
+	if !ok {
+		return false
+	}
+	return s.bind(name, first.Pos(), last.End())
+}
+
+// goMetavar returns the name of the metavariable that a pattern value is: an
+// identifier, an expression statement of one, or an unnamed field typed by one
+func goMetavar(p reflect.Value) (string, bool) {
+	return metavarIdent(p, goMetavarPrefix, goListMetavarPrefix)
+}
+
+// goListMetavar is goMetavar for $*x
+func goListMetavar(p reflect.Value) (string, bool) {
+	return metavarIdent(elem(p), goListMetavarPrefix)
+}
+
+func metavarIdent(p reflect.Value, prefixes ...string) (string, bool) {
+	if isNil(p) || !p.CanInterface() {
+		return "", false
+	}
+	var ident *ast.Ident
+	switch node := p.Interface().(type) {
+	case *ast.Ident:
+		ident = node
+	case *ast.ExprStmt:
+		ident, _ = node.X.(*ast.Ident)
+	case *ast.Field:
+		if len(node.Names) == 0 && node.Tag == nil {
+			ident, _ = node.Type.(*ast.Ident)
+		}
+	}
+	if ident == nil {
+		return "", false
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(ident.Name, prefix) {
+			return strings.TrimPrefix(ident.Name, prefix), true
+		}
+	}
+	return "", false
+}
+
+func nodeOf(n reflect.Value) (ast.Node, bool) {
+	if isNil(n) || !n.CanInterface() {
+		return nil, false
+	}
+	node, ok := n.Interface().(ast.Node)
+	return node, ok
+}
+
+func elem(v reflect.Value) reflect.Value {
+	if v.Kind() == reflect.Interface && !v.IsNil() {
+		return v.Elem()
+	}
+	return v
+}
+
+func isNil(v reflect.Value) bool {
+	if !v.IsValid() {
+		return true
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+		return v.IsNil()
+	}
+	return false
+}
+
+func sliceValues(v reflect.Value) []reflect.Value {
+	values := make([]reflect.Value, v.Len())
+	for i := range values {
+		values[i] = v.Index(i)
+	}
+	return values
+}
@@ -7,11 +7,16 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/xhd2015/llm-tools/jsonschema"
 	"github.com/xhd2015/llm-tools/tools/defs"
+	"github.com/xhd2015/llm-tools/tools/tokenizer"
 )
 
+// DEFAULT_CONCURRENCY is the default number of files read in parallel
+const DEFAULT_CONCURRENCY = 8
+
 // FileReadRequest represents a single file read request within the batch
 type FileReadRequest struct {
 	TargetFile                 string `json:"target_file"`
@@ -19,6 +24,9 @@ type FileReadRequest struct {
 	StartLineOneIndexed        int    `json:"start_line_one_indexed"`
 	EndLineOneIndexedInclusive int    `json:"end_line_one_indexed_inclusive"`
 	MaxLines                   int    `json:"max_lines,omitempty"` // Optional per-file max lines limit
+	// Include and Exclude filter the files expanded from a glob or directory target
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
 }
 
 // FileReadResponse represents the response for a single file read
@@ -29,6 +37,22 @@ type FileReadResponse struct {
 	LinesShown string `json:"lines_shown"`
 	Outline    string `json:"outline,omitempty"`
 	Error      string `json:"error,omitempty"`
+	// Tokens is the token count of Contents
+	Tokens int `json:"tokens,omitempty"`
+	// ExpandedFrom is the glob or directory target this file was expanded from
+	ExpandedFrom string `json:"expanded_from,omitempty"`
+	// Truncated is set when the batch budget cut this file short
+	Truncated bool `json:"truncated,omitempty"`
+	// NextStartLine is the line to pass as start_line_one_indexed to read the rest of a truncated file
+	NextStartLine int `json:"next_start_line,omitempty"`
+
+	// requestedStart and requestedEnd are the range asked for, zero when
+	// the entire file was requested
+	requestedStart int
+	requestedEnd   int
+	// lines are the selected lines, startLine is the one-indexed line of lines[0]
+	lines     []string
+	startLine int
 }
 
 // BatchReadFileRequest represents the input parameters for the batch_read_file tool
@@ -39,7 +63,12 @@ type BatchReadFileRequest struct {
 	GlobalMinLines  int               `json:"global_min_lines,omitempty"` // Global min lines per file (default: 200)
 	ContinueOnError bool              `json:"continue_on_error"`          // Whether to continue processing other files if one fails
 	IncludeOutline  bool              `json:"include_outline"`            // Whether to include outline in responses
-	Explanation     string            `json:"explanation"`
+	MaxTotalLines   int               `json:"max_total_lines,omitempty"`  // Budget of content lines across all files (default: unlimited)
+	MaxTotalTokens  int               `json:"max_total_tokens,omitempty"` // Budget of tokens across all files (default: unlimited)
+	Concurrency     int               `json:"concurrency,omitempty"`      // Number of files read in parallel (default: 8)
+	// MaxExpandedFiles caps how many files a single glob or directory entry expands to (default: 50)
+	MaxExpandedFiles int    `json:"max_expanded_files,omitempty"`
+	Explanation      string `json:"explanation"`
 }
 
 // BatchReadFileResponse represents the output of the batch_read_file tool
@@ -48,6 +77,14 @@ type BatchReadFileResponse struct {
 	TotalFiles   int                `json:"total_files"`
 	SuccessCount int                `json:"success_count"`
 	ErrorCount   int                `json:"error_count"`
+	// TotalTokens is the sum of the tokens of all returned contents
+	TotalTokens int `json:"total_tokens"`
+	// TruncatedFiles lists the files cut short or skipped because the budget ran out
+	TruncatedFiles []string `json:"truncated_files,omitempty"`
+	// Hint explains how to fetch the content that did not fit in the budget
+	Hint string `json:"hint,omitempty"`
+	// Warnings reports globs or directories that matched more files than allowed
+	Warnings []string `json:"warnings,omitempty"`
 }
 
 // GetToolDefinition returns the JSON schema definition for the batch_read_file tool
@@ -63,6 +100,9 @@ Key features:
 - Error handling with continue-on-error option
 - Optional outline generation
 - Structured response with success/error counts
+- Files are read in parallel
+- target_file may also be a directory or a glob pattern (supports **, e.g. "tools/**/*_test.go"). It expands to the matching text files, sorted by path, honoring .gitignore and the optional include/exclude globs, capped by max_expanded_files
+- Optional global budget (max_total_lines / max_total_tokens) shared by all files in request order. Files that do not fit are truncated with a marker and listed in truncated_files, each with a next_start_line to continue from
 
 This tool is particularly useful when you need to read multiple related files (e.g., examining imports, comparing implementations, or gathering context from multiple source files).`,
 		Name: "batch_read_file",
@@ -81,7 +121,7 @@ This tool is particularly useful when you need to read multiple related files (e
 						Properties: map[string]*jsonschema.JsonSchema{
 							"target_file": {
 								Type:        jsonschema.ParamTypeString,
-								Description: "The path of the file to read. You can use either a relative path in the workspace or an absolute path.",
+								Description: "The path of the file to read. You can use either a relative path in the workspace or an absolute path. May also be a directory or a glob pattern such as 'cmd/**/*.go', which expands to every matching file.",
 							},
 							"should_read_entire_file": {
 								Type:        jsonschema.ParamTypeBoolean,
@@ -99,6 +139,20 @@ This tool is particularly useful when you need to read multiple related files (e
 								Type:        jsonschema.ParamTypeNumber,
 								Description: "Optional per-file maximum lines limit. Overrides global_max_lines for this file.",
 							},
+							"include": {
+								Type:        jsonschema.ParamTypeArray,
+								Description: "Glob patterns a file expanded from a directory or glob target must match, e.g. ['*.go'].",
+								Items: &jsonschema.JsonSchema{
+									Type: jsonschema.ParamTypeString,
+								},
+							},
+							"exclude": {
+								Type:        jsonschema.ParamTypeArray,
+								Description: "Glob patterns excluding files expanded from a directory or glob target, e.g. ['*_test.go'].",
+								Items: &jsonschema.JsonSchema{
+									Type: jsonschema.ParamTypeString,
+								},
+							},
 						},
 						Required: []string{"target_file"},
 					},
@@ -111,6 +165,22 @@ This tool is particularly useful when you need to read multiple related files (e
 					Type:        jsonschema.ParamTypeNumber,
 					Description: "Global minimum lines per file for partial reads (default: 200). Applied when expanding ranges.",
 				},
+				"max_total_lines": {
+					Type:        jsonschema.ParamTypeNumber,
+					Description: "Optional budget of content lines across all files. Earlier files in the list are served first; the rest are truncated once the budget runs out.",
+				},
+				"max_total_tokens": {
+					Type:        jsonschema.ParamTypeNumber,
+					Description: "Optional budget of tokens across all files. Earlier files in the list are served first; the rest are truncated once the budget runs out.",
+				},
+				"concurrency": {
+					Type:        jsonschema.ParamTypeNumber,
+					Description: "Number of files read in parallel (default: 8).",
+				},
+				"max_expanded_files": {
+					Type:        jsonschema.ParamTypeNumber,
+					Description: "Maximum number of files a single directory or glob entry may expand to (default: 50).",
+				},
 				"continue_on_error": {
 					Type:        jsonschema.ParamTypeBoolean,
 					Description: "Whether to continue processing other files if one fails (default: true).",
@@ -138,23 +208,30 @@ func BatchReadFile(req BatchReadFileRequest) (*BatchReadFileResponse, error) {
 	if req.GlobalMinLines == 0 {
 		req.GlobalMinLines = 200
 	}
+	if req.Concurrency <= 0 {
+		req.Concurrency = DEFAULT_CONCURRENCY
+	}
+	if req.MaxExpandedFiles <= 0 {
+		req.MaxExpandedFiles = DEFAULT_MAX_EXPANDED_FILES
+	}
 
 	// Validate input
 	if len(req.Files) == 0 {
 		return nil, fmt.Errorf("at least one file must be specified")
 	}
 
-	var responses []FileReadResponse
+	planned, warnings := expandFileRequests(req.WorkspaceRoot, req.Files, req.MaxExpandedFiles)
+	responses := readFilesConcurrently(req, planned)
+
 	successCount := 0
 	errorCount := 0
-
-	for _, fileReq := range req.Files {
-		response := processFileRequest(req.WorkspaceRoot, fileReq, req.GlobalMaxLines, req.GlobalMinLines, req.IncludeOutline)
-		responses = append(responses, response)
-
+	for i, response := range responses {
 		if response.Error != "" {
 			errorCount++
 			if !req.ContinueOnError {
+				// files are read in parallel, drop everything after the first failure
+				// so the result looks the same as a sequential read
+				responses = responses[:i+1]
 				break
 			}
 		} else {
@@ -162,14 +239,189 @@ func BatchReadFile(req BatchReadFileRequest) (*BatchReadFileResponse, error) {
 		}
 	}
 
+	truncatedFiles := applyBudget(responses, req.MaxTotalLines, req.MaxTotalTokens)
+	totalTokens := 0
+	for i := range responses {
+		responses[i].Contents = joinContents(responses[i])
+		responses[i].Tokens = tokenizer.Count(responses[i].Contents)
+		totalTokens += responses[i].Tokens
+	}
+
+	var hint string
+	if len(truncatedFiles) > 0 {
+		hint = "The batch budget was exhausted. To read the rest, call again for the truncated files with start_line_one_indexed set to their next_start_line."
+	}
+
 	return &BatchReadFileResponse{
-		Files:        responses,
-		TotalFiles:   len(req.Files),
-		SuccessCount: successCount,
-		ErrorCount:   errorCount,
+		Files:          responses,
+		TotalFiles:     len(planned),
+		SuccessCount:   successCount,
+		ErrorCount:     errorCount,
+		TotalTokens:    totalTokens,
+		TruncatedFiles: truncatedFiles,
+		Hint:           hint,
+		Warnings:       warnings,
 	}, nil
 }
 
+// readFilesConcurrently reads all planned files with a bounded worker pool,
+// returning responses in request order
+func readFilesConcurrently(req BatchReadFileRequest, planned []plannedRead) []FileReadResponse {
+	responses := make([]FileReadResponse, len(planned))
+
+	workers := req.Concurrency
+	if workers > len(planned) {
+		workers = len(planned)
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				p := planned[i]
+				var response FileReadResponse
+				if p.err != "" {
+					response = FileReadResponse{TargetFile: p.req.TargetFile, Error: p.err}
+				} else {
+					response = processFileRequest(req.WorkspaceRoot, p.req, req.GlobalMaxLines, req.GlobalMinLines, req.IncludeOutline)
+				}
+				response.ExpandedFrom = p.expandedFrom
+				responses[i] = response
+			}
+		}()
+	}
+	for i := range planned {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return responses
+}
+
+// applyBudget walks the responses in request order and cuts their lines once the
+// global line or token budget is used up. A zero limit means unlimited. The
+// token budget covers the rendered contents, header and truncation marker
+// included. It returns the files that were cut.
+func applyBudget(responses []FileReadResponse, maxLines int, maxTokens int) []string {
+	if maxLines <= 0 && maxTokens <= 0 {
+		return nil
+	}
+	remainingLines := maxLines
+	remainingTokens := maxTokens
+
+	var truncatedFiles []string
+	for i := range responses {
+		response := &responses[i]
+		if response.Error != "" || len(response.lines) == 0 {
+			continue
+		}
+
+		keep := len(response.lines)
+		if maxLines > 0 && keep > remainingLines {
+			keep = remainingLines
+		}
+		if maxTokens > 0 {
+			// reserve the header, and the marker when lines will be cut
+			reserved := countLine(response.header())
+			if keep < len(response.lines) || reserved+tokenizer.CountLines(response.lines[:keep]) > remainingTokens {
+				reserved += countLine(response.marker(response.startLine + keep))
+			}
+			if remainingTokens-reserved <= 0 {
+				keep = 0
+			} else {
+				keep = tokenizer.FitLines(response.lines[:keep], remainingTokens-reserved)
+			}
+		}
+		cut := cutLines(*response, keep)
+		if maxTokens > 0 {
+			// the line numbers in the header and marker change with keep,
+			// drop lines until the rendered contents fit
+			for keep > 0 && tokenizer.Count(joinContents(cut)) > remainingTokens {
+				keep--
+				cut = cutLines(*response, keep)
+			}
+			// +1 for the newline separating files
+			remainingTokens -= tokenizer.Count(joinContents(cut)) + 1
+		}
+		if maxLines > 0 {
+			remainingLines -= keep
+		}
+		if cut.Truncated {
+			truncatedFiles = append(truncatedFiles, response.TargetFile)
+		}
+		*response = cut
+	}
+	return truncatedFiles
+}
+
+// cutLines returns response keeping only its first keep lines
+func cutLines(response FileReadResponse, keep int) FileReadResponse {
+	if keep >= len(response.lines) {
+		return response
+	}
+	response.Truncated = true
+	response.NextStartLine = response.startLine + keep
+	response.lines = response.lines[:keep]
+	if keep == 0 {
+		response.LinesShown = "none (batch budget exhausted)"
+	} else {
+		response.LinesShown = fmt.Sprintf("%d-%d (truncated by batch budget)", response.startLine, response.NextStartLine-1)
+	}
+	return response
+}
+
+// countLine returns the tokens of a line joined to others, 0 for an empty line
+func countLine(line string) int {
+	if line == "" {
+		return 0
+	}
+	return tokenizer.Count(line) + 1
+}
+
+// header is the context line prepended to the contents of a range read when
+// the returned lines are not the whole file, built from the lines left after
+// the budget
+func (response *FileReadResponse) header() string {
+	if response.requestedStart == 0 || len(response.lines) == 0 {
+		return ""
+	}
+	endLine := response.startLine + len(response.lines) - 1
+	if response.startLine == 1 && endLine == response.TotalLines {
+		return ""
+	}
+	return fmt.Sprintf("Requested to read lines %d-%d, but returning lines %d-%d to give more context.",
+		response.requestedStart, response.requestedEnd, response.startLine, endLine)
+}
+
+// marker is appended to the contents of a file the budget cut before nextStartLine
+func (response *FileReadResponse) marker(nextStartLine int) string {
+	return fmt.Sprintf("... [truncated by batch budget, continue from line %d of %d]", nextStartLine, response.TotalLines)
+}
+
+// joinContents renders the final contents of a response, adding a marker when
+// the budget truncated it
+func joinContents(response FileReadResponse) string {
+	if response.Error != "" {
+		return ""
+	}
+	var parts []string
+	if header := response.header(); header != "" {
+		parts = append(parts, header)
+	}
+	if len(response.lines) > 0 {
+		parts = append(parts, strings.Join(response.lines, "\n"))
+	}
+	// a file the budget left empty is reported by lines_shown alone
+	if response.Truncated && len(response.lines) > 0 {
+		parts = append(parts, response.marker(response.NextStartLine))
+	}
+	return strings.Join(parts, "\n")
+}
+
 // processFileRequest processes a single file read request
 func processFileRequest(workspaceRoot string, fileReq FileReadRequest, globalMaxLines, globalMinLines int, includeOutline bool) FileReadResponse {
 	response := FileReadResponse{
@@ -244,17 +496,19 @@ func processFileRequest(workspaceRoot string, fileReq FileReadRequest, globalMax
 	}
 
 	// Handle different reading modes
-	var contents string
+	var selectedLines []string
+	var startLine int
 	var linesShown string
 	var outline string
 
 	if fileReq.ShouldReadEntireFile {
 		// Read entire file, but respect max lines limit
+		startLine = 1
 		if totalLines > maxLines {
-			contents = strings.Join(lines[:maxLines], "\n")
+			selectedLines = lines[:maxLines]
 			linesShown = fmt.Sprintf("1-%d (truncated from %d total lines due to max_lines limit)", maxLines, totalLines)
 		} else {
-			contents = strings.Join(lines, "\n")
+			selectedLines = lines
 			linesShown = fmt.Sprintf("1-%d (entire file)", totalLines)
 		}
 		if includeOutline {
@@ -266,7 +520,7 @@ func processFileRequest(workspaceRoot string, fileReq FileReadRequest, globalMax
 		}
 	} else {
 		// Read specific range
-		startLine := fileReq.StartLineOneIndexed
+		startLine = fileReq.StartLineOneIndexed
 		endLine := fileReq.EndLineOneIndexedInclusive
 
 		// Validate line numbers
@@ -339,29 +593,25 @@ func processFileRequest(workspaceRoot string, fileReq FileReadRequest, globalMax
 			endIdx = totalLines - 1
 		}
 
-		selectedLines := lines[startIdx : endIdx+1]
-		contents = strings.Join(selectedLines, "\n")
+		selectedLines = lines[startIdx : endIdx+1]
 
 		// Generate lines shown description
 		if startLine == 1 && endLine == totalLines {
 			linesShown = fmt.Sprintf("1-%d (entire file)", totalLines)
 		} else {
 			linesShown = fmt.Sprintf("%d-%d", startLine, endLine)
-
-			// Add context information about lines not shown
-			if startLine > 1 || endLine < totalLines {
-				contextInfo := fmt.Sprintf("Requested to read lines %d-%d, but returning lines %d-%d to give more context.",
-					fileReq.StartLineOneIndexed, fileReq.EndLineOneIndexedInclusive, startLine, endLine)
-				contents = contextInfo + "\n" + contents
-			}
 		}
+		// the header about lines not shown is built once the budget is applied
+		response.requestedStart = fileReq.StartLineOneIndexed
+		response.requestedEnd = fileReq.EndLineOneIndexedInclusive
 
 		if includeOutline {
 			outline = generateOutline(selectedLines, fileReq.TargetFile)
 		}
 	}
 
-	response.Contents = contents
+	response.lines = selectedLines
+	response.startLine = startLine
 	response.LinesShown = linesShown
 	response.Outline = outline
 
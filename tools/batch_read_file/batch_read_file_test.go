@@ -0,0 +1,184 @@
+package batch_read_file
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xhd2015/llm-tools/tools/tokenizer"
+)
+
+// writeFiles creates files under a temp dir, each given by its line count
+func writeFiles(t *testing.T, files map[string]int) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, n := range files {
+		lines := make([]string, n)
+		for i := range lines {
+			lines[i] = fmt.Sprintf("line %d of %s", i+1, name)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestBatchReadFileBudget(t *testing.T) {
+	dir := writeFiles(t, map[string]int{"a.txt": 10, "b.txt": 10, "c.txt": 10})
+	entire := func(name string) FileReadRequest {
+		return FileReadRequest{TargetFile: name, ShouldReadEntireFile: true}
+	}
+
+	type want struct {
+		linesShown    string
+		truncated     bool
+		nextStartLine int
+	}
+	tests := []struct {
+		name          string
+		req           BatchReadFileRequest
+		want          []want
+		wantTruncated []string
+	}{
+		{
+			name: "no budget",
+			req:  BatchReadFileRequest{Files: []FileReadRequest{entire("a.txt"), entire("b.txt")}},
+			want: []want{{linesShown: "1-10 (entire file)"}, {linesShown: "1-10 (entire file)"}},
+		},
+		{
+			name: "line budget exhausted in second file",
+			req:  BatchReadFileRequest{Files: []FileReadRequest{entire("a.txt"), entire("b.txt"), entire("c.txt")}, MaxTotalLines: 14},
+			want: []want{
+				{linesShown: "1-10 (entire file)"},
+				{linesShown: "1-4 (truncated by batch budget)", truncated: true, nextStartLine: 5},
+				{linesShown: "none (batch budget exhausted)", truncated: true, nextStartLine: 1},
+			},
+			wantTruncated: []string{"b.txt", "c.txt"},
+		},
+		{
+			name: "line budget continues from the requested range",
+			req: BatchReadFileRequest{Files: []FileReadRequest{
+				{TargetFile: "a.txt", StartLineOneIndexed: 3, EndLineOneIndexedInclusive: 8},
+			}, MaxTotalLines: 5},
+			want: []want{
+				{linesShown: "3-7 (truncated by batch budget)", truncated: true, nextStartLine: 8},
+			},
+			wantTruncated: []string{"a.txt"},
+		},
+		{
+			name: "token budget exhausted by first file",
+			req:  BatchReadFileRequest{Files: []FileReadRequest{entire("a.txt"), entire("b.txt")}, MaxTotalTokens: 30},
+			want: []want{
+				{truncated: true},
+				{linesShown: "none (batch budget exhausted)", truncated: true, nextStartLine: 1},
+			},
+			wantTruncated: []string{"a.txt", "b.txt"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.req.WorkspaceRoot = dir
+			tt.req.ContinueOnError = true
+			resp, err := BatchReadFile(tt.req)
+			if err != nil {
+				t.Fatalf("BatchReadFile: %v", err)
+			}
+			if len(resp.Files) != len(tt.want) {
+				t.Fatalf("got %d files, want %d", len(resp.Files), len(tt.want))
+			}
+			for i, w := range tt.want {
+				file := resp.Files[i]
+				if w.linesShown != "" && file.LinesShown != w.linesShown {
+					t.Errorf("%s: lines_shown = %q, want %q", file.TargetFile, file.LinesShown, w.linesShown)
+				}
+				if file.Truncated != w.truncated {
+					t.Errorf("%s: truncated = %v, want %v", file.TargetFile, file.Truncated, w.truncated)
+				}
+				if w.nextStartLine != 0 && file.NextStartLine != w.nextStartLine {
+					t.Errorf("%s: next_start_line = %d, want %d", file.TargetFile, file.NextStartLine, w.nextStartLine)
+				}
+			}
+			if strings.Join(resp.TruncatedFiles, ",") != strings.Join(tt.wantTruncated, ",") {
+				t.Errorf("truncated_files = %v, want %v", resp.TruncatedFiles, tt.wantTruncated)
+			}
+			if tt.req.MaxTotalTokens > 0 {
+				// the files are joined by a newline each
+				total := resp.TotalTokens + len(resp.Files) - 1
+				if total > tt.req.MaxTotalTokens {
+					t.Errorf("total tokens %d exceed budget %d", total, tt.req.MaxTotalTokens)
+				}
+			}
+		})
+	}
+}
+
+func TestBatchReadFileNextStartLineResumes(t *testing.T) {
+	dir := writeFiles(t, map[string]int{"a.txt": 30})
+	var got []string
+	start := 1
+	for i := 0; i < 10 && start > 0; i++ {
+		resp, err := BatchReadFile(BatchReadFileRequest{
+			WorkspaceRoot: dir,
+			Files:         []FileReadRequest{{TargetFile: "a.txt", StartLineOneIndexed: start, EndLineOneIndexedInclusive: 30}},
+			MaxTotalLines: 12,
+		})
+		if err != nil {
+			t.Fatalf("BatchReadFile: %v", err)
+		}
+		file := resp.Files[0]
+		for _, line := range strings.Split(file.Contents, "\n") {
+			if strings.HasPrefix(line, "line ") {
+				got = append(got, line)
+			}
+		}
+		start = file.NextStartLine
+	}
+	if len(got) != 30 {
+		t.Fatalf("read %d lines across calls, want 30", len(got))
+	}
+	for i, line := range got {
+		if want := fmt.Sprintf("line %d of a.txt", i+1); line != want {
+			t.Fatalf("line %d = %q, want %q", i+1, line, want)
+		}
+	}
+}
+
+func TestBatchReadFileHeaderInBudget(t *testing.T) {
+	dir := writeFiles(t, map[string]int{"a.txt": 400})
+	req := BatchReadFileRequest{
+		WorkspaceRoot:  dir,
+		Files:          []FileReadRequest{{TargetFile: "a.txt", StartLineOneIndexed: 100, EndLineOneIndexedInclusive: 110}},
+		MaxTotalTokens: 200,
+	}
+	resp, err := BatchReadFile(req)
+	if err != nil {
+		t.Fatalf("BatchReadFile: %v", err)
+	}
+	file := resp.Files[0]
+	if !file.Truncated {
+		t.Fatalf("expected the file to be truncated, got lines %s", file.LinesShown)
+	}
+	if tokenizer.Count(file.Contents) > req.MaxTotalTokens {
+		t.Errorf("contents take %d tokens, budget is %d", tokenizer.Count(file.Contents), req.MaxTotalTokens)
+	}
+	// the header describes the lines actually returned
+	end := file.NextStartLine - 1
+	wantHeader := fmt.Sprintf("-%d to give more context.", end)
+	if header := strings.SplitN(file.Contents, "\n", 2)[0]; !strings.HasSuffix(header, wantHeader) {
+		t.Errorf("header = %q, want suffix %q", header, wantHeader)
+	}
+}
+
+func TestGetToolDefinitionConcurrency(t *testing.T) {
+	if _, ok := GetToolDefinition().Parameters.Properties["concurrency"]; !ok {
+		t.Errorf("concurrency is missing from the schema")
+	}
+}
@@ -3,6 +3,7 @@ package batch_read_file
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/xhd2015/less-gen/flags"
 )
@@ -14,13 +15,19 @@ Usage: llm-tools batch_read_file [OPTIONS]
 
 Options:
   --workspace-root <path>      workspace root directory (defaults to current directory)
-  --file <path>                file to read (can be specified multiple times)
+  --file <path>                file, directory or glob to read (can be specified multiple times)
+  --include <glob>             only read expanded files matching the glob (can be specified multiple times)
+  --exclude <glob>             skip expanded files matching the glob (can be specified multiple times)
+  --max-expanded-files <num>   maximum files a directory or glob expands to (default: 50)
   --entire-file                read entire files
   --start-line <num>           start line number (1-indexed)
   --end-line <num>             end line number (1-indexed, inclusive)
   --max-lines <num>            maximum lines per file
   --global-max-lines <num>     global maximum lines per file (default: 250)
   --global-min-lines <num>     global minimum lines per file (default: 200)
+  --max-total-lines <num>      budget of content lines across all files
+  --max-total-tokens <num>     budget of tokens across all files
+  --concurrency <num>          number of files read in parallel (default: 8)
   --continue-on-error          continue processing if one file fails
   --include-outline            include outline in responses
   --explanation <text>         explanation for the operation
@@ -28,29 +35,44 @@ Options:
 Examples:
   llm-tools batch_read_file --file file1.go --file file2.go --entire-file
   llm-tools batch_read_file --workspace-root /path/to/workspace --file file1.go --start-line 1 --end-line 50
+  llm-tools batch_read_file --file a.go --file b.go --file c.go --entire-file --max-total-lines 400
+  llm-tools batch_read_file --file 'tools/tree/*_test.go' --entire-file
+  llm-tools batch_read_file --file cmd --include '*.go' --exclude '*_test.go' --entire-file
 `
 
 func HandleCli(args []string) error {
 	var workspaceRoot string
 	var files []string
+	var include []string
+	var exclude []string
+	var maxExpandedFiles int
 	var entireFile bool
 	var startLine int
 	var endLine int
 	var maxLines int
 	var globalMaxLines int
 	var globalMinLines int
+	var maxTotalLines int
+	var maxTotalTokens int
+	var concurrency int
 	var continueOnError bool
 	var includeOutline bool
 	var explanation string
 
 	args, err := flags.String("--workspace-root", &workspaceRoot).
 		StringSlice("--file", &files).
+		StringSlice("--include", &include).
+		StringSlice("--exclude", &exclude).
+		Int("--max-expanded-files", &maxExpandedFiles).
 		Bool("--entire-file", &entireFile).
 		Int("--start-line", &startLine).
 		Int("--end-line", &endLine).
 		Int("--max-lines", &maxLines).
 		Int("--global-max-lines", &globalMaxLines).
 		Int("--global-min-lines", &globalMinLines).
+		Int("--max-total-lines", &maxTotalLines).
+		Int("--max-total-tokens", &maxTotalTokens).
+		Int("--concurrency", &concurrency).
 		Bool("--continue-on-error", &continueOnError).
 		Bool("--include-outline", &includeOutline).
 		String("--explanation", &explanation).
@@ -90,6 +112,8 @@ func HandleCli(args []string) error {
 		fileReq := FileReadRequest{
 			TargetFile:           file,
 			ShouldReadEntireFile: entireFile,
+			Include:              include,
+			Exclude:              exclude,
 		}
 
 		if !entireFile {
@@ -109,13 +133,17 @@ func HandleCli(args []string) error {
 	}
 
 	req := BatchReadFileRequest{
-		WorkspaceRoot:   workspaceRoot,
-		Files:           fileRequests,
-		GlobalMaxLines:  globalMaxLines,
-		GlobalMinLines:  globalMinLines,
-		ContinueOnError: continueOnError,
-		IncludeOutline:  includeOutline,
-		Explanation:     explanation,
+		WorkspaceRoot:    workspaceRoot,
+		Files:            fileRequests,
+		GlobalMaxLines:   globalMaxLines,
+		GlobalMinLines:   globalMinLines,
+		MaxTotalLines:    maxTotalLines,
+		MaxTotalTokens:   maxTotalTokens,
+		Concurrency:      concurrency,
+		MaxExpandedFiles: maxExpandedFiles,
+		ContinueOnError:  continueOnError,
+		IncludeOutline:   includeOutline,
+		Explanation:      explanation,
 	}
 
 	response, err := BatchReadFile(req)
@@ -124,7 +152,14 @@ func HandleCli(args []string) error {
 	}
 
 	// Print results
-	fmt.Printf("Total files: %d, Success: %d, Errors: %d\n", response.TotalFiles, response.SuccessCount, response.ErrorCount)
+	fmt.Printf("Total files: %d, Success: %d, Errors: %d, Tokens: %d\n", response.TotalFiles, response.SuccessCount, response.ErrorCount, response.TotalTokens)
+	for _, warning := range response.Warnings {
+		fmt.Printf("Warning: %s\n", warning)
+	}
+	if len(response.TruncatedFiles) > 0 {
+		fmt.Printf("Truncated by budget: %s\n", strings.Join(response.TruncatedFiles, ", "))
+		fmt.Println(response.Hint)
+	}
 	fmt.Println()
 
 	for _, fileResp := range response.Files {
@@ -132,7 +167,7 @@ func HandleCli(args []string) error {
 		if fileResp.Error != "" {
 			fmt.Printf("Error: %s\n", fileResp.Error)
 		} else {
-			fmt.Printf("Lines: %s (Total: %d)\n", fileResp.LinesShown, fileResp.TotalLines)
+			fmt.Printf("Lines: %s (Total: %d, Tokens: %d)\n", fileResp.LinesShown, fileResp.TotalLines, fileResp.Tokens)
 			if fileResp.Outline != "" {
 				fmt.Printf("Outline: %s\n", fileResp.Outline)
 			}
@@ -0,0 +1,227 @@
+package batch_read_file
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/xhd2015/llm-tools/tools/glob"
+	"github.com/xhd2015/llm-tools/tools/walk"
+)
+
+// DEFAULT_MAX_EXPANDED_FILES is the default cap on files a single glob or directory entry expands to
+const DEFAULT_MAX_EXPANDED_FILES = 50
+
+// plannedRead is a single file to read after glob and directory expansion
+type plannedRead struct {
+	req FileReadRequest
+	// expandedFrom is the glob or directory this file was expanded from
+	expandedFrom string
+	// err is set when the entry could not be expanded
+	err string
+}
+
+// expandFileRequests replaces glob and directory targets with one request per
+// matched file, keeping the order of the original requests. Matches of a single
+// entry are sorted by path so the expansion is deterministic.
+func expandFileRequests(workspaceRoot string, files []FileReadRequest, maxExpanded int) ([]plannedRead, []string) {
+	var planned []plannedRead
+	var warnings []string
+	for _, fileReq := range files {
+		kind, err := classifyTarget(workspaceRoot, fileReq.TargetFile)
+		if err != nil {
+			planned = append(planned, plannedRead{req: fileReq, err: err.Error()})
+			continue
+		}
+		if kind == targetFile {
+			planned = append(planned, plannedRead{req: fileReq})
+			continue
+		}
+
+		matches, total, err := expandTarget(workspaceRoot, fileReq, kind, maxExpanded)
+		if err != nil {
+			planned = append(planned, plannedRead{req: fileReq, err: err.Error()})
+			continue
+		}
+		if len(matches) == 0 {
+			planned = append(planned, plannedRead{req: fileReq, err: fmt.Sprintf("no files matched: %s", fileReq.TargetFile)})
+			continue
+		}
+		if total > len(matches) {
+			warnings = append(warnings, fmt.Sprintf("%s matched %d files, only the first %d are read; narrow the pattern, add exclude filters or raise max_expanded_files", fileReq.TargetFile, total, len(matches)))
+		}
+		for _, match := range matches {
+			expanded := fileReq
+			expanded.TargetFile = match
+			expanded.Include = nil
+			expanded.Exclude = nil
+			planned = append(planned, plannedRead{req: expanded, expandedFrom: fileReq.TargetFile})
+		}
+	}
+	return planned, warnings
+}
+
+type targetKind int
+
+const (
+	targetFile targetKind = iota
+	targetDir
+	targetGlob
+)
+
+// classifyTarget decides whether a target is a plain file, a directory or a glob.
+// An existing path always wins over glob interpretation.
+func classifyTarget(workspaceRoot string, target string) (targetKind, error) {
+	if target == "" {
+		return targetFile, nil
+	}
+	absPath := target
+	if !filepath.IsAbs(absPath) {
+		if workspaceRoot == "" {
+			// processFileRequest reports the missing workspace_root
+			return targetFile, nil
+		}
+		absPath = filepath.Join(workspaceRoot, absPath)
+	}
+	info, err := os.Stat(absPath)
+	if err == nil {
+		if info.IsDir() {
+			return targetDir, nil
+		}
+		return targetFile, nil
+	}
+	if glob.HasMeta(target) {
+		return targetGlob, nil
+	}
+	return targetFile, nil
+}
+
+// expandTarget lists the files matched by a directory or glob target.
+// It returns at most maxExpanded paths, together with the total number of matches.
+func expandTarget(workspaceRoot string, fileReq FileReadRequest, kind targetKind, maxExpanded int) ([]string, int, error) {
+	target := filepath.ToSlash(fileReq.TargetFile)
+
+	var walkRoot string
+	var pattern *glob.Pattern
+	if kind == targetDir {
+		walkRoot = strings.TrimSuffix(target, "/")
+	} else {
+		walkRoot = glob.StaticPrefix(target)
+		rest := strings.TrimPrefix(strings.TrimPrefix(target, walkRoot), "/")
+		var err error
+		// anchor to the walk root so "*.go" only matches the top level, like a shell glob
+		pattern, err = glob.Compile("/" + rest)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	includes, err := glob.CompileAll(fileReq.Include)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid include: %w", err)
+	}
+	excludes, err := glob.CompileAll(fileReq.Exclude)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid exclude: %w", err)
+	}
+
+	absRoot := filepath.FromSlash(walkRoot)
+	if !filepath.IsAbs(absRoot) {
+		if workspaceRoot == "" {
+			return nil, 0, fmt.Errorf("workspace_root is required when target_file is a relative path")
+		}
+		absRoot = filepath.Join(workspaceRoot, absRoot)
+	}
+	info, err := os.Stat(absRoot)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, 0, nil
+		}
+		return nil, 0, err
+	}
+	if !info.IsDir() {
+		return nil, 0, fmt.Errorf("not a directory: %s", walkRoot)
+	}
+
+	var matches []string
+	err = walk.Walk(absRoot, walk.Options{}, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// unreadable entries are skipped rather than failing the batch
+			if d != nil && d.IsDir() && path != absRoot {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			// follow links to files, links to directories are not descended into
+			info, err := os.Stat(path)
+			if err != nil || !info.Mode().IsRegular() {
+				return nil
+			}
+		} else if !d.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(absRoot, path)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if pattern != nil && !pattern.Match(rel) {
+			return nil
+		}
+		if len(includes) > 0 && !glob.MatchAny(includes, rel) {
+			return nil
+		}
+		if glob.MatchAny(excludes, rel) {
+			return nil
+		}
+		if isBinaryFile(path) {
+			return nil
+		}
+		matches = append(matches, joinTarget(walkRoot, rel))
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sort.Strings(matches)
+	total := len(matches)
+	if maxExpanded > 0 && len(matches) > maxExpanded {
+		matches = matches[:maxExpanded]
+	}
+	return matches, total, nil
+}
+
+// joinTarget joins a match back onto the walk root, keeping relative targets relative
+func joinTarget(walkRoot string, rel string) string {
+	if walkRoot == "" || walkRoot == "." {
+		return rel
+	}
+	return strings.TrimSuffix(walkRoot, "/") + "/" + rel
+}
+
+// isBinaryFile reports whether the file looks binary, judged by a NUL byte in its first 512 bytes
+func isBinaryFile(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return true
+	}
+	defer file.Close()
+
+	buffer := make([]byte, 512)
+	n, _ := file.Read(buffer)
+	for i := 0; i < n; i++ {
+		if buffer[i] == 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package batch_read_file
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExpandFileRequests(t *testing.T) {
+	dir := writeFiles(t, map[string]int{
+		"main.go":           1,
+		"README.md":         1,
+		"pkg/a.go":          1,
+		"pkg/a_test.go":     1,
+		"pkg/sub/b.go":      1,
+		"pkg/sub/notes.txt": 1,
+		"other/c.go":        1,
+	})
+	if err := os.Symlink(filepath.Join(dir, "other", "c.go"), filepath.Join(dir, "pkg", "link.go")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "other"), filepath.Join(dir, "pkg", "linkdir")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		req  FileReadRequest
+		want []string
+	}{
+		{"plain file", FileReadRequest{TargetFile: "main.go"}, []string{"main.go"}},
+		{"top level glob", FileReadRequest{TargetFile: "*.go"}, []string{"main.go"}},
+		{"recursive glob", FileReadRequest{TargetFile: "pkg/**/*.go"}, []string{"pkg/a.go", "pkg/a_test.go", "pkg/link.go", "pkg/sub/b.go"}},
+		{"glob with exclude", FileReadRequest{TargetFile: "pkg/**/*.go", Exclude: []string{"*_test.go"}}, []string{"pkg/a.go", "pkg/link.go", "pkg/sub/b.go"}},
+		{"directory", FileReadRequest{TargetFile: "pkg/sub"}, []string{"pkg/sub/b.go", "pkg/sub/notes.txt"}},
+		{"directory with include", FileReadRequest{TargetFile: "pkg/", Include: []string{"*.txt"}}, []string{"pkg/sub/notes.txt"}},
+		{"negated class", FileReadRequest{TargetFile: "pkg/[!a]*.go"}, []string{"pkg/link.go"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			planned, warnings := expandFileRequests(dir, []FileReadRequest{tt.req}, DEFAULT_MAX_EXPANDED_FILES)
+			var got []string
+			for _, p := range planned {
+				if p.err != "" {
+					t.Fatalf("unexpected error: %s", p.err)
+				}
+				got = append(got, p.req.TargetFile)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("expanded to %v, want %v", got, tt.want)
+			}
+			if len(warnings) > 0 {
+				t.Errorf("unexpected warnings: %v", warnings)
+			}
+		})
+	}
+}
+
+func TestExpandFileRequestsNoMatch(t *testing.T) {
+	dir := writeFiles(t, map[string]int{"main.go": 1})
+	planned, _ := expandFileRequests(dir, []FileReadRequest{{TargetFile: "*.rs"}}, DEFAULT_MAX_EXPANDED_FILES)
+	if len(planned) != 1 || planned[0].err != "no files matched: *.rs" {
+		t.Errorf("got %+v, want a single no files matched error", planned)
+	}
+}
+
+func TestBatchReadFileExpansionCap(t *testing.T) {
+	files := make(map[string]int)
+	for i := 0; i < DEFAULT_MAX_EXPANDED_FILES+5; i++ {
+		files[fmt.Sprintf("gen/f%03d.txt", i)] = 1
+	}
+	dir := writeFiles(t, files)
+
+	resp, err := BatchReadFile(BatchReadFileRequest{
+		WorkspaceRoot:   dir,
+		Files:           []FileReadRequest{{TargetFile: "gen/*.txt", ShouldReadEntireFile: true}},
+		ContinueOnError: true,
+	})
+	if err != nil {
+		t.Fatalf("BatchReadFile: %v", err)
+	}
+	if len(resp.Files) != DEFAULT_MAX_EXPANDED_FILES {
+		t.Fatalf("read %d files, want %d", len(resp.Files), DEFAULT_MAX_EXPANDED_FILES)
+	}
+	if last := resp.Files[len(resp.Files)-1]; last.TargetFile != "gen/f049.txt" || last.ExpandedFrom != "gen/*.txt" {
+		t.Errorf("last file = %s expanded from %s, want gen/f049.txt expanded from gen/*.txt", last.TargetFile, last.ExpandedFrom)
+	}
+	wantWarning := fmt.Sprintf("gen/*.txt matched %d files, only the first %d are read", DEFAULT_MAX_EXPANDED_FILES+5, DEFAULT_MAX_EXPANDED_FILES)
+	if len(resp.Warnings) != 1 || !strings.HasPrefix(resp.Warnings[0], wantWarning) {
+		t.Errorf("warnings = %v, want one starting with %q", resp.Warnings, wantWarning)
+	}
+}
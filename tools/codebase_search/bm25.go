@@ -0,0 +1,159 @@
+package codebase_search
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// BM25 parameters, the usual defaults
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// Field weights: a term in the declared symbol name counts as SYMBOL_WEIGHT
+// occurrences in the body, one in the file path as PATH_WEIGHT
+const (
+	SYMBOL_WEIGHT = 3.0
+	PATH_WEIGHT   = 1.0
+)
+
+var stopWords = map[string]bool{
+	"the": true, "is": true, "are": true, "and": true, "or": true, "but": true,
+	"in": true, "on": true, "at": true, "to": true, "for": true, "of": true,
+	"with": true, "by": true, "how": true, "what": true, "where": true, "when": true,
+	"why": true, "who": true, "which": true, "does": true, "do": true, "did": true,
+	"will": true, "would": true, "could": true, "should": true, "can": true, "may": true,
+	"might": true, "must": true, "shall": true, "a": true, "an": true, "this": true,
+	"that": true, "these": true, "those": true, "i": true, "you": true, "he": true,
+	"she": true, "it": true, "we": true, "they": true, "me": true, "him": true,
+	"her": true, "us": true, "them": true, "my": true, "your": true, "his": true,
+	"its": true, "our": true, "their": true, "be": true, "been": true, "was": true,
+	"were": true, "from": true, "as": true, "if": true, "there": true,
+}
+
+// Terms splits text into search terms. Identifiers are split at camel case
+// and snake case boundaries, so parseHTTPRequest gives parse, http and
+// request, and the whole identifier is kept as a term too. Terms are lower
+// case, plurals are reduced to the singular and stop words are dropped.
+func Terms(text string) []string {
+	var terms []string
+	add := func(word string) {
+		word = stem(strings.ToLower(word))
+		if len(word) < 2 || stopWords[word] {
+			return
+		}
+		terms = append(terms, word)
+	}
+	for _, word := range strings.FieldsFunc(text, func(r rune) bool { return !isAlnum(r) }) {
+		parts := splitIdentifier(word)
+		for _, part := range parts {
+			add(part)
+		}
+		if len(parts) > 1 {
+			add(word)
+		}
+	}
+	return terms
+}
+
+// splitIdentifier splits at lower to upper case changes and before the last
+// upper case letter of an acronym followed by lower case, e.g. HTTPServer
+func splitIdentifier(word string) []string {
+	runes := []rune(word)
+	var parts []string
+	start := 0
+	for i := 1; i < len(runes); i++ {
+		prev, cur := runes[i-1], runes[i]
+		boundary := unicode.IsLower(prev) && unicode.IsUpper(cur) ||
+			unicode.IsUpper(prev) && unicode.IsUpper(cur) && i+1 < len(runes) && unicode.IsLower(runes[i+1]) ||
+			unicode.IsLetter(prev) != unicode.IsLetter(cur)
+		if boundary {
+			parts = append(parts, string(runes[start:i]))
+			start = i
+		}
+	}
+	return append(parts, string(runes[start:]))
+}
+
+// stem reduces simple English plurals, enough for users and user to match
+func stem(word string) string {
+	switch {
+	case len(word) > 4 && strings.HasSuffix(word, "ies"):
+		return word[:len(word)-3] + "y"
+	case len(word) > 3 && strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss") &&
+		!strings.HasSuffix(word, "us") && !strings.HasSuffix(word, "is"):
+		return word[:len(word)-1]
+	}
+	return word
+}
+
+func isAlnum(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// document is a chunk with its weighted term frequencies
+type document struct {
+	chunk  Chunk
+	tf     map[string]float64
+	length float64
+}
+
+func newDocument(chunk Chunk) *document {
+	doc := &document{chunk: chunk, tf: make(map[string]float64)}
+	addTerms := func(text string, weight float64) {
+		for _, term := range Terms(text) {
+			doc.tf[term] += weight
+			doc.length += weight
+		}
+	}
+	addTerms(chunk.Content, 1)
+	addTerms(chunk.Symbol, SYMBOL_WEIGHT)
+	addTerms(chunk.File, PATH_WEIGHT)
+	return doc
+}
+
+// rankBM25 scores the documents containing any query term with Okapi BM25,
+// returning the scores in the order of docs, 0 for documents without a query term
+func rankBM25(docs []*document, query []string) []float64 {
+	scores := make([]float64, len(docs))
+	if len(docs) == 0 {
+		return scores
+	}
+	var totalLength float64
+	for _, doc := range docs {
+		totalLength += doc.length
+	}
+	avgLength := totalLength / float64(len(docs))
+	if avgLength == 0 {
+		avgLength = 1
+	}
+
+	seen := make(map[string]bool)
+	for _, term := range query {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+		df := 0
+		for _, doc := range docs {
+			if doc.tf[term] > 0 {
+				df++
+			}
+		}
+		if df == 0 {
+			continue
+		}
+		idf := math.Log(1 + (float64(len(docs)-df)+0.5)/(float64(df)+0.5))
+		for i, doc := range docs {
+			tf := doc.tf[term]
+			if tf == 0 {
+				continue
+			}
+			norm := bm25K1 * (1 - bm25B + bm25B*doc.length/avgLength)
+			scores[i] += idf * tf * (bm25K1 + 1) / (tf + norm)
+		}
+	}
+	return scores
+}
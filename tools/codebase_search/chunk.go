@@ -0,0 +1,321 @@
+package codebase_search
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strings"
+)
+
+// MAX_CHUNK_LINES is the longest chunk, longer declarations are split into windows of this size
+const MAX_CHUNK_LINES = 120
+
+// WINDOW_LINES is the size of the chunks of files without an outline
+const WINDOW_LINES = 40
+
+// Chunk kinds that are not a declaration keyword
+const (
+	KIND_METHOD  = "method"
+	KIND_SECTION = "section"
+	KIND_LINES   = "lines"
+)
+
+// Chunk is the unit of search results: a declaration, a document section or a window of lines
+type Chunk struct {
+	File string
+	// StartLine and EndLine are 1-based and inclusive
+	StartLine int
+	EndLine   int
+	// Symbol is the declared name, Type.Method for methods, empty for windows
+	Symbol  string
+	Kind    string
+	Content string
+}
+
+// ChunkFile splits a file along its declarations: Go files by their syntax
+// tree, other code by the outline lines read_file reports, markdown by
+// headings and anything else into fixed windows
+func ChunkFile(file string, src []byte) []Chunk {
+	lines := strings.Split(strings.ReplaceAll(string(src), "\r\n", "\n"), "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	var chunks []Chunk
+	ext := strings.ToLower(filepath.Ext(file))
+	switch {
+	case ext == ".go":
+		var ok bool
+		chunks, ok = chunkGo(src, lines)
+		if !ok {
+			chunks = chunkOutline(lines, isGoSymbol)
+		}
+	case ext == ".md" || ext == ".markdown":
+		chunks = chunkOutline(lines, isHeading)
+	case symbolMatcher(ext) != nil:
+		chunks = chunkOutline(lines, symbolMatcher(ext))
+	default:
+		chunks = chunkWindows(lines, 1, len(lines), "", KIND_LINES)
+	}
+
+	result := make([]Chunk, 0, len(chunks))
+	for _, chunk := range chunks {
+		if strings.TrimSpace(chunk.Content) == "" {
+			continue
+		}
+		chunk.File = file
+		result = append(result, chunk)
+	}
+	return result
+}
+
+// chunkGo makes a chunk of each top level declaration with its doc comment, imports excluded
+func chunkGo(src []byte, lines []string) ([]Chunk, bool) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		return nil, false
+	}
+	var chunks []Chunk
+	for _, decl := range file.Decls {
+		var doc *ast.CommentGroup
+		var symbol, kind string
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			doc = decl.Doc
+			symbol = decl.Name.Name
+			kind = "func"
+			if decl.Recv != nil && len(decl.Recv.List) > 0 {
+				symbol = receiverName(decl.Recv.List[0].Type) + "." + symbol
+				kind = KIND_METHOD
+			}
+		case *ast.GenDecl:
+			if decl.Tok == token.IMPORT {
+				continue
+			}
+			doc = decl.Doc
+			kind = decl.Tok.String()
+			symbol = specName(decl.Specs)
+		default:
+			continue
+		}
+		start := decl.Pos()
+		if doc != nil {
+			start = doc.Pos()
+		}
+		startLine := fset.Position(start).Line
+		endLine := fset.Position(decl.End()).Line
+		chunks = append(chunks, chunkWindows(lines, startLine, endLine, symbol, kind)...)
+	}
+	return chunks, true
+}
+
+// receiverName returns the type name of a method receiver, without pointer and type parameters
+func receiverName(expr ast.Expr) string {
+	switch expr := expr.(type) {
+	case *ast.StarExpr:
+		return receiverName(expr.X)
+	case *ast.IndexExpr:
+		return receiverName(expr.X)
+	case *ast.IndexListExpr:
+		return receiverName(expr.X)
+	case *ast.Ident:
+		return expr.Name
+	}
+	return ""
+}
+
+// specName returns the first name declared by a group of specs
+func specName(specs []ast.Spec) string {
+	for _, spec := range specs {
+		switch spec := spec.(type) {
+		case *ast.TypeSpec:
+			return spec.Name.Name
+		case *ast.ValueSpec:
+			if len(spec.Names) > 0 {
+				return spec.Names[0].Name
+			}
+		}
+	}
+	return ""
+}
+
+// chunkOutline starts a chunk at each outline line of the least indentation, together with
+// the comments above it. The lines before the first outline line form a chunk too.
+func chunkOutline(lines []string, isSymbol func(line string) bool) []Chunk {
+	top := -1
+	for _, line := range lines {
+		if isSymbol(line) {
+			if indent := indentation(line); top < 0 || indent < top {
+				top = indent
+			}
+		}
+	}
+	if top < 0 {
+		return chunkWindows(lines, 1, len(lines), "", KIND_LINES)
+	}
+
+	var starts []int
+	for i, line := range lines {
+		if !isSymbol(line) || indentation(line) != top {
+			continue
+		}
+		start := i
+		for start > 0 && isCommentLine(lines[start-1]) && (len(starts) == 0 || start-1 > starts[len(starts)-1]) {
+			start--
+		}
+		starts = append(starts, start)
+	}
+
+	var chunks []Chunk
+	if starts[0] > 0 {
+		chunks = append(chunks, chunkWindows(lines, 1, starts[0], "", KIND_LINES)...)
+	}
+	for i, start := range starts {
+		end := len(lines)
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		// the symbol line follows the comments above it
+		symbolLine := start
+		for symbolLine < end && !isSymbol(lines[symbolLine]) {
+			symbolLine++
+		}
+		symbol, kind := outlineSymbol(lines[symbolLine])
+		chunks = append(chunks, chunkWindows(lines, start+1, end, symbol, kind)...)
+	}
+	return chunks
+}
+
+// chunkWindows makes one chunk of lines startLine to endLine, or several of at most MAX_CHUNK_LINES
+// lines when it is longer. Windows of files without an outline are WINDOW_LINES long.
+func chunkWindows(lines []string, startLine int, endLine int, symbol string, kind string) []Chunk {
+	size := MAX_CHUNK_LINES
+	if kind == KIND_LINES && symbol == "" {
+		size = WINDOW_LINES
+	}
+	if endLine > len(lines) {
+		endLine = len(lines)
+	}
+	var chunks []Chunk
+	for start := startLine; start <= endLine; start += size {
+		end := start + size - 1
+		if end > endLine {
+			end = endLine
+		}
+		chunks = append(chunks, Chunk{
+			StartLine: start,
+			EndLine:   end,
+			Symbol:    symbol,
+			Kind:      kind,
+			Content:   strings.Join(lines[start-1:end], "\n"),
+		})
+	}
+	return chunks
+}
+
+// outlineSymbol extracts the declared name and its keyword from an outline line,
+// e.g. "export async function login(user) {" gives login and function
+func outlineSymbol(line string) (string, string) {
+	trimmed := strings.TrimSpace(line)
+	if strings.HasPrefix(trimmed, "#") && !strings.HasPrefix(trimmed, "#include") && !strings.HasPrefix(trimmed, "#define") {
+		return strings.TrimSpace(strings.TrimLeft(trimmed, "#")), KIND_SECTION
+	}
+	words := strings.FieldsFunc(trimmed, func(r rune) bool {
+		return !(r == '_' || r == '$' || r == '.' || isAlnum(r))
+	})
+	kind := KIND_LINES
+	for i, word := range words {
+		switch word {
+		case "export", "default", "async", "public", "private", "protected", "static", "final", "abstract":
+			continue
+		case "func", "function", "class", "interface", "struct", "enum", "def", "type", "const", "let", "var":
+			kind = word
+			if i+1 < len(words) {
+				return words[i+1], kind
+			}
+			return "", kind
+		}
+		// e.g. "public void login(" names the word before the parenthesis
+		if idx := strings.Index(trimmed, "("); idx > 0 {
+			fields := strings.Fields(trimmed[:idx])
+			if len(fields) > 0 {
+				return fields[len(fields)-1], "function"
+			}
+		}
+		return word, kind
+	}
+	return "", kind
+}
+
+func indentation(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " \t"))
+}
+
+func isCommentLine(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return strings.HasPrefix(trimmed, "//") || strings.HasPrefix(trimmed, "/*") ||
+		strings.HasPrefix(trimmed, "*") || strings.HasPrefix(trimmed, "@") ||
+		(strings.HasPrefix(trimmed, "#") && !strings.HasPrefix(trimmed, "#include"))
+}
+
+func isHeading(line string) bool {
+	return strings.HasPrefix(line, "#")
+}
+
+// symbolMatcher returns a function reporting whether a line declares a symbol,
+// or nil if the file type has no outline
+func symbolMatcher(ext string) func(line string) bool {
+	switch ext {
+	case ".js", ".ts", ".jsx", ".tsx", ".mjs", ".cjs":
+		return func(line string) bool {
+			trimmed := strings.TrimSpace(line)
+			return strings.HasPrefix(trimmed, "function ") ||
+				strings.HasPrefix(trimmed, "async function ") ||
+				strings.HasPrefix(trimmed, "class ") ||
+				strings.HasPrefix(trimmed, "interface ") ||
+				strings.HasPrefix(trimmed, "type ") ||
+				strings.HasPrefix(trimmed, "export ") ||
+				strings.HasPrefix(trimmed, "const ") ||
+				strings.HasPrefix(trimmed, "let ") ||
+				strings.HasPrefix(trimmed, "var ")
+		}
+	case ".py":
+		return func(line string) bool {
+			trimmed := strings.TrimSpace(line)
+			return strings.HasPrefix(trimmed, "def ") ||
+				strings.HasPrefix(trimmed, "async def ") ||
+				strings.HasPrefix(trimmed, "class ")
+		}
+	case ".java", ".kt", ".scala", ".cs":
+		return func(line string) bool {
+			trimmed := strings.TrimSpace(line)
+			return strings.Contains(trimmed, "class ") ||
+				strings.Contains(trimmed, "interface ") ||
+				strings.Contains(trimmed, "enum ") ||
+				(strings.HasPrefix(trimmed, "public ") || strings.HasPrefix(trimmed, "private ") ||
+					strings.HasPrefix(trimmed, "protected ") || strings.HasPrefix(trimmed, "fun ")) &&
+					strings.Contains(trimmed, "(")
+		}
+	case ".c", ".h", ".cpp", ".cc", ".cxx", ".hpp", ".rs", ".swift":
+		return func(line string) bool {
+			trimmed := strings.TrimSpace(line)
+			if trimmed == "" || isCommentLine(trimmed) || strings.HasSuffix(trimmed, ";") {
+				return false
+			}
+			return strings.HasPrefix(trimmed, "fn ") || strings.HasPrefix(trimmed, "pub ") ||
+				strings.HasPrefix(trimmed, "impl ") || strings.HasPrefix(trimmed, "func ") ||
+				strings.HasPrefix(trimmed, "class ") || strings.HasPrefix(trimmed, "struct ") ||
+				strings.HasPrefix(trimmed, "enum ") ||
+				(indentation(line) == 0 && strings.Contains(trimmed, "(") && !strings.HasPrefix(trimmed, "#"))
+		}
+	}
+	return nil
+}
+
+func isGoSymbol(line string) bool {
+	return indentation(line) == 0 && (strings.HasPrefix(line, "func ") ||
+		strings.HasPrefix(line, "type ") ||
+		strings.HasPrefix(line, "var ") ||
+		strings.HasPrefix(line, "const "))
+}
@@ -1,33 +1,60 @@
 package codebase_search
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/xhd2015/llm-tools/jsonschema"
 	"github.com/xhd2015/llm-tools/tools/defs"
 	"github.com/xhd2015/llm-tools/tools/dirs"
+	"github.com/xhd2015/llm-tools/tools/tokenizer"
+	"github.com/xhd2015/llm-tools/tools/walk"
 )
 
+// DEFAULT_LIMIT is the number of chunks returned when the request sets no limit
+const DEFAULT_LIMIT = 10
+
+// MAX_FILE_SIZE is the size above which files are not searched, they are usually generated
+const MAX_FILE_SIZE = 1 << 20
+
 // CodebaseSearchRequest represents the input parameters for the codebase_search tool
 type CodebaseSearchRequest struct {
 	WorkspaceRoot     string   `json:"workspace_root"`
 	Query             string   `json:"query"`
 	SearchOnlyPrs     bool     `json:"search_only_prs"`
 	TargetDirectories []string `json:"target_directories"`
-	Explanation       string   `json:"explanation"`
+	// Limit is the maximum number of chunks returned, DEFAULT_LIMIT if not positive
+	Limit int `json:"limit,omitempty"`
+	// Ranking is RANKING_BM25, RANKING_VECTOR or RANKING_HYBRID, hybrid if
+	// empty and an embedder is configured, bm25 otherwise
+	Ranking string `json:"ranking,omitempty"`
+	// MaxTokens caps the tokens of the returned matches, 0 means no limit
+	MaxTokens   int    `json:"max_tokens,omitempty"`
+	Explanation string `json:"explanation"`
 }
 
-// CodebaseSearchMatch represents a single search result
+// CodebaseSearchMatch is a ranked chunk: a whole function, type or section
 type CodebaseSearchMatch struct {
-	File    string  `json:"file"`
-	Content string  `json:"content"`
-	Line    int     `json:"line"`
-	Score   float64 `json:"score"`
-	Context string  `json:"context"`
+	File string `json:"file"`
+	// Line and EndLine are the first and last line of the chunk
+	Line    int    `json:"line"`
+	EndLine int    `json:"end_line"`
+	Symbol  string `json:"symbol,omitempty"`
+	Kind    string `json:"kind"`
+	// Score is the BM25 score, the vector similarity or the reciprocal rank
+	// fusion of both, depending on the ranking
+	Score float64 `json:"score"`
+	// LexicalScore and VectorScore are the scores fused in vector and hybrid ranking
+	LexicalScore float64 `json:"lexical_score,omitempty"`
+	VectorScore  float64 `json:"vector_score,omitempty"`
+	Content      string  `json:"content"`
 }
 
 // CodebaseSearchResponse represents the output of the codebase_search tool
@@ -36,12 +63,23 @@ type CodebaseSearchResponse struct {
 	TotalMatches int                   `json:"total_matches"`
 	Matches      []CodebaseSearchMatch `json:"matches"`
 	Truncated    bool                  `json:"truncated"`
+	// Tokens is the token count of the returned matches including their content
+	Tokens int `json:"tokens"`
+	// Message explains an empty result that is not due to the query
+	Message string `json:"message,omitempty"`
+	// Ranking is the ranking used
+	Ranking string `json:"ranking,omitempty"`
+	// Embedded is the number of chunks embedded by this search, the others were stored already
+	Embedded int `json:"embedded,omitempty"`
 }
 
 // GetToolDefinition returns the JSON schema definition for the codebase_search tool
 func GetToolDefinition() defs.ToolDefinition {
 	return defs.ToolDefinition{
-		Description: `semantic search that finds code by meaning, not exact text
+		Description: `local search that finds code by meaning, not exact text.
+Files are split into chunks along their declarations (a function, a type, a document section),
+ranked with BM25 over their words with identifiers split at camelCase and snake_case, and returned whole with their scores.
+When an embedding model is configured, chunks are also ranked by embedding similarity and both rankings are fused.
 
 ### When to Use This Tool
 
@@ -151,7 +189,15 @@ Skip codebase_search for:
 				},
 				"search_only_prs": {
 					Type:        jsonschema.ParamTypeBoolean,
-					Description: "If true, only search pull requests and return no code results.",
+					Description: "If true, only search pull requests and return no code results. Pull requests are not available locally, so this returns nothing.",
+				},
+				"limit": {
+					Type:        jsonschema.ParamTypeNumber,
+					Description: "Maximum number of chunks to return. Defaults to 10.",
+				},
+				"ranking": {
+					Type:        jsonschema.ParamTypeString,
+					Description: "bm25 ranks by words, vector by embedding similarity, hybrid fuses both. Defaults to hybrid when an embedder is configured, bm25 otherwise.",
 				},
 				"target_directories": {
 					Type:        jsonschema.ParamTypeArray,
@@ -160,6 +206,10 @@ Skip codebase_search for:
 						Type: jsonschema.ParamTypeString,
 					},
 				},
+				"max_tokens": {
+					Type:        jsonschema.ParamTypeNumber,
+					Description: "Maximum number of tokens of the returned matches including their context. Lower ranked matches are dropped first. Defaults to no limit.",
+				},
 				"explanation": {
 					Type:        jsonschema.ParamTypeString,
 					Description: "One sentence explanation as to why this tool is being used, and how it contributes to the goal.",
@@ -172,18 +222,29 @@ Skip codebase_search for:
 
 // CodebaseSearch executes the codebase_search tool with the given parameters
 func CodebaseSearch(req CodebaseSearchRequest) (*CodebaseSearchResponse, error) {
-	// For now, this is a basic implementation that simulates semantic search
-	// In a real implementation, this would use vector embeddings or similar technology
-	// For the current implementation, we'll use a simple text-based approach
+	root, err := dirs.GetPath(req.WorkspaceRoot, "", "workspace_root", true)
+	if err != nil {
+		return nil, err
+	}
+	if req.SearchOnlyPrs {
+		return &CodebaseSearchResponse{
+			Query:   req.Query,
+			Matches: []CodebaseSearchMatch{},
+			Message: "pull requests are not searchable locally, search without search_only_prs to get code results",
+		}, nil
+	}
+	query := Terms(req.Query)
+	if len(query) == 0 {
+		return nil, fmt.Errorf("query has no searchable words: %q", req.Query)
+	}
+	ranking, embedder, err := resolveRanking(req.Ranking)
+	if err != nil {
+		return nil, err
+	}
 
-	var searchPaths []string
-	if len(req.TargetDirectories) == 0 {
-		searchPathRoot, err := dirs.GetPath(req.WorkspaceRoot, "", "", true)
-		if err != nil {
-			return nil, err
-		}
-		searchPaths = []string{searchPathRoot}
-	} else {
+	searchPaths := []string{root}
+	if len(req.TargetDirectories) > 0 {
+		searchPaths = nil
 		for _, dir := range req.TargetDirectories {
 			searchPath, err := dirs.GetPath(req.WorkspaceRoot, dir, "target_directory", true)
 			if err != nil {
@@ -193,95 +254,121 @@ func CodebaseSearch(req CodebaseSearchRequest) (*CodebaseSearchResponse, error)
 		}
 	}
 
-	var matches []CodebaseSearchMatch
-
-	// Simple implementation: search for keywords in the query across files
-	keywords := extractKeywords(req.Query)
-
+	var docs []*document
 	for _, searchPath := range searchPaths {
-		pathMatches, err := searchInPath(searchPath, keywords, req.Query)
+		pathDocs, err := collectDocuments(root, searchPath)
 		if err != nil {
 			return nil, fmt.Errorf("error searching in path %s: %w", searchPath, err)
 		}
-		matches = append(matches, pathMatches...)
+		docs = append(docs, pathDocs...)
 	}
 
-	// Sort by score (descending) and limit results
-	matches = sortAndLimitMatches(matches, 50)
+	// a stable order makes ties deterministic whatever order the walk found files in
+	sort.Slice(docs, func(i, j int) bool {
+		if docs[i].chunk.File != docs[j].chunk.File {
+			return docs[i].chunk.File < docs[j].chunk.File
+		}
+		return docs[i].chunk.StartLine < docs[j].chunk.StartLine
+	})
+	lexical := rankBM25(docs, query)
+	var vector []float64
+	var embedded int
+	if embedder != nil {
+		vector, embedded, err = vectorScores(root, docs, req.Query, embedder, len(req.TargetDirectories) == 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+	matches := rankDocuments(docs, lexical, vector, ranking)
+	totalMatches := len(matches)
+	limit := req.Limit
+	if limit <= 0 {
+		limit = DEFAULT_LIMIT
+	}
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	matches, tokens := limitMatchTokens(matches, req.MaxTokens)
 
 	return &CodebaseSearchResponse{
 		Query:        req.Query,
-		TotalMatches: len(matches),
+		TotalMatches: totalMatches,
 		Matches:      matches,
-		Truncated:    len(matches) >= 50,
+		Truncated:    len(matches) < totalMatches,
+		Tokens:       tokens,
+		Ranking:      ranking,
+		Embedded:     embedded,
 	}, nil
 }
 
-// extractKeywords extracts relevant keywords from the search query
-func extractKeywords(query string) []string {
-	// Simple keyword extraction - in a real implementation this would be more sophisticated
-	words := strings.Fields(strings.ToLower(query))
-	var keywords []string
-
-	// Filter out common words
-	stopWords := map[string]bool{
-		"the": true, "is": true, "are": true, "and": true, "or": true, "but": true,
-		"in": true, "on": true, "at": true, "to": true, "for": true, "of": true,
-		"with": true, "by": true, "how": true, "what": true, "where": true, "when": true,
-		"why": true, "who": true, "which": true, "does": true, "do": true, "did": true,
-		"will": true, "would": true, "could": true, "should": true, "can": true, "may": true,
-		"might": true, "must": true, "shall": true, "a": true, "an": true, "this": true,
-		"that": true, "these": true, "those": true, "i": true, "you": true, "he": true,
-		"she": true, "it": true, "we": true, "they": true, "me": true, "him": true,
-		"her": true, "us": true, "them": true, "my": true, "your": true, "his": true,
-		"its": true, "our": true, "their": true,
-	}
-
-	for _, word := range words {
-		cleaned := strings.Trim(word, ".,!?;:")
-		if len(cleaned) > 2 && !stopWords[cleaned] {
-			keywords = append(keywords, cleaned)
+// limitMatchTokens keeps the best matches that fit in maxTokens, returning them with their token count
+func limitMatchTokens(matches []CodebaseSearchMatch, maxTokens int) ([]CodebaseSearchMatch, int) {
+	total := 0
+	for i, match := range matches {
+		tokens := tokenizer.Count(FormatMatch(match))
+		if maxTokens > 0 && total+tokens > maxTokens {
+			return matches[:i], total
 		}
+		total += tokens
 	}
-
-	return keywords
+	return matches, total
 }
 
-// searchInPath searches for matches in a given path
-func searchInPath(searchPath string, keywords []string, originalQuery string) ([]CodebaseSearchMatch, error) {
-	var matches []CodebaseSearchMatch
-
-	err := filepath.Walk(searchPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
+// FormatMatch renders a match as file:line-end_line symbol (score) followed by its content
+func FormatMatch(match CodebaseSearchMatch) string {
+	header := fmt.Sprintf("%s:%d-%d", match.File, match.Line, match.EndLine)
+	if match.Symbol != "" {
+		header += fmt.Sprintf(" %s %s", match.Kind, match.Symbol)
+	}
+	return fmt.Sprintf("%s (score: %g)\n%s", header, match.Score, match.Content)
+}
 
-		if info.IsDir() {
-			// Skip common directories that are not useful for code search
-			dirName := filepath.Base(path)
-			if dirName == ".git" || dirName == "node_modules" || dirName == ".vscode" ||
-				dirName == "dist" || dirName == "build" || dirName == "target" ||
-				dirName == "vendor" || dirName == ".idea" {
-				return filepath.SkipDir
-			}
-			return nil
-		}
+// collectDocuments chunks the code files under searchPath, which may also be a
+// single file, in parallel. Chunk files are relative to root.
+func collectDocuments(root string, searchPath string) ([]*document, error) {
+	info, err := os.Stat(searchPath)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return chunkDocuments(root, searchPath), nil
+	}
 
-		// Only search in code files
-		if !isCodeFile(path) {
+	var mutex sync.Mutex
+	var docs []*document
+	// skip .git, hidden and gitignored entries
+	err = walk.WalkParallel(searchPath, walk.Options{SkipHidden: true}, 0, func(path string, d fs.DirEntry) error {
+		if d.IsDir() || !isCodeFile(path) {
 			return nil
 		}
-
-		fileMatches, err := searchInFile(path, keywords, originalQuery)
-		if err != nil {
-			return nil // Skip files that can't be read
-		}
-
-		matches = append(matches, fileMatches...)
+		fileDocs := chunkDocuments(root, path)
+		mutex.Lock()
+		defer mutex.Unlock()
+		docs = append(docs, fileDocs...)
 		return nil
 	})
+	return docs, err
+}
 
-	return matches, err
+// chunkDocuments reads and chunks one file, skipping unreadable, large and binary files
+func chunkDocuments(root string, path string) []*document {
+	info, err := os.Stat(path)
+	if err != nil || info.Size() > MAX_FILE_SIZE {
+		return nil
+	}
+	src, err := os.ReadFile(path)
+	if err != nil || bytes.IndexByte(src, 0) >= 0 {
+		return nil
+	}
+	relPath, err := filepath.Rel(root, path)
+	if err != nil || strings.HasPrefix(relPath, "..") {
+		relPath = path
+	}
+	var docs []*document
+	for _, chunk := range ChunkFile(relPath, src) {
+		docs = append(docs, newDocument(chunk))
+	}
+	return docs
 }
 
 // isCodeFile checks if a file is a code file worth searching
@@ -306,105 +393,6 @@ func isCodeFile(path string) bool {
 		filepath.Base(path) == "Dockerfile" || filepath.Base(path) == "Rakefile"
 }
 
-// searchInFile searches for keywords in a specific file
-func searchInFile(filePath string, keywords []string, originalQuery string) ([]CodebaseSearchMatch, error) {
-	content, err := os.ReadFile(filePath)
-	if err != nil {
-		return nil, err
-	}
-
-	text := string(content)
-	lines := strings.Split(text, "\n")
-
-	var matches []CodebaseSearchMatch
-
-	for i, line := range lines {
-		score := calculateScore(line, keywords, originalQuery)
-		if score > 0.1 { // Threshold for relevance
-			matches = append(matches, CodebaseSearchMatch{
-				File:    filePath,
-				Content: strings.TrimSpace(line),
-				Line:    i + 1,
-				Score:   score,
-				Context: getContext(lines, i, 2),
-			})
-		}
-	}
-
-	return matches, nil
-}
-
-// calculateScore calculates relevance score for a line
-func calculateScore(line string, keywords []string, originalQuery string) float64 {
-	if len(keywords) == 0 {
-		return 0
-	}
-
-	lineLower := strings.ToLower(line)
-	var score float64
-
-	// Score based on keyword matches
-	for _, keyword := range keywords {
-		if strings.Contains(lineLower, keyword) {
-			score += 1.0 / float64(len(keywords))
-		}
-	}
-
-	// Bonus for exact phrase matches
-	if strings.Contains(lineLower, strings.ToLower(originalQuery)) {
-		score += 0.5
-	}
-
-	// Bonus for function/class/method definitions
-	if strings.Contains(lineLower, "func ") || strings.Contains(lineLower, "function ") ||
-		strings.Contains(lineLower, "class ") || strings.Contains(lineLower, "def ") ||
-		strings.Contains(lineLower, "interface ") || strings.Contains(lineLower, "type ") {
-		score += 0.3
-	}
-
-	// Bonus for comments that might explain functionality
-	if strings.Contains(lineLower, "//") || strings.Contains(lineLower, "/*") ||
-		strings.Contains(lineLower, "#") || strings.Contains(lineLower, "\"\"\"") {
-		score += 0.2
-	}
-
-	return score
-}
-
-// getContext returns surrounding lines for context
-func getContext(lines []string, lineIndex int, contextSize int) string {
-	start := lineIndex - contextSize
-	if start < 0 {
-		start = 0
-	}
-
-	end := lineIndex + contextSize + 1
-	if end > len(lines) {
-		end = len(lines)
-	}
-
-	contextLines := lines[start:end]
-	return strings.Join(contextLines, "\n")
-}
-
-// sortAndLimitMatches sorts matches by score and limits the number of results
-func sortAndLimitMatches(matches []CodebaseSearchMatch, limit int) []CodebaseSearchMatch {
-	// Simple bubble sort by score (descending)
-	for i := 0; i < len(matches)-1; i++ {
-		for j := 0; j < len(matches)-i-1; j++ {
-			if matches[j].Score < matches[j+1].Score {
-				matches[j], matches[j+1] = matches[j+1], matches[j]
-			}
-		}
-	}
-
-	if len(matches) > limit {
-		return matches[:limit]
-	}
-
-	return matches
-}
-
 func ParseJSONRequest(jsonInput string) (CodebaseSearchRequest, error) {
 	var req CodebaseSearchRequest
 	if err := json.Unmarshal([]byte(jsonInput), &req); err != nil {
@@ -0,0 +1,253 @@
+package codebase_search
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestTerms(t *testing.T) {
+	got := Terms("parseHTTPRequest user_passwords, the Users")
+	// snake case words are split at the underscore like any other separator
+	want := []string{"parse", "http", "request", "parsehttprequest", "user", "password", "user"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Terms() = %v, want %v", got, want)
+	}
+}
+
+func TestChunkFile(t *testing.T) {
+	src := `package auth
+
+import "crypto/sha256"
+
+// HashPassword hashes a password
+// before it is saved.
+func HashPassword(password string) []byte {
+	sum := sha256.Sum256([]byte(password))
+	return sum[:]
+}
+
+type Store struct{}
+
+func (s *Store) Save(user string) error {
+	return nil
+}
+`
+	type span struct {
+		Start, End   int
+		Symbol, Kind string
+	}
+	var got []span
+	for _, chunk := range ChunkFile("auth.go", []byte(src)) {
+		got = append(got, span{chunk.StartLine, chunk.EndLine, chunk.Symbol, chunk.Kind})
+	}
+	want := []span{
+		{5, 10, "HashPassword", "func"},
+		{12, 12, "Store", "type"},
+		{14, 16, "Store.Save", KIND_METHOD},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Go chunks = %+v, want %+v", got, want)
+	}
+
+	py := "import os\n\n# load the config\ndef load(path):\n    return open(path)\n\nclass Config:\n    def get(self):\n        pass\n"
+	got = nil
+	for _, chunk := range ChunkFile("config.py", []byte(py)) {
+		got = append(got, span{chunk.StartLine, chunk.EndLine, chunk.Symbol, chunk.Kind})
+	}
+	want = []span{
+		{1, 2, "", KIND_LINES},
+		{3, 6, "load", "def"},
+		{7, 9, "Config", "class"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Python chunks = %+v, want %+v", got, want)
+	}
+}
+
+func TestCodebaseSearch(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"auth/password.go": "package auth\n\n// HashPassword hashes a user password before saving\nfunc HashPassword(password string) string {\n\treturn password\n}\n\nfunc Logout() {}\n",
+		"auth/session.go":  "package auth\n\n// NewSession starts a session for a logged in user\nfunc NewSession(user string) string {\n\treturn user\n}\n",
+		"docs/guide.md":    "# Guide\n\nintro\n\n## Passwords\n\nPasswords are hashed before they are saved.\n",
+		"image.go":         "package main\x00",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	response, err := CodebaseSearch(CodebaseSearchRequest{WorkspaceRoot: root, Query: "Where do we hash user passwords before saving?"})
+	if err != nil {
+		t.Fatalf("CodebaseSearch failed: %v", err)
+	}
+	if len(response.Matches) < 2 {
+		t.Fatalf("got %d matches, want at least 2", len(response.Matches))
+	}
+	best := response.Matches[0]
+	if best.File != filepath.Join("auth", "password.go") || best.Symbol != "HashPassword" || best.Line != 3 || best.EndLine != 6 {
+		t.Errorf("best match = %+v, want HashPassword in auth/password.go lines 3-6", best)
+	}
+	for i := 1; i < len(response.Matches); i++ {
+		if response.Matches[i].Score > response.Matches[i-1].Score {
+			t.Errorf("matches are not sorted by score: %+v", response.Matches)
+		}
+	}
+
+	scoped, err := CodebaseSearch(CodebaseSearchRequest{WorkspaceRoot: root, Query: "passwords", TargetDirectories: []string{"docs"}})
+	if err != nil {
+		t.Fatalf("CodebaseSearch failed: %v", err)
+	}
+	if len(scoped.Matches) != 1 || scoped.Matches[0].Symbol != "Passwords" {
+		t.Errorf("scoped search = %+v, want the Passwords section only", scoped)
+	}
+
+	limited, err := CodebaseSearch(CodebaseSearchRequest{WorkspaceRoot: root, Query: "passwords", Limit: 1})
+	if err != nil {
+		t.Fatalf("CodebaseSearch failed: %v", err)
+	}
+	if len(limited.Matches) != 1 || !limited.Truncated || limited.TotalMatches < 2 {
+		t.Errorf("limited search = %+v, want 1 match of several, truncated", limited)
+	}
+
+	prs, err := CodebaseSearch(CodebaseSearchRequest{WorkspaceRoot: root, Query: "passwords", SearchOnlyPrs: true})
+	if err != nil {
+		t.Fatalf("CodebaseSearch failed: %v", err)
+	}
+	if len(prs.Matches) != 0 || prs.Message == "" {
+		t.Errorf("search_only_prs = %+v, want no matches and a message", prs)
+	}
+
+	if _, err := CodebaseSearch(CodebaseSearchRequest{WorkspaceRoot: root, Query: "how is it"}); err == nil {
+		t.Errorf("query of stop words succeeded, want error")
+	}
+}
+
+func TestCodebaseSearchEmbeddings(t *testing.T) {
+	t.Setenv(ENV_EMBEDDER, "hash")
+	root := t.TempDir()
+	write := func(name, content string) {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("auth/password.go", "package auth\n\n// HashPassword hashes a user password before saving\nfunc HashPassword(password string) string {\n\treturn password\n}\n\nfunc Logout() {}\n")
+	write("auth/session.go", "package auth\n\n// NewSession starts a session for a logged in user\nfunc NewSession(user string) string {\n\treturn user\n}\n")
+
+	first, err := CodebaseSearch(CodebaseSearchRequest{WorkspaceRoot: root, Query: "hash the password"})
+	if err != nil {
+		t.Fatalf("CodebaseSearch failed: %v", err)
+	}
+	if first.Ranking != RANKING_HYBRID || first.Embedded != 3 {
+		t.Errorf("first search ranking = %s, embedded = %d, want hybrid and 3", first.Ranking, first.Embedded)
+	}
+	if len(first.Matches) == 0 || first.Matches[0].Symbol != "HashPassword" || first.Matches[0].VectorScore <= 0 {
+		t.Fatalf("first search = %+v, want HashPassword first with a vector score", first.Matches)
+	}
+	store := VectorStorePath(root, HashEmbedder{Dimensions: DEFAULT_HASH_DIMENSIONS})
+	if _, err := os.Stat(store); err != nil {
+		t.Fatalf("vector store not saved: %v", err)
+	}
+	if ignore, err := os.ReadFile(filepath.Join(root, ".llm-tools", ".gitignore")); err != nil || string(ignore) != "*\n" {
+		t.Errorf(".llm-tools/.gitignore = %q, %v, want *", ignore, err)
+	}
+
+	// concurrent updates keep every vector
+	write("auth/token.go", "package auth\n\n// NewToken signs a token\nfunc NewToken() string {\n\treturn \"\"\n}\n")
+	write("auth/logout.go", "package auth\n\n// Forget drops a session\nfunc Forget() {}\n")
+	var wg sync.WaitGroup
+	for _, path := range []string{"auth/token.go", "auth/logout.go"} {
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			if _, err := UpdateEmbeddings(root, []string{path}); err != nil {
+				t.Errorf("UpdateEmbeddings(%s) failed: %v", path, err)
+			}
+		}(path)
+	}
+	wg.Wait()
+	if n := OpenVectorStore(store).Len(); n != 5 {
+		t.Errorf("store has %d vectors after concurrent updates, want 5", n)
+	}
+	os.Remove(filepath.Join(root, "auth", "token.go"))
+	os.Remove(filepath.Join(root, "auth", "logout.go"))
+
+	second, err := CodebaseSearch(CodebaseSearchRequest{WorkspaceRoot: root, Query: "hash the password", Ranking: RANKING_VECTOR})
+	if err != nil {
+		t.Fatalf("CodebaseSearch failed: %v", err)
+	}
+	if second.Embedded != 0 || second.Matches[0].Symbol != "HashPassword" {
+		t.Errorf("second search embedded %d, best %+v, want nothing embedded and HashPassword", second.Embedded, second.Matches[0])
+	}
+
+	write("auth/session.go", "package auth\n\n// NewSession starts a session for a logged in user\nfunc NewSession(user string, ttl int) string {\n\treturn user\n}\n")
+	third, err := CodebaseSearch(CodebaseSearchRequest{WorkspaceRoot: root, Query: "session"})
+	if err != nil {
+		t.Fatalf("CodebaseSearch failed: %v", err)
+	}
+	if third.Embedded != 1 {
+		t.Errorf("after changing one chunk embedded %d, want 1", third.Embedded)
+	}
+	if n := OpenVectorStore(store).Len(); n != 3 {
+		t.Errorf("store has %d vectors, want 3 after pruning the changed chunk", n)
+	}
+
+	t.Setenv(ENV_EMBEDDER, "")
+	if _, err := CodebaseSearch(CodebaseSearchRequest{WorkspaceRoot: root, Query: "session", Ranking: RANKING_VECTOR}); err == nil {
+		t.Errorf("vector ranking without an embedder succeeded, want error")
+	}
+	bm25, err := CodebaseSearch(CodebaseSearchRequest{WorkspaceRoot: root, Query: "session"})
+	if err != nil {
+		t.Fatalf("CodebaseSearch failed: %v", err)
+	}
+	if bm25.Ranking != RANKING_BM25 || bm25.Matches[0].VectorScore != 0 {
+		t.Errorf("search without an embedder = %+v, want bm25 ranking", bm25)
+	}
+}
+
+func TestOpenAIEmbedder(t *testing.T) {
+	var got embeddingRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/embeddings" || r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		// out of order, as the API does not promise to keep it
+		w.Write([]byte(`{"data":[{"index":1,"embedding":[0,2]},{"index":0,"embedding":[3,4]}]}`))
+	}))
+	defer server.Close()
+
+	embedder := &OpenAIEmbedder{BaseURL: server.URL + "/v1/", Model: "nomic-embed-text", APIKey: "secret"}
+	vectors, err := embedder.Embed([]string{"a", "b"})
+	if err != nil {
+		t.Fatalf("Embed failed: %v", err)
+	}
+	want := [][]float32{{0.6, 0.8}, {0, 1}}
+	if !reflect.DeepEqual(vectors, want) {
+		t.Errorf("Embed() = %v, want %v", vectors, want)
+	}
+	if got.Model != "nomic-embed-text" || !reflect.DeepEqual(got.Input, []string{"a", "b"}) {
+		t.Errorf("request = %+v, want the model and both texts", got)
+	}
+
+	embedder.APIKey = ""
+	if _, err := embedder.Embed([]string{"a"}); err == nil {
+		t.Errorf("Embed with a rejected request succeeded, want error")
+	}
+}
@@ -9,7 +9,7 @@ import (
 )
 
 const help = `
-llm-tools codebase_search performs semantic search to find code by meaning
+llm-tools codebase_search finds the functions, types and sections that best match a question
 
 Usage: llm-tools codebase_search <query> [OPTIONS]
 
@@ -17,23 +17,40 @@ Options:
   --workspace-root <path>      workspace root directory (defaults to current directory)
   --target-directories <dirs>  comma-separated list of target directories to search in
   --search-only-prs            only search pull requests and return no code results
+  --limit <num>                maximum number of chunks to return (default 10)
+  --ranking <mode>             bm25, vector or hybrid (default hybrid if an embedder is configured, else bm25)
+  --max-tokens <num>           maximum tokens of returned matches (0 = no limit)
   --explanation <text>         explanation for the operation
 
+Embeddings:
+  LLM_TOOLS_EMBEDDING_URL      base URL of an OpenAI compatible API, e.g. http://localhost:11434/v1
+  LLM_TOOLS_EMBEDDING_MODEL    embedding model served there, e.g. nomic-embed-text
+  LLM_TOOLS_EMBEDDING_API_KEY  API key sent as a bearer token, if required
+  LLM_TOOLS_EMBEDDER=hash      use the built-in hashing embedder instead, no model needed
+  Embeddings are stored under <workspace-root>/.llm-tools/embeddings, only changed chunks are embedded again.
+
 Examples:
   llm-tools codebase_search "How does user authentication work?"
   llm-tools codebase_search "Where are user roles checked?" --target-directories backend/auth/
   llm-tools codebase_search "What is the login flow?" --workspace-root /path/to/workspace
+  LLM_TOOLS_EMBEDDING_URL=http://localhost:11434/v1 LLM_TOOLS_EMBEDDING_MODEL=nomic-embed-text llm-tools codebase_search "session expiry"
 `
 
 func HandleCli(args []string) error {
 	var workspaceRoot string
 	var targetDirectories string
 	var searchOnlyPrs bool
+	var limit int
+	var ranking string
+	var maxTokens int
 	var explanation string
 
 	args, err := flags.String("--workspace-root", &workspaceRoot).
 		String("--target-directories", &targetDirectories).
 		Bool("--search-only-prs", &searchOnlyPrs).
+		Int("--limit", &limit).
+		String("--ranking", &ranking).
+		Int("--max-tokens", &maxTokens).
 		String("--explanation", &explanation).
 		Help("-h,--help", help).
 		Parse(args)
@@ -72,7 +89,10 @@ func HandleCli(args []string) error {
 		WorkspaceRoot:     workspaceRoot,
 		Query:             query,
 		SearchOnlyPrs:     searchOnlyPrs,
+		Limit:             limit,
+		Ranking:           ranking,
 		TargetDirectories: targetDirs,
+		MaxTokens:         maxTokens,
 		Explanation:       explanation,
 	}
 
@@ -87,20 +107,24 @@ func HandleCli(args []string) error {
 	if response.Truncated {
 		fmt.Printf(" (truncated)")
 	}
+	fmt.Printf(", tokens: %d, ranking: %s", response.Tokens, response.Ranking)
+	if response.Embedded > 0 {
+		fmt.Printf(", embedded: %d", response.Embedded)
+	}
 	fmt.Println()
 	fmt.Println()
 
 	if len(response.Matches) == 0 {
-		fmt.Println("No matches found.")
+		if response.Message != "" {
+			fmt.Println(response.Message)
+		} else {
+			fmt.Println("No matches found.")
+		}
 		return nil
 	}
 
 	for _, match := range response.Matches {
-		fmt.Printf("%s:%d (score: %.2f)\n", match.File, match.Line, match.Score)
-		fmt.Printf("  %s\n", match.Content)
-		if match.Context != "" {
-			fmt.Printf("  Context:\n%s\n", match.Context)
-		}
+		fmt.Println(FormatMatch(match))
 		fmt.Println()
 	}
 
@@ -0,0 +1,204 @@
+package codebase_search
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Environment variables selecting the embedder
+const (
+	// ENV_EMBEDDER is openai or hash, openai is implied when ENV_EMBEDDING_URL is set
+	ENV_EMBEDDER = "LLM_TOOLS_EMBEDDER"
+	// ENV_EMBEDDING_URL is the base URL of an OpenAI compatible API, e.g.
+	// http://localhost:11434/v1 for ollama or http://localhost:8080/v1 for llama.cpp
+	ENV_EMBEDDING_URL     = "LLM_TOOLS_EMBEDDING_URL"
+	ENV_EMBEDDING_MODEL   = "LLM_TOOLS_EMBEDDING_MODEL"
+	ENV_EMBEDDING_API_KEY = "LLM_TOOLS_EMBEDDING_API_KEY"
+	// ENV_EMBEDDING_DIMENSIONS sets the size of hash embeddings
+	ENV_EMBEDDING_DIMENSIONS = "LLM_TOOLS_EMBEDDING_DIMENSIONS"
+)
+
+// DEFAULT_HASH_DIMENSIONS is the size of hash embeddings when not configured
+const DEFAULT_HASH_DIMENSIONS = 256
+
+// Embedder turns texts into vectors whose dot product measures how related the texts are
+type Embedder interface {
+	// Name identifies the embedder and its model, vectors of different names are not comparable
+	Name() string
+	// Embed returns one L2 normalized vector per text, in order
+	Embed(texts []string) ([][]float32, error)
+}
+
+// NewEmbedderFromEnv returns the embedder configured by the environment, nil if none is
+func NewEmbedderFromEnv() (Embedder, error) {
+	kind := os.Getenv(ENV_EMBEDDER)
+	url := os.Getenv(ENV_EMBEDDING_URL)
+	if kind == "" && url != "" {
+		kind = "openai"
+	}
+	switch kind {
+	case "":
+		return nil, nil
+	case "hash":
+		dimensions := DEFAULT_HASH_DIMENSIONS
+		if value := os.Getenv(ENV_EMBEDDING_DIMENSIONS); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid %s: %s", ENV_EMBEDDING_DIMENSIONS, value)
+			}
+			dimensions = n
+		}
+		return HashEmbedder{Dimensions: dimensions}, nil
+	case "openai":
+		if url == "" {
+			return nil, fmt.Errorf("%s=openai requires %s", ENV_EMBEDDER, ENV_EMBEDDING_URL)
+		}
+		model := os.Getenv(ENV_EMBEDDING_MODEL)
+		if model == "" {
+			return nil, fmt.Errorf("%s requires %s", ENV_EMBEDDING_URL, ENV_EMBEDDING_MODEL)
+		}
+		return &OpenAIEmbedder{BaseURL: url, Model: model, APIKey: os.Getenv(ENV_EMBEDDING_API_KEY)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported %s: %s, expected openai or hash", ENV_EMBEDDER, kind)
+	}
+}
+
+// HashEmbedder hashes the search terms of a text into a fixed number of
+// signed buckets. It needs no model and is deterministic, which suits tests,
+// but it only relates texts sharing words.
+type HashEmbedder struct {
+	Dimensions int
+}
+
+func (h HashEmbedder) Name() string {
+	return fmt.Sprintf("hash-%d", h.Dimensions)
+}
+
+func (h HashEmbedder) Embed(texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vector := make([]float32, h.Dimensions)
+		for _, term := range Terms(text) {
+			hash := fnv.New64a()
+			hash.Write([]byte(term))
+			sum := hash.Sum64()
+			sign := float32(1)
+			if sum>>63 == 1 {
+				sign = -1
+			}
+			vector[sum%uint64(h.Dimensions)] += sign
+		}
+		vectors[i] = normalize(vector)
+	}
+	return vectors, nil
+}
+
+// OpenAIEmbedder calls the /embeddings endpoint of an OpenAI compatible API,
+// which may be a local server such as ollama or llama.cpp
+type OpenAIEmbedder struct {
+	BaseURL string
+	Model   string
+	APIKey  string
+	Client  *http.Client
+}
+
+func (o *OpenAIEmbedder) Name() string {
+	return "openai-" + o.Model
+}
+
+type embeddingRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type embeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
+func (o *OpenAIEmbedder) Embed(texts []string) ([][]float32, error) {
+	body, err := json.Marshal(embeddingRequest{Model: o.Model, Input: texts})
+	if err != nil {
+		return nil, err
+	}
+	url := strings.TrimSuffix(o.BaseURL, "/") + "/embeddings"
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if o.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+o.APIKey)
+	}
+	client := o.Client
+	if client == nil {
+		client = &http.Client{Timeout: 60 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("embedding request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embedding response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		if len(data) > 200 {
+			data = data[:200]
+		}
+		return nil, fmt.Errorf("embedding request failed: %s: %s", resp.Status, data)
+	}
+	var result embeddingResponse
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse embedding response: %w", err)
+	}
+	if len(result.Data) != len(texts) {
+		return nil, fmt.Errorf("embedding response has %d vectors for %d texts", len(result.Data), len(texts))
+	}
+	sort.Slice(result.Data, func(i, j int) bool { return result.Data[i].Index < result.Data[j].Index })
+	vectors := make([][]float32, len(texts))
+	for i, d := range result.Data {
+		vectors[i] = normalize(d.Embedding)
+	}
+	return vectors, nil
+}
+
+// normalize scales v to unit length in place, so that dot products are cosine similarities
+func normalize(v []float32) []float32 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return v
+	}
+	norm := float32(math.Sqrt(sum))
+	for i := range v {
+		v[i] /= norm
+	}
+	return v
+}
+
+func dot(a, b []float32) float32 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var sum float32
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
@@ -0,0 +1,165 @@
+package codebase_search
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Rankings of codebase_search
+const (
+	// RANKING_BM25 ranks chunks by their words only
+	RANKING_BM25 = "bm25"
+	// RANKING_VECTOR ranks chunks by the similarity of their embedding to the query's
+	RANKING_VECTOR = "vector"
+	// RANKING_HYBRID fuses both rankings with reciprocal rank fusion
+	RANKING_HYBRID = "hybrid"
+)
+
+// RRF_K dampens the weight of the top ranks in reciprocal rank fusion, 60 as in the original paper
+const RRF_K = 60
+
+// VECTOR_CANDIDATES is the number of nearest chunks that take part in vector and hybrid ranking
+const VECTOR_CANDIDATES = 100
+
+// resolveRanking returns the ranking to use and the embedder it needs. Without
+// a requested ranking it is hybrid when an embedder is configured, bm25 otherwise.
+func resolveRanking(ranking string) (string, Embedder, error) {
+	switch ranking {
+	case "", RANKING_BM25, RANKING_VECTOR, RANKING_HYBRID:
+	default:
+		return "", nil, fmt.Errorf("unsupported ranking: %s, expected %s, %s or %s", ranking, RANKING_BM25, RANKING_VECTOR, RANKING_HYBRID)
+	}
+	if ranking == RANKING_BM25 {
+		return ranking, nil, nil
+	}
+	embedder, err := NewEmbedderFromEnv()
+	if err != nil {
+		return "", nil, err
+	}
+	if embedder == nil {
+		if ranking == "" {
+			return RANKING_BM25, nil, nil
+		}
+		return "", nil, fmt.Errorf("%s ranking requires an embedder, set %s or %s=hash", ranking, ENV_EMBEDDING_URL, ENV_EMBEDDER)
+	}
+	if ranking == "" {
+		ranking = RANKING_HYBRID
+	}
+	return ranking, embedder, nil
+}
+
+// vectorScores returns the cosine similarity of each document to the query.
+// Document vectors come from the workspace's vector store, only new or
+// changed chunks are embedded; with prune, vectors of chunks that are gone
+// are dropped. It also returns the number of chunks embedded.
+func vectorScores(root string, docs []*document, query string, embedder Embedder, prune bool) ([]float64, int, error) {
+	texts := make([]string, len(docs))
+	for i, doc := range docs {
+		texts[i] = embedText(doc.chunk)
+	}
+	vectors, embedded, err := updateStore(root, embedder, texts, prune)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	queryVectors, err := embedder.Embed([]string{query})
+	if err != nil {
+		return nil, 0, err
+	}
+	scores := make([]float64, len(docs))
+	for i, vector := range vectors {
+		scores[i] = float64(dot(queryVectors[0], vector))
+	}
+	return scores, embedded, nil
+}
+
+// rankDocuments returns the matching documents best first. lexical holds
+// the BM25 score of each document and vector, nil for bm25 ranking, the
+// similarity of each document to the query.
+func rankDocuments(docs []*document, lexical []float64, vector []float64, ranking string) []CodebaseSearchMatch {
+	lexicalRanks := ranks(lexical, 0)
+	vectorRanks := ranks(vector, VECTOR_CANDIDATES)
+
+	type scored struct {
+		match CodebaseSearchMatch
+		score float64
+	}
+	var results []scored
+	for i, doc := range docs {
+		lexicalRank, inLexical := lexicalRanks[i]
+		vectorRank, inVector := vectorRanks[i]
+		var score float64
+		switch ranking {
+		case RANKING_VECTOR:
+			if !inVector {
+				continue
+			}
+			score = vector[i]
+		case RANKING_HYBRID:
+			if inLexical {
+				score += 1 / float64(RRF_K+lexicalRank)
+			}
+			if inVector {
+				score += 1 / float64(RRF_K+vectorRank)
+			}
+			if score == 0 {
+				continue
+			}
+		default:
+			if !inLexical {
+				continue
+			}
+			score = lexical[i]
+		}
+		match := CodebaseSearchMatch{
+			File:    doc.chunk.File,
+			Line:    doc.chunk.StartLine,
+			EndLine: doc.chunk.EndLine,
+			Symbol:  doc.chunk.Symbol,
+			Kind:    doc.chunk.Kind,
+			Score:   round(score, 5),
+			Content: doc.chunk.Content,
+		}
+		if ranking != RANKING_BM25 {
+			match.LexicalScore = round(lexical[i], 3)
+			match.VectorScore = round(vector[i], 3)
+		}
+		results = append(results, scored{match: match, score: score})
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].score > results[j].score
+	})
+	matches := make([]CodebaseSearchMatch, len(results))
+	for i, result := range results {
+		matches[i] = result.match
+	}
+	return matches
+}
+
+// ranks maps the index of each positive score to its 1-based rank, keeping
+// at most max of them if max is positive. Ties keep the order of the scores.
+func ranks(scores []float64, max int) map[int]int {
+	var indexes []int
+	for i, score := range scores {
+		if score > 0 {
+			indexes = append(indexes, i)
+		}
+	}
+	sort.SliceStable(indexes, func(i, j int) bool {
+		return scores[indexes[i]] > scores[indexes[j]]
+	})
+	if max > 0 && len(indexes) > max {
+		indexes = indexes[:max]
+	}
+	result := make(map[int]int, len(indexes))
+	for rank, i := range indexes {
+		result[i] = rank + 1
+	}
+	return result
+}
+
+func round(x float64, digits int) float64 {
+	scale := math.Pow(10, float64(digits))
+	return math.Round(x*scale) / scale
+}
@@ -0,0 +1,311 @@
+package codebase_search
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// VECTOR_DIR is where embeddings are stored, relative to the workspace root
+const VECTOR_DIR = ".llm-tools/embeddings"
+
+// storeMutex serializes updates of the vector stores of the process, so that
+// concurrent updates do not drop each other's vectors when they save
+var storeMutex sync.Mutex
+
+// EMBED_BATCH is the number of chunks sent to the embedder at once
+const EMBED_BATCH = 64
+
+// MAX_EMBED_BYTES is the length of a chunk's text beyond which it is cut before embedding
+const MAX_EMBED_BYTES = 8000
+
+const vectorMagic = "llmvec1\n"
+
+// vectorKeySize is the length of the truncated SHA-256 of a chunk's text that keys its vector
+const vectorKeySize = 16
+
+// VectorStore holds the embeddings of chunks by one embedder. Vectors are
+// keyed by the hash of the embedded text, so a chunk is only embedded again
+// when it changes, wherever it moves.
+type VectorStore struct {
+	path       string
+	dimensions int
+	vectors    map[string][]float32
+	dirty      bool
+}
+
+// VectorStorePath returns the file storing the embeddings of embedder for the workspace at root
+func VectorStorePath(root string, embedder Embedder) string {
+	name := strings.Map(func(r rune) rune {
+		if isAlnum(r) || r == '-' || r == '.' || r == '_' {
+			return r
+		}
+		return '_'
+	}, embedder.Name())
+	return filepath.Join(root, filepath.FromSlash(VECTOR_DIR), name+".vec")
+}
+
+// OpenVectorStore loads the store at path. A missing or unreadable store is
+// empty, its vectors are computed again.
+func OpenVectorStore(path string) *VectorStore {
+	store := &VectorStore{path: path, vectors: make(map[string][]float32)}
+	file, err := os.Open(path)
+	if err != nil {
+		return store
+	}
+	defer file.Close()
+	if err := store.read(bufio.NewReader(file)); err != nil {
+		store.dimensions = 0
+		store.vectors = make(map[string][]float32)
+	}
+	return store
+}
+
+// read parses the format written by Save: magic, then dimensions and count as
+// uvarints, then per vector its key followed by little endian float32s
+func (s *VectorStore) read(r *bufio.Reader) error {
+	magic := make([]byte, len(vectorMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != vectorMagic {
+		return fmt.Errorf("not a vector store")
+	}
+	dimensions, err := binary.ReadUvarint(r)
+	if err != nil {
+		return err
+	}
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return err
+	}
+	s.dimensions = int(dimensions)
+	buf := make([]byte, vectorKeySize+4*s.dimensions)
+	for i := uint64(0); i < count; i++ {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return err
+		}
+		vector := make([]float32, s.dimensions)
+		for j := range vector {
+			vector[j] = math.Float32frombits(binary.LittleEndian.Uint32(buf[vectorKeySize+4*j:]))
+		}
+		s.vectors[string(buf[:vectorKeySize])] = vector
+	}
+	return nil
+}
+
+// Save writes the store if it changed, to a temporary file renamed into place
+func (s *VectorStore) Save() error {
+	if !s.dirty {
+		return nil
+	}
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriterSize(tmp, 1<<20)
+	buf := make([]byte, binary.MaxVarintLen64)
+	w.WriteString(vectorMagic)
+	w.Write(buf[:binary.PutUvarint(buf, uint64(s.dimensions))])
+	w.Write(buf[:binary.PutUvarint(buf, uint64(len(s.vectors)))])
+	for key, vector := range s.vectors {
+		w.WriteString(key)
+		for _, x := range vector {
+			binary.LittleEndian.PutUint32(buf, math.Float32bits(x))
+			w.Write(buf[:4])
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+// Len returns the number of stored vectors
+func (s *VectorStore) Len() int {
+	return len(s.vectors)
+}
+
+// Embed returns the vectors of texts, embedding in batches only those not
+// stored yet. It returns the number of texts that were embedded.
+func (s *VectorStore) Embed(embedder Embedder, texts []string) ([][]float32, int, error) {
+	keys := make([]string, len(texts))
+	var missing []int
+	pending := make(map[string]bool)
+	for i, text := range texts {
+		keys[i] = vectorKey(text)
+		if _, ok := s.vectors[keys[i]]; !ok && !pending[keys[i]] {
+			pending[keys[i]] = true
+			missing = append(missing, i)
+		}
+	}
+	for start := 0; start < len(missing); start += EMBED_BATCH {
+		end := start + EMBED_BATCH
+		if end > len(missing) {
+			end = len(missing)
+		}
+		batch := make([]string, 0, end-start)
+		for _, i := range missing[start:end] {
+			batch = append(batch, texts[i])
+		}
+		vectors, err := embedder.Embed(batch)
+		if err != nil {
+			return nil, 0, err
+		}
+		for j, i := range missing[start:end] {
+			if err := s.put(keys[i], vectors[j]); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+	result := make([][]float32, len(texts))
+	for i, key := range keys {
+		result[i] = s.vectors[key]
+	}
+	return result, len(missing), nil
+}
+
+func (s *VectorStore) put(key string, vector []float32) error {
+	if len(s.vectors) > 0 && len(vector) != s.dimensions {
+		return fmt.Errorf("embedder returned %d dimensions, the store at %s has %d", len(vector), s.path, s.dimensions)
+	}
+	s.dimensions = len(vector)
+	s.vectors[key] = vector
+	s.dirty = true
+	return nil
+}
+
+// Prune removes the vectors of texts other than these, the chunks that no longer exist
+func (s *VectorStore) Prune(texts []string) {
+	keep := make(map[string]bool, len(texts))
+	for _, text := range texts {
+		keep[vectorKey(text)] = true
+	}
+	for key := range s.vectors {
+		if !keep[key] {
+			delete(s.vectors, key)
+			s.dirty = true
+		}
+	}
+}
+
+// updateStore embeds the texts missing from the store of embedder for the
+// workspace at root and saves it, dropping vectors of other texts with prune.
+// It returns the vectors of texts and the number of texts embedded.
+func updateStore(root string, embedder Embedder, texts []string, prune bool) ([][]float32, int, error) {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+
+	store := OpenVectorStore(VectorStorePath(root, embedder))
+	vectors, embedded, err := store.Embed(embedder, texts)
+	if err != nil {
+		return nil, 0, err
+	}
+	if prune {
+		store.Prune(texts)
+	}
+	if err := store.Save(); err != nil {
+		return nil, 0, fmt.Errorf("failed to save embeddings: %w", err)
+	}
+	if err := ignoreToolsDir(filepath.Dir(filepath.Join(root, filepath.FromSlash(VECTOR_DIR)))); err != nil {
+		return nil, 0, err
+	}
+	return vectors, embedded, nil
+}
+
+// ignoreToolsDir keeps git from picking up the stores in dir by adding a
+// .gitignore ignoring everything, if dir exists and has none
+func ignoreToolsDir(dir string) error {
+	path := filepath.Join(dir, ".gitignore")
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		return nil
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return nil
+	}
+	if err := os.WriteFile(path, []byte("*\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+func vectorKey(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return string(sum[:vectorKeySize])
+}
+
+// embedText is what is embedded for a chunk: its location and symbol give
+// context to the content, which is cut to MAX_EMBED_BYTES
+func embedText(chunk Chunk) string {
+	text := fmt.Sprintf("%s %s %s\n%s", chunk.File, chunk.Kind, chunk.Symbol, chunk.Content)
+	if len(text) > MAX_EMBED_BYTES {
+		cut := MAX_EMBED_BYTES
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		text = text[:cut]
+	}
+	return text
+}
+
+// UpdateEmbeddings embeds the chunks of the given paths of the workspace at
+// root, slash separated and relative to it, so that later searches only embed
+// the query. With no paths the whole workspace is embedded and vectors of
+// chunks that no longer exist are dropped. It does nothing if no embedder is
+// configured and returns the number of chunks embedded.
+func UpdateEmbeddings(root string, paths []string) (int, error) {
+	embedder, err := NewEmbedderFromEnv()
+	if err != nil || embedder == nil {
+		return 0, err
+	}
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return 0, err
+	}
+	searchPaths := []string{root}
+	if len(paths) > 0 {
+		searchPaths = nil
+		for _, path := range paths {
+			absPath := filepath.Join(root, filepath.FromSlash(path))
+			if info, err := os.Stat(absPath); err != nil || (!info.IsDir() && !isCodeFile(absPath)) {
+				continue
+			}
+			searchPaths = append(searchPaths, absPath)
+		}
+	}
+	var texts []string
+	for _, searchPath := range searchPaths {
+		docs, err := collectDocuments(root, searchPath)
+		if err != nil {
+			return 0, err
+		}
+		for _, doc := range docs {
+			texts = append(texts, embedText(doc.chunk))
+		}
+	}
+
+	_, embedded, err := updateStore(root, embedder, texts, len(paths) == 0)
+	if err != nil {
+		return 0, err
+	}
+	return embedded, nil
+}
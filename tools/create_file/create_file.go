@@ -9,6 +9,7 @@ import (
 	"github.com/xhd2015/llm-tools/jsonschema"
 	"github.com/xhd2015/llm-tools/tools/defs"
 	"github.com/xhd2015/llm-tools/tools/dirs"
+	"github.com/xhd2015/llm-tools/tools/watch/notify"
 )
 
 // CreateFileRequest represents the input parameters for the create_file tool
@@ -79,6 +80,7 @@ func CreateFile(req CreateFileRequest) (*CreateFileResponse, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create file: %w", err)
 	}
+	notify.Changed(filePath)
 
 	return &CreateFileResponse{
 		Success:     true,
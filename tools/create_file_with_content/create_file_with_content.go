@@ -9,6 +9,7 @@ import (
 	"github.com/xhd2015/llm-tools/jsonschema"
 	"github.com/xhd2015/llm-tools/tools/defs"
 	"github.com/xhd2015/llm-tools/tools/dirs"
+	"github.com/xhd2015/llm-tools/tools/watch/notify"
 )
 
 // CreateFileWithContentRequest represents the input parameters for the create_file_with_content tool
@@ -91,6 +92,7 @@ func CreateFileWithContent(req CreateFileWithContentRequest) (*CreateFileWithCon
 	if err != nil {
 		return nil, fmt.Errorf("failed to write file: %w", err)
 	}
+	notify.Changed(filePath)
 
 	return &CreateFileWithContentResponse{
 		Success:      true,
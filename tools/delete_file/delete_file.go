@@ -10,6 +10,7 @@ import (
 	"github.com/xhd2015/llm-tools/jsonschema"
 	"github.com/xhd2015/llm-tools/tools/defs"
 	"github.com/xhd2015/llm-tools/tools/dirs"
+	"github.com/xhd2015/llm-tools/tools/watch/notify"
 )
 
 // DeleteFileRequest represents the input parameters for the delete_file tool
@@ -144,6 +145,7 @@ func DeleteFile(req DeleteFileRequest) (*DeleteFileResponse, error) {
 			DeletedFile: req.TargetFile,
 		}, nil
 	}
+	notify.Changed(filePath)
 
 	return &DeleteFileResponse{
 		Success:     true,
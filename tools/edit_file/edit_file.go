@@ -9,6 +9,7 @@ import (
 	"github.com/xhd2015/llm-tools/jsonschema"
 	"github.com/xhd2015/llm-tools/tools/defs"
 	"github.com/xhd2015/llm-tools/tools/dirs"
+	"github.com/xhd2015/llm-tools/tools/watch/notify"
 )
 
 // EditFileRequest represents the input parameters for the edit_file tool
@@ -106,6 +107,7 @@ func EditFile(req EditFileRequest) (*EditFileResponse, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to write file: %w", err)
 	}
+	notify.Changed(filePath)
 
 	return &EditFileResponse{
 		Success:      true,
@@ -3,31 +3,78 @@ package file_search
 import (
 	"encoding/json"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/xhd2015/llm-tools/jsonschema"
 	"github.com/xhd2015/llm-tools/tools/defs"
 	"github.com/xhd2015/llm-tools/tools/dirs"
+	"github.com/xhd2015/llm-tools/tools/glob"
+	"github.com/xhd2015/llm-tools/tools/walk"
 )
 
 // FileSearchRequest represents the input parameters for the file_search tool
 type FileSearchRequest struct {
 	WorkspaceRoot string `json:"workspace_root"`
 	Query         string `json:"query"`
-	Explanation   string `json:"explanation"`
+	// Mode is MODE_FUZZY (default), MODE_GLOB or MODE_REGEX
+	Mode string `json:"mode,omitempty"`
+	// Type is TYPE_FILE (default), TYPE_DIR, TYPE_SYMLINK or TYPE_ANY
+	Type string `json:"type,omitempty"`
+	// Extensions keeps entries with one of these extensions, with or without the dot
+	Extensions []string `json:"extensions,omitempty"`
+	// MinSize and MaxSize bound the size in bytes, with an optional unit such as 100KB
+	MinSize string `json:"min_size,omitempty"`
+	MaxSize string `json:"max_size,omitempty"`
+	// ModifiedAfter and ModifiedBefore bound the mtime, as an age such as
+	// 24h or 7d, a date or an RFC 3339 time
+	ModifiedAfter  string `json:"modified_after,omitempty"`
+	ModifiedBefore string `json:"modified_before,omitempty"`
+	// SortBy is SORT_SCORE, SORT_PATH, SORT_SIZE or SORT_MTIME, score for
+	// fuzzy queries and path otherwise by default
+	SortBy string `json:"sort_by,omitempty"`
+	// IncludeMetadata adds the type, size and mtime of each match
+	IncludeMetadata bool `json:"include_metadata,omitempty"`
+	// Limit is the maximum number of matches returned, DEFAULT_LIMIT if not positive
+	Limit       int    `json:"limit,omitempty"`
+	Explanation string `json:"explanation"`
+}
+
+// DEFAULT_LIMIT is the number of matches returned when no limit is given
+const DEFAULT_LIMIT = 10
+
+// skipDirs are dependency, build output and editor directories that are not
+// searched even when they are not gitignored
+var skipDirs = map[string]bool{
+	"node_modules": true,
+	"vendor":       true,
+	"dist":         true,
+	"build":        true,
+	"target":       true,
+	".idea":        true,
+	".vscode":      true,
 }
 
 // FileSearchMatch represents a single file search result
 type FileSearchMatch struct {
-	File  string  `json:"file"`
-	Score float64 `json:"score"`
+	File string `json:"file"`
+	// Score is the fuzzy match score, higher is better; it grows with the
+	// length of the query and is only comparable within one search
+	Score float64 `json:"score,omitempty"`
+	// Type is set when include_metadata is or entries other than files are searched
+	Type    string `json:"type,omitempty"`
+	Size    *int64 `json:"size,omitempty"`
+	ModTime string `json:"mod_time,omitempty"`
 }
 
 // FileSearchResponse represents the output of the file_search tool
 type FileSearchResponse struct {
+	// TotalMatches counts all matching files, including those beyond the limit
 	TotalMatches int               `json:"total_matches"`
 	Matches      []FileSearchMatch `json:"matches"`
 	Truncated    bool              `json:"truncated"`
@@ -36,7 +83,7 @@ type FileSearchResponse struct {
 // GetToolDefinition returns the JSON schema definition for the file_search tool
 func GetToolDefinition() defs.ToolDefinition {
 	return defs.ToolDefinition{
-		Description: "Fast file search based on fuzzy matching against file path. Use if you know part of the file path but don't know where it's located exactly. Response will be capped to 10 results. Make your query more specific if need to filter results further.",
+		Description: "Fast file search based on fuzzy matching against file path. Use if you know part of the file path but don't know where it's located exactly. Matches at the start of path segments, camelCase humps and in the file name rank first. Separate several terms with spaces to require all of them, e.g. 'auth handler'. With mode glob or regex the query matches paths exactly, e.g. 'api/**/*.proto'. Filter by extensions, size, modification time and type, e.g. Go files over 100KB changed in the last day: extensions ['go'], min_size '100KB', modified_after '24h'. node_modules, vendor, dist, build, target, .idea and .vscode directories and gitignored files are not searched. Response is capped to 10 results unless limit is given. Make your query more specific if need to filter results further.",
 		Name:        "file_search",
 		Parameters: &jsonschema.JsonSchema{
 			Type: jsonschema.ParamTypeObject,
@@ -47,7 +94,50 @@ func GetToolDefinition() defs.ToolDefinition {
 				},
 				"query": {
 					Type:        jsonschema.ParamTypeString,
-					Description: "Fuzzy filename to search for",
+					Description: "Fuzzy filename to search for, space separated terms must all match. A glob or regular expression in glob and regex mode. May be empty when filters are given.",
+				},
+				"mode": {
+					Type:        jsonschema.ParamTypeString,
+					Description: "fuzzy (default), glob (e.g. '*.go', 'api/**/*.proto'; a pattern without / matches file names) or regex (Go syntax, matched against the relative path).",
+				},
+				"type": {
+					Type:        jsonschema.ParamTypeString,
+					Description: "Entries to return: file (default, symlinks to files included), dir, symlink or any.",
+				},
+				"extensions": {
+					Type:        jsonschema.ParamTypeArray,
+					Description: "Only return entries with one of these extensions, e.g. ['go', 'proto'].",
+					Items: &jsonschema.JsonSchema{
+						Type: jsonschema.ParamTypeString,
+					},
+				},
+				"min_size": {
+					Type:        jsonschema.ParamTypeString,
+					Description: "Minimum size, in bytes or with a unit: 512, 100KB, 1.5MB (units are powers of 1024).",
+				},
+				"max_size": {
+					Type:        jsonschema.ParamTypeString,
+					Description: "Maximum size, in bytes or with a unit: 512, 100KB, 1.5MB.",
+				},
+				"modified_after": {
+					Type:        jsonschema.ParamTypeString,
+					Description: "Only entries modified after this: an age such as 30m, 24h, 7d or 2w, a date such as 2024-05-01, or an RFC 3339 time.",
+				},
+				"modified_before": {
+					Type:        jsonschema.ParamTypeString,
+					Description: "Only entries modified before this, in the same formats as modified_after.",
+				},
+				"sort_by": {
+					Type:        jsonschema.ParamTypeString,
+					Description: "score (default for fuzzy queries), path (default otherwise), size (largest first) or mtime (most recent first).",
+				},
+				"include_metadata": {
+					Type:        jsonschema.ParamTypeBoolean,
+					Description: "Return the type, size and modification time of each match.",
+				},
+				"limit": {
+					Type:        jsonschema.ParamTypeNumber,
+					Description: "Maximum number of results to return. Defaults to 10.",
 				},
 				"explanation": {
 					Type:        jsonschema.ParamTypeString,
@@ -70,40 +160,73 @@ func FileSearch(req FileSearchRequest) (*FileSearchResponse, error) {
 		return nil, fmt.Errorf("workspace root does not exist: %s", req.WorkspaceRoot)
 	}
 
-	var matches []FileSearchMatch
+	filter, err := newFilter(req, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	match, err := newMatcher(req.Mode, req.Query, filter.active())
+	if err != nil {
+		return nil, err
+	}
+	sortBy := req.SortBy
+	switch sortBy {
+	case "":
+		sortBy = SORT_PATH
+		if (req.Mode == "" || req.Mode == MODE_FUZZY) && strings.TrimSpace(req.Query) != "" {
+			sortBy = SORT_SCORE
+		}
+	case SORT_SCORE, SORT_PATH, SORT_SIZE, SORT_MTIME:
+	default:
+		return nil, fmt.Errorf("unsupported sort_by: %s, expected %s, %s, %s or %s", req.SortBy, SORT_SCORE, SORT_PATH, SORT_SIZE, SORT_MTIME)
+	}
+	needsInfo := filter.needsInfo() || req.IncludeMetadata || sortBy == SORT_SIZE || sortBy == SORT_MTIME
+
+	type found struct {
+		match   FileSearchMatch
+		size    int64
+		modTime time.Time
+	}
+	var results []found
 
-	// Walk through all files in the workspace
-	err = filepath.Walk(searchPath, func(path string, info os.FileInfo, err error) error {
+	// Walk through all files in the workspace, skipping .git, gitignored entries and skipDirs
+	err = walk.Walk(searchPath, walk.Options{}, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
-
-		if info.IsDir() {
-			// Skip common directories that are not useful for file search
-			dirName := filepath.Base(path)
-			if dirName == ".git" || dirName == "node_modules" || dirName == ".vscode" ||
-				dirName == "dist" || dirName == "build" || dirName == "target" ||
-				dirName == "vendor" || dirName == ".idea" || dirName == ".DS_Store" {
-				return filepath.SkipDir
-			}
+		if d.IsDir() && path != searchPath && skipDirs[d.Name()] {
+			return filepath.SkipDir
+		}
+		if path == searchPath || !filter.matchEntry(path, d) {
 			return nil
 		}
 
-		// Calculate fuzzy match score
-		score := calculateFuzzyScore(path, req.Query, searchPath)
-		if score > 0 {
-			// Make path relative to workspace root for display
-			relPath, err := filepath.Rel(searchPath, path)
-			if err != nil {
-				relPath = path
+		// Make path relative to workspace root for matching and display
+		relPath, err := filepath.Rel(searchPath, path)
+		if err != nil {
+			relPath = path
+		}
+		score, ok := match(filepath.ToSlash(relPath))
+		if !ok {
+			return nil
+		}
+		result := found{match: FileSearchMatch{File: relPath, Score: float64(score)}}
+		if needsInfo {
+			// the entry itself, symlinks are not followed
+			info, err := d.Info()
+			if err != nil || !filter.matchInfo(info) {
+				return nil
 			}
-
-			matches = append(matches, FileSearchMatch{
-				File:  relPath,
-				Score: score,
-			})
+			result.size, result.modTime = info.Size(), info.ModTime()
 		}
-
+		if req.IncludeMetadata || filter.typ != TYPE_FILE {
+			result.match.Type = entryType(d)
+		}
+		if req.IncludeMetadata {
+			size := result.size
+			result.match.Size = &size
+			result.match.ModTime = result.modTime.Format(time.RFC3339)
+		}
+		results = append(results, result)
 		return nil
 	})
 
@@ -111,196 +234,91 @@ func FileSearch(req FileSearchRequest) (*FileSearchResponse, error) {
 		return nil, fmt.Errorf("error walking directory: %w", err)
 	}
 
-	// Sort by score (descending) and limit to 10 results
-	sort.Slice(matches, func(i, j int) bool {
-		return matches[i].Score > matches[j].Score
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		switch sortBy {
+		case SORT_SCORE:
+			if a.match.Score != b.match.Score {
+				return a.match.Score > b.match.Score
+			}
+			// then shorter paths, like fzf
+			if len(a.match.File) != len(b.match.File) {
+				return len(a.match.File) < len(b.match.File)
+			}
+		case SORT_SIZE:
+			if a.size != b.size {
+				return a.size > b.size
+			}
+		case SORT_MTIME:
+			if !a.modTime.Equal(b.modTime) {
+				return a.modTime.After(b.modTime)
+			}
+		}
+		return a.match.File < b.match.File
 	})
 
-	truncated := len(matches) > 10
+	totalMatches := len(results)
+	limit := req.Limit
+	if limit <= 0 {
+		limit = DEFAULT_LIMIT
+	}
+	truncated := len(results) > limit
 	if truncated {
-		matches = matches[:10]
+		results = results[:limit]
+	}
+	matches := make([]FileSearchMatch, len(results))
+	for i, result := range results {
+		matches[i] = result.match
 	}
 
 	return &FileSearchResponse{
-		TotalMatches: len(matches),
+		TotalMatches: totalMatches,
 		Matches:      matches,
 		Truncated:    truncated,
 	}, nil
 }
 
-// calculateFuzzyScore calculates a fuzzy match score for a file path
-func calculateFuzzyScore(filePath, query, workspaceRoot string) float64 {
-	// Make path relative to workspace root for scoring
-	relPath, err := filepath.Rel(workspaceRoot, filePath)
-	if err != nil {
-		relPath = filePath
-	}
-
-	// Normalize paths for comparison
-	relPath = filepath.ToSlash(relPath)
-	query = strings.ToLower(query)
-	relPathLower := strings.ToLower(relPath)
-
-	// Exact match gets highest score
-	if relPathLower == query {
-		return 1.0
-	}
-
-	// Filename exact match gets very high score
-	filename := filepath.Base(relPath)
-	filenameLower := strings.ToLower(filename)
-	if filenameLower == query {
-		return 0.9
-	}
-
-	// Filename contains query gets high score
-	if strings.Contains(filenameLower, query) {
-		return 0.8
-	}
-
-	// Path contains query gets good score
-	if strings.Contains(relPathLower, query) {
-		return 0.7
-	}
-
-	// Try fuzzy matching
-	fuzzyScore := fuzzyMatch(relPathLower, query)
-	if fuzzyScore > 0.3 {
-		return fuzzyScore * 0.6 // Scale down fuzzy matches
-	}
-
-	return 0
-}
-
-// fuzzyMatch performs fuzzy string matching
-func fuzzyMatch(text, pattern string) float64 {
-	if pattern == "" {
-		return 0
-	}
-
-	if text == pattern {
-		return 1.0
-	}
-
-	// Simple fuzzy matching: check if all characters in pattern appear in order in text
-	textRunes := []rune(text)
-	patternRunes := []rune(pattern)
-
-	if len(patternRunes) > len(textRunes) {
-		return 0
-	}
-
-	textIndex := 0
-	matchedChars := 0
-
-	for _, patternChar := range patternRunes {
-		found := false
-		for textIndex < len(textRunes) {
-			if textRunes[textIndex] == patternChar {
-				matchedChars++
-				textIndex++
-				found = true
-				break
-			}
-			textIndex++
-		}
-		if !found {
-			return 0
+// newMatcher returns the function matching slash separated relative paths
+// for the mode, with the fuzzy score of each match. An empty query matches
+// everything when filters select the entries.
+func newMatcher(mode string, query string, filtered bool) (func(path string) (int, bool), error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		if !filtered {
+			return nil, fmt.Errorf("query is required unless extensions, size or modification time filters are given")
 		}
+		return func(path string) (int, bool) { return 0, true }, nil
 	}
-
-	// Score based on how many characters matched and how close they are
-	if matchedChars == len(patternRunes) {
-		// Calculate score based on match density
-		score := float64(matchedChars) / float64(len(textRunes))
-
-		// Bonus for consecutive matches
-		consecutiveBonus := calculateConsecutiveBonus(text, pattern)
-		score += consecutiveBonus * 0.3
-
-		// Bonus for matches at word boundaries
-		wordBoundaryBonus := calculateWordBoundaryBonus(text, pattern)
-		score += wordBoundaryBonus * 0.2
-
-		return score
-	}
-
-	return 0
-}
-
-// calculateConsecutiveBonus calculates bonus for consecutive character matches
-func calculateConsecutiveBonus(text, pattern string) float64 {
-	if pattern == "" {
-		return 0
-	}
-
-	maxConsecutive := 0
-	currentConsecutive := 0
-
-	textIndex := 0
-	for _, patternChar := range pattern {
-		found := false
-		for textIndex < len(text) {
-			if rune(text[textIndex]) == patternChar {
-				if currentConsecutive > 0 {
-					currentConsecutive++
-				} else {
-					currentConsecutive = 1
-				}
-				if currentConsecutive > maxConsecutive {
-					maxConsecutive = currentConsecutive
-				}
-				textIndex++
-				found = true
-				break
-			} else {
-				currentConsecutive = 0
-				textIndex++
-			}
-		}
-		if !found {
-			break
+	switch mode {
+	case "", MODE_FUZZY:
+		terms := queryTerms(query)
+		return func(path string) (int, bool) { return scorePath(path, terms) }, nil
+	case MODE_GLOB:
+		pattern, err := glob.Compile(query)
+		if err != nil {
+			return nil, fmt.Errorf("invalid glob: %w", err)
 		}
-	}
-
-	return float64(maxConsecutive) / float64(len(pattern))
-}
-
-// calculateWordBoundaryBonus calculates bonus for matches at word boundaries
-func calculateWordBoundaryBonus(text, pattern string) float64 {
-	if pattern == "" {
-		return 0
-	}
-
-	// Simple word boundary detection: spaces, slashes, dots, underscores
-	wordBoundaries := []rune{' ', '/', '.', '_', '-'}
-
-	boundaryMatches := 0
-	textRunes := []rune(text)
-
-	for _, patternChar := range pattern {
-		for i, textChar := range textRunes {
-			if textChar == patternChar {
-				// Check if this is at a word boundary
-				if i == 0 || contains(wordBoundaries, textRunes[i-1]) {
-					boundaryMatches++
-				}
-				break
-			}
+		return func(path string) (int, bool) { return 0, pattern.Match(path) }, nil
+	case MODE_REGEX:
+		re, err := regexp.Compile(query)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex: %w", err)
 		}
+		return func(path string) (int, bool) { return 0, re.MatchString(path) }, nil
+	default:
+		return nil, fmt.Errorf("unsupported mode: %s, expected %s, %s or %s", mode, MODE_FUZZY, MODE_GLOB, MODE_REGEX)
 	}
-
-	return float64(boundaryMatches) / float64(len(pattern))
 }
 
-// contains checks if a slice contains a specific rune
-func contains(slice []rune, item rune) bool {
-	for _, s := range slice {
-		if s == item {
-			return true
-		}
+// entryType returns TYPE_FILE, TYPE_DIR or TYPE_SYMLINK
+func entryType(d fs.DirEntry) string {
+	switch {
+	case d.Type()&fs.ModeSymlink != 0:
+		return TYPE_SYMLINK
+	case d.IsDir():
+		return TYPE_DIR
 	}
-	return false
+	return TYPE_FILE
 }
 
 func ParseJSONRequest(jsonInput string) (FileSearchRequest, error) {
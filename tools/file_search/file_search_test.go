@@ -0,0 +1,222 @@
+package file_search
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFuzzyScore(t *testing.T) {
+	score := func(text, pattern string) int {
+		s, ok := fuzzyScore([]rune(text), []rune(pattern))
+		if !ok {
+			t.Fatalf("fuzzyScore(%q, %q) did not match", text, pattern)
+		}
+		return s
+	}
+	if _, ok := fuzzyScore([]rune("main.go"), []rune("mg.")); ok {
+		t.Errorf("out of order pattern matched")
+	}
+	better := []struct {
+		pattern, better, worse string
+	}{
+		// a consecutive run beats scattered characters
+		{"main", "cmd/main.go", "cmd/my_app/input.go"},
+		// segment starts beat the middle of words
+		{"fs", "file_search.go", "refs.go"},
+		// camelCase humps beat letters inside words
+		{"ls", "ListStore.go", "tools.go"},
+		// case does not matter
+		{"ListDir", "list_dir.go", "l_i_s_t_dir.go"},
+	}
+	for _, tt := range better {
+		if b, w := score(tt.better, tt.pattern), score(tt.worse, tt.pattern); b <= w {
+			t.Errorf("%q scores %d in %s and %d in %s, want the first higher", tt.pattern, b, tt.better, w, tt.worse)
+		}
+	}
+}
+
+func TestFileSearch(t *testing.T) {
+	root := t.TempDir()
+	files := []string{
+		"tools/read_file/read_file.go",
+		"tools/read_file/read_file_tool.go",
+		"tools/batch_read_file/batch_read_file.go",
+		"docs/reading/files.md",
+		"build/read_file.go",
+		// skipped without being gitignored
+		"node_modules/read_file/read_file.js",
+		".vscode/read_file.json",
+		".gitignore",
+	}
+	for _, name := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		content := ""
+		if name == ".gitignore" {
+			content = "build/\n"
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	response, err := FileSearch(FileSearchRequest{WorkspaceRoot: root, Query: "read_file", Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, match := range response.Matches {
+		got = append(got, filepath.ToSlash(match.File))
+	}
+	want := []string{"tools/read_file/read_file.go", "tools/read_file/read_file_tool.go"}
+	if !reflect.DeepEqual(got, want) || response.TotalMatches != 3 || !response.Truncated {
+		t.Errorf("search = %v of %d, truncated %v, want %v of 3, truncated", got, response.TotalMatches, response.Truncated, want)
+	}
+
+	// every term must match
+	response, err = FileSearch(FileSearchRequest{WorkspaceRoot: root, Query: "batch file"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.TotalMatches != 1 || filepath.ToSlash(response.Matches[0].File) != "tools/batch_read_file/batch_read_file.go" {
+		t.Errorf("search for batch file = %+v, want batch_read_file.go only", response.Matches)
+	}
+
+	if _, err := FileSearch(FileSearchRequest{WorkspaceRoot: root, Query: "  "}); err == nil {
+		t.Errorf("empty query succeeded, want error")
+	}
+}
+
+func TestFileSearchFilters(t *testing.T) {
+	root := t.TempDir()
+	now := time.Now()
+	files := []struct {
+		name string
+		size int
+		age  time.Duration
+	}{
+		{"api/v1/user.proto", 100, time.Hour},
+		{"api/v1/old.proto", 100, 72 * time.Hour},
+		{"api/README.md", 10, time.Hour},
+		{"big.go", 200 << 10, 48 * time.Hour},
+		{"small.go", 10, time.Hour},
+	}
+	for _, file := range files {
+		path := filepath.Join(root, filepath.FromSlash(file.name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, make([]byte, file.size), 0644); err != nil {
+			t.Fatal(err)
+		}
+		mtime := now.Add(-file.age)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	search := func(req FileSearchRequest) []string {
+		t.Helper()
+		req.WorkspaceRoot = root
+		response, err := FileSearch(req)
+		if err != nil {
+			t.Fatalf("FileSearch(%+v) failed: %v", req, err)
+		}
+		var got []string
+		for _, match := range response.Matches {
+			got = append(got, filepath.ToSlash(match.File))
+		}
+		return got
+	}
+	tests := []struct {
+		name string
+		req  FileSearchRequest
+		want []string
+	}{
+		{"glob recent", FileSearchRequest{Query: "api/**/*.proto", Mode: MODE_GLOB, ModifiedAfter: "1d"}, []string{"api/v1/user.proto"}},
+		{"glob base name", FileSearchRequest{Query: "*.proto", Mode: MODE_GLOB}, []string{"api/v1/old.proto", "api/v1/user.proto"}},
+		{"regex", FileSearchRequest{Query: `^[a-z]+\.go$`, Mode: MODE_REGEX}, []string{"big.go", "small.go"}},
+		{"extension and size", FileSearchRequest{Extensions: []string{".GO"}, MinSize: "100KB"}, []string{"big.go"}},
+		{"max size", FileSearchRequest{Extensions: []string{"md", "go"}, MaxSize: "10"}, []string{"api/README.md", "small.go"}},
+		{"modified before", FileSearchRequest{Query: "proto", ModifiedBefore: "2d"}, []string{"api/v1/old.proto"}},
+		{"sort by size", FileSearchRequest{Mode: MODE_GLOB, Query: "*", SortBy: SORT_SIZE, Limit: 2}, []string{"big.go", "api/v1/old.proto"}},
+		{"sort by mtime", FileSearchRequest{Extensions: []string{"go"}, SortBy: SORT_MTIME}, []string{"small.go", "big.go"}},
+		{"dirs", FileSearchRequest{Query: "v1", Type: TYPE_DIR}, []string{"api/v1"}},
+	}
+	for _, tt := range tests {
+		if got := search(tt.req); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	response, err := FileSearch(FileSearchRequest{WorkspaceRoot: root, Query: "small", IncludeMetadata: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	match := response.Matches[0]
+	if match.Type != TYPE_FILE || match.Size == nil || *match.Size != 10 || match.ModTime == "" {
+		t.Errorf("metadata = %+v, want a 10 byte file with its mtime", match)
+	}
+
+	for _, req := range []FileSearchRequest{
+		{Query: "x", Mode: "exact"},
+		{Query: "[", Mode: MODE_GLOB},
+		{Query: "(", Mode: MODE_REGEX},
+		{Query: "x", MinSize: "big"},
+		{Query: "x", ModifiedAfter: "yesterday"},
+		{Query: "x", SortBy: "name"},
+		{Query: "x", Type: "fifo"},
+		{},
+	} {
+		req.WorkspaceRoot = root
+		if _, err := FileSearch(req); err == nil {
+			t.Errorf("FileSearch(%+v) succeeded, want error", req)
+		}
+	}
+}
+
+func TestFileSearchSymlinks(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "file.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, "file.txt"), filepath.Join(root, "link_file")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, "dir"), filepath.Join(root, "link_dir")); err != nil {
+		t.Fatal(err)
+	}
+
+	for typ, want := range map[string][]string{
+		TYPE_FILE:    {"link_file"},
+		TYPE_SYMLINK: {"link_dir", "link_file"},
+	} {
+		response, err := FileSearch(FileSearchRequest{WorkspaceRoot: root, Query: "link_*", Mode: MODE_GLOB, Type: typ})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, match := range response.Matches {
+			got = append(got, filepath.ToSlash(match.File))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("type %s: got %v, want %v", typ, got, want)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for input, want := range map[string]int64{"512": 512, "100KB": 100 << 10, "1.5m": 3 << 19, "2 GiB": 2 << 30, "7b": 7} {
+		if got, err := parseSize(input); err != nil || got != want {
+			t.Errorf("parseSize(%q) = %d, %v, want %d", input, got, err, want)
+		}
+	}
+}
@@ -11,23 +11,56 @@ import (
 const help = `
 llm-tools file_search performs fast fuzzy file search based on file path
 
-Usage: llm-tools file_search <query> [OPTIONS]
+Usage: llm-tools file_search [query] [OPTIONS]
 
 Options:
   --workspace-root <path>      workspace root directory (defaults to current directory)
+  --mode <mode>                fuzzy (default), glob or regex
+  --type <type>                file (default), dir, symlink or any
+  --ext <ext>                  only entries with this extension, repeatable
+  --min-size <size>            minimum size, e.g. 100KB
+  --max-size <size>            maximum size, e.g. 1MB
+  --modified-after <when>      modified after an age (24h, 7d), a date or an RFC 3339 time
+  --modified-before <when>     modified before an age, a date or an RFC 3339 time
+  --sort <order>               score, path, size or mtime
+  -l,--long                    show type, size and modification time
+  --limit <num>                maximum number of results (default 10)
   --explanation <text>         explanation for the operation
 
 Examples:
   llm-tools file_search "main.go"
   llm-tools file_search "user" --workspace-root /path/to/workspace
   llm-tools file_search "test.js"
+  llm-tools file_search "auth handler" --limit 20
+  llm-tools file_search "api/**/*.proto" --mode glob --modified-after 1d
+  llm-tools file_search --ext go --min-size 100KB --sort size -l
 `
 
 func HandleCli(args []string) error {
 	var workspaceRoot string
+	var mode string
+	var typ string
+	var extensions []string
+	var minSize string
+	var maxSize string
+	var modifiedAfter string
+	var modifiedBefore string
+	var sortBy string
+	var long bool
+	var limit int
 	var explanation string
 
 	args, err := flags.String("--workspace-root", &workspaceRoot).
+		String("--mode", &mode).
+		String("--type", &typ).
+		StringSlice("--ext", &extensions).
+		String("--min-size", &minSize).
+		String("--max-size", &maxSize).
+		String("--modified-after", &modifiedAfter).
+		String("--modified-before", &modifiedBefore).
+		String("--sort", &sortBy).
+		Bool("-l,--long", &long).
+		Int("--limit", &limit).
 		String("--explanation", &explanation).
 		Help("-h,--help", help).
 		Parse(args)
@@ -35,15 +68,14 @@ func HandleCli(args []string) error {
 		return err
 	}
 
-	if len(args) == 0 {
-		return fmt.Errorf("search query is required")
-	}
-
 	if len(args) > 1 {
 		return fmt.Errorf("unrecognized extra arguments: %v", strings.Join(args[1:], ","))
 	}
 
-	query := args[0]
+	var query string
+	if len(args) > 0 {
+		query = args[0]
+	}
 
 	// Use current working directory if workspace_root is not provided
 	if workspaceRoot == "" {
@@ -54,9 +86,19 @@ func HandleCli(args []string) error {
 	}
 
 	req := FileSearchRequest{
-		WorkspaceRoot: workspaceRoot,
-		Query:         query,
-		Explanation:   explanation,
+		WorkspaceRoot:   workspaceRoot,
+		Query:           query,
+		Mode:            mode,
+		Type:            typ,
+		Extensions:      extensions,
+		MinSize:         minSize,
+		MaxSize:         maxSize,
+		ModifiedAfter:   modifiedAfter,
+		ModifiedBefore:  modifiedBefore,
+		SortBy:          sortBy,
+		IncludeMetadata: long,
+		Limit:           limit,
+		Explanation:     explanation,
 	}
 
 	response, err := FileSearch(req)
@@ -68,7 +110,7 @@ func HandleCli(args []string) error {
 	fmt.Printf("Search query: %s\n", req.Query)
 	fmt.Printf("Total matches: %d", response.TotalMatches)
 	if response.Truncated {
-		fmt.Printf(" (truncated to 10)")
+		fmt.Printf(" (showing %d)", len(response.Matches))
 	}
 	fmt.Println()
 	fmt.Println()
@@ -79,7 +121,14 @@ func HandleCli(args []string) error {
 	}
 
 	for _, match := range response.Matches {
-		fmt.Printf("%s (score: %.2f)\n", match.File, match.Score)
+		if long {
+			fmt.Printf("%-7s %10d  %s  ", match.Type, *match.Size, match.ModTime)
+		}
+		fmt.Printf("%s", match.File)
+		if match.Score != 0 {
+			fmt.Printf(" (score: %g)", match.Score)
+		}
+		fmt.Println()
 	}
 
 	return nil
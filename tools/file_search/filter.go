@@ -0,0 +1,205 @@
+package file_search
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Search modes
+const (
+	MODE_FUZZY = "fuzzy"
+	MODE_GLOB  = "glob"
+	MODE_REGEX = "regex"
+)
+
+// Entry types
+const (
+	// TYPE_FILE is every entry but directories, symlinks included
+	TYPE_FILE    = "file"
+	TYPE_DIR     = "dir"
+	TYPE_SYMLINK = "symlink"
+	TYPE_ANY     = "any"
+)
+
+// Sort orders
+const (
+	SORT_SCORE = "score"
+	SORT_PATH  = "path"
+	// SORT_SIZE and SORT_MTIME put the largest and most recent first
+	SORT_SIZE  = "size"
+	SORT_MTIME = "mtime"
+)
+
+// filter holds the parsed metadata filters of a request
+type filter struct {
+	typ            string
+	extensions     map[string]bool
+	minSize        int64
+	maxSize        int64
+	modifiedAfter  time.Time
+	modifiedBefore time.Time
+}
+
+func newFilter(req FileSearchRequest, now time.Time) (*filter, error) {
+	f := &filter{typ: req.Type, minSize: -1, maxSize: -1}
+	switch f.typ {
+	case "":
+		f.typ = TYPE_FILE
+	case TYPE_FILE, TYPE_DIR, TYPE_SYMLINK, TYPE_ANY:
+	default:
+		return nil, fmt.Errorf("unsupported type: %s, expected %s, %s, %s or %s", req.Type, TYPE_FILE, TYPE_DIR, TYPE_SYMLINK, TYPE_ANY)
+	}
+	for _, ext := range req.Extensions {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if f.extensions == nil {
+			f.extensions = make(map[string]bool)
+		}
+		f.extensions["."+strings.TrimPrefix(ext, ".")] = true
+	}
+	var err error
+	if req.MinSize != "" {
+		if f.minSize, err = parseSize(req.MinSize); err != nil {
+			return nil, fmt.Errorf("invalid min_size: %w", err)
+		}
+	}
+	if req.MaxSize != "" {
+		if f.maxSize, err = parseSize(req.MaxSize); err != nil {
+			return nil, fmt.Errorf("invalid max_size: %w", err)
+		}
+	}
+	if req.ModifiedAfter != "" {
+		if f.modifiedAfter, err = parseTime(req.ModifiedAfter, now); err != nil {
+			return nil, fmt.Errorf("invalid modified_after: %w", err)
+		}
+	}
+	if req.ModifiedBefore != "" {
+		if f.modifiedBefore, err = parseTime(req.ModifiedBefore, now); err != nil {
+			return nil, fmt.Errorf("invalid modified_before: %w", err)
+		}
+	}
+	return f, nil
+}
+
+// active reports whether the filter restricts anything beyond the type
+func (f *filter) active() bool {
+	return f.extensions != nil || f.minSize >= 0 || f.maxSize >= 0 || !f.modifiedAfter.IsZero() || !f.modifiedBefore.IsZero()
+}
+
+// needsInfo reports whether the filter needs the size or mtime of entries
+func (f *filter) needsInfo() bool {
+	return f.minSize >= 0 || f.maxSize >= 0 || !f.modifiedAfter.IsZero() || !f.modifiedBefore.IsZero()
+}
+
+// matchEntry checks the type and extension of an entry
+func (f *filter) matchEntry(path string, d fs.DirEntry) bool {
+	isDir := d.IsDir()
+	isSymlink := d.Type()&fs.ModeSymlink != 0
+	switch f.typ {
+	case TYPE_FILE:
+		if isDir {
+			return false
+		}
+		if isSymlink {
+			// links count as files unless they point to a directory
+			if info, err := os.Stat(path); err == nil && info.IsDir() {
+				return false
+			}
+		}
+	case TYPE_DIR:
+		if !isDir {
+			return false
+		}
+	case TYPE_SYMLINK:
+		if !isSymlink {
+			return false
+		}
+	}
+	if f.extensions != nil && !f.extensions[strings.ToLower(filepath.Ext(path))] {
+		return false
+	}
+	return true
+}
+
+// matchInfo checks the size and mtime of an entry
+func (f *filter) matchInfo(info fs.FileInfo) bool {
+	if f.minSize >= 0 && info.Size() < f.minSize {
+		return false
+	}
+	if f.maxSize >= 0 && info.Size() > f.maxSize {
+		return false
+	}
+	if !f.modifiedAfter.IsZero() && info.ModTime().Before(f.modifiedAfter) {
+		return false
+	}
+	if !f.modifiedBefore.IsZero() && !info.ModTime().Before(f.modifiedBefore) {
+		return false
+	}
+	return true
+}
+
+// parseSize parses a size such as 512, 100KB or 1.5M. Units are powers of
+// 1024 and case insensitive, B, K, KB, KiB and so on up to G.
+func parseSize(s string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	unit := int64(1)
+	for _, u := range []struct {
+		suffixes []string
+		size     int64
+	}{
+		{[]string{"GIB", "GB", "G"}, 1 << 30},
+		{[]string{"MIB", "MB", "M"}, 1 << 20},
+		{[]string{"KIB", "KB", "K"}, 1 << 10},
+		{[]string{"B"}, 1},
+	} {
+		found := false
+		for _, suffix := range u.suffixes {
+			if strings.HasSuffix(value, suffix) {
+				value = strings.TrimSpace(strings.TrimSuffix(value, suffix))
+				unit = u.size
+				found = true
+				break
+			}
+		}
+		if found {
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%q is not a size such as 512, 100KB or 1.5MB", s)
+	}
+	return int64(n * float64(unit)), nil
+}
+
+// parseTime parses a point in time: an age relative to now such as 30m, 24h,
+// 7d or 2w, a date such as 2024-05-01, or an RFC 3339 time
+func parseTime(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if n := len(s); n > 1 && (s[n-1] == 'd' || s[n-1] == 'w') {
+		if count, err := strconv.ParseFloat(s[:n-1], 64); err == nil && count >= 0 {
+			day := 24 * time.Hour
+			if s[n-1] == 'w' {
+				day *= 7
+			}
+			return now.Add(-time.Duration(count * float64(day))), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%q is not an age such as 24h or 7d, a date such as 2024-05-01 or an RFC 3339 time", s)
+}
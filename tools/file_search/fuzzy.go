@@ -0,0 +1,257 @@
+package file_search
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Scores of the fuzzy matcher, the same as fzf's: a matched character is
+// worth scoreMatch, a gap costs scoreGapStart for its first character and
+// scoreGapExtension for the next ones, and characters at word boundaries,
+// camelCase humps or right after a path separator earn a bonus.
+const (
+	scoreMatch        = 16
+	scoreGapStart     = -3
+	scoreGapExtension = -1
+
+	// bonusBoundary is earned by a character after a non-word character such as _ - or .
+	bonusBoundary = scoreMatch / 2
+	// bonusBoundaryWhite is earned at the start of the text or after a space
+	bonusBoundaryWhite = bonusBoundary + 2
+	// bonusBoundaryDelimiter is earned after a path separator, at the start of a segment
+	bonusBoundaryDelimiter = bonusBoundary + 1
+	bonusNonWord           = scoreMatch / 2
+	// bonusCamel123 is earned by an upper case letter after a lower case one,
+	// or a digit after a non digit
+	bonusCamel123 = bonusBoundary + scoreGapExtension
+	// bonusConsecutive keeps a run of matched characters together: it makes
+	// up for the gap a split match would cost
+	bonusConsecutive = -(scoreGapStart + scoreGapExtension)
+	// bonusFirstCharMultiplier weighs the bonus of the first matched character
+	bonusFirstCharMultiplier = 2
+	// bonusBasename is earned per character of a term matched within the file name
+	bonusBasename = 2
+)
+
+type charClass int
+
+const (
+	charWhite charClass = iota
+	charNonWord
+	charDelimiter
+	charLower
+	charUpper
+	charLetter
+	charNumber
+)
+
+// invalidScore marks alignments that are impossible
+const invalidScore = -1 << 30
+
+func classOf(r rune) charClass {
+	switch {
+	case r >= 'a' && r <= 'z':
+		return charLower
+	case r >= 'A' && r <= 'Z':
+		return charUpper
+	case r >= '0' && r <= '9':
+		return charNumber
+	case r == '/' || r == '\\':
+		return charDelimiter
+	case r == ' ' || r == '\t':
+		return charWhite
+	case r < 128:
+		return charNonWord
+	case unicode.IsLower(r):
+		return charLower
+	case unicode.IsUpper(r):
+		return charUpper
+	case unicode.IsLetter(r):
+		return charLetter
+	case unicode.IsNumber(r):
+		return charNumber
+	case unicode.IsSpace(r):
+		return charWhite
+	}
+	return charNonWord
+}
+
+// bonusFor is the bonus of a character of class after one of class prev
+func bonusFor(prev charClass, class charClass) int {
+	if class > charDelimiter {
+		switch prev {
+		case charWhite:
+			return bonusBoundaryWhite
+		case charDelimiter:
+			return bonusBoundaryDelimiter
+		case charNonWord:
+			return bonusBoundary
+		}
+	}
+	if prev == charLower && class == charUpper || prev != charNumber && class == charNumber {
+		return bonusCamel123
+	}
+	switch class {
+	case charNonWord, charDelimiter:
+		return bonusNonWord
+	case charWhite:
+		return bonusBoundaryWhite
+	}
+	return 0
+}
+
+// fuzzyScore scores the best alignment of pattern as a subsequence of text,
+// the fzf v2 algorithm: a Smith-Waterman style dynamic program over every
+// position each pattern character can match at. Matching is case
+// insensitive, queries often spell ListDir what is named list_dir. ok is
+// false if pattern is not a subsequence of text.
+func fuzzyScore(text []rune, pattern []rune) (score int, ok bool) {
+	if len(pattern) == 0 || len(pattern) > len(text) {
+		return 0, false
+	}
+	fold := unicode.ToLower
+
+	// first is the earliest position each pattern character can match at,
+	// which also rejects texts that do not contain the pattern
+	first := make([]int, len(pattern))
+	j := 0
+	for i, p := range pattern {
+		p = fold(p)
+		for j < len(text) && fold(text[j]) != p {
+			j++
+		}
+		if j == len(text) {
+			return 0, false
+		}
+		first[i] = j
+		j++
+	}
+
+	bonus := make([]int, len(text))
+	prev := charWhite
+	for j, r := range text {
+		class := classOf(r)
+		bonus[j] = bonusFor(prev, class)
+		prev = class
+	}
+
+	// h[j] is the best score of the pattern so far with its last character
+	// matched at or before j, c[j] the length of the run of consecutive
+	// matches ending at j
+	n := len(text)
+	h := make([]int, n)
+	c := make([]int, n)
+	prevH := make([]int, n)
+	prevC := make([]int, n)
+	for i, p := range pattern {
+		p = fold(p)
+		inGap := false
+		for j := 0; j < n; j++ {
+			h[j], c[j] = invalidScore, 0
+			if j < first[i] {
+				continue
+			}
+			s2 := invalidScore
+			if j > 0 && h[j-1] != invalidScore {
+				if inGap {
+					s2 = h[j-1] + scoreGapExtension
+				} else {
+					s2 = h[j-1] + scoreGapStart
+				}
+			}
+			s1 := invalidScore
+			consecutive := 0
+			if fold(text[j]) == p {
+				b := bonus[j]
+				if i == 0 {
+					s1 = scoreMatch + b*bonusFirstCharMultiplier
+					consecutive = 1
+				} else if j > 0 && prevH[j-1] != invalidScore {
+					consecutive = prevC[j-1] + 1
+					if consecutive > 1 {
+						// a run keeps the bonus of its first character, unless
+						// this one starts a better boundary
+						fb := bonus[j-consecutive+1]
+						if b >= bonusBoundary && b > fb {
+							consecutive = 1
+						} else {
+							b = max3(b, bonusConsecutive, fb)
+						}
+					}
+					s1 = prevH[j-1] + scoreMatch
+					if s1+b < s2 {
+						s1 += bonus[j]
+						consecutive = 0
+					} else {
+						s1 += b
+					}
+				}
+			}
+			if s1 == invalidScore && s2 == invalidScore {
+				continue
+			}
+			inGap = s1 < s2
+			if inGap {
+				h[j] = s2
+			} else {
+				h[j], c[j] = s1, consecutive
+			}
+		}
+		h, prevH = prevH, h
+		c, prevC = prevC, c
+	}
+
+	// prevH holds the last row: the best score is at the last matched
+	// character, trailing gaps are not penalized
+	best := invalidScore
+	last := fold(pattern[len(pattern)-1])
+	for j := first[len(pattern)-1]; j < n; j++ {
+		if prevH[j] > best && fold(text[j]) == last && prevC[j] > 0 {
+			best = prevH[j]
+		}
+	}
+	if best == invalidScore {
+		return 0, false
+	}
+	return best, true
+}
+
+// scorePath scores a slash separated path against the space separated terms
+// of a query, all of which must match. A term matching within the file name
+// scores as if the rest of the path were not there, plus bonusBasename per
+// character, so that file names win over matches spread across directories.
+func scorePath(path string, terms [][]rune) (int, bool) {
+	text := []rune(path)
+	base := []rune(path[strings.LastIndex(path, "/")+1:])
+	total := 0
+	for _, term := range terms {
+		score, ok := fuzzyScore(text, term)
+		if !ok {
+			return 0, false
+		}
+		if baseScore, ok := fuzzyScore(base, term); ok && baseScore+bonusBasename*len(term) > score {
+			score = baseScore + bonusBasename*len(term)
+		}
+		total += score
+	}
+	return total, true
+}
+
+// queryTerms splits a query at spaces into the terms to match
+func queryTerms(query string) [][]rune {
+	var terms [][]rune
+	for _, field := range strings.Fields(query) {
+		terms = append(terms, []rune(field))
+	}
+	return terms
+}
+
+func max3(a, b, c int) int {
+	if b > a {
+		a = b
+	}
+	if c > a {
+		a = c
+	}
+	return a
+}
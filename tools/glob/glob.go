@@ -0,0 +1,235 @@
+package glob
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Pattern is a compiled glob pattern.
+//
+// Supported syntax:
+//
+//	"*"      any sequence of characters except '/'
+//	"?"      any single character except '/'
+//	"**"     any sequence of path segments, including none (e.g. "**/*.go", "cmd/**")
+//	"[abc]"  character class, [!abc] or [^abc] negates it
+//	"{a,b}"  alternatives, may be nested
+//	"\x"     escapes x
+//
+// Like ripgrep and gitignore, a pattern without a '/' matches the base name
+// of a path at any depth, while a pattern containing a '/' is matched against
+// the whole relative path. A leading '/' anchors the pattern to the root.
+type Pattern struct {
+	raw      string
+	re       *regexp.Regexp
+	basename bool
+}
+
+// Compile parses a glob pattern
+func Compile(pattern string) (*Pattern, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("empty glob pattern")
+	}
+	p := pattern
+	anchored := strings.HasPrefix(p, "/")
+	if anchored {
+		p = strings.TrimPrefix(p, "/")
+	}
+	basename := !anchored && !strings.Contains(p, "/")
+
+	expr, err := ToRegexp(p)
+	if err != nil {
+		return nil, fmt.Errorf("invalid glob pattern %q: %w", pattern, err)
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid glob pattern %q: %w", pattern, err)
+	}
+	return &Pattern{
+		raw:      pattern,
+		re:       re,
+		basename: basename,
+	}, nil
+}
+
+// MustCompile is like Compile but panics on error
+func MustCompile(pattern string) *Pattern {
+	p, err := Compile(pattern)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// CompileAll compiles a list of patterns, skipping empty ones
+func CompileAll(patterns []string) ([]*Pattern, error) {
+	var result []*Pattern
+	for _, pattern := range patterns {
+		if pattern == "" {
+			continue
+		}
+		p, err := Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, p)
+	}
+	return result, nil
+}
+
+// String returns the original pattern
+func (p *Pattern) String() string {
+	return p.raw
+}
+
+// Match reports whether the slash-separated relative path matches the pattern
+func (p *Pattern) Match(path string) bool {
+	path = strings.TrimPrefix(path, "./")
+	if p.basename {
+		if idx := strings.LastIndex(path, "/"); idx >= 0 {
+			path = path[idx+1:]
+		}
+	}
+	return p.re.MatchString(path)
+}
+
+// MatchAny reports whether path matches any of the patterns
+func MatchAny(patterns []*Pattern, path string) bool {
+	for _, p := range patterns {
+		if p.Match(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match compiles pattern and matches it against path
+func Match(pattern string, path string) (bool, error) {
+	p, err := Compile(pattern)
+	if err != nil {
+		return false, err
+	}
+	return p.Match(path), nil
+}
+
+// HasMeta reports whether s contains any glob meta characters
+func HasMeta(s string) bool {
+	return strings.ContainsAny(s, "*?[{\\")
+}
+
+// StaticPrefix returns the leading directory of pattern that contains no meta
+// characters, which is where a walk for the pattern can start.
+// For "tools/**/*.go" it returns "tools", for "*.go" it returns "".
+func StaticPrefix(pattern string) string {
+	segments := strings.Split(pattern, "/")
+	var prefix []string
+	for i, seg := range segments {
+		// the last segment is the file part, never a directory to start from
+		if i == len(segments)-1 || HasMeta(seg) {
+			break
+		}
+		prefix = append(prefix, seg)
+	}
+	return strings.Join(prefix, "/")
+}
+
+// ToRegexp translates a glob pattern (without the leading '/' anchor) into an
+// anchored regular expression matched against slash-separated paths
+func ToRegexp(pattern string) (string, error) {
+	var b strings.Builder
+	b.WriteString("^")
+
+	depth := 0 // nesting of {...}
+	n := len(pattern)
+	for i := 0; i < n; i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			if i+1 < n && pattern[i+1] == '*' {
+				// "**" is only special as a whole path segment
+				atStart := i == 0 || pattern[i-1] == '/'
+				j := i + 2
+				atEnd := j == n || pattern[j] == '/'
+				if atStart && atEnd {
+					if j == n {
+						if i == 0 {
+							// "**" alone matches everything
+							b.WriteString(".*")
+						} else {
+							// "dir/**" matches everything inside dir
+							b.WriteString(".+")
+						}
+						i = j - 1
+					} else {
+						// "**/" matches zero or more directories
+						b.WriteString("(?:.*/)?")
+						i = j
+					}
+					continue
+				}
+				// a**b behaves like a*b
+				b.WriteString("[^/]*")
+				i++
+				continue
+			}
+			b.WriteString("[^/]*")
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated character class")
+			}
+			// allow "]" as the first character of the class
+			if end == 0 {
+				next := strings.IndexByte(pattern[i+2:], ']')
+				if next < 0 {
+					return "", fmt.Errorf("unterminated character class")
+				}
+				end = next + 1
+			}
+			class := pattern[i+1 : i+1+end]
+			b.WriteString("[")
+			if strings.HasPrefix(class, "!") || strings.HasPrefix(class, "^") {
+				// like * and ?, a negated class never matches the separator
+				b.WriteString("^/")
+				class = class[1:]
+			}
+			class = strings.ReplaceAll(class, `\`, `\\`)
+			b.WriteString(strings.ReplaceAll(class, "]", `\]`))
+			b.WriteString("]")
+			i += end + 1
+		case '{':
+			depth++
+			b.WriteString("(?:")
+		case '}':
+			if depth == 0 {
+				b.WriteString(`\}`)
+				continue
+			}
+			depth--
+			b.WriteString(")")
+		case ',':
+			if depth > 0 {
+				b.WriteString("|")
+			} else {
+				b.WriteString(",")
+			}
+		case '\\':
+			if i+1 < n {
+				i++
+				b.WriteString(regexp.QuoteMeta(string(pattern[i])))
+			} else {
+				b.WriteString(`\\`)
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	if depth > 0 {
+		return "", fmt.Errorf("unterminated alternative")
+	}
+	b.WriteString("$")
+	return b.String(), nil
+}
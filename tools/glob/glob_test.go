@@ -0,0 +1,86 @@
+package glob
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"*.go", "main.go", true},
+		{"*.go", "tools/tree/tree.go", true},
+		{"*.go", "main.go.txt", false},
+		{"*_test.go", "tools/tree/tree_test.go", true},
+		{"tools/*.go", "tools/a.go", true},
+		{"tools/*.go", "tools/tree/a.go", false},
+		{"tools/**/*.go", "tools/a.go", true},
+		{"tools/**/*.go", "tools/tree/a.go", true},
+		{"tools/**/*.go", "tools/tree/x/a.go", true},
+		{"tools/**/*.go", "cmd/a.go", false},
+		{"**/*.go", "a.go", true},
+		{"**/*.go", "a/b/c.go", true},
+		{"tools/**", "tools/a/b", true},
+		{"tools/**", "tools", false},
+		{"**", "anything/at/all", true},
+		{"/main.go", "main.go", true},
+		{"/main.go", "cmd/main.go", false},
+		{"file?.txt", "file1.txt", true},
+		{"file?.txt", "file12.txt", false},
+		{"file[12].txt", "file2.txt", true},
+		{"file[!12].txt", "file2.txt", false},
+		{"file[!12].txt", "file3.txt", true},
+		{"a[!x]b", "a/b", false},
+		{"a[^x]b", "a/b", false},
+		{"a[!x]b", "acb", true},
+		{"a[]x]b", "a]b", true},
+		{"*.{go,md}", "README.md", true},
+		{"*.{go,md}", "main.go", true},
+		{"*.{go,md}", "main.rs", false},
+		{"{cmd,tools}/**/*.go", "cmd/llm-tools/llm-tools.go", true},
+		{`\*.go`, "*.go", true},
+		{`\*.go`, "a.go", false},
+		{"a**b", "axxb", true},
+		{"a**b", "ax/xb", false},
+		{"file{1,2}", "file1", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+"~"+tt.path, func(t *testing.T) {
+			got, err := Match(tt.pattern, tt.path)
+			if err != nil {
+				t.Fatalf("Match(%q, %q) error: %v", tt.pattern, tt.path, err)
+			}
+			if got != tt.want {
+				t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileInvalid(t *testing.T) {
+	for _, pattern := range []string{"", "file[abc", "{a,b"} {
+		if _, err := Compile(pattern); err == nil {
+			t.Errorf("Compile(%q) expected error", pattern)
+		}
+	}
+}
+
+func TestStaticPrefix(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{"*.go", ""},
+		{"main.go", ""},
+		{"tools/tree/*_test.go", "tools/tree"},
+		{"tools/**/*.go", "tools"},
+		{"/abs/path/**", "/abs/path"},
+		{"{a,b}/*.go", ""},
+	}
+	for _, tt := range tests {
+		if got := StaticPrefix(tt.pattern); got != tt.want {
+			t.Errorf("StaticPrefix(%q) = %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
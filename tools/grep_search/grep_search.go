@@ -9,9 +9,12 @@ import (
 
 	"github.com/xhd2015/llm-tools/jsonschema"
 	"github.com/xhd2015/llm-tools/tools/defs"
+	"github.com/xhd2015/llm-tools/tools/dirs"
+	"github.com/xhd2015/llm-tools/tools/grep_search/index_search"
 	"github.com/xhd2015/llm-tools/tools/grep_search/model"
 	"github.com/xhd2015/llm-tools/tools/grep_search/pure_go_search"
 	"github.com/xhd2015/llm-tools/tools/grep_search/rg_search"
+	"github.com/xhd2015/llm-tools/tools/tokenizer"
 )
 
 // Re-export types for backward compatibility
@@ -27,8 +30,11 @@ This is best for finding exact text matches or regex patterns.
 This is preferred over semantic search when we know the exact symbol/function name/etc. to search in some set of directories/file types.
 
 Use this tool to run fast, exact regex searches over text files using the ripgrep engine.
-To avoid overwhelming output, the results are capped at 50 matches.
+To avoid overwhelming output, 50 matches are returned per page, sorted by file and line. total_matches and total_files count all matches;
+when truncated, pass next_cursor as cursor to get the next page.
 Use the include or exclude patterns to filter the search scope by file type or specific paths.
+Results are grouped per file: the matches are in files[].matches, there is no top-level matches list. Set before_context/after_context to get surrounding lines without a follow-up read_file call.
+When you only need to know where a symbol is used, set output_mode to files_with_matches (paths only) or count (matching lines per file), which costs far fewer tokens.
 
 - Always escape special regex characters: ( ) [ ] { } + * ? ^ $ | . \
 - Use \ to escape any of these characters when they appear in your search string.
@@ -73,6 +79,52 @@ Use the include or exclude patterns to filter the search scope by file type or s
 					Type:        jsonschema.ParamTypeString,
 					Description: "Glob pattern for files to include (e.g. '*.ts' for TypeScript files)",
 				},
+				"include_patterns": {
+					Type:        jsonschema.ParamTypeArray,
+					Description: "More include globs, a file is searched if it matches any of them. Globs without '/' match file names at any depth, ** matches any number of directories and {a,b} alternatives are supported.",
+					Items: &jsonschema.JsonSchema{
+						Type: jsonschema.ParamTypeString,
+					},
+				},
+				"exclude_patterns": {
+					Type:        jsonschema.ParamTypeArray,
+					Description: "More exclude globs. A glob matching a directory excludes everything below it.",
+					Items: &jsonschema.JsonSchema{
+						Type: jsonschema.ParamTypeString,
+					},
+				},
+				"before_context": {
+					Type:        jsonschema.ParamTypeNumber,
+					Description: "Number of lines to return before each match, like grep -B. Lines that are themselves matches are not repeated as context.",
+				},
+				"after_context": {
+					Type:        jsonschema.ParamTypeNumber,
+					Description: "Number of lines to return after each match, like grep -A.",
+				},
+				"multiline": {
+					Type:        jsonschema.ParamTypeBoolean,
+					Description: "Allow the pattern to match across lines, like rg -U. Use \\n to match line breaks; a match spanning lines reports end_line.",
+				},
+				"output_mode": {
+					Type:        jsonschema.ParamTypeString,
+					Description: "content returns matching lines (default), files_with_matches returns only file paths, count returns the number of matching lines per file.",
+				},
+				"limit": {
+					Type:        jsonschema.ParamTypeNumber,
+					Description: "Maximum number of matches to return, or of files in files_with_matches and count modes. Defaults to 50.",
+				},
+				"offset": {
+					Type:        jsonschema.ParamTypeNumber,
+					Description: "Number of matches (or files) to skip, in file and line order.",
+				},
+				"cursor": {
+					Type:        jsonschema.ParamTypeString,
+					Description: "The next_cursor of a previous response to continue the same query. Takes precedence over offset.",
+				},
+				"max_tokens": {
+					Type:        jsonschema.ParamTypeNumber,
+					Description: "Maximum number of tokens of the returned matches. Matches beyond the budget are dropped and truncated is set. Defaults to no limit.",
+				},
 				"explanation": {
 					Type:        jsonschema.ParamTypeString,
 					Description: "One sentence explanation as to why this tool is being used, and how it contributes to the goal.",
@@ -84,8 +136,15 @@ Use the include or exclude patterns to filter the search scope by file type or s
 }
 
 // createSearcher creates the appropriate searcher based on availability
-func createSearcher() model.GrepSearcher {
-	// Try ripgrep first
+func createSearcher(req GrepSearchRequest) model.GrepSearcher {
+	// a fresh trigram index answers fastest
+	if dir, err := dirs.GetPath(req.WorkspaceRoot, req.RelativePathToSearch, "relative_path_to_search", true); err == nil {
+		if indexSearcher := index_search.NewIndexSearcher(dir); indexSearcher.IsAvailable() {
+			return indexSearcher
+		}
+	}
+
+	// Then ripgrep
 	ripgrepSearcher := rg_search.NewRipgrepSearcher()
 	if ripgrepSearcher.IsAvailable() {
 		return ripgrepSearcher
@@ -98,13 +157,83 @@ func createSearcher() model.GrepSearcher {
 
 // GrepSearch executes the grep_search tool with the given parameters
 func GrepSearch(req GrepSearchRequest) (*GrepSearchResponse, error) {
-	searcher := createSearcher()
-	return searcher.Search(req)
+	return search(createSearcher(req), req)
 }
 
 func GoGrepSearch(req GrepSearchRequest) (*GrepSearchResponse, error) {
-	searcher := pure_go_search.NewPureGoSearcher()
-	return searcher.Search(req)
+	return search(pure_go_search.NewPureGoSearcher(), req)
+}
+
+func search(searcher model.GrepSearcher, req GrepSearchRequest) (*GrepSearchResponse, error) {
+	response, err := searcher.Search(req)
+	if err != nil {
+		return nil, err
+	}
+	if !model.IsContentMode(req) {
+		applyFilesTokenLimit(response, req)
+		return response, nil
+	}
+	applyTokenLimit(response, req)
+	response.Files = model.GroupByFile(response.Matches)
+	return response, nil
+}
+
+// applyTokenLimit keeps the leading matches that fit in req.MaxTokens and records
+// the token count of what is returned. The next cursor resumes after the last kept match.
+func applyTokenLimit(response *GrepSearchResponse, req GrepSearchRequest) {
+	lines := make([]string, len(response.Matches))
+	for i, match := range response.Matches {
+		lines[i] = FormatMatch(match)
+	}
+	n := tokenizer.FitLines(lines, req.MaxTokens)
+	if n < len(response.Matches) {
+		response.Matches = response.Matches[:n]
+		response.Truncated = true
+		response.NextCursor = model.EncodeCursor(req, response.Offset+n)
+	}
+	response.Tokens = tokenizer.CountLines(lines[:n])
+}
+
+// applyFilesTokenLimit is applyTokenLimit for the count and files_with_matches modes
+func applyFilesTokenLimit(response *GrepSearchResponse, req GrepSearchRequest) {
+	lines := make([]string, len(response.Files))
+	for i, file := range response.Files {
+		lines[i] = FormatFile(file, req.OutputMode)
+	}
+	n := tokenizer.FitLines(lines, req.MaxTokens)
+	if n < len(response.Files) {
+		response.Files = response.Files[:n]
+		response.Truncated = true
+		response.NextCursor = model.EncodeCursor(req, response.Offset+n)
+	}
+	response.Tokens = tokenizer.CountLines(lines[:n])
+}
+
+// FormatFile renders a file entry of the count mode as file:count, and as
+// the bare path in files_with_matches mode
+func FormatFile(file model.GrepSearchFile, mode string) string {
+	if mode == model.OUTPUT_COUNT {
+		return fmt.Sprintf("%s:%d", file.File, file.MatchCount)
+	}
+	return file.File
+}
+
+// FormatMatch renders a match as file:line: content, preceded and followed
+// by its context lines as file-line- content, like grep does
+func FormatMatch(match GrepSearchMatch) string {
+	var b strings.Builder
+	for _, ctx := range match.ContextBefore {
+		fmt.Fprintf(&b, "%s-%d- %s\n", match.File, ctx.Line, ctx.Content)
+	}
+	if match.Column > 0 {
+		fmt.Fprintf(&b, "%s:%d:%d: %s", match.File, match.Line, match.Column, match.Content)
+	} else {
+		fmt.Fprintf(&b, "%s:%d: %s", match.File, match.Line, match.Content)
+	}
+	for _, ctx := range match.ContextAfter {
+		fmt.Fprintf(&b, "\n%s-%d- %s", match.File, ctx.Line, ctx.Content)
+	}
+	return b.String()
 }
 
 // GrepSearchSimple provides a simpler interface for backward compatibility
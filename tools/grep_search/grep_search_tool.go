@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/xhd2015/less-gen/flags"
+	"github.com/xhd2015/llm-tools/tools/grep_search/model"
 )
 
 const help = `
@@ -15,28 +16,65 @@ Usage: llm-tools grep_search <pattern> [OPTIONS]
 
 Options:
   --case-sensitive             enable case sensitive search
-  --exclude <pattern>          exclude files/directories matching pattern
-  --include <pattern>          include files/directories matching pattern
+  --exclude <pattern>          exclude files/directories matching pattern (can be specified multiple times)
+  --include <pattern>          include files matching pattern (can be specified multiple times)
+  -B,--before-context <num>    show <num> lines before each match
+  -A,--after-context <num>     show <num> lines after each match
+  -C,--context <num>           show <num> lines before and after each match
+  -U,--multiline               allow matches to span lines
+  --output-mode <mode>         content (default), files_with_matches or count
+  -l,--files-with-matches      only print the paths of files with matches
+  -c,--count                   only print the number of matching lines per file
+  --limit <num>                maximum number of matches to return (default 50)
+  --offset <num>               skip the first <num> matches
+  --cursor <cursor>            continue from the next cursor of a previous search
+  --max-tokens <num>           maximum tokens of returned matches (0 = no limit)
   --explanation <text>         explanation for the operation
 
 Examples:
   llm-tools grep_search "function.*main"
   llm-tools grep_search "HandleCli" --include "*.go"
+  llm-tools grep_search "TODO" --include "*.ts" --include "*.tsx" --exclude "node_modules"
   llm-tools grep_search "TODO" --exclude "*.log" --case-sensitive
+  llm-tools grep_search "func HandleCli" -C 3
+  llm-tools grep_search "GrepSearchRequest" -l
+  llm-tools grep_search "struct \{\n\s*Name" -U
 `
 
 func HandleCli(args []string) error {
 	var caseSensitive bool
-	var excludePattern string
-	var includePattern string
+	var excludePatterns []string
+	var includePatterns []string
+	var beforeContext int
+	var afterContext int
+	var context int
+	var multiline bool
+	var outputMode string
+	var filesWithMatches bool
+	var count bool
+	var limit int
+	var offset int
+	var cursor string
+	var maxTokens int
 	var explanation string
 	var dir string
 
 	var useGoGrep bool
 
 	args, err := flags.Bool("--case-sensitive", &caseSensitive).
-		String("--exclude", &excludePattern).
-		String("--include", &includePattern).
+		StringSlice("--exclude", &excludePatterns).
+		StringSlice("--include", &includePatterns).
+		Int("-B,--before-context", &beforeContext).
+		Int("-A,--after-context", &afterContext).
+		Int("-C,--context", &context).
+		Bool("-U,--multiline", &multiline).
+		String("--output-mode", &outputMode).
+		Bool("-l,--files-with-matches", &filesWithMatches).
+		Bool("-c,--count", &count).
+		Int("--limit", &limit).
+		Int("--offset", &offset).
+		String("--cursor", &cursor).
+		Int("--max-tokens", &maxTokens).
 		String("--explanation", &explanation).
 		String("--dir", &dir).
 		Bool("--use-go-grep", &useGoGrep).
@@ -55,6 +93,20 @@ func HandleCli(args []string) error {
 	}
 
 	query := args[0]
+	if filesWithMatches {
+		outputMode = model.OUTPUT_FILES_WITH_MATCHES
+	}
+	if count {
+		outputMode = model.OUTPUT_COUNT
+	}
+	if context > 0 {
+		if beforeContext == 0 {
+			beforeContext = context
+		}
+		if afterContext == 0 {
+			afterContext = context
+		}
+	}
 
 	cwd, err := os.Getwd()
 	if err != nil {
@@ -66,8 +118,16 @@ func HandleCli(args []string) error {
 		RelativePathToSearch: dir,
 		Query:                query,
 		CaseSensitive:        caseSensitive,
-		ExcludePattern:       excludePattern,
-		IncludePattern:       includePattern,
+		ExcludePatterns:      excludePatterns,
+		IncludePatterns:      includePatterns,
+		BeforeContext:        beforeContext,
+		AfterContext:         afterContext,
+		Multiline:            multiline,
+		OutputMode:           outputMode,
+		Limit:                limit,
+		Offset:               offset,
+		Cursor:               cursor,
+		MaxTokens:            maxTokens,
 		Explanation:          explanation,
 	}
 
@@ -87,24 +147,52 @@ func HandleCli(args []string) error {
 
 	// Print results
 	fmt.Printf("Search query: %s\n", response.SearchQuery)
-	fmt.Printf("Total matches: %d", response.TotalMatches)
+	fileList := !model.IsContentMode(req)
+	if outputMode == model.OUTPUT_FILES_WITH_MATCHES {
+		fmt.Printf("Total files: %d", response.TotalFiles)
+	} else {
+		fmt.Printf("Total matches: %d in %d files", response.TotalMatches, response.TotalFiles)
+	}
 	if response.Truncated {
-		fmt.Printf(" (truncated)")
+		shown := len(response.Matches)
+		if fileList {
+			shown = len(response.Files)
+		}
+		fmt.Printf(", showing %d-%d", response.Offset+1, response.Offset+shown)
 	}
+	fmt.Printf(", tokens: %d", response.Tokens)
 	fmt.Println()
+	if response.NextCursor != "" {
+		fmt.Printf("Next page: --cursor %s\n", response.NextCursor)
+	}
 	fmt.Println()
 
-	if len(response.Matches) == 0 {
+	if len(response.Files) == 0 {
 		fmt.Println("No matches found.")
 		return nil
 	}
 
-	for _, match := range response.Matches {
-		fmt.Printf("%s:%d", match.File, match.Line)
-		if match.Column > 0 {
-			fmt.Printf(":%d", match.Column)
+	if fileList {
+		for _, file := range response.Files {
+			fmt.Println(FormatFile(file, outputMode))
+		}
+		return nil
+	}
+
+	for i, file := range response.Files {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("%s (%d matches)\n", file.File, file.MatchCount)
+		for _, match := range file.Matches {
+			for _, ctx := range match.ContextBefore {
+				fmt.Printf("%d- %s\n", ctx.Line, ctx.Content)
+			}
+			fmt.Printf("%d: %s\n", match.Line, match.Content)
+			for _, ctx := range match.ContextAfter {
+				fmt.Printf("%d- %s\n", ctx.Line, ctx.Content)
+			}
 		}
-		fmt.Printf(": %s\n", match.Content)
 	}
 
 	return nil
@@ -0,0 +1,1041 @@
+package index_search
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xhd2015/llm-tools/tools/grep_search/pure_go_search"
+	"github.com/xhd2015/llm-tools/tools/walk"
+)
+
+// INDEX_DIR is the directory of the index, relative to the indexed root
+const INDEX_DIR = ".llm-tools/index"
+
+// DEFAULT_MAX_AGE is how long an index that no watcher owns is used after it
+// was built, LLM_TOOLS_INDEX_MAX_AGE overrides it with a Go duration such as 30m
+const DEFAULT_MAX_AGE = time.Hour
+
+// OWNER_HEARTBEAT is how often a watcher marks the index it keeps up to date
+// as owned, the index is no longer owned after three missed heartbeats
+const OWNER_HEARTBEAT = 20 * time.Second
+
+const (
+	indexFile    = "trigrams.idx"
+	overlayFile  = "overlay.idx"
+	metaFile     = "meta.json"
+	ownerFile    = "watcher"
+	magic        = "llmtri1\n"
+	overlayMagic = "llmovl1\n"
+	// batchSize is the number of files read in parallel before their
+	// trigrams are merged into the posting lists
+	batchSize = 1024
+	// minMerge is the overlay size below which Update never merges it into
+	// the index, above it the overlay is merged once it outgrows a tenth of
+	// the index
+	minMerge = 1000
+)
+
+// updateMutex serializes the builds and updates of the process
+var updateMutex sync.Mutex
+
+// Meta describes an index, it is stored next to it so that freshness can be
+// checked without loading the index
+type Meta struct {
+	Version  int       `json:"version"`
+	BuiltAt  time.Time `json:"built_at"`
+	Files    int       `json:"files"`
+	Trigrams int       `json:"trigrams"`
+	// Reused is the number of files whose trigrams were taken from the
+	// previous index because their size and mtime did not change
+	Reused int `json:"reused"`
+	// Overlay is the number of files updated since BuiltAt, which are kept
+	// apart from the index until they are merged into it
+	Overlay int `json:"overlay"`
+}
+
+// FileEntry is an indexed file
+type FileEntry struct {
+	// Path is relative to the indexed root, slash separated
+	Path    string
+	Size    int64
+	ModTime int64
+}
+
+// Index is a trigram index of the text files under Root. Files are the files
+// that are searched: hidden, gitignored, binary and large files are skipped
+// like the pure Go searcher does. Posting lists are decoded on demand.
+//
+// Update does not rewrite the index: the files under the changed paths go to
+// an overlay, which replaces the files of the base index under those paths.
+type Index struct {
+	Root    string
+	BuiltAt time.Time
+	// Files are the base files followed by the overlay files, IDs index it
+	Files []FileEntry
+
+	base    *segment
+	overlay *segment
+	// changed are the paths covered by the overlay, removed marks the base
+	// files under them
+	changed []string
+	removed []bool
+}
+
+// segment is a set of files with the posting lists of their trigrams
+type segment struct {
+	files []FileEntry
+	// trigrams is sorted, postings[i] holds the encoded file IDs of trigrams[i]
+	trigrams []uint32
+	postings [][]byte
+}
+
+// Dir returns the index directory of root
+func Dir(root string) string {
+	return filepath.Join(root, filepath.FromSlash(INDEX_DIR))
+}
+
+// FindRoot returns the closest parent of dir, dir included, that has an index,
+// or "" if there is none
+func FindRoot(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(Dir(dir), indexFile)); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// ReadMeta reads the metadata of the index of root
+func ReadMeta(root string) (*Meta, error) {
+	data, err := os.ReadFile(filepath.Join(Dir(root), metaFile))
+	if err != nil {
+		return nil, err
+	}
+	var meta Meta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("invalid index metadata: %w", err)
+	}
+	return &meta, nil
+}
+
+// MaxAge returns how long an index that no watcher owns is used after it was built
+func MaxAge() time.Duration {
+	if value := os.Getenv("LLM_TOOLS_INDEX_MAX_AGE"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+	}
+	return DEFAULT_MAX_AGE
+}
+
+// Fresh reports whether the index of root can serve searches: a watcher owns
+// it, or it is younger than MaxAge. Searches check the files of an index no
+// watcher owns against it, so edited and new files are found, the age only
+// bounds how many of them are searched without the index.
+func Fresh(root string) bool {
+	if Owned(root) {
+		return true
+	}
+	meta, err := ReadMeta(root)
+	if err != nil {
+		return false
+	}
+	return time.Since(meta.BuiltAt) <= MaxAge()
+}
+
+// Own marks the index of root as kept up to date by a watcher, which calls
+// it again every OWNER_HEARTBEAT while it watches
+func Own(root string) error {
+	return os.WriteFile(filepath.Join(Dir(root), ownerFile), []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
+
+// Disown marks the index of root as no longer kept up to date
+func Disown(root string) error {
+	err := os.Remove(filepath.Join(Dir(root), ownerFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Owned reports whether a watcher keeps the index of root up to date, in
+// which case searches trust it without checking the files
+func Owned(root string) bool {
+	info, err := os.Stat(filepath.Join(Dir(root), ownerFile))
+	return err == nil && time.Since(info.ModTime()) < 3*OWNER_HEARTBEAT
+}
+
+// Build indexes root and writes the index to root/.llm-tools/index. Unless
+// full is set, files whose size and mtime are unchanged since the previous
+// index reuse its trigrams, so only changed files are read.
+func Build(root string, full bool) (*Meta, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	updateMutex.Lock()
+	defer updateMutex.Unlock()
+	return buildAll(root, full)
+}
+
+func buildAll(root string, full bool) (*Meta, error) {
+	builtAt := time.Now()
+	files, err := listFiles(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var previous *Index
+	if !full {
+		// a missing or unreadable previous index means a full build
+		previous, _ = Load(root)
+	}
+	return build(root, builtAt, files, previous)
+}
+
+// Update updates the index of root for the given paths only, slash separated
+// and relative to root, without walking the rest of the tree. A path may be a
+// file or a directory that was created, changed or removed. The files under
+// the paths are written to the overlay, the index and its BuiltAt are left
+// alone until the overlay is large enough to be merged into it. Without a
+// usable index or with no paths, it is an incremental Build.
+func Update(root string, paths []string) (*Meta, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	updateMutex.Lock()
+	defer updateMutex.Unlock()
+	previous, err := Load(root)
+	if err != nil || len(paths) == 0 {
+		return buildAll(root, false)
+	}
+
+	updated := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		updated[strings.TrimSuffix(path, "/")] = true
+	}
+	changed := make(map[string]bool, len(previous.changed)+len(updated))
+	for _, path := range previous.changed {
+		changed[path] = true
+	}
+	for path := range updated {
+		changed[path] = true
+	}
+
+	// the overlay files outside of the updated paths are kept, those under
+	// them are listed again
+	var files []FileEntry
+	for _, file := range previous.overlay.files {
+		if !under(updated, file.Path) {
+			files = append(files, file)
+		}
+	}
+	filter := walk.NewFilter(root, walk.Options{SkipHidden: true})
+	for path := range updated {
+		absPath := filepath.Join(root, filepath.FromSlash(path))
+		info, err := os.Stat(absPath)
+		if err != nil || skipped(filter, root, absPath, info.IsDir()) {
+			continue
+		}
+		if !info.IsDir() {
+			if entry, ok := fileEntry(root, absPath); ok {
+				files = append(files, entry)
+			}
+			continue
+		}
+		dirFiles, err := listFiles(absPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range dirFiles {
+			file.Path = path + "/" + file.Path
+			files = append(files, file)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+
+	threshold := len(previous.base.files) / 10
+	if threshold < minMerge {
+		threshold = minMerge
+	}
+	if len(files)+len(changed) > threshold {
+		// merge the overlay into the index, keeping the time it was built
+		// since only the changed paths were looked at
+		for _, file := range previous.base.files {
+			if !under(changed, file.Path) {
+				files = append(files, file)
+			}
+		}
+		sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+		return build(root, previous.BuiltAt, files, previous)
+	}
+	return buildOverlay(root, previous, changed, files)
+}
+
+// under reports whether path or one of its parents is in paths
+func under(paths map[string]bool, path string) bool {
+	for {
+		if paths[path] {
+			return true
+		}
+		idx := strings.LastIndex(path, "/")
+		if idx < 0 {
+			return false
+		}
+		path = path[:idx]
+	}
+}
+
+// skipped reports whether the path or one of its parents under root is not indexed
+func skipped(filter *walk.Filter, root string, path string, isDir bool) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return true
+	}
+	current := root
+	parts := strings.Split(rel, string(filepath.Separator))
+	for i, part := range parts {
+		current = filepath.Join(current, part)
+		if filter.Skip(current, isDir || i < len(parts)-1) {
+			return true
+		}
+	}
+	return false
+}
+
+// build indexes files, taking the trigrams of those unchanged since
+// previous from it, and writes the index, which replaces the overlay
+func build(root string, builtAt time.Time, files []FileEntry, previous *Index) (*Meta, error) {
+	postings := make(map[uint32][]uint32)
+	reused := make([]bool, len(files))
+	if previous != nil {
+		previous.base.reuse(previous.removed, files, postings, reused)
+		previous.overlay.reuse(nil, files, postings, reused)
+	}
+	kept := indexFiles(root, files, reused, postings)
+
+	meta := &Meta{
+		Version:  1,
+		BuiltAt:  builtAt,
+		Files:    len(kept),
+		Trigrams: len(postings),
+		Reused:   countTrue(reused),
+	}
+	dir := Dir(root)
+	if err := writeSegment(dir, indexFile, []byte(magic), kept, postings); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(filepath.Join(dir, overlayFile)); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err := writeMeta(root, meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// buildOverlay indexes the files under the changed paths, taking the
+// trigrams of those unchanged from the previous overlay, and writes them as
+// the overlay of the unchanged index
+func buildOverlay(root string, previous *Index, changed map[string]bool, files []FileEntry) (*Meta, error) {
+	postings := make(map[uint32][]uint32)
+	reused := make([]bool, len(files))
+	previous.overlay.reuse(nil, files, postings, reused)
+	kept := indexFiles(root, files, reused, postings)
+
+	paths := make([]string, 0, len(changed))
+	for path := range changed {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	buf := make([]byte, binary.MaxVarintLen64)
+	header := []byte(overlayMagic)
+	header = append(header, buf[:binary.PutUvarint(buf, uint64(len(paths)))]...)
+	for _, path := range paths {
+		header = append(header, buf[:binary.PutUvarint(buf, uint64(len(path)))]...)
+		header = append(header, path...)
+	}
+
+	meta := &Meta{
+		Version:  1,
+		BuiltAt:  previous.BuiltAt,
+		Files:    len(kept),
+		Trigrams: len(previous.base.trigrams),
+		Reused:   countTrue(reused),
+		Overlay:  len(kept),
+	}
+	for _, file := range previous.base.files {
+		if !under(changed, file.Path) {
+			meta.Files++
+		}
+	}
+	for t := range postings {
+		if _, ok := previous.base.find(t); !ok {
+			meta.Trigrams++
+		}
+	}
+	if err := writeSegment(Dir(root), overlayFile, header, kept, postings); err != nil {
+		return nil, err
+	}
+	if err := writeMeta(root, meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// indexFiles reads the trigrams of the files that were not reused into
+// postings, drops the binary and unreadable files and renumbers the postings
+// of the remaining ones, which it returns
+func indexFiles(root string, files []FileEntry, reused []bool, postings map[uint32][]uint32) []FileEntry {
+	var toRead []int
+	for id := range files {
+		if !reused[id] {
+			toRead = append(toRead, id)
+		}
+	}
+	indexed := make([]bool, len(files))
+	for start := 0; start < len(toRead); start += batchSize {
+		end := start + batchSize
+		if end > len(toRead) {
+			end = len(toRead)
+		}
+		batch := toRead[start:end]
+		sets := make([][]uint32, len(batch))
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, runtime.NumCPU())
+		for i, id := range batch {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(i int, id int) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				sets[i] = fileTrigrams(filepath.Join(root, filepath.FromSlash(files[id].Path)))
+			}(i, id)
+		}
+		wg.Wait()
+		for i, id := range batch {
+			if sets[i] == nil {
+				continue
+			}
+			indexed[id] = true
+			for _, t := range sets[i] {
+				postings[t] = append(postings[t], uint32(id))
+			}
+		}
+	}
+
+	var kept []FileEntry
+	newID := make([]uint32, len(files))
+	for id, file := range files {
+		if reused[id] || indexed[id] {
+			newID[id] = uint32(len(kept))
+			kept = append(kept, file)
+		}
+	}
+	for t, ids := range postings {
+		for i, id := range ids {
+			ids[i] = newID[id]
+		}
+		// reused and newly read files were appended separately
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		postings[t] = ids
+	}
+	return kept
+}
+
+func countTrue(values []bool) int {
+	n := 0
+	for _, v := range values {
+		if v {
+			n++
+		}
+	}
+	return n
+}
+
+// listFiles returns the searchable files under root sorted by path
+func listFiles(root string) ([]FileEntry, error) {
+	var mutex sync.Mutex
+	var files []FileEntry
+	err := walk.WalkParallel(root, walk.Options{SkipHidden: true}, 0, func(path string, d fs.DirEntry) error {
+		if d.IsDir() {
+			return nil
+		}
+		entry, ok := fileEntry(root, path)
+		if !ok {
+			return nil
+		}
+		mutex.Lock()
+		files = append(files, entry)
+		mutex.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+// fileEntry returns the entry of a file under root, ok is false if the file
+// is not searched because it is binary, too large or not a regular file
+func fileEntry(root string, path string) (FileEntry, bool) {
+	if pure_go_search.HasBinaryExtension(path) {
+		return FileEntry{}, false
+	}
+	// follows symlinks, like the searchers reading the file do
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() || info.Size() > pure_go_search.MAX_FILE_SIZE {
+		return FileEntry{}, false
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return FileEntry{}, false
+	}
+	return FileEntry{Path: filepath.ToSlash(rel), Size: info.Size(), ModTime: info.ModTime().UnixNano()}, true
+}
+
+// reuse copies the postings of the files of the segment that are unchanged
+// in files, except those marked in skip, using the IDs of files, and marks
+// them in reused
+func (seg *segment) reuse(skip []bool, files []FileEntry, postings map[uint32][]uint32, reused []bool) {
+	oldToNew := make([]int, len(seg.files))
+	byPath := make(map[string]int, len(files))
+	for id, file := range files {
+		byPath[file.Path] = id
+	}
+	found := false
+	for oldID, old := range seg.files {
+		oldToNew[oldID] = -1
+		if skip != nil && skip[oldID] {
+			continue
+		}
+		if id, ok := byPath[old.Path]; ok && files[id].Size == old.Size && files[id].ModTime == old.ModTime {
+			oldToNew[oldID] = id
+			reused[id] = true
+			found = true
+		}
+	}
+	if !found {
+		return
+	}
+	for i, t := range seg.trigrams {
+		for _, oldID := range decodePostings(seg.postings[i]) {
+			if id := oldToNew[oldID]; id >= 0 {
+				postings[t] = append(postings[t], uint32(id))
+			}
+		}
+	}
+}
+
+// fileTrigrams returns the distinct trigrams of the ASCII folded content of
+// a file, nil for binary or unreadable files
+func fileTrigrams(path string) []uint32 {
+	data, err := os.ReadFile(path)
+	if err != nil || bytes.IndexByte(data, 0) >= 0 {
+		return nil
+	}
+	seen := make(map[uint32]struct{})
+	for i := 0; i+3 <= len(data); i++ {
+		seen[trigramOf(fold(data[i]), fold(data[i+1]), fold(data[i+2]))] = struct{}{}
+	}
+	set := make([]uint32, 0, len(seen))
+	for t := range seen {
+		set = append(set, t)
+	}
+	return set
+}
+
+func fold(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+func trigramOf(a, b, c byte) uint32 {
+	return uint32(a)<<16 | uint32(b)<<8 | uint32(c)
+}
+
+// writeSegment stores a segment in a temporary file of dir renamed into
+// place, so readers never see a partial file. The format is, all integers as
+// uvarints:
+//
+//	header: the magic of the index, or of the overlay followed by the path
+//	        count and per path: path length, path
+//	file count, then per file: path length, path, size, mtime
+//	trigram count, then per trigram: trigram delta, posting count, posting byte length
+//	posting lists, each a sequence of file ID deltas
+func writeSegment(dir string, name string, header []byte, files []FileEntry, postings map[uint32][]uint32) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, name+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriterSize(tmp, 1<<20)
+	buf := make([]byte, binary.MaxVarintLen64)
+	putUvarint := func(v uint64) {
+		n := binary.PutUvarint(buf, v)
+		w.Write(buf[:n])
+	}
+
+	w.Write(header)
+	putUvarint(uint64(len(files)))
+	for _, file := range files {
+		putUvarint(uint64(len(file.Path)))
+		w.WriteString(file.Path)
+		putUvarint(uint64(file.Size))
+		putUvarint(uint64(file.ModTime))
+	}
+
+	trigrams := make([]uint32, 0, len(postings))
+	for t := range postings {
+		trigrams = append(trigrams, t)
+	}
+	sort.Slice(trigrams, func(i, j int) bool { return trigrams[i] < trigrams[j] })
+	encoded := make([][]byte, len(trigrams))
+	for i, t := range trigrams {
+		encoded[i] = encodePostings(postings[t])
+	}
+
+	putUvarint(uint64(len(trigrams)))
+	var last uint32
+	for i, t := range trigrams {
+		putUvarint(uint64(t - last))
+		last = t
+		putUvarint(uint64(len(postings[t])))
+		putUvarint(uint64(len(encoded[i])))
+	}
+	for _, e := range encoded {
+		w.Write(e)
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, name))
+}
+
+func writeMeta(root string, meta *Meta) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(Dir(root), metaFile), data, 0644)
+}
+
+func encodePostings(ids []uint32) []byte {
+	var out []byte
+	buf := make([]byte, binary.MaxVarintLen32)
+	var last uint32
+	for _, id := range ids {
+		n := binary.PutUvarint(buf, uint64(id-last))
+		out = append(out, buf[:n]...)
+		last = id
+	}
+	return out
+}
+
+func decodePostings(data []byte) []uint32 {
+	var ids []uint32
+	var last uint32
+	for len(data) > 0 {
+		delta, n := binary.Uvarint(data)
+		if n <= 0 {
+			break
+		}
+		data = data[n:]
+		last += uint32(delta)
+		ids = append(ids, last)
+	}
+	return ids
+}
+
+// cache keeps loaded indexes of long running processes such as the MCP server
+var cache = struct {
+	sync.Mutex
+	indexes map[string]cachedIndex
+}{indexes: make(map[string]cachedIndex)}
+
+type cachedIndex struct {
+	modTime time.Time
+	// overlayTime is zero without overlay
+	overlayTime time.Time
+	index       *Index
+}
+
+// Load reads the index of root with its overlay, reusing a previously loaded
+// one if the files did not change
+func Load(root string) (*Index, error) {
+	dir := Dir(root)
+	file := filepath.Join(dir, indexFile)
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	var overlayTime time.Time
+	if overlayInfo, err := os.Stat(filepath.Join(dir, overlayFile)); err == nil {
+		overlayTime = overlayInfo.ModTime()
+	}
+	cache.Lock()
+	cached, ok := cache.indexes[file]
+	cache.Unlock()
+	ok = ok && cached.modTime.Equal(info.ModTime())
+	if ok && cached.overlayTime.Equal(overlayTime) {
+		return cached.index, nil
+	}
+
+	var base *segment
+	if ok {
+		base = cached.index.base
+	} else {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		base, err = parse(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid index %s, rebuild it with llm-tools index build --full: %w", file, err)
+		}
+	}
+	overlay := &segment{}
+	var changed []string
+	if !overlayTime.IsZero() {
+		// a missing overlay was merged into the index since the stat
+		data, err := os.ReadFile(filepath.Join(dir, overlayFile))
+		if err == nil {
+			changed, overlay, err = parseOverlay(data)
+			if err != nil {
+				return nil, fmt.Errorf("invalid index overlay in %s, rebuild it with llm-tools index build --full: %w", dir, err)
+			}
+		}
+	}
+	idx := newIndex(root, base, changed, overlay)
+	if meta, err := ReadMeta(root); err == nil {
+		idx.BuiltAt = meta.BuiltAt
+	}
+
+	cache.Lock()
+	cache.indexes[file] = cachedIndex{modTime: info.ModTime(), overlayTime: overlayTime, index: idx}
+	cache.Unlock()
+	return idx, nil
+}
+
+// newIndex puts the overlay over the base, replacing the base files under the changed paths
+func newIndex(root string, base *segment, changed []string, overlay *segment) *Index {
+	idx := &Index{Root: root, base: base, overlay: overlay, changed: changed}
+	idx.Files = make([]FileEntry, 0, len(base.files)+len(overlay.files))
+	idx.Files = append(append(idx.Files, base.files...), overlay.files...)
+	if len(changed) > 0 {
+		paths := make(map[string]bool, len(changed))
+		for _, path := range changed {
+			paths[path] = true
+		}
+		idx.removed = make([]bool, len(base.files))
+		for id, file := range base.files {
+			idx.removed[id] = under(paths, file.Path)
+		}
+	}
+	return idx
+}
+
+// reader decodes the uvarints and bytes of a segment, keeping the first error
+type reader struct {
+	data []byte
+	err  error
+}
+
+func (r *reader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		if r.err == nil {
+			r.err = fmt.Errorf("truncated")
+		}
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *reader) bytes(n uint64) []byte {
+	if n > uint64(len(r.data)) {
+		if r.err == nil {
+			r.err = fmt.Errorf("truncated")
+		}
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func parse(data []byte) (*segment, error) {
+	if !bytes.HasPrefix(data, []byte(magic)) {
+		return nil, fmt.Errorf("unknown format")
+	}
+	return parseSegment(&reader{data: data[len(magic):]})
+}
+
+func parseOverlay(data []byte) ([]string, *segment, error) {
+	if !bytes.HasPrefix(data, []byte(overlayMagic)) {
+		return nil, nil, fmt.Errorf("unknown format")
+	}
+	r := &reader{data: data[len(overlayMagic):]}
+	numPaths := r.uvarint()
+	var paths []string
+	for i := uint64(0); i < numPaths && r.err == nil; i++ {
+		paths = append(paths, string(r.bytes(r.uvarint())))
+	}
+	if r.err != nil {
+		return nil, nil, r.err
+	}
+	seg, err := parseSegment(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	return paths, seg, nil
+}
+
+func parseSegment(r *reader) (*segment, error) {
+	seg := &segment{}
+	numFiles := r.uvarint()
+	for i := uint64(0); i < numFiles && r.err == nil; i++ {
+		path := string(r.bytes(r.uvarint()))
+		size := r.uvarint()
+		modTime := r.uvarint()
+		seg.files = append(seg.files, FileEntry{Path: path, Size: int64(size), ModTime: int64(modTime)})
+	}
+
+	numTrigrams := r.uvarint()
+	var lengths []uint64
+	var last uint32
+	for i := uint64(0); i < numTrigrams && r.err == nil; i++ {
+		last += uint32(r.uvarint())
+		r.uvarint() // posting count
+		seg.trigrams = append(seg.trigrams, last)
+		lengths = append(lengths, r.uvarint())
+	}
+	for _, n := range lengths {
+		seg.postings = append(seg.postings, r.bytes(n))
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return seg, nil
+}
+
+// find returns the position of trigram key in the segment
+func (seg *segment) find(key uint32) (int, bool) {
+	i := sort.Search(len(seg.trigrams), func(i int) bool { return seg.trigrams[i] >= key })
+	return i, i < len(seg.trigrams) && seg.trigrams[i] == key
+}
+
+// lookup returns the IDs of the files of the segment containing trigram key
+func (seg *segment) lookup(key uint32) []uint32 {
+	i, ok := seg.find(key)
+	if !ok {
+		return nil
+	}
+	return decodePostings(seg.postings[i])
+}
+
+// lookup returns the IDs of the files containing trigram t, those of the
+// overlay follow those of the base
+func (idx *Index) lookup(t string) []uint32 {
+	key := trigramOf(t[0], t[1], t[2])
+	ids := idx.base.lookup(key)
+	if idx.removed != nil {
+		kept := ids[:0]
+		for _, id := range ids {
+			if !idx.removed[id] {
+				kept = append(kept, id)
+			}
+		}
+		ids = kept
+	}
+	offset := uint32(len(idx.base.files))
+	for _, id := range idx.overlay.lookup(key) {
+		ids = append(ids, offset+id)
+	}
+	return ids
+}
+
+// Candidates returns the IDs of the files that may match q, sorted
+func (idx *Index) Candidates(q *Query) []uint32 {
+	ids, all := idx.eval(q)
+	if all {
+		ids = make([]uint32, 0, len(idx.Files))
+		for i := range idx.Files {
+			if i < len(idx.removed) && idx.removed[i] {
+				continue
+			}
+			ids = append(ids, uint32(i))
+		}
+	}
+	return ids
+}
+
+// eval returns the files matching q, all is set instead when q matches every file
+func (idx *Index) eval(q *Query) (ids []uint32, all bool) {
+	switch q.Op {
+	case QUERY_ALL:
+		return nil, true
+	case QUERY_AND:
+		all = true
+		for _, t := range q.Trigrams {
+			ids, all = intersect(ids, all, idx.lookup(t)), false
+			if len(ids) == 0 {
+				return nil, false
+			}
+		}
+		for _, sub := range q.Sub {
+			subIDs, subAll := idx.eval(sub)
+			if subAll {
+				continue
+			}
+			ids, all = intersect(ids, all, subIDs), false
+			if len(ids) == 0 {
+				return nil, false
+			}
+		}
+		return ids, all
+	case QUERY_OR:
+		for _, t := range q.Trigrams {
+			ids = union(ids, idx.lookup(t))
+		}
+		for _, sub := range q.Sub {
+			subIDs, subAll := idx.eval(sub)
+			if subAll {
+				return nil, true
+			}
+			ids = union(ids, subIDs)
+		}
+		return ids, false
+	}
+	return nil, true
+}
+
+// intersect intersects two sorted lists, a is every file when all is set
+func intersect(a []uint32, all bool, b []uint32) []uint32 {
+	if all {
+		return b
+	}
+	var result []uint32
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+	return result
+}
+
+// union merges two sorted lists
+func union(a []uint32, b []uint32) []uint32 {
+	result := make([]uint32, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			result = append(result, a[i])
+			i++
+		case a[i] > b[j]:
+			result = append(result, b[j])
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+	result = append(result, a[i:]...)
+	return append(result, b[j:]...)
+}
+
+// FilesUnder returns the paths of the given files that are under the slash
+// separated directory relDir, relative to it
+func (idx *Index) FilesUnder(ids []uint32, relDir string) []string {
+	prefix := ""
+	if relDir != "" && relDir != "." {
+		prefix = strings.TrimSuffix(relDir, "/") + "/"
+	}
+	var paths []string
+	for _, id := range ids {
+		path := idx.Files[id].Path
+		if strings.HasPrefix(path, prefix) {
+			paths = append(paths, filepath.FromSlash(path[len(prefix):]))
+		}
+	}
+	return paths
+}
+
+// verify checks the candidate paths returned by FilesUnder for relDir against
+// the files under it: it returns the candidates that are still searched, and
+// the files created or changed since they were indexed whatever their
+// trigrams, since the index cannot rule them out
+func (idx *Index) verify(candidates []string, relDir string) ([]string, error) {
+	prefix := ""
+	if relDir != "" && relDir != "." {
+		prefix = strings.TrimSuffix(relDir, "/") + "/"
+	}
+	indexed := make(map[string]FileEntry)
+	for id, file := range idx.Files {
+		if id < len(idx.removed) && idx.removed[id] {
+			continue
+		}
+		if strings.HasPrefix(file.Path, prefix) {
+			indexed[file.Path[len(prefix):]] = file
+		}
+	}
+	isCandidate := make(map[string]bool, len(candidates))
+	for _, path := range candidates {
+		isCandidate[filepath.ToSlash(path)] = true
+	}
+
+	files, err := listFiles(filepath.Join(idx.Root, filepath.FromSlash(relDir)))
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, file := range files {
+		entry, ok := indexed[file.Path]
+		if isCandidate[file.Path] || !ok || entry.Size != file.Size || entry.ModTime != file.ModTime {
+			paths = append(paths, filepath.FromSlash(file.Path))
+		}
+	}
+	return paths, nil
+}
@@ -0,0 +1,107 @@
+package index_search
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/xhd2015/llm-tools/tools/dirs"
+	"github.com/xhd2015/llm-tools/tools/grep_search/model"
+	"github.com/xhd2015/llm-tools/tools/grep_search/pure_go_search"
+)
+
+// IndexSearcher implements GrepSearcher with the trigram index built by
+// llm-tools index build. The index selects the candidate files, which are
+// then searched by the pure Go engine. Unless a watcher owns the index, the
+// files created or changed since they were indexed are searched too, so
+// results are the same as without the index.
+type IndexSearcher struct {
+	// dir is the directory the searcher was created for
+	dir string
+	// root is the directory the index covers, "" if there is none
+	root string
+}
+
+// NewIndexSearcher creates a searcher for dir using the closest index
+// found in dir or its parents
+func NewIndexSearcher(dir string) *IndexSearcher {
+	return &IndexSearcher{dir: dir, root: FindRoot(dir)}
+}
+
+// IsAvailable reports whether a fresh index covers the directory
+func (s *IndexSearcher) IsAvailable() bool {
+	if s.root == "" || !Fresh(s.root) {
+		return false
+	}
+	_, ok := s.relDir(s.dir)
+	return ok
+}
+
+// relDir returns dir relative to the index root, ok is false if the index
+// does not cover it because it is outside the root or hidden
+func (s *IndexSearcher) relDir(dir string) (string, bool) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(s.root, absDir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == "." {
+		return "", true
+	}
+	for _, part := range strings.Split(rel, "/") {
+		if strings.HasPrefix(part, ".") {
+			return "", false
+		}
+	}
+	return rel, true
+}
+
+// Search executes the grep_search tool using the index
+func (s *IndexSearcher) Search(req model.GrepSearchRequest) (*model.GrepSearchResponse, error) {
+	if req.Query == "" {
+		return nil, fmt.Errorf("query is required")
+	}
+	if err := model.CheckOutputMode(req.OutputMode); err != nil {
+		return nil, err
+	}
+	dir, err := dirs.GetPath(req.WorkspaceRoot, req.RelativePathToSearch, "relative_path_to_search", true)
+	if err != nil {
+		return nil, err
+	}
+	if s.root == "" {
+		return nil, fmt.Errorf("no index found for %s, create one with llm-tools index build", dir)
+	}
+	relDir, ok := s.relDir(dir)
+	if !ok {
+		return nil, fmt.Errorf("the index of %s does not cover %s", s.root, dir)
+	}
+
+	q, err := PlanQuery(req.Query, req.CaseSensitive)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex pattern: %w", err)
+	}
+	idx, err := Load(s.root)
+	if err != nil {
+		return nil, err
+	}
+	candidates := idx.FilesUnder(idx.Candidates(q), relDir)
+	if !Owned(s.root) {
+		candidates, err = idx.verify(candidates, relDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	matches, err := pure_go_search.NewPureGoSearcher().SearchPaths(req, candidates)
+	if err != nil {
+		return nil, err
+	}
+	if !model.IsContentMode(req) {
+		return model.NewFilesResponse(req, model.CountByFile(req.OutputMode, matches))
+	}
+	return model.NewResponse(req, matches)
+}
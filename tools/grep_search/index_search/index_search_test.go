@@ -0,0 +1,258 @@
+package index_search
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xhd2015/llm-tools/tools/grep_search/model"
+	"github.com/xhd2015/llm-tools/tools/grep_search/searchertest"
+)
+
+func TestIndexSearcherConformance(t *testing.T) {
+	searchertest.Run(t, func(t *testing.T, root string) model.GrepSearcher {
+		if _, err := Build(root, false); err != nil {
+			t.Fatalf("Build failed: %v", err)
+		}
+		return NewIndexSearcher(root)
+	})
+}
+
+func BenchmarkSearch(b *testing.B) {
+	searchertest.Benchmark(b, func(b *testing.B, root string) model.GrepSearcher {
+		if _, err := Build(root, false); err != nil {
+			b.Fatalf("Build failed: %v", err)
+		}
+		return NewIndexSearcher(root)
+	})
+}
+
+func TestPlanQuery(t *testing.T) {
+	tests := []struct {
+		expr          string
+		caseSensitive bool
+		want          string
+	}{
+		{"hello", true, `"ell" "hel" "llo"`},
+		{"Hello", false, `"ell" "hel" "llo"`},
+		{"ab", true, "+"},
+		{"foo|barbaz", true, `"foo" | ("arb" "bar" "baz" "rba")`},
+		// \w+ is unbounded, only the literals on each side are required
+		{"func \\w+\\(ctx", true, `"(ct" "ctx" "fun" "nc " "unc"`},
+		{"colou?r", true, `("col" "lor" "olo") | ("col" "lou" "olo" "our")`},
+		{".*", true, "+"},
+		{"école", false, "+"},
+		// trigrams are bytes, they may split a UTF-8 sequence
+		{"école", true, `"col" "ole" "\xa9co" "éc"`},
+	}
+	for _, tt := range tests {
+		q, err := PlanQuery(tt.expr, tt.caseSensitive)
+		if err != nil {
+			t.Fatalf("PlanQuery(%q) failed: %v", tt.expr, err)
+		}
+		if got := q.String(); got != tt.want {
+			t.Errorf("PlanQuery(%q, %v) = %s, want %s", tt.expr, tt.caseSensitive, got, tt.want)
+		}
+	}
+}
+
+func TestBuildIncremental(t *testing.T) {
+	root := t.TempDir()
+	write := func(name string, content string) {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a.txt", "alpha\n")
+	write("b.txt", "beta\n")
+	if _, err := Build(root, false); err != nil {
+		t.Fatal(err)
+	}
+
+	write("b.txt", "gamma gamma\n")
+	write("c.txt", "alpha again\n")
+	meta, err := Build(root, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Files != 3 || meta.Reused != 1 {
+		t.Errorf("got %d files with %d reused, want 3 with 1 reused", meta.Files, meta.Reused)
+	}
+
+	idx, err := Load(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for query, want := range map[string][]string{
+		"alpha": {"a.txt", "c.txt"},
+		"beta":  nil,
+		"gamma": {"b.txt"},
+	} {
+		q, _ := PlanQuery(query, true)
+		got := idx.FilesUnder(idx.Candidates(q), "")
+		if len(got) != len(want) {
+			t.Errorf("candidates of %s = %v, want %v", query, got, want)
+			continue
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Errorf("candidates of %s = %v, want %v", query, got, want)
+				break
+			}
+		}
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	root := t.TempDir()
+	write := func(name string, content string) {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a.txt", "alpha\n")
+	write("b.txt", "beta\n")
+	write(".gitignore", "ignored/\n")
+	built, err := Build(root, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexPath := filepath.Join(Dir(root), indexFile)
+	before, err := os.ReadFile(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// d.txt is not reported, so it stays out of the index
+	write("a.txt", "delta\n")
+	write("d.txt", "delta\n")
+	write("sub/c.txt", "delta\n")
+	write("ignored/e.txt", "delta\n")
+	if err := os.Remove(filepath.Join(root, "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := Update(root, []string{"a.txt", "b.txt", "sub", "ignored"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Files != 2 || meta.Reused != 0 || meta.Overlay != 2 {
+		t.Errorf("got %d files with %d reused and %d in the overlay, want 2 with 0 reused and 2 in the overlay", meta.Files, meta.Reused, meta.Overlay)
+	}
+	// the changes only go to the overlay
+	if !meta.BuiltAt.Equal(built.BuiltAt) {
+		t.Errorf("Update moved BuiltAt from %v to %v", built.BuiltAt, meta.BuiltAt)
+	}
+	after, err := os.ReadFile(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != string(before) {
+		t.Errorf("Update rewrote the index")
+	}
+
+	candidates := func(query string) string {
+		t.Helper()
+		idx, err := Load(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q, _ := PlanQuery(query, true)
+		return strings.Join(idx.FilesUnder(idx.Candidates(q), ""), " ")
+	}
+	if got := candidates("delta"); got != "a.txt sub/c.txt" {
+		t.Errorf("candidates of delta = %v, want a.txt sub/c.txt", got)
+	}
+	if got := candidates("beta"); got != "" {
+		t.Errorf("candidates of beta = %v, want none", got)
+	}
+
+	// a second update keeps the first one's overlay files
+	write("b.txt", "beta again\n")
+	meta, err = Update(root, []string{"b.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Files != 3 || meta.Reused != 2 || meta.Overlay != 3 {
+		t.Errorf("got %d files with %d reused and %d in the overlay, want 3 with 2 reused and 3 in the overlay", meta.Files, meta.Reused, meta.Overlay)
+	}
+	if got := candidates("delta"); got != "a.txt sub/c.txt" {
+		t.Errorf("candidates of delta = %v, want a.txt sub/c.txt", got)
+	}
+	if got := candidates("beta"); got != "b.txt" {
+		t.Errorf("candidates of beta = %v, want b.txt", got)
+	}
+
+	// a build merges the overlay
+	meta, err = Build(root, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Files != 4 || meta.Reused != 3 || meta.Overlay != 0 {
+		t.Errorf("got %d files with %d reused and %d in the overlay, want 4 with 3 reused and 0 in the overlay", meta.Files, meta.Reused, meta.Overlay)
+	}
+	if _, err := os.Stat(filepath.Join(Dir(root), overlayFile)); !os.IsNotExist(err) {
+		t.Errorf("the overlay was not removed by Build: %v", err)
+	}
+	if got := candidates("delta"); got != "a.txt d.txt sub/c.txt" {
+		t.Errorf("candidates of delta = %v, want a.txt d.txt sub/c.txt", got)
+	}
+}
+
+func TestIndexSearcherVerify(t *testing.T) {
+	root := t.TempDir()
+	write := func(name string, content string) {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a.txt", "alpha\n")
+	write("b.txt", "beta\n")
+	if _, err := Build(root, false); err != nil {
+		t.Fatal(err)
+	}
+	// changed without updating the index
+	write("b.txt", "alpha\n")
+	write("c.txt", "alpha\n")
+
+	search := func() string {
+		t.Helper()
+		searcher := NewIndexSearcher(root)
+		if !searcher.IsAvailable() {
+			t.Fatal("index not available")
+		}
+		response, err := searcher.Search(model.GrepSearchRequest{WorkspaceRoot: root, Query: "alpha", OutputMode: model.OUTPUT_FILES_WITH_MATCHES})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var files []string
+		for _, file := range response.Files {
+			files = append(files, file.File)
+		}
+		return strings.Join(files, " ")
+	}
+	if got := search(); got != "a.txt b.txt c.txt" {
+		t.Errorf("files = %s, want a.txt b.txt c.txt", got)
+	}
+
+	// an owned index is trusted as is
+	if err := Own(root); err != nil {
+		t.Fatal(err)
+	}
+	if !Owned(root) {
+		t.Fatal("index not owned")
+	}
+	if got := search(); got != "a.txt" {
+		t.Errorf("files of the owned index = %s, want a.txt", got)
+	}
+	if err := Disown(root); err != nil {
+		t.Fatal(err)
+	}
+	if Owned(root) {
+		t.Error("index still owned")
+	}
+}
@@ -0,0 +1,100 @@
+package index_search
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/xhd2015/less-gen/flags"
+)
+
+const help = `
+llm-tools index manages the trigram index that speeds up grep_search on large repositories
+
+Usage: llm-tools index <command> [OPTIONS]
+
+Commands:
+  build                        build or incrementally update the index
+  status                       show whether an index exists and is fresh
+
+Options:
+  --dir <dir>                  directory to index (default: current directory)
+  --full                       build: re-read every file instead of reusing unchanged ones
+
+The index is stored in <dir>/.llm-tools/index. grep_search uses it as is while
+llm-tools watch keeps it up to date. Otherwise it uses it while it is younger
+than LLM_TOOLS_INDEX_MAX_AGE (default 1h), checking the size and mtime of the
+files against it so that changed and new files are searched too, and falls
+back to ripgrep once it is older.
+
+Examples:
+  llm-tools index build
+  llm-tools index build --dir ~/monorepo --full
+  llm-tools index status
+`
+
+func HandleCli(args []string) error {
+	var dir string
+	var full bool
+
+	args, err := flags.String("--dir", &dir).
+		Bool("--full", &full).
+		Help("-h,--help", help).
+		Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		return fmt.Errorf("requires command: build or status, see llm-tools index --help")
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("unrecognized extra arguments: %v", strings.Join(args[1:], ","))
+	}
+
+	if dir == "" {
+		dir, err = os.Getwd()
+		if err != nil {
+			return err
+		}
+	}
+
+	switch args[0] {
+	case "build":
+		start := time.Now()
+		meta, err := Build(dir, full)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Indexed %d files (%d unchanged), %d trigrams in %v\n", meta.Files, meta.Reused, meta.Trigrams, time.Since(start).Round(time.Millisecond))
+		fmt.Printf("Index: %s\n", Dir(dir))
+		return nil
+	case "status":
+		root := FindRoot(dir)
+		if root == "" {
+			fmt.Println("No index found, create one with llm-tools index build")
+			return nil
+		}
+		meta, err := ReadMeta(root)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Index: %s\n", Dir(root))
+		fmt.Printf("Files: %d, trigrams: %d\n", meta.Files, meta.Trigrams)
+		fmt.Printf("Built: %s (%v ago)\n", meta.BuiltAt.Format(time.RFC3339), time.Since(meta.BuiltAt).Round(time.Second))
+		if meta.Overlay > 0 {
+			fmt.Printf("Updated since built: %d files\n", meta.Overlay)
+		}
+		if Owned(root) {
+			fmt.Println("Status: kept up to date by a watcher, used by grep_search")
+		} else if Fresh(root) {
+			fmt.Println("Status: fresh, used by grep_search")
+		} else {
+			fmt.Println("Status: stale, rebuild with llm-tools index build")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unrecognized command: %s, expected build or status", args[0])
+	}
+}
@@ -0,0 +1,273 @@
+package index_search
+
+import (
+	"regexp/syntax"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxExact is the largest set of exact strings tracked for a sub-expression,
+// beyond it the strings are turned into a trigram query
+const maxExact = 16
+
+// maxClass is the largest character class expanded into exact strings
+const maxClass = 8
+
+// QueryOp is the operator of a Query
+type QueryOp int
+
+const (
+	// QUERY_ALL matches every file
+	QUERY_ALL QueryOp = iota
+	// QUERY_AND matches files containing all trigrams and matching all sub queries
+	QUERY_AND
+	// QUERY_OR matches files containing any trigram or matching any sub query
+	QUERY_OR
+)
+
+// Query is a boolean combination of trigrams that a file must contain to
+// possibly match a regular expression. Trigrams are ASCII lower case, the
+// index stores them the same way so case folding never loses candidates.
+type Query struct {
+	Op       QueryOp
+	Trigrams []string
+	Sub      []*Query
+}
+
+var allQuery = &Query{Op: QUERY_ALL}
+
+// String renders the query for debugging, e.g. ("abc" "bcd") | "xyz"
+func (q *Query) String() string {
+	if q.Op == QUERY_ALL {
+		return "+"
+	}
+	var parts []string
+	for _, t := range q.Trigrams {
+		parts = append(parts, strconv.Quote(t))
+	}
+	for _, sub := range q.Sub {
+		parts = append(parts, "("+sub.String()+")")
+	}
+	sep := " "
+	if q.Op == QUERY_OR {
+		sep = " | "
+	}
+	return strings.Join(parts, sep)
+}
+
+// PlanQuery computes the trigram query of a grep_search regex. Trigrams are
+// case folded, so the plan only differs for non-ASCII letters, whose folded
+// forms may have other bytes and are then not required. Only ASCII is folded:
+// a case insensitive k or s does not find the Kelvin sign or the long s,
+// which regexp (?i) would match.
+func PlanQuery(expr string, caseSensitive bool) (*Query, error) {
+	if !caseSensitive {
+		expr = "(?i)" + expr
+	}
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+	return analyze(re.Simplify()).query(), nil
+}
+
+// info is what the analysis knows about the strings a sub-expression matches
+type info struct {
+	// exact is the set of all strings it can match, nil if unknown or too large
+	exact []string
+	// match must hold for any file containing a match
+	match *Query
+}
+
+func (i info) query() *Query {
+	if i.exact == nil {
+		return i.match
+	}
+	var alternatives []*Query
+	for _, s := range i.exact {
+		alternatives = append(alternatives, trigramQuery(s))
+	}
+	return and(i.match, or(alternatives...))
+}
+
+func exactInfo(strs ...string) info {
+	return info{exact: strs, match: allQuery}
+}
+
+func anyInfo() info {
+	return info{match: allQuery}
+}
+
+func analyze(re *syntax.Regexp) info {
+	switch re.Op {
+	case syntax.OpEmptyMatch, syntax.OpBeginLine, syntax.OpEndLine, syntax.OpBeginText,
+		syntax.OpEndText, syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+		return exactInfo("")
+	case syntax.OpLiteral:
+		s := string(re.Rune)
+		if re.Flags&syntax.FoldCase != 0 && !isASCII(s) {
+			// Unicode folding may change the bytes, e.g. É and é
+			return anyInfo()
+		}
+		return exactInfo(foldASCII(s))
+	case syntax.OpCharClass:
+		var chars []string
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			for r := re.Rune[i]; r <= re.Rune[i+1]; r++ {
+				if len(chars) >= maxClass {
+					return anyInfo()
+				}
+				chars = append(chars, foldASCII(string(r)))
+			}
+		}
+		return exactInfo(dedupe(chars)...)
+	case syntax.OpCapture:
+		return analyze(re.Sub[0])
+	case syntax.OpQuest:
+		sub := analyze(re.Sub[0])
+		if sub.exact != nil {
+			return exactInfo(dedupe(append([]string{""}, sub.exact...))...)
+		}
+		return anyInfo()
+	case syntax.OpPlus:
+		// at least one occurrence, but its extent is unknown
+		return info{match: analyze(re.Sub[0]).query()}
+	case syntax.OpRepeat:
+		if re.Min == 0 {
+			return anyInfo()
+		}
+		return info{match: analyze(re.Sub[0]).query()}
+	case syntax.OpConcat:
+		acc := exactInfo("")
+		for _, sub := range re.Sub {
+			next := analyze(sub)
+			if acc.exact != nil && next.exact != nil && len(acc.exact)*len(next.exact) <= maxExact {
+				var product []string
+				for _, a := range acc.exact {
+					for _, b := range next.exact {
+						product = append(product, a+b)
+					}
+				}
+				acc = info{exact: dedupe(product), match: and(acc.match, next.match)}
+				continue
+			}
+			// the strings across this boundary are lost, only trigrams
+			// within each side are required
+			acc = info{match: and(acc.query(), next.query())}
+		}
+		return acc
+	case syntax.OpAlternate:
+		var exact []string
+		var queries []*Query
+		allExact := true
+		for _, sub := range re.Sub {
+			i := analyze(sub)
+			queries = append(queries, i.query())
+			if i.exact == nil {
+				allExact = false
+			} else {
+				exact = append(exact, i.exact...)
+			}
+		}
+		if allExact && len(exact) <= maxExact {
+			return exactInfo(dedupe(exact)...)
+		}
+		return info{match: or(queries...)}
+	}
+	// OpAnyChar, OpAnyCharNotNL, OpStar and anything else
+	return anyInfo()
+}
+
+// trigramQuery requires all trigrams of s, strings shorter than 3 bytes match everything
+func trigramQuery(s string) *Query {
+	if len(s) < 3 {
+		return allQuery
+	}
+	q := &Query{Op: QUERY_AND}
+	for i := 0; i+3 <= len(s); i++ {
+		q.Trigrams = append(q.Trigrams, s[i:i+3])
+	}
+	q.Trigrams = dedupe(q.Trigrams)
+	return q
+}
+
+func and(queries ...*Query) *Query {
+	result := &Query{Op: QUERY_AND}
+	for _, q := range queries {
+		switch q.Op {
+		case QUERY_ALL:
+			continue
+		case QUERY_AND:
+			result.Trigrams = append(result.Trigrams, q.Trigrams...)
+			result.Sub = append(result.Sub, q.Sub...)
+		default:
+			result.Sub = append(result.Sub, q)
+		}
+	}
+	if len(result.Trigrams) == 0 && len(result.Sub) == 0 {
+		return allQuery
+	}
+	if len(result.Trigrams) == 0 && len(result.Sub) == 1 {
+		return result.Sub[0]
+	}
+	result.Trigrams = dedupe(result.Trigrams)
+	return result
+}
+
+func or(queries ...*Query) *Query {
+	result := &Query{Op: QUERY_OR}
+	for _, q := range queries {
+		switch {
+		case q.Op == QUERY_ALL:
+			return allQuery
+		case q.Op == QUERY_AND && len(q.Trigrams) == 1 && len(q.Sub) == 0:
+			result.Trigrams = append(result.Trigrams, q.Trigrams[0])
+		case q.Op == QUERY_OR:
+			result.Trigrams = append(result.Trigrams, q.Trigrams...)
+			result.Sub = append(result.Sub, q.Sub...)
+		default:
+			result.Sub = append(result.Sub, q)
+		}
+	}
+	if len(result.Trigrams) == 0 && len(result.Sub) == 0 {
+		return allQuery
+	}
+	if len(result.Trigrams) == 0 && len(result.Sub) == 1 {
+		return result.Sub[0]
+	}
+	result.Trigrams = dedupe(result.Trigrams)
+	return result
+}
+
+// foldASCII lowers ASCII letters, like the index does with file contents
+func foldASCII(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if 'A' <= c && c <= 'Z' {
+			b[i] = c + 'a' - 'A'
+		}
+	}
+	return string(b)
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+func dedupe(strs []string) []string {
+	sort.Strings(strs)
+	result := strs[:0]
+	for i, s := range strs {
+		if i == 0 || s != strs[i-1] {
+			result = append(result, s)
+		}
+	}
+	return result
+}
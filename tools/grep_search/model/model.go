@@ -1,5 +1,27 @@
 package model
 
+import (
+	"encoding/base64"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DEFAULT_LIMIT is the number of matches returned per page when the request sets no limit
+const DEFAULT_LIMIT = 50
+
+// Output modes of a search
+const (
+	// OUTPUT_CONTENT returns the matching lines, the default
+	OUTPUT_CONTENT = "content"
+	// OUTPUT_FILES_WITH_MATCHES returns only the paths of files with a match, like rg -l
+	OUTPUT_FILES_WITH_MATCHES = "files_with_matches"
+	// OUTPUT_COUNT returns the number of matching lines per file, like rg -c
+	OUTPUT_COUNT = "count"
+)
+
 // GrepSearchRequest represents the input parameters for the grep_search tool
 type GrepSearchRequest struct {
 	WorkspaceRoot        string `json:"workspace_root"`
@@ -8,25 +30,79 @@ type GrepSearchRequest struct {
 	CaseSensitive        bool   `json:"case_sensitive,omitempty"`
 	ExcludePattern       string `json:"exclude_pattern,omitempty"`
 	IncludePattern       string `json:"include_pattern,omitempty"`
-	Explanation          string `json:"explanation"`
+	// IncludePatterns and ExcludePatterns add more globs, a file is searched
+	// if it matches any include glob and no exclude glob
+	IncludePatterns []string `json:"include_patterns,omitempty"`
+	ExcludePatterns []string `json:"exclude_patterns,omitempty"`
+	// BeforeContext and AfterContext are the number of lines returned around each match
+	BeforeContext int `json:"before_context,omitempty"`
+	AfterContext  int `json:"after_context,omitempty"`
+	// Multiline lets the pattern match across lines, like rg -U
+	Multiline bool `json:"multiline,omitempty"`
+	// OutputMode is one of OUTPUT_CONTENT, OUTPUT_FILES_WITH_MATCHES or OUTPUT_COUNT, content if empty
+	OutputMode string `json:"output_mode,omitempty"`
+	// Limit is the maximum number of matches returned, or files outside content mode, DEFAULT_LIMIT if not positive
+	Limit int `json:"limit,omitempty"`
+	// Offset skips that many matches, Cursor takes precedence when set
+	Offset int `json:"offset,omitempty"`
+	// Cursor is the next_cursor of a previous response to the same query
+	Cursor string `json:"cursor,omitempty"`
+	// MaxTokens caps the tokens of the returned matches, 0 means no limit
+	MaxTokens   int    `json:"max_tokens,omitempty"`
+	Explanation string `json:"explanation"`
 }
 
-// GrepSearchMatch represents a single search match result
+// GrepSearchMatch represents a single search match result.
+// All matches on the same line, or on overlapping lines in multiline mode,
+// are reported as one match located at the first of them.
 type GrepSearchMatch struct {
-	File       string `json:"file"`
-	Line       int    `json:"line"`
+	File string `json:"file,omitempty"`
+	Line int    `json:"line"`
+	// EndLine is the last line of a match spanning several lines in multiline mode
+	EndLine    int    `json:"end_line,omitempty"`
 	Column     int    `json:"column,omitempty"`
 	Content    string `json:"content"`
 	MatchStart int    `json:"match_start,omitempty"`
 	MatchEnd   int    `json:"match_end,omitempty"`
+	// ContextBefore and ContextAfter are the surrounding lines that are not part of any match
+	ContextBefore []ContextLine `json:"context_before,omitempty"`
+	ContextAfter  []ContextLine `json:"context_after,omitempty"`
+}
+
+// ContextLine is a line around a match
+type ContextLine struct {
+	Line    int    `json:"line"`
+	Content string `json:"content"`
+}
+
+// GrepSearchFile groups the matches found in one file
+type GrepSearchFile struct {
+	File string `json:"file"`
+	// MatchCount is not reported in files_with_matches mode
+	MatchCount int `json:"match_count,omitempty"`
+	// Matches omit the file name, which is given by File. Only set in content mode.
+	Matches []GrepSearchMatch `json:"matches,omitempty"`
 }
 
 // GrepSearchResponse represents the output of the grep_search tool
 type GrepSearchResponse struct {
-	Matches      []GrepSearchMatch `json:"matches"`
-	TotalMatches int               `json:"total_matches"`
-	SearchQuery  string            `json:"search_query"`
-	Truncated    bool              `json:"truncated"`
+	// Matches is the flat list of matches. The JSON output carries them
+	// grouped in Files only, it no longer has a top-level matches key.
+	Matches []GrepSearchMatch `json:"-"`
+	Files   []GrepSearchFile  `json:"files"`
+	// TotalMatches and TotalFiles count all matches of the query, not just this page.
+	// TotalMatches is 0 in files_with_matches mode, which stops at the first match of a file.
+	TotalMatches int    `json:"total_matches"`
+	TotalFiles   int    `json:"total_files"`
+	SearchQuery  string `json:"search_query"`
+	// Offset is the position of the first returned match among all matches,
+	// or of the first returned file outside content mode
+	Offset int `json:"offset"`
+	// Truncated is set when more matches follow this page, NextCursor fetches them
+	Truncated  bool   `json:"truncated"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	// Tokens is the token count of the returned matches rendered as file:line: content
+	Tokens int `json:"tokens"`
 }
 
 // GrepSearcher defines the interface for different grep search implementations
@@ -34,3 +110,290 @@ type GrepSearcher interface {
 	Search(req GrepSearchRequest) (*GrepSearchResponse, error)
 	IsAvailable() bool
 }
+
+// GroupByFile groups matches by file, keeping the order in which files first appear
+func GroupByFile(matches []GrepSearchMatch) []GrepSearchFile {
+	files := []GrepSearchFile{}
+	index := make(map[string]int)
+	for _, match := range matches {
+		i, ok := index[match.File]
+		if !ok {
+			i = len(files)
+			index[match.File] = i
+			files = append(files, GrepSearchFile{File: match.File})
+		}
+		match.File = ""
+		files[i].Matches = append(files[i].Matches, match)
+		files[i].MatchCount++
+	}
+	return files
+}
+
+// AttachContext fills ContextBefore and ContextAfter of the matches of a single
+// file. lineAt returns the content of a one-indexed line, ok is false past the
+// end of the file or for lines the caller does not know. Lines covered by a
+// match are never reported as context.
+func AttachContext(matches []GrepSearchMatch, before int, after int, lineAt func(line int) (string, bool)) {
+	if before <= 0 && after <= 0 {
+		return
+	}
+	matched := make(map[int]bool)
+	for _, match := range matches {
+		for line := match.Line; line <= lastLine(match); line++ {
+			matched[line] = true
+		}
+	}
+	for i := range matches {
+		match := &matches[i]
+		for line := match.Line - before; line < match.Line; line++ {
+			if line < 1 || matched[line] {
+				continue
+			}
+			if content, ok := lineAt(line); ok {
+				match.ContextBefore = append(match.ContextBefore, ContextLine{Line: line, Content: content})
+			}
+		}
+		end := lastLine(*match)
+		for line := end + 1; line <= end+after; line++ {
+			if matched[line] {
+				continue
+			}
+			content, ok := lineAt(line)
+			if !ok {
+				break
+			}
+			match.ContextAfter = append(match.ContextAfter, ContextLine{Line: line, Content: content})
+		}
+	}
+}
+
+func lastLine(match GrepSearchMatch) int {
+	if match.EndLine > match.Line {
+		return match.EndLine
+	}
+	return match.Line
+}
+
+// Globs returns all include and exclude globs of the request
+func Globs(req GrepSearchRequest) (include []string, exclude []string) {
+	if req.IncludePattern != "" {
+		include = append(include, req.IncludePattern)
+	}
+	if req.ExcludePattern != "" {
+		exclude = append(exclude, req.ExcludePattern)
+	}
+	for _, pattern := range req.IncludePatterns {
+		if pattern != "" {
+			include = append(include, pattern)
+		}
+	}
+	for _, pattern := range req.ExcludePatterns {
+		if pattern != "" {
+			exclude = append(exclude, pattern)
+		}
+	}
+	return include, exclude
+}
+
+// CheckOutputMode validates the output mode of a request
+func CheckOutputMode(mode string) error {
+	switch mode {
+	case "", OUTPUT_CONTENT, OUTPUT_FILES_WITH_MATCHES, OUTPUT_COUNT:
+		return nil
+	}
+	return fmt.Errorf("invalid output_mode %q, expected %s, %s or %s", mode, OUTPUT_CONTENT, OUTPUT_FILES_WITH_MATCHES, OUTPUT_COUNT)
+}
+
+// IsContentMode reports whether the request returns matching lines
+func IsContentMode(req GrepSearchRequest) bool {
+	return req.OutputMode == "" || req.OutputMode == OUTPUT_CONTENT
+}
+
+// CountByFile reduces matches to one entry per file for the count and
+// files_with_matches modes, in the order files first appear
+func CountByFile(mode string, matches []GrepSearchMatch) []GrepSearchFile {
+	files := GroupByFile(matches)
+	for i := range files {
+		files[i].Matches = nil
+		if mode == OUTPUT_FILES_WITH_MATCHES {
+			files[i].MatchCount = 0
+		}
+	}
+	return files
+}
+
+// NewFilesResponse builds the response of a count or files_with_matches search
+// from all matching files. Files are ordered by path and paged like matches.
+func NewFilesResponse(req GrepSearchRequest, files []GrepSearchFile) (*GrepSearchResponse, error) {
+	offset, err := StartOffset(req)
+	if err != nil {
+		return nil, err
+	}
+	limit := req.Limit
+	if limit <= 0 {
+		limit = DEFAULT_LIMIT
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].File < files[j].File
+	})
+	response := &GrepSearchResponse{
+		Matches:     []GrepSearchMatch{},
+		Files:       []GrepSearchFile{},
+		TotalFiles:  len(files),
+		SearchQuery: req.Query,
+		Offset:      offset,
+	}
+	for _, file := range files {
+		response.TotalMatches += file.MatchCount
+	}
+	if offset < len(files) {
+		end := offset + limit
+		if end > len(files) {
+			end = len(files)
+		}
+		response.Files = files[offset:end]
+	}
+	next := offset + len(response.Files)
+	if next < len(files) {
+		response.Truncated = true
+		response.NextCursor = EncodeCursor(req, next)
+	}
+	return response, nil
+}
+
+// NewResponse builds the response of a search from all matches of the query.
+// Matches are ordered by file path and line so that pages are deterministic
+// across runs and backends, then the page selected by the request is kept.
+func NewResponse(req GrepSearchRequest, matches []GrepSearchMatch) (*GrepSearchResponse, error) {
+	page, err := NewPage(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, match := range matches {
+		page.Add(match)
+	}
+	return page.Response(), nil
+}
+
+// Page collects the matches of a search added in any order and keeps only
+// those up to the end of the page selected by the request, in the order of
+// NewResponse, so that searchers streaming their matches need not hold all
+// of them. All matches and their files are counted.
+type Page struct {
+	req    GrepSearchRequest
+	offset int
+	limit  int
+	// kept is sorted and holds at most offset+limit matches
+	kept  []GrepSearchMatch
+	total int
+	files map[string]bool
+}
+
+// NewPage creates the page selected by req, failing on an invalid cursor
+func NewPage(req GrepSearchRequest) (*Page, error) {
+	offset, err := StartOffset(req)
+	if err != nil {
+		return nil, err
+	}
+	limit := req.Limit
+	if limit <= 0 {
+		limit = DEFAULT_LIMIT
+	}
+	return &Page{req: req, offset: offset, limit: limit, files: make(map[string]bool)}, nil
+}
+
+// Add counts a match and keeps it if it sorts before the end of the page
+func (p *Page) Add(match GrepSearchMatch) {
+	p.total++
+	p.files[match.File] = true
+	less := func(a GrepSearchMatch, b GrepSearchMatch) bool {
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	}
+	max := p.offset + p.limit
+	i := sort.Search(len(p.kept), func(i int) bool { return less(match, p.kept[i]) })
+	if i >= max {
+		return
+	}
+	if len(p.kept) < max {
+		p.kept = append(p.kept, GrepSearchMatch{})
+	}
+	copy(p.kept[i+1:], p.kept[i:])
+	p.kept[i] = match
+}
+
+// Response returns the page with the counts of all added matches
+func (p *Page) Response() *GrepSearchResponse {
+	response := &GrepSearchResponse{
+		Matches:      []GrepSearchMatch{},
+		TotalMatches: p.total,
+		TotalFiles:   len(p.files),
+		SearchQuery:  p.req.Query,
+		Offset:       p.offset,
+	}
+	if p.offset < len(p.kept) {
+		response.Matches = p.kept[p.offset:]
+	}
+	next := p.offset + len(response.Matches)
+	if next < p.total {
+		response.Truncated = true
+		response.NextCursor = EncodeCursor(p.req, next)
+	}
+	return response
+}
+
+// StartOffset returns the offset of the first requested match, decoded from
+// the cursor if one is given
+func StartOffset(req GrepSearchRequest) (int, error) {
+	if req.Cursor == "" {
+		if req.Offset < 0 {
+			return 0, fmt.Errorf("offset must not be negative: %d", req.Offset)
+		}
+		return req.Offset, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(req.Cursor)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cursor: %w", err)
+	}
+	offsetStr, hash, ok := strings.Cut(string(data), ":")
+	offset, err := strconv.Atoi(offsetStr)
+	if !ok || err != nil || offset < 0 {
+		return 0, fmt.Errorf("invalid cursor: %s", req.Cursor)
+	}
+	if hash != queryHash(req) {
+		return 0, fmt.Errorf("cursor does not belong to this query, repeat the query without cursor")
+	}
+	return offset, nil
+}
+
+// EncodeCursor returns the cursor resuming the request at offset. It is bound
+// to the query and its filters, a cursor reused with another query is rejected.
+func EncodeCursor(req GrepSearchRequest, offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset) + ":" + queryHash(req)))
+}
+
+// queryHash identifies the parameters that determine the full list of matches
+func queryHash(req GrepSearchRequest) string {
+	mode := req.OutputMode
+	if IsContentMode(req) {
+		mode = OUTPUT_CONTENT
+	}
+	include, exclude := Globs(req)
+	h := fnv.New32a()
+	for _, part := range []string{
+		req.RelativePathToSearch,
+		req.Query,
+		strconv.FormatBool(req.CaseSensitive),
+		strings.Join(include, ","),
+		strings.Join(exclude, ","),
+		strconv.FormatBool(req.Multiline),
+		mode,
+	} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return strconv.FormatUint(uint64(h.Sum32()), 16)
+}
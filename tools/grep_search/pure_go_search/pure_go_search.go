@@ -1,20 +1,37 @@
 package pure_go_search
 
 import (
-	"bufio"
+	"bytes"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
+	pathpkg "path"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
+	"unicode/utf8"
 
 	"github.com/xhd2015/llm-tools/tools/dirs"
+	"github.com/xhd2015/llm-tools/tools/glob"
+	"github.com/xhd2015/llm-tools/tools/grep_search/model"
 	"github.com/xhd2015/llm-tools/tools/grep_search/rg_search"
+	"github.com/xhd2015/llm-tools/tools/walk"
 )
 
-// PureGoSearcher implements GrepSearcher using pure Go without external dependencies
-type PureGoSearcher struct{}
+// MAX_FILE_SIZE is the size above which files are not searched
+const MAX_FILE_SIZE = 10 * 1024 * 1024
+
+// PureGoSearcher implements GrepSearcher using pure Go without external dependencies.
+// It follows ripgrep's defaults: hidden and gitignored files are skipped, files
+// containing a NUL byte are treated as binary, and globs have gitignore semantics.
+type PureGoSearcher struct {
+	// Workers is the number of files searched concurrently, runtime.NumCPU() if not positive
+	Workers int
+}
 
 // NewPureGoSearcher creates a new pure Go searcher
 func NewPureGoSearcher() PureGoSearcher {
@@ -32,233 +49,489 @@ func (p PureGoSearcher) Search(req rg_search.GrepSearchRequest) (*rg_search.Grep
 	if req.Query == "" {
 		return nil, fmt.Errorf("query is required")
 	}
+	if err := model.CheckOutputMode(req.OutputMode); err != nil {
+		return nil, err
+	}
 
 	filePath, err := dirs.GetPath(req.WorkspaceRoot, req.RelativePathToSearch, "relative_path_to_search", true)
 	if err != nil {
 		return nil, err
 	}
 
-	// Compile regex pattern
-	regExpr := req.Query
-	if !req.CaseSensitive {
-		regExpr = "(?i)" + regExpr
-	}
-	regex, err := regexp.Compile(regExpr)
+	m, err := newMatcher(req)
 	if err != nil {
-		return nil, fmt.Errorf("invalid regex pattern: %w", err)
+		return nil, err
 	}
 
 	// Search for matches
-	matches, err := p.searchFiles(filePath, regex, req)
+	matches, err := p.searchFiles(filePath, m, req)
 	if err != nil {
 		return nil, err
 	}
 
-	// Check if results were truncated
-	truncated := len(matches) >= 50
-
-	return &rg_search.GrepSearchResponse{
-		Matches:      matches,
-		TotalMatches: len(matches),
-		SearchQuery:  req.Query,
-		Truncated:    truncated,
-	}, nil
+	if !model.IsContentMode(req) {
+		return model.NewFilesResponse(req, model.CountByFile(req.OutputMode, matches))
+	}
+	return model.NewResponse(req, matches)
 }
 
-// searchFiles recursively searches files in the directory
-func (p PureGoSearcher) searchFiles(dir string, regex *regexp.Regexp, req rg_search.GrepSearchRequest) ([]rg_search.GrepSearchMatch, error) {
-	var matches []rg_search.GrepSearchMatch
-	var includePattern, excludePattern *regexp.Regexp
-
-	// Compile include pattern if specified
-	if req.IncludePattern != "" {
-		includePattern, _ = regexp.Compile(p.globToRegex(req.IncludePattern))
+// searchFiles searches the files under dir in parallel and returns all
+// matches, the caller sorts them and selects the requested page
+func (p PureGoSearcher) searchFiles(dir string, m *matcher, req rg_search.GrepSearchRequest) ([]rg_search.GrepSearchMatch, error) {
+	includeGlobs, excludeGlobs := model.Globs(req)
+	include, err := glob.CompileAll(includeGlobs)
+	if err != nil {
+		return nil, err
 	}
-
-	// Compile exclude pattern if specified
-	if req.ExcludePattern != "" {
-		excludePattern, _ = regexp.Compile(p.globToRegex(req.ExcludePattern))
+	exclude, err := glob.CompileAll(excludeGlobs)
+	if err != nil {
+		return nil, err
 	}
 
-	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err // Skip files that can't be read
-		}
-
-		// Skip directories
-		if d.IsDir() {
-			return nil
-		}
+	var mutex sync.Mutex
+	var matches []rg_search.GrepSearchMatch
 
-		// Get relative path
+	// skip hidden and gitignored entries, as ripgrep does by default
+	err = walk.WalkParallel(dir, walk.Options{SkipHidden: true}, p.Workers, func(path string, d fs.DirEntry) error {
 		relPath, err := filepath.Rel(dir, path)
 		if err != nil {
 			relPath = path
 		}
+		slashPath := filepath.ToSlash(relPath)
 
-		// Check include pattern
-		if includePattern != nil && !includePattern.MatchString(relPath) {
+		// like rg --glob, exclude globs prune directories while include
+		// globs only select files
+		if d.IsDir() {
+			if glob.MatchAny(exclude, slashPath) {
+				return filepath.SkipDir
+			}
 			return nil
 		}
-
-		// Check exclude pattern
-		if excludePattern != nil && excludePattern.MatchString(relPath) {
+		if len(include) > 0 && !glob.MatchAny(include, slashPath) {
 			return nil
 		}
-
-		// Skip binary files and large files
-		if p.shouldSkipFile(path) {
+		if glob.MatchAny(exclude, slashPath) {
+			return nil
+		}
+		if HasBinaryExtension(path) {
 			return nil
 		}
 
-		// Search in file
-		fileMatches, err := p.searchInFile(path, relPath, regex)
-		if err != nil {
+		fileMatches, err := p.searchInFile(path, relPath, m, req)
+		if err != nil || len(fileMatches) == 0 {
 			return nil // Skip files that can't be read
 		}
-
+		mutex.Lock()
 		matches = append(matches, fileMatches...)
+		mutex.Unlock()
+		return nil
+	})
+	return matches, err
+}
 
-		// Stop if we've reached the limit
-		if len(matches) >= 50 {
-			return fmt.Errorf("reached match limit")
+// SearchPaths searches the given files instead of walking the search directory.
+// relPaths are relative to the search directory of req and are filtered by its
+// globs, an exclude glob matching a parent directory excludes the file. It lets
+// searchers that select candidate files themselves share this engine.
+func (p PureGoSearcher) SearchPaths(req rg_search.GrepSearchRequest, relPaths []string) ([]rg_search.GrepSearchMatch, error) {
+	if err := model.CheckOutputMode(req.OutputMode); err != nil {
+		return nil, err
+	}
+	dir, err := dirs.GetPath(req.WorkspaceRoot, req.RelativePathToSearch, "relative_path_to_search", true)
+	if err != nil {
+		return nil, err
+	}
+	m, err := newMatcher(req)
+	if err != nil {
+		return nil, err
+	}
+	includeGlobs, excludeGlobs := model.Globs(req)
+	include, err := glob.CompileAll(includeGlobs)
+	if err != nil {
+		return nil, err
+	}
+	exclude, err := glob.CompileAll(excludeGlobs)
+	if err != nil {
+		return nil, err
+	}
+
+	workers := p.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var matches []rg_search.GrepSearchMatch
+	sem := make(chan struct{}, workers)
+	for _, relPath := range relPaths {
+		slashPath := filepath.ToSlash(relPath)
+		if len(include) > 0 && !glob.MatchAny(include, slashPath) {
+			continue
+		}
+		if excludedPath(exclude, slashPath) || HasBinaryExtension(relPath) {
+			continue
 		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(relPath string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fileMatches, err := p.searchInFile(filepath.Join(dir, relPath), relPath, m, req)
+			if err != nil || len(fileMatches) == 0 {
+				return
+			}
+			mutex.Lock()
+			matches = append(matches, fileMatches...)
+			mutex.Unlock()
+		}(relPath)
+	}
+	wg.Wait()
+	return matches, nil
+}
 
-		return nil
-	})
+// excludedPath reports whether the slash separated path or one of its parent
+// directories matches an exclude glob
+func excludedPath(exclude []*glob.Pattern, slashPath string) bool {
+	for path := slashPath; path != "."; path = pathpkg.Dir(path) {
+		if glob.MatchAny(exclude, path) {
+			return true
+		}
+	}
+	return false
+}
 
-	// If we stopped due to reaching limit, ignore the error
-	if err != nil && err.Error() == "reached match limit" {
-		err = nil
+// matcher finds the matching lines of a file
+type matcher struct {
+	regex     *regexp.Regexp
+	multiline bool
+	// literal is set when the query has no regex metacharacters, it is then
+	// searched with bytes.Index. It is lower case when foldCase is set.
+	literal  []byte
+	foldCase bool
+	// prefilter matches the whole file at least wherever regex matches a line,
+	// so files it rejects are skipped without splitting them into lines
+	prefilter *regexp.Regexp
+}
+
+func newMatcher(req rg_search.GrepSearchRequest) (*matcher, error) {
+	// Compile regex pattern
+	regExpr := req.Query
+	if !req.CaseSensitive {
+		regExpr = "(?i)" + regExpr
+	}
+	regex, err := regexp.Compile(regExpr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex pattern: %w", err)
 	}
 
-	// Limit to 50 matches
-	if len(matches) > 50 {
-		matches = matches[:50]
+	m := &matcher{regex: regex, multiline: req.Multiline}
+	if req.Multiline {
+		// the regex already runs once over the whole file
+		return m, nil
 	}
+	if isLiteral(req.Query, req.CaseSensitive) {
+		m.literal = []byte(req.Query)
+		if !req.CaseSensitive {
+			m.foldCase = true
+			m.literal = asciiLower(m.literal)
+		}
+		return m, nil
+	}
+	// \A and \z anchor at each line when matching lines but not in the whole file
+	if !strings.Contains(req.Query, `\A`) && !strings.Contains(req.Query, `\z`) {
+		m.prefilter, err = regexp.Compile("(?m)" + regExpr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex pattern: %w", err)
+		}
+	}
+	return m, nil
+}
 
-	return matches, err
+// isLiteral reports whether query can be searched with bytes.Index. Case
+// insensitive queries must be ASCII, and without k and s whose Unicode case
+// folding includes the Kelvin sign and the long s.
+func isLiteral(query string, caseSensitive bool) bool {
+	if regexp.QuoteMeta(query) != query || strings.Contains(query, "\n") {
+		return false
+	}
+	if caseSensitive {
+		return true
+	}
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		if c >= utf8.RuneSelf || c == 'k' || c == 'K' || c == 's' || c == 'S' {
+			return false
+		}
+	}
+	return true
 }
 
-// searchInFile searches for matches in a single file
-func (p PureGoSearcher) searchInFile(fullPath string, relPath string, regex *regexp.Regexp) ([]rg_search.GrepSearchMatch, error) {
-	var matches []rg_search.GrepSearchMatch
+// asciiLower lowers ASCII letters, keeping byte offsets unchanged
+func asciiLower(data []byte) []byte {
+	lower := make([]byte, len(data))
+	for i, c := range data {
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		lower[i] = c
+	}
+	return lower
+}
 
-	file, err := os.Open(fullPath)
+// bufferPool recycles the buffers files are read into
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, 64*1024)
+		return &buf
+	},
+}
+
+// readFile reads a file into a pooled buffer in chunks, it returns nil for
+// files above MAX_FILE_SIZE. The buffer must be released with putBuffer.
+func readFile(path string) (*[]byte, error) {
+	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	lineNumber := 0
-
-	for scanner.Scan() {
-		lineNumber++
-		line := scanner.Text()
-
-		// Find all matches in this line
-		indices := regex.FindAllStringIndex(line, -1)
-		for _, match := range indices {
-			result := rg_search.GrepSearchMatch{
-				File:       relPath,
-				Line:       lineNumber,
-				Column:     match[0] + 1, // Convert to 1-indexed
-				Content:    line,
-				MatchStart: match[0],
-				MatchEnd:   match[1],
-			}
-			matches = append(matches, result)
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > MAX_FILE_SIZE {
+		return nil, nil
+	}
 
-			// Stop if we've reached the limit
-			if len(matches) >= 50 {
-				return matches, nil
-			}
+	bufp := bufferPool.Get().(*[]byte)
+	buf := (*bufp)[:0]
+	if int64(cap(buf)) < info.Size()+1 {
+		buf = make([]byte, 0, info.Size()+1)
+	}
+	for {
+		if len(buf) == cap(buf) {
+			// the file grew since Stat
+			buf = append(buf, 0)[:len(buf)]
+		}
+		n, err := f.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			*bufp = buf
+			putBuffer(bufp)
+			return nil, err
+		}
+		if len(buf) > MAX_FILE_SIZE {
+			*bufp = buf
+			putBuffer(bufp)
+			return nil, nil
 		}
 	}
+	*bufp = buf
+	return bufp, nil
+}
 
-	if err := scanner.Err(); err != nil {
+func putBuffer(bufp *[]byte) {
+	// don't keep the memory of exceptionally large files around
+	if cap(*bufp) <= 1024*1024 {
+		bufferPool.Put(bufp)
+	}
+}
+
+// searchInFile searches for matches in a single file. Like ripgrep, all
+// occurrences on one line are reported as a single match at the first of them.
+func (p PureGoSearcher) searchInFile(fullPath string, relPath string, m *matcher, req rg_search.GrepSearchRequest) ([]rg_search.GrepSearchMatch, error) {
+	bufp, err := readFile(fullPath)
+	if err != nil || bufp == nil {
 		return nil, err
 	}
+	defer putBuffer(bufp)
+	data := *bufp
+
+	// binary file, ripgrep skips those when searching directories
+	if bytes.IndexByte(data, 0) >= 0 {
+		return nil, nil
+	}
+
+	var matches []rg_search.GrepSearchMatch
+	switch {
+	case m.multiline:
+		matches = matchMultiline(data, newLineIndex(data), relPath, m.regex)
+	case m.literal != nil:
+		matches = matchLiteral(data, relPath, m)
+	default:
+		if m.prefilter != nil && !m.prefilter.Match(data) {
+			return nil, nil
+		}
+		lines := newLineIndex(data)
+		for i := range lines {
+			line := lines.line(data, i)
+			loc := m.regex.FindIndex(line)
+			if loc == nil {
+				continue
+			}
+			matches = append(matches, newMatch(relPath, i, line, loc))
+		}
+	}
+	if len(matches) == 0 || !model.IsContentMode(req) {
+		// only the number of matching lines is reported
+		return matches, nil
+	}
 
+	lines := newLineIndex(data)
+	model.AttachContext(matches, req.BeforeContext, req.AfterContext, func(line int) (string, bool) {
+		if line < 1 || line > len(lines) {
+			return "", false
+		}
+		return string(bytes.TrimSuffix(lines.line(data, line-1), []byte("\r"))), true
+	})
 	return matches, nil
 }
 
-// shouldSkipFile determines if a file should be skipped based on its properties
-func (p PureGoSearcher) shouldSkipFile(filePath string) bool {
-	// Get file info
-	info, err := os.Stat(filePath)
-	if err != nil {
-		return true
+// matchLiteral finds the lines containing m.literal with bytes.Index
+func matchLiteral(data []byte, relPath string, m *matcher) []rg_search.GrepSearchMatch {
+	haystack := data
+	if m.foldCase {
+		haystack = asciiLower(data)
 	}
-
-	// Skip large files (> 10MB)
-	if info.Size() > 10*1024*1024 {
-		return true
+	var matches []rg_search.GrepSearchMatch
+	var lines lineIndex
+	for offset := 0; offset <= len(haystack); {
+		idx := bytes.Index(haystack[offset:], m.literal)
+		if idx < 0 {
+			break
+		}
+		if lines == nil {
+			lines = newLineIndex(data)
+		}
+		pos := offset + idx
+		i := lines.lineOf(pos)
+		start := lines[i]
+		matches = append(matches, newMatch(relPath, i, lines.line(data, i), []int{pos - start, pos - start + len(m.literal)}))
+		// continue on the next line, one match per line
+		end := bytes.IndexByte(haystack[pos:], '\n')
+		if end < 0 {
+			break
+		}
+		offset = pos + end + 1
 	}
+	return matches
+}
 
-	// Skip common binary file extensions
-	ext := strings.ToLower(filepath.Ext(filePath))
-	binaryExtensions := []string{
-		".exe", ".dll", ".so", ".dylib", ".a", ".lib", ".o", ".obj",
-		".bin", ".dat", ".db", ".sqlite", ".sqlite3",
-		".jpg", ".jpeg", ".png", ".gif", ".bmp", ".tiff", ".ico",
-		".mp3", ".mp4", ".avi", ".mov", ".wmv", ".flv", ".webm",
-		".pdf", ".doc", ".docx", ".xls", ".xlsx", ".ppt", ".pptx",
-		".zip", ".tar", ".gz", ".bz2", ".7z", ".rar",
-		".class", ".jar", ".war", ".ear",
-		".pyc", ".pyo", ".pyd",
-		".node", ".wasm",
+// newMatch creates the match of the zero-indexed line i at loc
+func newMatch(relPath string, i int, line []byte, loc []int) rg_search.GrepSearchMatch {
+	return rg_search.GrepSearchMatch{
+		File:       relPath,
+		Line:       i + 1,
+		Column:     loc[0] + 1, // Convert to 1-indexed
+		Content:    string(bytes.TrimSuffix(line, []byte("\r"))),
+		MatchStart: loc[0],
+		MatchEnd:   loc[1],
 	}
+}
 
-	for _, binaryExt := range binaryExtensions {
-		if ext == binaryExt {
-			return true
+// lineIndex holds the start offsets of the lines of a file. A trailing
+// newline does not start another line.
+type lineIndex []int
+
+func newLineIndex(data []byte) lineIndex {
+	if len(data) == 0 {
+		return lineIndex{}
+	}
+	starts := lineIndex{0}
+	for i, c := range data {
+		if c == '\n' && i+1 < len(data) {
+			starts = append(starts, i+1)
 		}
 	}
+	return starts
+}
 
-	// Check if file appears to be binary by reading first few bytes
-	file, err := os.Open(filePath)
-	if err != nil {
-		return true
+// line returns the zero-indexed line i without its newline
+func (idx lineIndex) line(data []byte, i int) []byte {
+	end := len(data)
+	if i+1 < len(idx) {
+		end = idx[i+1] - 1
 	}
-	defer file.Close()
+	return bytes.TrimSuffix(data[idx[i]:end], []byte("\n"))
+}
 
-	buffer := make([]byte, 512)
-	n, err := file.Read(buffer)
-	if err != nil && n == 0 {
-		return true
-	}
+// lineOf returns the zero-indexed line containing the byte offset
+func (idx lineIndex) lineOf(offset int) int {
+	return sort.Search(len(idx), func(i int) bool { return idx[i] > offset }) - 1
+}
 
-	// Check for null bytes (common in binary files)
-	for i := 0; i < n; i++ {
-		if buffer[i] == 0 {
-			return true
+// matchMultiline matches regex against the whole file. Matches sharing a line
+// are merged into one, whose content covers all lines they span, as rg -U does.
+func matchMultiline(data []byte, lines lineIndex, relPath string, regex *regexp.Regexp) []rg_search.GrepSearchMatch {
+	var matches []rg_search.GrepSearchMatch
+	var startLine, endLine int
+	var first []int
+	flush := func() {
+		if first == nil {
+			return
+		}
+		blockStart := lines[startLine]
+		contentLines := make([]string, 0, endLine-startLine+1)
+		for i := startLine; i <= endLine; i++ {
+			contentLines = append(contentLines, string(bytes.TrimSuffix(lines.line(data, i), []byte("\r"))))
+		}
+		match := rg_search.GrepSearchMatch{
+			File:       relPath,
+			Line:       startLine + 1,
+			Column:     first[0] - blockStart + 1,
+			Content:    strings.Join(contentLines, "\n"),
+			MatchStart: first[0] - blockStart,
+			MatchEnd:   first[1] - blockStart,
 		}
+		if endLine > startLine {
+			match.EndLine = endLine + 1
+		}
+		matches = append(matches, match)
+		first = nil
 	}
+	for _, loc := range regex.FindAllIndex(data, -1) {
+		if loc[0] >= len(data) && len(lines) > 0 {
+			// an empty match at the very end of the file
+			continue
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		matchStart := lines.lineOf(loc[0])
+		matchEnd := matchStart
+		if loc[1] > loc[0] {
+			matchEnd = lines.lineOf(loc[1] - 1)
+		}
+		if first != nil && matchStart <= endLine {
+			if matchEnd > endLine {
+				endLine = matchEnd
+			}
+			continue
+		}
+		flush()
+		first = loc
+		startLine, endLine = matchStart, matchEnd
+	}
+	flush()
+	return matches
+}
 
-	return false
+// binaryExtensions are skipped without reading them
+var binaryExtensions = map[string]bool{
+	".exe": true, ".dll": true, ".so": true, ".dylib": true, ".a": true, ".lib": true, ".o": true, ".obj": true,
+	".bin": true, ".dat": true, ".db": true, ".sqlite": true, ".sqlite3": true,
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".bmp": true, ".tiff": true, ".ico": true,
+	".mp3": true, ".mp4": true, ".avi": true, ".mov": true, ".wmv": true, ".flv": true, ".webm": true,
+	".pdf": true, ".doc": true, ".docx": true, ".xls": true, ".xlsx": true, ".ppt": true, ".pptx": true,
+	".zip": true, ".tar": true, ".gz": true, ".bz2": true, ".7z": true, ".rar": true,
+	".class": true, ".jar": true, ".war": true, ".ear": true,
+	".pyc": true, ".pyo": true, ".pyd": true,
+	".node": true, ".wasm": true,
 }
 
-// globToRegex converts a glob pattern to a regular expression
-func (p PureGoSearcher) globToRegex(glob string) string {
-	// Simple glob to regex conversion
-	// * -> .*
-	// ? -> .
-	// Escape other regex special characters
-	result := strings.ReplaceAll(glob, ".", "\\.")
-	result = strings.ReplaceAll(result, "*", ".*")
-	result = strings.ReplaceAll(result, "?", ".")
-	result = strings.ReplaceAll(result, "+", "\\+")
-	result = strings.ReplaceAll(result, "^", "\\^")
-	result = strings.ReplaceAll(result, "$", "\\$")
-	result = strings.ReplaceAll(result, "(", "\\(")
-	result = strings.ReplaceAll(result, ")", "\\)")
-	result = strings.ReplaceAll(result, "[", "\\[")
-	result = strings.ReplaceAll(result, "]", "\\]")
-	result = strings.ReplaceAll(result, "{", "\\{")
-	result = strings.ReplaceAll(result, "}", "\\}")
-	result = strings.ReplaceAll(result, "|", "\\|")
-
-	return "^" + result + "$"
+// HasBinaryExtension reports whether the file extension denotes a binary format
+func HasBinaryExtension(filePath string) bool {
+	return binaryExtensions[strings.ToLower(filepath.Ext(filePath))]
 }
@@ -3,10 +3,12 @@ package pure_go_search
 import (
 	"os"
 	"path/filepath"
-	"regexp"
+	"strings"
 	"testing"
 
+	"github.com/xhd2015/llm-tools/tools/grep_search/model"
 	"github.com/xhd2015/llm-tools/tools/grep_search/rg_search"
+	"github.com/xhd2015/llm-tools/tools/grep_search/searchertest"
 )
 
 // createTestFiles creates temporary test files for testing
@@ -346,90 +348,93 @@ func TestPureGoSearcher_Search_MatchDetails(t *testing.T) {
 	}
 }
 
-func TestPureGoSearcher_shouldSkipFile(t *testing.T) {
-	searcher := NewPureGoSearcher()
+func TestPureGoSearcher_Search_Globs(t *testing.T) {
 	tmpDir := t.TempDir()
+	for _, name := range []string{
+		"main.go",
+		"main_test.go",
+		"cmd/tool/tool.go",
+		"cmd/tool/tool.ts",
+		"vendor/lib/lib.go",
+		"web/app.ts",
+		"web/app.tsx",
+	} {
+		path := filepath.Join(tmpDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte("needle\n"), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+	searcher := NewPureGoSearcher()
 
 	tests := []struct {
-		name        string
-		fileName    string
-		content     []byte
-		shouldSkip  bool
-		description string
+		name    string
+		include []string
+		exclude []string
+		want    []string
 	}{
-		{
-			name:        "text file",
-			fileName:    "test.txt",
-			content:     []byte("hello world"),
-			shouldSkip:  false,
-			description: "regular text file should not be skipped",
-		},
-		{
-			name:        "binary file",
-			fileName:    "test.bin",
-			content:     []byte{0x00, 0x01, 0x02, 0x03},
-			shouldSkip:  true,
-			description: "binary file should be skipped",
-		},
-		{
-			name:        "executable",
-			fileName:    "test.exe",
-			content:     []byte("hello world"),
-			shouldSkip:  true,
-			description: "executable file should be skipped by extension",
-		},
-		{
-			name:        "go file",
-			fileName:    "test.go",
-			content:     []byte("package main"),
-			shouldSkip:  false,
-			description: "go file should not be skipped",
-		},
+		{"basename at any depth", []string{"*.go"}, nil, []string{"cmd/tool/tool.go", "main.go", "main_test.go", "vendor/lib/lib.go"}},
+		{"star does not cross slashes", []string{"cmd/*.go"}, nil, nil},
+		{"doublestar", []string{"cmd/**/*.go"}, nil, []string{"cmd/tool/tool.go"}},
+		{"braces", []string{"*.{ts,tsx}"}, nil, []string{"cmd/tool/tool.ts", "web/app.ts", "web/app.tsx"}},
+		{"several includes", []string{"*.tsx", "main*.go"}, nil, []string{"main.go", "main_test.go", "web/app.tsx"}},
+		{"excluded directory", []string{"*.go"}, []string{"vendor", "*_test.go"}, []string{"cmd/tool/tool.go", "main.go"}},
 	}
-
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			filePath := filepath.Join(tmpDir, tt.fileName)
-			if err := os.WriteFile(filePath, tt.content, 0644); err != nil {
-				t.Fatalf("Failed to create test file: %v", err)
+			req := rg_search.GrepSearchRequest{
+				WorkspaceRoot:   tmpDir,
+				Query:           "needle",
+				IncludePatterns: tt.include,
+				ExcludePatterns: tt.exclude,
+				OutputMode:      "files_with_matches",
 			}
-
-			result := searcher.shouldSkipFile(filePath)
-			if result != tt.shouldSkip {
-				t.Errorf("shouldSkipFile(%s) = %v, expected %v (%s)",
-					tt.fileName, result, tt.shouldSkip, tt.description)
+			response, err := searcher.Search(req)
+			if err != nil {
+				t.Fatalf("Search failed: %v", err)
+			}
+			var got []string
+			for _, file := range response.Files {
+				got = append(got, filepath.ToSlash(file.File))
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Expected files %v, got %v", tt.want, got)
 			}
 		})
 	}
 }
 
-func TestPureGoSearcher_globToRegex(t *testing.T) {
+func TestPureGoSearcher_Search_Literal(t *testing.T) {
+	tmpDir := t.TempDir()
+	content := "Hello World\r\nhello again, HELLO\nbye\n"
+	if err := os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
 	searcher := NewPureGoSearcher()
 
-	tests := []struct {
-		glob     string
-		expected string
-	}{
-		{"*.go", "^.*\\.go$"},
-		{"test.txt", "^test\\.txt$"},
-		{"dir/*", "^dir/.*$"},
-		{"file?.txt", "^file.\\.txt$"},
-		{"test[abc]", "^test\\[abc\\]$"},
-		{"file{1,2}", "^file\\{1,2\\}$"},
+	response, err := searcher.Search(rg_search.GrepSearchRequest{WorkspaceRoot: tmpDir, Query: "hello"})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	// one match per line, CR stripped from the content
+	if len(response.Matches) != 2 {
+		t.Fatalf("Expected 2 matches, got %d", len(response.Matches))
+	}
+	if m := response.Matches[0]; m.Line != 1 || m.Column != 1 || m.Content != "Hello World" {
+		t.Errorf("Unexpected first match: %+v", m)
+	}
+	if m := response.Matches[1]; m.Line != 2 || m.Column != 1 || m.MatchEnd != 5 {
+		t.Errorf("Unexpected second match: %+v", m)
 	}
 
-	for _, tt := range tests {
-		t.Run(tt.glob, func(t *testing.T) {
-			result := searcher.globToRegex(tt.glob)
-			if result != tt.expected {
-				t.Errorf("globToRegex(%s) = %s, expected %s", tt.glob, result, tt.expected)
-			}
-
-			// Also test that the regex compiles
-			if _, err := regexp.Compile(result); err != nil {
-				t.Errorf("Generated regex %s does not compile: %v", result, err)
-			}
-		})
+	response, err = searcher.Search(rg_search.GrepSearchRequest{WorkspaceRoot: tmpDir, Query: "HELLO", CaseSensitive: true})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(response.Matches) != 1 || response.Matches[0].Column != 14 {
+		t.Errorf("Expected HELLO at 2:14, got %+v", response.Matches)
 	}
 }
 
@@ -469,3 +474,200 @@ func TestPureGoSearcher_Search_Truncation(t *testing.T) {
 		t.Error("Expected truncated flag to be true when matches reach limit")
 	}
 }
+
+func TestPureGoSearcher_Search_Context(t *testing.T) {
+	tmpDir := createTestFiles(t)
+	searcher := NewPureGoSearcher()
+
+	req := rg_search.GrepSearchRequest{
+		WorkspaceRoot:  tmpDir,
+		Query:          "pattern",
+		IncludePattern: "file1.txt",
+		BeforeContext:  1,
+		AfterContext:   1,
+	}
+
+	response, err := searcher.Search(req)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(response.Matches) != 2 {
+		t.Fatalf("Expected 2 matches, got %d", len(response.Matches))
+	}
+
+	first := response.Matches[0]
+	if len(first.ContextBefore) != 1 || first.ContextBefore[0].Line != 1 || first.ContextBefore[0].Content != "line one" {
+		t.Errorf("Unexpected context before first match: %+v", first.ContextBefore)
+	}
+	if len(first.ContextAfter) != 1 || first.ContextAfter[0].Line != 3 {
+		t.Errorf("Unexpected context after first match: %+v", first.ContextAfter)
+	}
+
+	// line 3 is context of both matches, the last line has nothing after it
+	second := response.Matches[1]
+	if len(second.ContextBefore) != 1 || second.ContextBefore[0].Line != 3 {
+		t.Errorf("Unexpected context before second match: %+v", second.ContextBefore)
+	}
+	if len(second.ContextAfter) != 0 {
+		t.Errorf("Expected no context after last line, got %+v", second.ContextAfter)
+	}
+}
+
+func TestPureGoSearcher_Search_Multiline(t *testing.T) {
+	tmpDir := createTestFiles(t)
+	searcher := NewPureGoSearcher()
+
+	req := rg_search.GrepSearchRequest{
+		WorkspaceRoot: tmpDir,
+		Query:         `func main\(\) \{\n\s*fmt`,
+		Multiline:     true,
+	}
+
+	response, err := searcher.Search(req)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(response.Matches) != 1 {
+		t.Fatalf("Expected 1 match, got %d", len(response.Matches))
+	}
+	match := response.Matches[0]
+	if match.File != "file2.go" || match.Line != 5 || match.EndLine != 6 {
+		t.Errorf("Unexpected match: %+v", match)
+	}
+
+	// without multiline the newline can never match
+	req.Multiline = false
+	response, err = searcher.Search(req)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(response.Matches) != 0 {
+		t.Errorf("Expected no matches without multiline, got %d", len(response.Matches))
+	}
+}
+
+func TestPureGoSearcher_Search_Pagination(t *testing.T) {
+	tmpDir := t.TempDir()
+	// 3 files with 30 matches each, so the default page ends in the middle of b.txt
+	for _, name := range []string{"c.txt", "a.txt", "b.txt"} {
+		content := ""
+		for i := 0; i < 30; i++ {
+			content += "pattern\n"
+		}
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+	searcher := NewPureGoSearcher()
+
+	req := rg_search.GrepSearchRequest{
+		WorkspaceRoot: tmpDir,
+		Query:         "pattern",
+	}
+	response, err := searcher.Search(req)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if response.TotalMatches != 90 || response.TotalFiles != 3 {
+		t.Errorf("Expected totals 90 matches in 3 files, got %d in %d", response.TotalMatches, response.TotalFiles)
+	}
+	if len(response.Matches) != 50 || !response.Truncated || response.NextCursor == "" {
+		t.Fatalf("Expected a truncated first page of 50, got %d (truncated=%v)", len(response.Matches), response.Truncated)
+	}
+	last := response.Matches[49]
+	if last.File != "b.txt" || last.Line != 20 {
+		t.Errorf("Expected the page to end at b.txt:20, got %s:%d", last.File, last.Line)
+	}
+
+	// the cursor continues right after the first page
+	req.Cursor = response.NextCursor
+	response, err = searcher.Search(req)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(response.Matches) != 40 || response.Truncated || response.NextCursor != "" {
+		t.Errorf("Expected a final page of 40, got %d (truncated=%v)", len(response.Matches), response.Truncated)
+	}
+	if first := response.Matches[0]; first.File != "b.txt" || first.Line != 21 || response.Offset != 50 {
+		t.Errorf("Expected the page to start at b.txt:21, got %s:%d at offset %d", first.File, first.Line, response.Offset)
+	}
+
+	// a cursor cannot be reused for another query
+	req.Query = "other"
+	if _, err := searcher.Search(req); err == nil {
+		t.Error("Expected an error for a cursor of another query")
+	}
+
+	req = rg_search.GrepSearchRequest{WorkspaceRoot: tmpDir, Query: "pattern", Offset: 85, Limit: 10}
+	response, err = searcher.Search(req)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(response.Matches) != 5 || response.Matches[0].File != "c.txt" || response.Matches[0].Line != 26 {
+		t.Errorf("Unexpected page for offset 85: %+v", response.Matches)
+	}
+}
+
+func TestPureGoSearcher_Search_OutputModes(t *testing.T) {
+	tmpDir := createTestFiles(t)
+	searcher := NewPureGoSearcher()
+
+	req := rg_search.GrepSearchRequest{
+		WorkspaceRoot: tmpDir,
+		Query:         "pattern",
+		OutputMode:    "count",
+	}
+	response, err := searcher.Search(req)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	counts := make(map[string]int)
+	for _, file := range response.Files {
+		counts[file.File] = file.MatchCount
+		if len(file.Matches) != 0 {
+			t.Errorf("Expected no matches in count mode for %s", file.File)
+		}
+	}
+	want := map[string]int{"file1.txt": 2, "file2.go": 2, "file3.json": 2, filepath.Join("subdir", "nested.txt"): 1}
+	if len(counts) != len(want) {
+		t.Errorf("Expected counts %v, got %v", want, counts)
+	}
+	for file, n := range want {
+		if counts[file] != n {
+			t.Errorf("Expected %d matches in %s, got %d", n, file, counts[file])
+		}
+	}
+	if response.TotalMatches != 7 || response.TotalFiles != 4 {
+		t.Errorf("Expected 7 matches in 4 files, got %d in %d", response.TotalMatches, response.TotalFiles)
+	}
+
+	req.OutputMode = "files_with_matches"
+	req.Limit = 3
+	response, err = searcher.Search(req)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(response.Files) != 3 || response.Files[0].File != "file1.txt" || response.Files[0].MatchCount != 0 {
+		t.Errorf("Unexpected files: %+v", response.Files)
+	}
+	if !response.Truncated || response.TotalFiles != 4 {
+		t.Errorf("Expected a truncated page of 4 files, got truncated=%v total=%d", response.Truncated, response.TotalFiles)
+	}
+
+	req.OutputMode = "lines"
+	if _, err := searcher.Search(req); err == nil {
+		t.Error("Expected an error for an unknown output mode")
+	}
+}
+
+func TestPureGoSearcherConformance(t *testing.T) {
+	searchertest.Run(t, func(t *testing.T, root string) model.GrepSearcher {
+		return NewPureGoSearcher()
+	})
+}
+
+func BenchmarkSearch(b *testing.B) {
+	searchertest.Benchmark(b, func(b *testing.B, root string) model.GrepSearcher {
+		return NewPureGoSearcher()
+	})
+}
@@ -1,14 +1,20 @@
 package rg_search
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
 
 	"github.com/xhd2015/llm-tools/tools/dirs"
 	"github.com/xhd2015/llm-tools/tools/grep_search/model"
+	"github.com/xhd2015/llm-tools/tools/walk"
 )
 
 // GrepSearchRequest represents the input parameters for the grep_search tool
@@ -40,12 +46,19 @@ func (r *RipgrepSearcher) Search(req GrepSearchRequest) (*GrepSearchResponse, er
 	if req.Query == "" {
 		return nil, fmt.Errorf("query is required")
 	}
+	if err := model.CheckOutputMode(req.OutputMode); err != nil {
+		return nil, err
+	}
 
 	// Check if ripgrep is available
 	if !r.IsAvailable() {
 		return nil, fmt.Errorf("ripgrep (rg) is not installed or not in PATH")
 	}
 
+	if !model.IsContentMode(req) {
+		return r.searchFileList(req)
+	}
+
 	// Try JSON-based search first
 	response, err := r.searchWithJSON(req)
 	if err != nil {
@@ -59,20 +72,29 @@ func (r *RipgrepSearcher) Search(req GrepSearchRequest) (*GrepSearchResponse, er
 	return response, nil
 }
 
-// searchWithJSON executes ripgrep with JSON output
+// MAX_JSON_LINE bounds a line of rg --json output, which holds a matching
+// line and grows with it
+const MAX_JSON_LINE = 64 << 20
+
+// searchWithJSON executes ripgrep with JSON output. The output is streamed:
+// every match is counted but only those up to the end of the page are kept.
 func (r *RipgrepSearcher) searchWithJSON(req GrepSearchRequest) (*GrepSearchResponse, error) {
 	filePath, err := dirs.GetPath(req.WorkspaceRoot, req.RelativePathToSearch, "relative_path_to_search", true)
 	if err != nil {
 		return nil, err
 	}
+	page, err := model.NewPage(req)
+	if err != nil {
+		return nil, err
+	}
 
 	// Build ripgrep command
 	args := []string{
-		"--json",            // Output in JSON format for easier parsing
-		"--line-number",     // Include line numbers
-		"--column",          // Include column numbers
-		"--no-heading",      // Don't group by file
-		"--max-count", "50", // Limit to 50 matches total
+		"--json",        // Output in JSON format for easier parsing
+		"--line-number", // Include line numbers
+		"--column",      // Include column numbers
+		"--no-heading",  // Don't group by file
+		// no --max-count: it is per file, the page is cut from all matches
 	}
 
 	// Add case sensitivity option
@@ -80,18 +102,23 @@ func (r *RipgrepSearcher) searchWithJSON(req GrepSearchRequest) (*GrepSearchResp
 		args = append(args, "--ignore-case")
 	}
 
-	// Add include pattern if specified
-	if req.IncludePattern != "" {
-		args = append(args, "--glob", req.IncludePattern)
-	}
+	// Add include and exclude globs
+	args = append(args, globArgs(req)...)
 
-	// Add exclude pattern if specified
-	if req.ExcludePattern != "" {
-		args = append(args, "--glob", "!"+req.ExcludePattern)
+	if req.BeforeContext > 0 {
+		args = append(args, "--before-context", strconv.Itoa(req.BeforeContext))
+	}
+	if req.AfterContext > 0 {
+		args = append(args, "--after-context", strconv.Itoa(req.AfterContext))
+	}
+	if req.Multiline {
+		args = append(args, "--multiline")
 	}
 
-	// Add the search pattern
-	args = append(args, req.Query)
+	args = append(args, ignoreFileArgs(filePath)...)
+
+	// Add the search pattern, --regexp keeps a pattern starting with - from being a flag
+	args = append(args, "--regexp", req.Query)
 
 	// Add search path (current directory)
 	args = append(args, ".")
@@ -99,48 +126,44 @@ func (r *RipgrepSearcher) searchWithJSON(req GrepSearchRequest) (*GrepSearchResp
 	// Execute ripgrep command
 	cmd := exec.Command("rg", args...)
 	cmd.Dir = filePath
-	output, err := cmd.Output()
-
-	// ripgrep returns exit code 1 when no matches are found, which is not an error
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		if exitError, ok := err.(*exec.ExitError); ok {
-			if exitError.ExitCode() == 1 {
-				// No matches found
-				return &GrepSearchResponse{
-					Matches:      []GrepSearchMatch{},
-					TotalMatches: 0,
-					SearchQuery:  req.Query,
-					Truncated:    false,
-				}, nil
-			}
-		}
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
 		return nil, fmt.Errorf("ripgrep command failed: %w", err)
 	}
+	scanErr := r.scanRipgrepJSON(stdout, req.BeforeContext, req.AfterContext, page.Add)
+	if scanErr != nil {
+		cmd.Process.Kill()
+	}
+	err = cmd.Wait()
+	if scanErr != nil {
+		return nil, fmt.Errorf("failed to read ripgrep output: %w", scanErr)
+	}
 
-	// Parse ripgrep JSON output
-	matches, err := r.ParseRipgrepOutput(string(output))
+	// ripgrep returns exit code 1 when no matches are found, which is not an error
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse ripgrep output: %w", err)
+		if exitError, ok := err.(*exec.ExitError); !ok || exitError.ExitCode() != 1 {
+			return nil, fmt.Errorf("ripgrep command failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+		}
 	}
-
-	// Check if results were truncated
-	truncated := len(matches) >= 50
-
-	return &GrepSearchResponse{
-		Matches:      matches,
-		TotalMatches: len(matches),
-		SearchQuery:  req.Query,
-		Truncated:    truncated,
-	}, nil
+	return page.Response(), nil
 }
 
 // searchSimple provides a simpler interface using basic ripgrep without JSON parsing
 func (r *RipgrepSearcher) searchSimple(req GrepSearchRequest) (*GrepSearchResponse, error) {
+	filePath, err := dirs.GetPath(req.WorkspaceRoot, req.RelativePathToSearch, "relative_path_to_search", true)
+	if err != nil {
+		return nil, err
+	}
+
 	// Build ripgrep command
 	args := []string{
-		"--line-number",     // Include line numbers
-		"--no-heading",      // Don't group by file
-		"--max-count", "50", // Limit to 50 matches total
+		"--line-number", // Include line numbers
+		"--no-heading",  // Don't group by file
 	}
 
 	// Add case sensitivity option
@@ -148,24 +171,25 @@ func (r *RipgrepSearcher) searchSimple(req GrepSearchRequest) (*GrepSearchRespon
 		args = append(args, "--ignore-case")
 	}
 
-	// Add include pattern if specified
-	if req.IncludePattern != "" {
-		args = append(args, "--glob", req.IncludePattern)
-	}
+	// Add include and exclude globs
+	args = append(args, globArgs(req)...)
 
-	// Add exclude pattern if specified
-	if req.ExcludePattern != "" {
-		args = append(args, "--glob", "!"+req.ExcludePattern)
+	// context lines are not requested here, the text format cannot tell them apart reliably
+	if req.Multiline {
+		args = append(args, "--multiline")
 	}
 
+	args = append(args, ignoreFileArgs(filePath)...)
+
 	// Add the search pattern
-	args = append(args, req.Query)
+	args = append(args, "--regexp", req.Query)
 
 	// Add search path (current directory)
 	args = append(args, ".")
 
 	// Execute ripgrep command
 	cmd := exec.Command("rg", args...)
+	cmd.Dir = filePath
 	output, err := cmd.Output()
 
 	// ripgrep returns exit code 1 when no matches are found, which is not an error
@@ -173,12 +197,7 @@ func (r *RipgrepSearcher) searchSimple(req GrepSearchRequest) (*GrepSearchRespon
 		if exitError, ok := err.(*exec.ExitError); ok {
 			if exitError.ExitCode() == 1 {
 				// No matches found
-				return &GrepSearchResponse{
-					Matches:      []GrepSearchMatch{},
-					TotalMatches: 0,
-					SearchQuery:  req.Query,
-					Truncated:    false,
-				}, nil
+				return model.NewResponse(req, nil)
 			}
 		}
 		return nil, fmt.Errorf("ripgrep command failed: %w", err)
@@ -190,108 +209,186 @@ func (r *RipgrepSearcher) searchSimple(req GrepSearchRequest) (*GrepSearchRespon
 		return nil, fmt.Errorf("failed to parse ripgrep output: %w", err)
 	}
 
-	// Check if results were truncated
-	truncated := len(matches) >= 50
-
-	return &GrepSearchResponse{
-		Matches:      matches,
-		TotalMatches: len(matches),
-		SearchQuery:  req.Query,
-		Truncated:    truncated,
-	}, nil
+	return model.NewResponse(req, matches)
 }
 
-// ParseRipgrepOutput parses the JSON output from ripgrep
-func (r *RipgrepSearcher) ParseRipgrepOutput(output string) ([]GrepSearchMatch, error) {
-	var matches []GrepSearchMatch
+// searchFileList lists the files with matches using rg --files-with-matches or rg --count
+func (r *RipgrepSearcher) searchFileList(req GrepSearchRequest) (*GrepSearchResponse, error) {
+	filePath, err := dirs.GetPath(req.WorkspaceRoot, req.RelativePathToSearch, "relative_path_to_search", true)
+	if err != nil {
+		return nil, err
+	}
 
-	lines := strings.Split(strings.TrimSpace(output), "\n")
-	for _, line := range lines {
-		if line == "" {
-			continue
-		}
+	args := []string{"--files-with-matches"}
+	if req.OutputMode == model.OUTPUT_COUNT {
+		args = []string{"--count", "--with-filename"}
+	}
+	if !req.CaseSensitive {
+		args = append(args, "--ignore-case")
+	}
+	args = append(args, globArgs(req)...)
+	if req.Multiline {
+		args = append(args, "--multiline")
+	}
+	args = append(args, ignoreFileArgs(filePath)...)
+	args = append(args, "--regexp", req.Query, ".")
 
-		// Parse each JSON line from ripgrep
-		var rgResult map[string]interface{}
-		if err := json.Unmarshal([]byte(line), &rgResult); err != nil {
-			continue // Skip malformed lines
+	cmd := exec.Command("rg", args...)
+	cmd.Dir = filePath
+	output, err := cmd.Output()
+	if err != nil {
+		if exitError, ok := err.(*exec.ExitError); ok && exitError.ExitCode() == 1 {
+			// No matches found
+			return model.NewFilesResponse(req, nil)
 		}
+		return nil, fmt.Errorf("ripgrep command failed: %w", err)
+	}
+	return model.NewFilesResponse(req, ParseFileList(string(output), req.OutputMode == model.OUTPUT_COUNT))
+}
 
-		// Only process match results
-		if rgResult["type"] != "match" {
+// ParseFileList parses the output of rg --files-with-matches, or of rg --count
+// when withCount is set, whose lines are path:count
+func ParseFileList(output string, withCount bool) []model.GrepSearchFile {
+	var files []model.GrepSearchFile
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if line == "" {
 			continue
 		}
-
-		data, ok := rgResult["data"].(map[string]interface{})
-		if !ok {
-			continue
+		file := model.GrepSearchFile{File: line}
+		if withCount {
+			idx := strings.LastIndex(line, ":")
+			if idx < 0 {
+				continue
+			}
+			count, err := strconv.Atoi(line[idx+1:])
+			if err != nil {
+				continue
+			}
+			file.File = line[:idx]
+			file.MatchCount = count
 		}
+		file.File = strings.TrimPrefix(file.File, "./")
+		files = append(files, file)
+	}
+	return files
+}
 
-		// Extract file path
-		path, ok := data["path"].(map[string]interface{})
-		if !ok {
-			continue
-		}
-		filePath, ok := path["text"].(string)
-		if !ok {
-			continue
-		}
+// globArgs converts the include and exclude globs to --glob flags. Several
+// include globs match a file if any of them does.
+func globArgs(req GrepSearchRequest) []string {
+	include, exclude := model.Globs(req)
+	var args []string
+	for _, pattern := range include {
+		args = append(args, "--glob", pattern)
+	}
+	for _, pattern := range exclude {
+		args = append(args, "--glob", "!"+pattern)
+	}
+	return args
+}
 
-		// Extract line number
-		lineNumber := 0
-		if lineData, ok := data["line_number"].(float64); ok {
-			lineNumber = int(lineData)
-		}
+// ignoreFileArgs passes the .llm-toolsignore file of the repository root to
+// ripgrep, which only knows .gitignore, .ignore and .rgignore natively.
+// ripgrep evaluates its patterns relative to the search directory, so
+// anchored patterns only apply as written when the root is searched. Nested
+// .llm-toolsignore files are not passed: their patterns would not be
+// anchored to their directory.
+func ignoreFileArgs(searchDir string) []string {
+	file := filepath.Join(walk.NewIgnore(searchDir).Root(), walk.IGNORE_FILE)
+	if _, err := os.Stat(file); err != nil {
+		return nil
+	}
+	return []string{"--ignore-file", file}
+}
 
-		// Extract line content
-		lines, ok := data["lines"].(map[string]interface{})
-		if !ok {
-			continue
-		}
-		content, ok := lines["text"].(string)
-		if !ok {
-			continue
-		}
+// ParseRipgrepOutput parses the JSON output from ripgrep
+func (r *RipgrepSearcher) ParseRipgrepOutput(output string) ([]GrepSearchMatch, error) {
+	var matches []GrepSearchMatch
+	err := r.scanRipgrepJSON(strings.NewReader(output), 0, 0, func(match GrepSearchMatch) {
+		matches = append(matches, match)
+	})
+	return matches, err
+}
 
-		// Extract match positions
-		submatches, ok := data["submatches"].([]interface{})
-		if !ok || len(submatches) == 0 {
-			continue
-		}
+// rgMessage is a line of rg --json output, only the fields we use
+type rgMessage struct {
+	Type string `json:"type"`
+	Data struct {
+		Path struct {
+			Text string `json:"text"`
+		} `json:"path"`
+		Lines struct {
+			Text string `json:"text"`
+		} `json:"lines"`
+		LineNumber int `json:"line_number"`
+		Submatches []struct {
+			Start int `json:"start"`
+			End   int `json:"end"`
+		} `json:"submatches"`
+	} `json:"data"`
+}
 
-		// Get the first submatch for column information
-		firstMatch, ok := submatches[0].(map[string]interface{})
-		if !ok {
-			continue
+// scanRipgrepJSON reads rg --json output and passes its matches to add, a
+// file at a time. Context lines are collected per file and attached with the
+// same rules as the pure Go searcher.
+func (r *RipgrepSearcher) scanRipgrepJSON(output io.Reader, before int, after int, add func(match GrepSearchMatch)) error {
+	var fileMatches []GrepSearchMatch
+	fileLines := make(map[int]string)
+	flushFile := func() {
+		model.AttachContext(fileMatches, before, after, func(line int) (string, bool) {
+			content, ok := fileLines[line]
+			return content, ok
+		})
+		for _, match := range fileMatches {
+			add(match)
 		}
+		fileMatches = nil
+		fileLines = make(map[int]string)
+	}
 
-		column := 0
-		matchStart := 0
-		matchEnd := 0
-
-		if startData, ok := firstMatch["start"].(float64); ok {
-			column = int(startData) + 1 // Convert to 1-indexed
-			matchStart = int(startData)
+	scanner := bufio.NewScanner(output)
+	scanner.Buffer(make([]byte, 64*1024), MAX_JSON_LINE)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
 		}
-
-		if endData, ok := firstMatch["end"].(float64); ok {
-			matchEnd = int(endData)
+		var msg rgMessage
+		if err := json.Unmarshal(line, &msg); err != nil {
+			continue // Skip malformed lines
 		}
-
-		// Create match result
-		match := GrepSearchMatch{
-			File:       filePath,
-			Line:       lineNumber,
-			Column:     column,
-			Content:    strings.TrimRight(content, "\n\r"),
-			MatchStart: matchStart,
-			MatchEnd:   matchEnd,
+		switch msg.Type {
+		case "begin":
+			flushFile()
+		case "context", "match":
+			lines := strings.Split(strings.TrimRight(msg.Data.Lines.Text, "\n"), "\n")
+			for i, content := range lines {
+				lines[i] = strings.TrimSuffix(content, "\r")
+				fileLines[msg.Data.LineNumber+i] = lines[i]
+			}
+			if msg.Type == "context" || len(msg.Data.Submatches) == 0 {
+				continue
+			}
+			first := msg.Data.Submatches[0]
+			match := GrepSearchMatch{
+				File:       strings.TrimPrefix(msg.Data.Path.Text, "./"),
+				Line:       msg.Data.LineNumber,
+				Column:     first.Start + 1, // Convert to 1-indexed
+				Content:    strings.Join(lines, "\n"),
+				MatchStart: first.Start,
+				MatchEnd:   first.End,
+			}
+			if len(lines) > 1 {
+				match.EndLine = msg.Data.LineNumber + len(lines) - 1
+			}
+			fileMatches = append(fileMatches, match)
 		}
-
-		matches = append(matches, match)
 	}
-
-	return matches, nil
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	flushFile()
+	return nil
 }
 
 // ParseSimpleRipgrepOutput parses the simple text output from ripgrep
@@ -310,7 +407,7 @@ func (r *RipgrepSearcher) ParseSimpleRipgrepOutput(output string) ([]GrepSearchM
 			continue
 		}
 
-		filePath := parts[0]
+		filePath := strings.TrimPrefix(parts[0], "./")
 		lineNumber, err := strconv.Atoi(parts[1])
 		if err != nil {
 			continue
@@ -0,0 +1,21 @@
+package rg_search_test
+
+import (
+	"testing"
+
+	"github.com/xhd2015/llm-tools/tools/grep_search/model"
+	"github.com/xhd2015/llm-tools/tools/grep_search/rg_search"
+	"github.com/xhd2015/llm-tools/tools/grep_search/searchertest"
+)
+
+func TestRipgrepSearcherConformance(t *testing.T) {
+	searchertest.Run(t, func(t *testing.T, root string) model.GrepSearcher {
+		return rg_search.NewRipgrepSearcher()
+	})
+}
+
+func BenchmarkSearch(b *testing.B) {
+	searchertest.Benchmark(b, func(b *testing.B, root string) model.GrepSearcher {
+		return rg_search.NewRipgrepSearcher()
+	})
+}
@@ -0,0 +1,305 @@
+// Package searchertest is a conformance suite for model.GrepSearcher
+// implementations. Every backend must return exactly the same results for
+// the same fixture tree, so callers never depend on which one is installed.
+package searchertest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xhd2015/llm-tools/tools/grep_search/model"
+)
+
+// Files is the fixture tree searched by Run, keyed by slash separated path.
+// It is a git repository so that ripgrep honors its .gitignore.
+var Files = map[string]string{
+	".git/HEAD":          "ref: refs/heads/master\n",
+	".gitignore":         "*.log\nbuild/\n",
+	".env":               "needle\n",
+	".hidden/secret.txt": "needle\n",
+	"app.log":            "needle\n",
+	"build/out.txt":      "needle\n",
+	"binary.txt":         "\x00needle\n",
+	"crlf.txt":           "first needle\r\nsecond line\r\nthird needle\r\n",
+	"unicode.txt":        "héllo wörld needle\nÉCOLE needle\n",
+	"docs/readme.md":     "a needle in docs\n",
+	"src/main.go":        "package main\n\nfunc main() {\n\tneedle()\n}\n",
+	"src/util_test.go":   "needle\n",
+}
+
+// Fixture writes Files to a new temporary directory and isolates the test from
+// the user's git configuration, whose global excludes both backends would read
+func Fixture(t *testing.T) string {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+
+	root := t.TempDir()
+	for name, content := range Files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// Match is the part of a model.GrepSearchMatch that backends must agree on
+type Match struct {
+	File       string
+	Line       int
+	EndLine    int
+	Column     int
+	Content    string
+	MatchStart int
+	MatchEnd   int
+	Before     []model.ContextLine
+	After      []model.ContextLine
+}
+
+func toMatches(matches []model.GrepSearchMatch) []Match {
+	result := []Match{}
+	for _, m := range matches {
+		result = append(result, Match{
+			File:       filepath.ToSlash(m.File),
+			Line:       m.Line,
+			EndLine:    m.EndLine,
+			Column:     m.Column,
+			Content:    m.Content,
+			MatchStart: m.MatchStart,
+			MatchEnd:   m.MatchEnd,
+			Before:     m.ContextBefore,
+			After:      m.ContextAfter,
+		})
+	}
+	return result
+}
+
+// needle returns the match of the literal "needle" in file
+func needle(file string, line int, column int, content string) Match {
+	return Match{File: file, Line: line, Column: column, Content: content, MatchStart: column - 1, MatchEnd: column + 5}
+}
+
+// Case is a request run against the fixture and the matches it must return
+type Case struct {
+	Name string
+	Req  model.GrepSearchRequest
+	Want []Match
+}
+
+// Cases covers skip rules, globs, case folding, byte columns, line endings,
+// context lines and multiline matches
+var Cases = []Case{
+	{
+		// hidden, gitignored and binary files are skipped
+		Name: "skip rules",
+		Req:  model.GrepSearchRequest{Query: "needle"},
+		Want: []Match{
+			needle("crlf.txt", 1, 7, "first needle"),
+			needle("crlf.txt", 3, 7, "third needle"),
+			needle("docs/readme.md", 1, 3, "a needle in docs"),
+			needle("src/main.go", 4, 2, "\tneedle()"),
+			needle("src/util_test.go", 1, 1, "needle"),
+			needle("unicode.txt", 1, 15, "héllo wörld needle"),
+			needle("unicode.txt", 2, 8, "ÉCOLE needle"),
+		},
+	},
+	{
+		// columns and offsets count bytes, not runes
+		Name: "unicode column",
+		Req:  model.GrepSearchRequest{Query: "wörld", CaseSensitive: true},
+		Want: []Match{{File: "unicode.txt", Line: 1, Column: 8, Content: "héllo wörld needle", MatchStart: 7, MatchEnd: 13}},
+	},
+	{
+		Name: "unicode case folding",
+		Req:  model.GrepSearchRequest{Query: "école"},
+		Want: []Match{{File: "unicode.txt", Line: 2, Column: 1, Content: "ÉCOLE needle", MatchStart: 0, MatchEnd: 6}},
+	},
+	{
+		Name: "case sensitive",
+		Req:  model.GrepSearchRequest{Query: "École", CaseSensitive: true},
+		Want: []Match{},
+	},
+	{
+		// the carriage return is stripped from the content but, as in rg
+		// without --crlf, $ does not match before it
+		Name: "crlf line endings",
+		Req:  model.GrepSearchRequest{Query: "needle$", IncludePattern: "*.txt"},
+		Want: []Match{
+			needle("unicode.txt", 1, 15, "héllo wörld needle"),
+			needle("unicode.txt", 2, 8, "ÉCOLE needle"),
+		},
+	},
+	{
+		Name: "include and exclude globs",
+		Req:  model.GrepSearchRequest{Query: "needle", IncludePattern: "*.go", ExcludePattern: "*_test.go"},
+		Want: []Match{needle("src/main.go", 4, 2, "\tneedle()")},
+	},
+	{
+		Name: "several include globs",
+		Req:  model.GrepSearchRequest{Query: "needle", IncludePatterns: []string{"docs/**", "*_test.go"}},
+		Want: []Match{
+			needle("docs/readme.md", 1, 3, "a needle in docs"),
+			needle("src/util_test.go", 1, 1, "needle"),
+		},
+	},
+	{
+		Name: "excluded directory",
+		Req:  model.GrepSearchRequest{Query: "needle", ExcludePatterns: []string{"src", "*.txt"}},
+		Want: []Match{needle("docs/readme.md", 1, 3, "a needle in docs")},
+	},
+	{
+		Name: "context lines",
+		Req:  model.GrepSearchRequest{Query: `func main`, IncludePattern: "*.go", BeforeContext: 1, AfterContext: 1},
+		Want: []Match{{
+			File: "src/main.go", Line: 3, Column: 1, Content: "func main() {", MatchStart: 0, MatchEnd: 9,
+			Before: []model.ContextLine{{Line: 2, Content: ""}},
+			After:  []model.ContextLine{{Line: 4, Content: "\tneedle()"}},
+		}},
+	},
+	{
+		Name: "multiline",
+		Req:  model.GrepSearchRequest{Query: `main\(\) \{\n\s*needle`, Multiline: true},
+		Want: []Match{{File: "src/main.go", Line: 3, EndLine: 4, Column: 6, Content: "func main() {\n\tneedle()", MatchStart: 5, MatchEnd: 21}},
+	},
+}
+
+// Run runs the conformance suite against the searcher that newSearcher
+// creates for the fixture root, so that searchers needing preparation such
+// as an index can be tested too
+func Run(t *testing.T, newSearcher func(t *testing.T, root string) model.GrepSearcher) {
+	root := Fixture(t)
+	searcher := newSearcher(t, root)
+	if !searcher.IsAvailable() {
+		t.Skipf("%T is not available", searcher)
+	}
+
+	for _, tc := range Cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			req := tc.Req
+			req.WorkspaceRoot = root
+			response, err := searcher.Search(req)
+			if err != nil {
+				t.Fatalf("Search failed: %v", err)
+			}
+			got := toMatches(response.Matches)
+			if !reflect.DeepEqual(got, tc.Want) {
+				t.Errorf("got matches\n%+v\nwant\n%+v", got, tc.Want)
+			}
+			if response.TotalMatches != len(tc.Want) {
+				t.Errorf("got total_matches %d, want %d", response.TotalMatches, len(tc.Want))
+			}
+		})
+	}
+
+	t.Run("count", func(t *testing.T) {
+		response, err := searcher.Search(model.GrepSearchRequest{WorkspaceRoot: root, Query: "needle", OutputMode: model.OUTPUT_COUNT})
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		got := make(map[string]int)
+		for _, file := range response.Files {
+			got[filepath.ToSlash(file.File)] = file.MatchCount
+		}
+		want := map[string]int{"crlf.txt": 2, "docs/readme.md": 1, "src/main.go": 1, "src/util_test.go": 1, "unicode.txt": 2}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got counts %v, want %v", got, want)
+		}
+	})
+
+	t.Run("pagination", func(t *testing.T) {
+		req := model.GrepSearchRequest{WorkspaceRoot: root, Query: "needle", Limit: 4}
+		first, err := searcher.Search(req)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if !first.Truncated || first.TotalMatches != 7 || first.TotalFiles != 5 {
+			t.Fatalf("got truncated=%v total_matches=%d total_files=%d, want true 7 5", first.Truncated, first.TotalMatches, first.TotalFiles)
+		}
+		req.Cursor = first.NextCursor
+		second, err := searcher.Search(req)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		got := append(toMatches(first.Matches), toMatches(second.Matches)...)
+		if want := Cases[0].Want; !reflect.DeepEqual(got, want) {
+			t.Errorf("pages do not add up to all matches\n%+v\nwant\n%+v", got, want)
+		}
+	})
+}
+
+// GenerateTree writes dirs directories of filesPerDir Go-like files of the
+// given number of lines to a new temporary directory. Every tenth file calls
+// needle and every file declares a few Handler functions.
+func GenerateTree(tb testing.TB, dirs int, filesPerDir int, lines int) string {
+	tb.Helper()
+	root := tb.TempDir()
+	var content strings.Builder
+	for d := 0; d < dirs; d++ {
+		dir := filepath.Join(root, fmt.Sprintf("pkg%03d", d))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			tb.Fatal(err)
+		}
+		for f := 0; f < filesPerDir; f++ {
+			content.Reset()
+			fmt.Fprintf(&content, "package pkg%03d\n\n", d)
+			for l := 0; l < lines; l++ {
+				switch {
+				case l == lines/2 && (d*filesPerDir+f)%10 == 0:
+					content.WriteString("\tneedle(ctx)\n")
+				case l%25 == 0:
+					fmt.Fprintf(&content, "func Request%dHandler(ctx context.Context) error {\n", l)
+				default:
+					fmt.Fprintf(&content, "\tvalue%d := process(ctx, %d, \"item-%d\")\n", l, l*f, d)
+				}
+			}
+			if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("file%03d.go", f)), []byte(content.String()), 0644); err != nil {
+				tb.Fatal(err)
+			}
+		}
+	}
+	return root
+}
+
+// BenchCases are the searches of Benchmark
+var BenchCases = []Case{
+	{Name: "literal", Req: model.GrepSearchRequest{Query: "needle", CaseSensitive: true}},
+	{Name: "literal_ignore_case", Req: model.GrepSearchRequest{Query: "NEEDLE"}},
+	{Name: "regex", Req: model.GrepSearchRequest{Query: `func Request\d+Handler\(`, CaseSensitive: true}},
+	{Name: "files_with_matches", Req: model.GrepSearchRequest{Query: "needle", OutputMode: model.OUTPUT_FILES_WITH_MATCHES}},
+	{Name: "no_match", Req: model.GrepSearchRequest{Query: "no such needle", CaseSensitive: true}},
+}
+
+// Benchmark runs BenchCases against the searcher that newSearcher creates for
+// a tree of 100 directories of 20 files of 200 lines, like Run does for the
+// conformance cases
+func Benchmark(b *testing.B, newSearcher func(b *testing.B, root string) model.GrepSearcher) {
+	home := b.TempDir()
+	b.Setenv("HOME", home)
+	b.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	root := GenerateTree(b, 100, 20, 200)
+	searcher := newSearcher(b, root)
+	if !searcher.IsAvailable() {
+		b.Skipf("%T is not available", searcher)
+	}
+
+	for _, bc := range BenchCases {
+		b.Run(bc.Name, func(b *testing.B) {
+			req := bc.Req
+			req.WorkspaceRoot = root
+			for i := 0; i < b.N; i++ {
+				if _, err := searcher.Search(req); err != nil {
+					b.Fatalf("Search failed: %v", err)
+				}
+			}
+		})
+	}
+}
@@ -3,32 +3,87 @@ package list_dir
 import (
 	"encoding/json"
 	"fmt"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
+	"strings"
+	"time"
 
 	"github.com/xhd2015/llm-tools/jsonschema"
 	"github.com/xhd2015/llm-tools/tools/defs"
+	"github.com/xhd2015/llm-tools/tools/glob"
+	"github.com/xhd2015/llm-tools/tools/tokenizer"
+	"github.com/xhd2015/llm-tools/tools/units"
+	"github.com/xhd2015/llm-tools/tools/walk"
+)
+
+// DEFAULT_MAX_ENTRIES is the number of entries listed when max_entries is not set
+const DEFAULT_MAX_ENTRIES = 500
+
+// Entry types
+const (
+	TYPE_FILE    = "file"
+	TYPE_DIR     = "dir"
+	TYPE_SYMLINK = "symlink"
+	TYPE_OTHER   = "other"
 )
 
 // ListDirRequest represents the input parameters for the list_dir tool
 type ListDirRequest struct {
 	WorkspaceRoot         string `json:"workspace_root"`
 	RelativeWorkspacePath string `json:"relative_workspace_path"`
-	Explanation           string `json:"explanation"`
+	// Depth lists entries up to this many levels down, 1 (the default) lists direct children only
+	Depth int `json:"depth,omitempty"`
+	// IncludePatterns are globs files must match, directories are always listed
+	IncludePatterns []string `json:"include_patterns,omitempty"`
+	// ExcludePatterns are globs of entries to leave out, excluded directories are not descended
+	ExcludePatterns []string `json:"exclude_patterns,omitempty"`
+	// SkipHidden leaves out entries whose name starts with a dot
+	SkipHidden bool `json:"skip_hidden,omitempty"`
+	// Gitignore leaves out .git and entries ignored by .gitignore or .llm-toolsignore
+	Gitignore bool `json:"gitignore,omitempty"`
+	// MaxEntries caps the listed entries, shallower ones first (default 500)
+	MaxEntries int `json:"max_entries,omitempty"`
+	// MaxTokens caps the tokens of the listed entries, shallower ones first, 0 means no limit
+	MaxTokens   int    `json:"max_tokens,omitempty"`
+	Explanation string `json:"explanation"`
+}
+
+// Entry is a listed file, directory or symlink
+type Entry struct {
+	// Path is slash separated and relative to the listed directory, with a / suffix for directories
+	Path string `json:"path"`
+	Type string `json:"type"`
+	// Size is the size in bytes of files and symlinks
+	Size *int64 `json:"size,omitempty"`
+	// ModTime is the modification time in RFC 3339
+	ModTime string `json:"mtime,omitempty"`
+	// Target is where a symlink points to
+	Target string `json:"target,omitempty"`
+	// Children counts the entries of a directory, after the hidden and gitignore filters
+	Children *int `json:"children,omitempty"`
+	// Truncated is set on directories some of whose children were dropped by max_entries
+	Truncated bool `json:"truncated,omitempty"`
 }
 
 // ListDirResponse represents the output of the list_dir tool
 type ListDirResponse struct {
-	Contents []string `json:"contents"`
-	Path     string   `json:"path"`
-	Count    int      `json:"count"`
+	Entries []Entry `json:"entries"`
+	Path    string  `json:"path"`
+	// Count is the number of entries up to depth, listed or not
+	Count int `json:"count"`
+	// Tokens is the token count of Entries, one FormatEntry line each
+	Tokens int `json:"tokens"`
+	// Truncated is set when entries were dropped to fit max_entries or max_tokens
+	Truncated bool `json:"truncated,omitempty"`
 }
 
 // GetToolDefinition returns the JSON schema definition for the list_dir tool
 func GetToolDefinition() defs.ToolDefinition {
 	return defs.ToolDefinition{
-		Description: "List the contents of a directory. The quick tool to use for discovery, before using more targeted tools like semantic search or file reading. Useful to try to understand the file structure before diving deeper into specific files. Can be used to explore the codebase.",
+		Description: "List the contents of a directory. The quick tool to use for discovery, before using more targeted tools like semantic search or file reading. Useful to try to understand the file structure before diving deeper into specific files. Can be used to explore the codebase. Each entry comes with its type, size, modification time, symlink target or number of children.",
 		Name:        "list_dir",
 		Parameters: &jsonschema.JsonSchema{
 			Type: jsonschema.ParamTypeObject,
@@ -41,6 +96,36 @@ func GetToolDefinition() defs.ToolDefinition {
 					Type:        jsonschema.ParamTypeString,
 					Description: "Path to list contents of, relative to the workspace root.",
 				},
+				"depth": {
+					Type:        jsonschema.ParamTypeNumber,
+					Description: "How many levels to list, 1 lists direct children only. Defaults to 1.",
+				},
+				"include_patterns": {
+					Type:        jsonschema.ParamTypeArray,
+					Items:       &jsonschema.JsonSchema{Type: jsonschema.ParamTypeString},
+					Description: "Glob patterns files must match, such as *.go or src/**/*.ts. A pattern without / matches the file name. Directories are always listed.",
+				},
+				"exclude_patterns": {
+					Type:        jsonschema.ParamTypeArray,
+					Items:       &jsonschema.JsonSchema{Type: jsonschema.ParamTypeString},
+					Description: "Glob patterns of files and directories to leave out.",
+				},
+				"skip_hidden": {
+					Type:        jsonschema.ParamTypeBoolean,
+					Description: "Leave out entries whose name starts with a dot.",
+				},
+				"gitignore": {
+					Type:        jsonschema.ParamTypeBoolean,
+					Description: "Leave out .git and entries ignored by .gitignore or .llm-toolsignore.",
+				},
+				"max_entries": {
+					Type:        jsonschema.ParamTypeNumber,
+					Description: fmt.Sprintf("Maximum number of entries, shallower ones are kept first. Defaults to %d.", DEFAULT_MAX_ENTRIES),
+				},
+				"max_tokens": {
+					Type:        jsonschema.ParamTypeNumber,
+					Description: "Maximum number of tokens of the listed entries, shallower ones are kept first. Defaults to no limit.",
+				},
 				"explanation": {
 					Type:        jsonschema.ParamTypeString,
 					Description: "One sentence explanation as to why this tool is being used, and how it contributes to the goal.",
@@ -82,24 +167,106 @@ func ListDir(req ListDirRequest) (*ListDirResponse, error) {
 		return nil, fmt.Errorf("path is not a directory: %s", req.RelativeWorkspacePath)
 	}
 
-	// Read directory contents
-	entries, err := os.ReadDir(opDir)
+	depth := req.Depth
+	if depth <= 0 {
+		depth = 1
+	}
+	maxEntries := req.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = DEFAULT_MAX_ENTRIES
+	}
+	includes, err := glob.CompileAll(req.IncludePatterns)
+	if err != nil {
+		return nil, fmt.Errorf("invalid include pattern: %w", err)
+	}
+	excludes, err := glob.CompileAll(req.ExcludePatterns)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read directory: %w", err)
+		return nil, fmt.Errorf("invalid exclude pattern: %w", err)
+	}
+	var filter *walk.Filter
+	if req.Gitignore || req.SkipHidden {
+		filter = walk.NewFilter(opDir, walk.Options{NoIgnore: !req.Gitignore, SkipHidden: req.SkipHidden})
+	}
+	readDir := func(dir string) ([]os.DirEntry, error) {
+		if filter != nil {
+			return filter.ReadDir(dir)
+		}
+		return walk.ReadDir(dir)
 	}
 
-	// Process entries
-	var contents []string
-	for _, entry := range entries {
-		name := entry.Name()
-		if entry.IsDir() {
-			name += "/"
+	// list level by level so that max_entries keeps the shallower entries
+	type pendingDir struct {
+		rel   string
+		entry *Entry
+	}
+	var entries []*Entry
+	count := 0
+	level := []pendingDir{{}}
+	for d := 1; len(level) > 0; d++ {
+		var next []pendingDir
+		for _, dir := range level {
+			if d > depth && dir.entry == nil {
+				continue
+			}
+			children, err := readDir(filepath.Join(opDir, filepath.FromSlash(dir.rel)))
+			if err != nil {
+				if dir.rel == "" {
+					return nil, fmt.Errorf("failed to read directory: %w", err)
+				}
+				// unreadable directories are listed without children
+				continue
+			}
+			if dir.entry != nil {
+				n := len(children)
+				dir.entry.Children = &n
+			}
+			if d > depth {
+				continue
+			}
+			for _, child := range children {
+				rel := child.Name()
+				if dir.rel != "" {
+					rel = dir.rel + "/" + rel
+				}
+				isDir := child.IsDir()
+				if glob.MatchAny(excludes, rel) || !isDir && len(includes) > 0 && !glob.MatchAny(includes, rel) {
+					continue
+				}
+				count++
+				if len(entries) >= maxEntries {
+					if dir.entry != nil {
+						dir.entry.Truncated = true
+					}
+					if isDir {
+						// still counted, but not listed
+						next = append(next, pendingDir{rel: rel})
+					}
+					continue
+				}
+				entry := newEntry(opDir, rel, child)
+				entries = append(entries, entry)
+				if isDir {
+					next = append(next, pendingDir{rel: rel, entry: entry})
+				}
+			}
 		}
-		contents = append(contents, name)
+		level = next
 	}
 
-	// Sort contents for consistent output
-	sort.Strings(contents)
+	// cut to max_tokens in the same level order, keeping shallower entries
+	n := fitEntries(entries, req.MaxTokens)
+	entries = entries[:n]
+
+	// a directory sorts right before its contents, since / follows the
+	// characters that usually end a name prefix
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+	lines := formatEntries(entries)
+	listed := make([]Entry, n)
+	for i := 0; i < n; i++ {
+		listed[i] = *entries[i]
+	}
 
 	// Clean up the path for display
 	displayPath := req.RelativeWorkspacePath
@@ -108,12 +275,102 @@ func ListDir(req ListDirRequest) (*ListDirResponse, error) {
 	}
 
 	return &ListDirResponse{
-		Contents: contents,
-		Path:     displayPath,
-		Count:    len(contents),
+		Entries:   listed,
+		Path:      displayPath,
+		Count:     count,
+		Tokens:    tokenizer.CountLines(lines),
+		Truncated: n < count,
 	}, nil
 }
 
+// fitEntries returns how many leading entries fit within maxTokens, marking
+// the directories whose children are cut as truncated. A non-positive
+// maxTokens means unlimited.
+func fitEntries(entries []*Entry, maxTokens int) int {
+	n := tokenizer.FitLines(formatEntries(entries), maxTokens)
+	if n == len(entries) {
+		return n
+	}
+	byPath := make(map[string]*Entry, len(entries))
+	for _, entry := range entries {
+		byPath[entry.Path] = entry
+	}
+	for ; n > 0; n-- {
+		for _, entry := range entries[n:] {
+			parent := path.Dir(strings.TrimSuffix(entry.Path, "/")) + "/"
+			if dir, ok := byPath[parent]; ok {
+				dir.Truncated = true
+			}
+		}
+		// the truncated marks lengthen the lines of their directories
+		if tokenizer.CountLines(formatEntries(entries[:n])) <= maxTokens {
+			break
+		}
+	}
+	return n
+}
+
+func formatEntries(entries []*Entry) []string {
+	lines := make([]string, len(entries))
+	for i, entry := range entries {
+		lines[i] = FormatEntry(*entry)
+	}
+	return lines
+}
+
+// newEntry describes the directory entry d found at rel under root
+func newEntry(root string, rel string, d os.DirEntry) *Entry {
+	entry := &Entry{Path: rel}
+	switch {
+	case d.IsDir():
+		entry.Type = TYPE_DIR
+		entry.Path += "/"
+	case d.Type()&fs.ModeSymlink != 0:
+		entry.Type = TYPE_SYMLINK
+		if target, err := os.Readlink(filepath.Join(root, filepath.FromSlash(rel))); err == nil {
+			entry.Target = target
+		}
+	case d.Type().IsRegular():
+		entry.Type = TYPE_FILE
+	default:
+		entry.Type = TYPE_OTHER
+	}
+	info, err := d.Info()
+	if err != nil {
+		// removed since it was listed
+		return entry
+	}
+	if entry.Type != TYPE_DIR {
+		size := info.Size()
+		entry.Size = &size
+	}
+	entry.ModTime = info.ModTime().Format(time.RFC3339)
+	return entry
+}
+
+// FormatEntry renders an entry as one line: its path followed by the
+// symlink target, the number of children or the size
+func FormatEntry(entry Entry) string {
+	line := entry.Path
+	switch {
+	case entry.Target != "":
+		line += " -> " + entry.Target
+	case entry.Children != nil:
+		if *entry.Children == 1 {
+			line += " (1 entry"
+		} else {
+			line += fmt.Sprintf(" (%d entries", *entry.Children)
+		}
+		if entry.Truncated {
+			line += ", truncated"
+		}
+		line += ")"
+	case entry.Size != nil:
+		line += fmt.Sprintf(" (%s)", units.FormatSize(*entry.Size))
+	}
+	return line
+}
+
 func ParseJSONRequest(jsonInput string) (ListDirRequest, error) {
 	var req ListDirRequest
 	if err := json.Unmarshal([]byte(jsonInput), &req); err != nil {
@@ -0,0 +1,126 @@
+package list_dir
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/xhd2015/llm-tools/tools/internal/testfiles"
+	"github.com/xhd2015/llm-tools/tools/tokenizer"
+)
+
+func lines(resp *ListDirResponse) []string {
+	var lines []string
+	for _, entry := range resp.Entries {
+		lines = append(lines, FormatEntry(entry))
+	}
+	return lines
+}
+
+func TestListDir(t *testing.T) {
+	root := t.TempDir()
+	testfiles.Write(t, root, map[string]string{
+		".env":              "SECRET=1\n",
+		".gitignore":        "build/\n",
+		"main.go":           "package main\n",
+		"README.md":         "# readme\n",
+		"build/out.bin":     "bin",
+		"pkg/a/a.go":        "package a\n",
+		"pkg/a/a_test.go":   "package a\n",
+		"pkg/b/b.go":        "package b\n",
+		"pkg/b/data/x.json": "{}",
+	})
+	if err := os.Symlink("main.go", filepath.Join(root, "link.go")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		req       ListDirRequest
+		want      []string
+		count     int
+		truncated bool
+	}{
+		{
+			name:  "direct children",
+			req:   ListDirRequest{},
+			want:  []string{".env (9B)", ".gitignore (7B)", "README.md (9B)", "build/ (1 entry)", "link.go -> main.go", "main.go (13B)", "pkg/ (2 entries)"},
+			count: 7,
+		},
+		{
+			name:  "gitignore and hidden",
+			req:   ListDirRequest{Gitignore: true, SkipHidden: true},
+			want:  []string{"README.md (9B)", "link.go -> main.go", "main.go (13B)", "pkg/ (2 entries)"},
+			count: 4,
+		},
+		{
+			name: "depth and globs",
+			req: ListDirRequest{
+				Depth:           3,
+				Gitignore:       true,
+				SkipHidden:      true,
+				IncludePatterns: []string{"*.go"},
+				ExcludePatterns: []string{"*_test.go", "data"},
+			},
+			want:  []string{"link.go -> main.go", "main.go (13B)", "pkg/ (2 entries)", "pkg/a/ (2 entries)", "pkg/a/a.go (10B)", "pkg/b/ (2 entries)", "pkg/b/b.go (10B)"},
+			count: 7,
+		},
+		{
+			name:      "max entries keeps shallower entries",
+			req:       ListDirRequest{Depth: 2, Gitignore: true, SkipHidden: true, MaxEntries: 5},
+			want:      []string{"README.md (9B)", "link.go -> main.go", "main.go (13B)", "pkg/ (2 entries, truncated)", "pkg/a/ (2 entries)"},
+			count:     6,
+			truncated: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := tt.req
+			req.WorkspaceRoot = root
+			req.RelativeWorkspacePath = "."
+			resp, err := ListDir(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := lines(resp); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("entries = %q, want %q", got, tt.want)
+			}
+			if resp.Count != tt.count || resp.Truncated != tt.truncated {
+				t.Errorf("count, truncated = %d, %v, want %d, %v", resp.Count, resp.Truncated, tt.count, tt.truncated)
+			}
+		})
+	}
+
+	resp, err := ListDir(ListDirRequest{WorkspaceRoot: root, RelativeWorkspacePath: "pkg/a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := resp.Entries[0]
+	if entry.Type != TYPE_FILE || entry.Size == nil || *entry.Size != 10 || entry.ModTime == "" {
+		t.Errorf("entry = %+v, want a 10 byte file with mtime", entry)
+	}
+}
+
+func TestListDirMaxTokens(t *testing.T) {
+	root := t.TempDir()
+	testfiles.Write(t, root, map[string]string{
+		"a/x/1.txt": "1",
+		"a/x/2.txt": "2",
+		"a/y.txt":   "y",
+		"z.txt":     "z",
+	})
+
+	// room for two lines, the a/ line marked truncated included
+	want := []string{"a/ (2 entries, truncated)", "z.txt (1B)"}
+	resp, err := ListDir(ListDirRequest{WorkspaceRoot: root, RelativeWorkspacePath: ".", Depth: 2, MaxTokens: tokenizer.CountLines(want)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lines(resp); !reflect.DeepEqual(got, want) {
+		t.Errorf("entries = %q, want %q", got, want)
+	}
+	if resp.Count != 4 || !resp.Truncated || resp.Tokens > tokenizer.CountLines(want) {
+		t.Errorf("count, truncated, tokens = %d, %v, %d, want 4, true, at most %d", resp.Count, resp.Truncated, resp.Tokens, tokenizer.CountLines(want))
+	}
+}
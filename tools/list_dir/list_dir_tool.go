@@ -14,19 +14,41 @@ Usage: llm-tools list_dir <path> [OPTIONS]
 
 Options:
   --workspace-root <path>      workspace root directory (defaults to current directory)
+  -d,--depth <num>             levels to list, 1 lists direct children only (default 1)
+  --include <glob>             only files matching the glob, repeatable
+  --exclude <glob>             leave out entries matching the glob, repeatable
+  --skip-hidden                leave out entries whose name starts with a dot
+  --gitignore                  leave out .git and gitignored entries
+  --max-entries <num>          maximum number of entries (default 500)
+  --max-tokens <num>           maximum tokens of listed entries (0 = no limit)
   --explanation <text>         explanation for the operation
 
 Examples:
   llm-tools list_dir .
   llm-tools list_dir src --workspace-root /path/to/workspace
   llm-tools list_dir tools --explanation "Exploring tool structure"
+  llm-tools list_dir . --depth 3 --gitignore --include '*.go'
 `
 
 func HandleCli(args []string) error {
 	var workspaceRoot string
+	var depth int
+	var includes []string
+	var excludes []string
+	var skipHidden bool
+	var gitignore bool
+	var maxEntries int
+	var maxTokens int
 	var explanation string
 
 	args, err := flags.String("--workspace-root", &workspaceRoot).
+		Int("-d,--depth", &depth).
+		StringSlice("--include", &includes).
+		StringSlice("--exclude", &excludes).
+		Bool("--skip-hidden", &skipHidden).
+		Bool("--gitignore", &gitignore).
+		Int("--max-entries", &maxEntries).
+		Int("--max-tokens", &maxTokens).
 		String("--explanation", &explanation).
 		Help("-h,--help", help).
 		Parse(args)
@@ -55,6 +77,13 @@ func HandleCli(args []string) error {
 	req := ListDirRequest{
 		WorkspaceRoot:         workspaceRoot,
 		RelativeWorkspacePath: relativePath,
+		Depth:                 depth,
+		IncludePatterns:       includes,
+		ExcludePatterns:       excludes,
+		SkipHidden:            skipHidden,
+		Gitignore:             gitignore,
+		MaxEntries:            maxEntries,
+		MaxTokens:             maxTokens,
 		Explanation:           explanation,
 	}
 
@@ -65,11 +94,15 @@ func HandleCli(args []string) error {
 
 	// Print results
 	fmt.Printf("Directory: %s\n", response.Path)
-	fmt.Printf("Items: %d\n", response.Count)
+	fmt.Printf("Items: %d", response.Count)
+	if response.Truncated {
+		fmt.Printf(" (showing %d)", len(response.Entries))
+	}
+	fmt.Printf(", tokens: %d\n", response.Tokens)
 	fmt.Println()
 
-	for _, item := range response.Contents {
-		fmt.Println(item)
+	for _, entry := range response.Entries {
+		fmt.Println(FormatEntry(entry))
 	}
 
 	return nil
@@ -11,6 +11,7 @@ import (
 	"github.com/xhd2015/llm-tools/jsonschema"
 	"github.com/xhd2015/llm-tools/tools/defs"
 	"github.com/xhd2015/llm-tools/tools/dirs"
+	"github.com/xhd2015/llm-tools/tools/tokenizer"
 )
 
 // ReadFileRequest represents the input parameters for the read_file tool
@@ -20,7 +21,9 @@ type ReadFileRequest struct {
 	ShouldReadEntireFile       bool   `json:"should_read_entire_file"`
 	StartLineOneIndexed        int    `json:"start_line_one_indexed"`
 	EndLineOneIndexedInclusive int    `json:"end_line_one_indexed_inclusive"`
-	Explanation                string `json:"explanation"`
+	// MaxTokens caps the tokens of the returned contents, 0 means no limit
+	MaxTokens   int    `json:"max_tokens,omitempty"`
+	Explanation string `json:"explanation"`
 }
 
 // ReadFileResponse represents the output of the read_file tool
@@ -29,6 +32,12 @@ type ReadFileResponse struct {
 	TotalLines int    `json:"total_lines"`
 	LinesShown string `json:"lines_shown"`
 	Outline    string `json:"outline,omitempty"`
+	// Tokens is the token count of Contents
+	Tokens int `json:"tokens"`
+	// LinesBefore summarizes the lines above the returned range, nil if none were skipped
+	LinesBefore *ElidedLines `json:"lines_before,omitempty"`
+	// LinesAfter summarizes the lines below the returned range, nil if none were skipped
+	LinesAfter *ElidedLines `json:"lines_after,omitempty"`
 }
 
 // GetToolDefinition returns the JSON schema definition for the read_file tool
@@ -70,6 +79,10 @@ Reading the entire file is not allowed in most cases. You are only allowed to re
 					Type:        jsonschema.ParamTypeNumber,
 					Description: "The one-indexed line number to end reading at (inclusive).",
 				},
+				"max_tokens": {
+					Type:        jsonschema.ParamTypeNumber,
+					Description: "Maximum number of tokens to return. The contents are cut at a line boundary and the remaining lines are summarized in lines_after. Defaults to no limit.",
+				},
 				"explanation": {
 					Type:        jsonschema.ParamTypeString,
 					Description: "One sentence explanation as to why this tool is being used, and how it contributes to the goal.",
@@ -116,12 +129,20 @@ func ReadFile(req ReadFileRequest) (*ReadFileResponse, error) {
 	var contents string
 	var linesShown string
 	var outline string
+	var linesBefore *ElidedLines
+	var linesAfter *ElidedLines
 
 	if req.ShouldReadEntireFile {
 		// Read entire file
-		contents = strings.Join(lines, "\n")
-		linesShown = fmt.Sprintf("1-%d (entire file)", totalLines)
-		outline = generateOutline(lines, req.TargetFile)
+		endLine := tokenizer.FitLines(lines, req.MaxTokens)
+		contents = strings.Join(lines[:endLine], "\n")
+		if endLine < totalLines {
+			linesShown = formatTokenTruncated(1, endLine)
+			linesAfter = summarizeElidedLines(lines, endLine+1, totalLines, req.TargetFile)
+		} else {
+			linesShown = fmt.Sprintf("1-%d (entire file)", totalLines)
+		}
+		outline = generateOutline(lines[:endLine], req.TargetFile)
 	} else {
 		// Read specific range
 		startLine := req.StartLineOneIndexed
@@ -189,10 +210,18 @@ func ReadFile(req ReadFileRequest) (*ReadFileResponse, error) {
 		}
 
 		selectedLines := lines[startIdx : endIdx+1]
+		truncatedByTokens := false
+		if n := tokenizer.FitLines(selectedLines, req.MaxTokens); n < len(selectedLines) {
+			selectedLines = selectedLines[:n]
+			endLine = startLine + n - 1
+			truncatedByTokens = true
+		}
 		contents = strings.Join(selectedLines, "\n")
 
 		// Generate lines shown description
-		if startLine == 1 && endLine == totalLines {
+		if truncatedByTokens {
+			linesShown = formatTokenTruncated(startLine, endLine)
+		} else if startLine == 1 && endLine == totalLines {
 			linesShown = fmt.Sprintf("1-%d (entire file)", totalLines)
 		} else {
 			linesShown = fmt.Sprintf("%d-%d", startLine, endLine)
@@ -206,16 +235,29 @@ func ReadFile(req ReadFileRequest) (*ReadFileResponse, error) {
 		}
 
 		outline = generateOutline(selectedLines, req.TargetFile)
+		linesBefore = summarizeElidedLines(lines, 1, startLine-1, req.TargetFile)
+		linesAfter = summarizeElidedLines(lines, endLine+1, totalLines, req.TargetFile)
 	}
 
 	return &ReadFileResponse{
-		Contents:   contents,
-		TotalLines: totalLines,
-		LinesShown: linesShown,
-		Outline:    outline,
+		Contents:    contents,
+		TotalLines:  totalLines,
+		LinesShown:  linesShown,
+		Outline:     outline,
+		Tokens:      tokenizer.Count(contents),
+		LinesBefore: linesBefore,
+		LinesAfter:  linesAfter,
 	}, nil
 }
 
+// formatTokenTruncated describes a range cut short by max_tokens
+func formatTokenTruncated(startLine int, endLine int) string {
+	if endLine < startLine {
+		return fmt.Sprintf("none (line %d alone exceeds max_tokens)", startLine)
+	}
+	return fmt.Sprintf("%d-%d (truncated by max_tokens)", startLine, endLine)
+}
+
 // generateOutline creates a brief outline of the file contents
 func generateOutline(lines []string, filename string) string {
 	if len(lines) == 0 {
@@ -247,11 +289,7 @@ func generateGoOutline(lines []string) string {
 
 	for _, line := range lines {
 		trimmed := strings.TrimSpace(line)
-		if strings.HasPrefix(trimmed, "func ") ||
-			strings.HasPrefix(trimmed, "type ") ||
-			strings.HasPrefix(trimmed, "var ") ||
-			strings.HasPrefix(trimmed, "const ") ||
-			strings.HasPrefix(trimmed, "package ") {
+		if isGoSymbol(trimmed) {
 			outline = append(outline, trimmed)
 		}
 	}
@@ -269,12 +307,7 @@ func generateJSOutline(lines []string) string {
 
 	for _, line := range lines {
 		trimmed := strings.TrimSpace(line)
-		if strings.HasPrefix(trimmed, "function ") ||
-			strings.HasPrefix(trimmed, "class ") ||
-			strings.HasPrefix(trimmed, "export ") ||
-			strings.HasPrefix(trimmed, "const ") ||
-			strings.HasPrefix(trimmed, "let ") ||
-			strings.HasPrefix(trimmed, "var ") {
+		if isJSSymbol(trimmed) {
 			outline = append(outline, trimmed)
 		}
 	}
@@ -292,10 +325,7 @@ func generatePyOutline(lines []string) string {
 
 	for _, line := range lines {
 		trimmed := strings.TrimSpace(line)
-		if strings.HasPrefix(trimmed, "def ") ||
-			strings.HasPrefix(trimmed, "class ") ||
-			strings.HasPrefix(trimmed, "import ") ||
-			strings.HasPrefix(trimmed, "from ") {
+		if isPySymbol(trimmed) {
 			outline = append(outline, trimmed)
 		}
 	}
@@ -313,11 +343,7 @@ func generateJavaOutline(lines []string) string {
 
 	for _, line := range lines {
 		trimmed := strings.TrimSpace(line)
-		if strings.Contains(trimmed, "public class ") ||
-			strings.Contains(trimmed, "private class ") ||
-			strings.Contains(trimmed, "public interface ") ||
-			strings.Contains(trimmed, "public ") && strings.Contains(trimmed, "(") ||
-			strings.Contains(trimmed, "private ") && strings.Contains(trimmed, "(") {
+		if isJavaSymbol(trimmed) {
 			outline = append(outline, trimmed)
 		}
 	}
@@ -335,11 +361,7 @@ func generateCppOutline(lines []string) string {
 
 	for _, line := range lines {
 		trimmed := strings.TrimSpace(line)
-		if strings.HasPrefix(trimmed, "#include ") ||
-			strings.HasPrefix(trimmed, "#define ") ||
-			strings.Contains(trimmed, "class ") ||
-			strings.Contains(trimmed, "struct ") ||
-			(strings.Contains(trimmed, "(") && strings.Contains(trimmed, ")") && !strings.HasPrefix(trimmed, "//")) {
+		if isCppSymbol(trimmed) {
 			outline = append(outline, trimmed)
 		}
 	}
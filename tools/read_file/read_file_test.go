@@ -0,0 +1,116 @@
+package read_file
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeSource writes a file of n filler lines with the given lines replaced
+func writeSource(t *testing.T, name string, n int, lines map[int]string) string {
+	t.Helper()
+	content := make([]string, n)
+	for i := range content {
+		content[i] = fmt.Sprintf("// filler %d", i+1)
+		if line, ok := lines[i+1]; ok {
+			content[i] = line
+		}
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(strings.Join(content, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestReadFileElidedLines(t *testing.T) {
+	tests := []struct {
+		name       string
+		file       string
+		lines      map[int]string
+		wantBefore *ElidedLines
+		wantAfter  *ElidedLines
+	}{
+		{
+			name: "go",
+			file: "demo.go",
+			lines: map[int]string{
+				1:   "package demo",
+				10:  "func Early() {",
+				11:  "\tvar local = 1",
+				300: "func Middle() {",
+				500: "type Late struct {",
+				501: "\tconst inner = 2",
+			},
+			wantBefore: &ElidedLines{StartLine: 1, EndLine: 205, LineCount: 205, Symbols: []LineSymbol{
+				{Line: 1, Text: "package demo"},
+				{Line: 10, Text: "func Early()"},
+			}},
+			wantAfter: &ElidedLines{StartLine: 406, EndLine: 600, LineCount: 195, Symbols: []LineSymbol{
+				{Line: 500, Text: "type Late struct"},
+			}},
+		},
+		{
+			name: "js",
+			file: "demo.js",
+			lines: map[int]string{
+				5:   "export function early() {",
+				12:  "  const inner = 1",
+				300: "function middle() {",
+				450: "class Late {",
+			},
+			wantBefore: &ElidedLines{StartLine: 1, EndLine: 205, LineCount: 205, Symbols: []LineSymbol{
+				{Line: 5, Text: "export function early()"},
+				{Line: 12, Text: "const inner = 1"},
+			}},
+			wantAfter: &ElidedLines{StartLine: 406, EndLine: 600, LineCount: 195, Symbols: []LineSymbol{
+				{Line: 450, Text: "class Late"},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeSource(t, tt.file, 600, tt.lines)
+			resp, err := ReadFile(ReadFileRequest{
+				WorkspaceRoot:              dir,
+				TargetFile:                 tt.file,
+				StartLineOneIndexed:        300,
+				EndLineOneIndexedInclusive: 310,
+			})
+			if err != nil {
+				t.Fatalf("ReadFile: %v", err)
+			}
+			if resp.LinesShown != "206-405" {
+				t.Fatalf("lines_shown = %q, want 206-405", resp.LinesShown)
+			}
+			if !reflect.DeepEqual(resp.LinesBefore, tt.wantBefore) {
+				t.Errorf("lines_before = %+v, want %+v", resp.LinesBefore, tt.wantBefore)
+			}
+			if !reflect.DeepEqual(resp.LinesAfter, tt.wantAfter) {
+				t.Errorf("lines_after = %+v, want %+v", resp.LinesAfter, tt.wantAfter)
+			}
+		})
+	}
+}
+
+func TestSummarizeElidedLines(t *testing.T) {
+	lines := make([]string, maxElidedSymbols+10)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("func F%d() {", i+1)
+	}
+
+	if got := summarizeElidedLines(lines, 5, 4, "a.go"); got != nil {
+		t.Errorf("empty range: got %+v, want nil", got)
+	}
+	if got := summarizeElidedLines(lines, 1, 3, "a.txt"); got == nil || got.LineCount != 3 || len(got.Symbols) != 0 {
+		t.Errorf("file without symbols: got %+v, want 3 lines and no symbols", got)
+	}
+	got := summarizeElidedLines(lines, 1, len(lines), "a.go")
+	if len(got.Symbols) != maxElidedSymbols || got.OmittedSymbols != 10 {
+		t.Errorf("got %d symbols and %d omitted, want %d and 10", len(got.Symbols), got.OmittedSymbols, maxElidedSymbols)
+	}
+}
@@ -17,6 +17,7 @@ Options:
   --entire-file                read entire file
   --start-line <num>           start line number (1-indexed)
   --end-line <num>             end line number (1-indexed, inclusive)
+  --max-tokens <num>           maximum tokens of returned contents (0 = no limit)
   --explanation <text>         explanation for the operation
 
 Examples:
@@ -30,12 +31,14 @@ func HandleCli(args []string) error {
 	var entireFile bool
 	var startLine int
 	var endLine int
+	var maxTokens int
 	var explanation string
 
 	args, err := flags.String("--workspace-root", &workspaceRoot).
 		Bool("--entire-file", &entireFile).
 		Int("--start-line", &startLine).
 		Int("--end-line", &endLine).
+		Int("--max-tokens", &maxTokens).
 		String("--explanation", &explanation).
 		Help("-h,--help", help).
 		Parse(args)
@@ -72,6 +75,7 @@ func HandleCli(args []string) error {
 		ShouldReadEntireFile:       entireFile,
 		StartLineOneIndexed:        startLine,
 		EndLineOneIndexedInclusive: endLine,
+		MaxTokens:                  maxTokens,
 		Explanation:                explanation,
 	}
 
@@ -82,12 +86,17 @@ func HandleCli(args []string) error {
 
 	// Print results
 	fmt.Printf("File: %s\n", targetFile)
-	fmt.Printf("Lines: %s (Total: %d)\n", response.LinesShown, response.TotalLines)
+	fmt.Printf("Lines: %s (Total: %d, Tokens: %d)\n", response.LinesShown, response.TotalLines, response.Tokens)
 	if response.Outline != "" {
 		fmt.Printf("Outline: %s\n", response.Outline)
 	}
+	fmt.Print(formatElidedLines("Before", response.LinesBefore))
 	fmt.Println()
 	fmt.Println(response.Contents)
+	if response.LinesAfter != nil {
+		fmt.Println()
+		fmt.Print(formatElidedLines("After", response.LinesAfter))
+	}
 
 	return nil
 }
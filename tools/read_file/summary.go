@@ -0,0 +1,163 @@
+package read_file
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// maxElidedSymbols caps the symbols listed for a single elided region
+const maxElidedSymbols = 30
+
+// ElidedLines summarizes a contiguous run of lines that was not returned
+type ElidedLines struct {
+	StartLine      int          `json:"start_line"`
+	EndLine        int          `json:"end_line"`
+	LineCount      int          `json:"line_count"`
+	Symbols        []LineSymbol `json:"symbols,omitempty"`
+	OmittedSymbols int          `json:"omitted_symbols,omitempty"`
+}
+
+// LineSymbol is a symbol definition found at a specific line
+type LineSymbol struct {
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+// summarizeElidedLines builds a summary for the one-indexed inclusive range [startLine, endLine].
+// It returns nil when the range is empty.
+func summarizeElidedLines(lines []string, startLine int, endLine int, filename string) *ElidedLines {
+	if startLine < 1 {
+		startLine = 1
+	}
+	if endLine > len(lines) {
+		endLine = len(lines)
+	}
+	if startLine > endLine {
+		return nil
+	}
+
+	summary := &ElidedLines{
+		StartLine: startLine,
+		EndLine:   endLine,
+		LineCount: endLine - startLine + 1,
+	}
+
+	isSymbol := symbolMatcher(filename)
+	if isSymbol == nil {
+		return summary
+	}
+	for i := startLine - 1; i < endLine; i++ {
+		line := lines[i]
+		if !isSymbol(line) {
+			continue
+		}
+		if len(summary.Symbols) >= maxElidedSymbols {
+			summary.OmittedSymbols++
+			continue
+		}
+		summary.Symbols = append(summary.Symbols, LineSymbol{
+			Line: i + 1,
+			Text: symbolText(line),
+		})
+	}
+	return summary
+}
+
+// symbolMatcher returns a function reporting whether a raw line defines a symbol,
+// or nil if the file type has no notion of symbols
+func symbolMatcher(filename string) func(line string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	switch ext {
+	case ".go":
+		// only top-level declarations, locals inside function bodies are noise
+		return func(line string) bool {
+			return !isIndented(line) && isGoSymbol(line)
+		}
+	case ".js", ".ts", ".jsx", ".tsx":
+		return func(line string) bool {
+			return isJSSymbol(strings.TrimSpace(line))
+		}
+	case ".py":
+		return func(line string) bool {
+			return isPySymbol(strings.TrimSpace(line))
+		}
+	case ".java":
+		return func(line string) bool {
+			return isJavaSymbol(strings.TrimSpace(line))
+		}
+	case ".cpp", ".cc", ".cxx", ".c", ".h", ".hpp":
+		return func(line string) bool {
+			return isCppSymbol(strings.TrimSpace(line))
+		}
+	default:
+		return nil
+	}
+}
+
+func isIndented(line string) bool {
+	return strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")
+}
+
+// symbolText shortens a definition line for display, dropping a trailing opening brace
+func symbolText(line string) string {
+	text := strings.TrimSpace(line)
+	text = strings.TrimSpace(strings.TrimSuffix(text, "{"))
+	return text
+}
+
+func isGoSymbol(trimmed string) bool {
+	return strings.HasPrefix(trimmed, "func ") ||
+		strings.HasPrefix(trimmed, "type ") ||
+		strings.HasPrefix(trimmed, "var ") ||
+		strings.HasPrefix(trimmed, "const ") ||
+		strings.HasPrefix(trimmed, "package ")
+}
+
+func isJSSymbol(trimmed string) bool {
+	return strings.HasPrefix(trimmed, "function ") ||
+		strings.HasPrefix(trimmed, "class ") ||
+		strings.HasPrefix(trimmed, "export ") ||
+		strings.HasPrefix(trimmed, "const ") ||
+		strings.HasPrefix(trimmed, "let ") ||
+		strings.HasPrefix(trimmed, "var ")
+}
+
+func isPySymbol(trimmed string) bool {
+	return strings.HasPrefix(trimmed, "def ") ||
+		strings.HasPrefix(trimmed, "class ") ||
+		strings.HasPrefix(trimmed, "import ") ||
+		strings.HasPrefix(trimmed, "from ")
+}
+
+func isJavaSymbol(trimmed string) bool {
+	return strings.Contains(trimmed, "public class ") ||
+		strings.Contains(trimmed, "private class ") ||
+		strings.Contains(trimmed, "public interface ") ||
+		strings.Contains(trimmed, "public ") && strings.Contains(trimmed, "(") ||
+		strings.Contains(trimmed, "private ") && strings.Contains(trimmed, "(")
+}
+
+func isCppSymbol(trimmed string) bool {
+	return strings.HasPrefix(trimmed, "#include ") ||
+		strings.HasPrefix(trimmed, "#define ") ||
+		strings.Contains(trimmed, "class ") ||
+		strings.Contains(trimmed, "struct ") ||
+		(strings.Contains(trimmed, "(") && strings.Contains(trimmed, ")") && !strings.HasPrefix(trimmed, "//"))
+}
+
+// formatElidedLines renders a summary as plain text for CLI output
+func formatElidedLines(label string, summary *ElidedLines) string {
+	if summary == nil {
+		return ""
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: lines %d-%d (%d lines not shown)\n", label, summary.StartLine, summary.EndLine, summary.LineCount)
+	for _, sym := range summary.Symbols {
+		fmt.Fprintf(&b, "  %d: %s\n", sym.Line, sym.Text)
+	}
+	if summary.OmittedSymbols > 0 {
+		fmt.Fprintf(&b, "  ... %d more symbols\n", summary.OmittedSymbols)
+	}
+	return b.String()
+}
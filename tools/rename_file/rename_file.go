@@ -9,6 +9,7 @@ import (
 	"github.com/xhd2015/llm-tools/jsonschema"
 	"github.com/xhd2015/llm-tools/tools/defs"
 	"github.com/xhd2015/llm-tools/tools/dirs"
+	"github.com/xhd2015/llm-tools/tools/watch/notify"
 )
 
 // RenameFileRequest represents the input parameters for the rename_file tool
@@ -85,6 +86,7 @@ func RenameFile(req RenameFileRequest) (*RenameFileResponse, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to rename file: %w", err)
 	}
+	notify.Changed(sourcePath, targetPath)
 
 	return &RenameFileResponse{
 		Success:        true,
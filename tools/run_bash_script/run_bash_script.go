@@ -15,6 +15,7 @@ import (
 
 	"github.com/xhd2015/llm-tools/jsonschema"
 	"github.com/xhd2015/llm-tools/tools/defs"
+	"github.com/xhd2015/llm-tools/tools/tokenizer"
 )
 
 var presetEnvs = []string{
@@ -26,6 +27,8 @@ type RunBashScriptRequest struct {
 	Cwd         string `json:"cwd"`
 	Script      string `json:"script"`
 	Explanation string `json:"explanation,omitempty"`
+	// MaxTokens caps the tokens of the output instead of the default character limit
+	MaxTokens int `json:"max_tokens,omitempty"`
 
 	RunBashScriptRequestOptions
 }
@@ -43,6 +46,7 @@ type RunBashScriptRequestOptions struct {
 // RunBashScriptResponse represents the output of the run_bash_script tool
 type RunBashScriptResponse struct {
 	Output   string `json:"output"`
+	Tokens   int    `json:"tokens"`
 	ExitCode int    `json:"exit_code,omitempty"`
 	Hint     string `json:"hint,omitempty"`
 	Duration string `json:"duration,omitempty"`
@@ -74,6 +78,10 @@ Adhere to the following guidelines:
 					Type:        jsonschema.ParamTypeString,
 					Description: "simple explanation of the purpose of this script",
 				},
+				"max_tokens": {
+					Type:        jsonschema.ParamTypeNumber,
+					Description: "maximum tokens of the output, the head and tail of a longer output are kept, cut at line boundaries. Defaults to a limit of about 3600 characters",
+				},
 			},
 			Required: []string{"script"},
 		},
@@ -153,7 +161,7 @@ func RunBashScript(req RunBashScriptRequest) (*RunBashScriptResponse, error) {
 	cmd.ExtraFiles = scriptSetup.extraFiles
 
 	// Run command in foreground
-	err := runBash(cmd, response, cleanOutput)
+	err := runBash(cmd, response, cleanOutput, req.MaxTokens)
 	if err != nil {
 		response.Error = err.Error()
 		if exitError, ok := err.(*exec.ExitError); ok {
@@ -228,7 +236,7 @@ func (c *scriptFilesSetup) wait() {
 }
 
 // runBash executes a command in the foreground and captures output
-func runBash(cmd *exec.Cmd, response *RunBashScriptResponse, cleanOutput bool) error {
+func runBash(cmd *exec.Cmd, response *RunBashScriptResponse, cleanOutput bool, maxTokens int) error {
 	// Create pipes for stdout and stderr
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
@@ -295,12 +303,23 @@ func runBash(cmd *exec.Cmd, response *RunBashScriptResponse, cleanOutput bool) e
 	origLen := len(output)
 	log.Printf("script completed, output len: %d", origLen)
 
-	contentAfterEllipse, truncated := ellipse(output, 3612)
-	if truncated {
-		response.Hint = appendHint(response.Hint, fmt.Sprintf("output is truncated to %d, original len %d is too large, use proper tool to iteratively inspect the content", len(contentAfterEllipse), origLen))
-		log.Printf("Output is truncated to %d, original len %d is too large, use proper tool to iteratively inspect the content", len(contentAfterEllipse), origLen)
+	if maxTokens > 0 {
+		truncatedOutput, truncated := ellipseTokens(output, maxTokens)
+		if truncated {
+			origTokens := tokenizer.Count(output)
+			response.Hint = appendHint(response.Hint, fmt.Sprintf("output is truncated to its head and tail within %d tokens, original %d tokens is too large, use proper tool to iteratively inspect the content", maxTokens, origTokens))
+			log.Printf("Output is truncated to %d tokens, original %d tokens is too large", maxTokens, origTokens)
+		}
+		response.Output = truncatedOutput
+	} else {
+		contentAfterEllipse, truncated := ellipse(output, 3612)
+		if truncated {
+			response.Hint = appendHint(response.Hint, fmt.Sprintf("output is truncated to %d, original len %d is too large, use proper tool to iteratively inspect the content", len(contentAfterEllipse), origLen))
+			log.Printf("Output is truncated to %d, original len %d is too large, use proper tool to iteratively inspect the content", len(contentAfterEllipse), origLen)
+		}
+		response.Output = contentAfterEllipse
 	}
-	response.Output = contentAfterEllipse
+	response.Tokens = tokenizer.Count(response.Output)
 
 	if cmdErr != nil {
 		return cmdErr
@@ -419,6 +438,33 @@ func ellipse(msg string, maxLen int) (string, bool) {
 	return string(runes[:maxLen]) + "...", true
 }
 
+// ellipseTokens is ellipse with a token budget that keeps both ends of msg:
+// the head within half of maxTokens and the tail within the rest, cut at
+// line boundaries around a "..." line
+func ellipseTokens(msg string, maxTokens int) (string, bool) {
+	if tokenizer.Count(msg) <= maxTokens {
+		return msg, false
+	}
+	lines := strings.Split(msg, "\n")
+	budget := maxTokens - tokenizer.Count("\n...\n")
+	head := tokenizer.FitLines(lines, budget/2)
+	if head > 0 {
+		budget -= tokenizer.CountLines(lines[:head])
+	}
+	rest := lines[head:]
+	reversed := make([]string, len(rest))
+	for i, line := range rest {
+		reversed[len(rest)-1-i] = line
+	}
+	tail := tokenizer.FitLines(reversed, budget)
+	if head == 0 && tail == 0 {
+		// a single line is too long, keep its head
+		truncated, _ := tokenizer.Truncate(msg, maxTokens)
+		return truncated + "...", true
+	}
+	return strings.Join(lines[:head], "\n") + "\n...\n" + strings.Join(lines[len(lines)-tail:], "\n"), true
+}
+
 // wrapScriptWithLsAlias wraps the script with a bash function and alias that intercepts ls
 // and removes the -l flag for token efficiency
 func wrapScriptWithLsAlias() string {
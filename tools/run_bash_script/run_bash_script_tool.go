@@ -14,6 +14,7 @@ Usage: llm-tools run_bash_script <command> [OPTIONS]
 
 Options:
   --background                 run command in background
+  --max-tokens <num>           maximum tokens of the output
   --explanation <text>         explanation for the operation
 
 Examples:
@@ -24,9 +25,11 @@ Examples:
 
 func HandleCli(args []string) error {
 	var isBackground bool
+	var maxTokens int
 	var explanation string
 
 	args, err := flags.Bool("--background", &isBackground).
+		Int("--max-tokens", &maxTokens).
 		String("--explanation", &explanation).
 		Help("-h,--help", help).
 		Parse(args)
@@ -44,6 +47,7 @@ func HandleCli(args []string) error {
 	req := RunBashScriptRequest{
 		Script:      command,
 		Explanation: explanation,
+		MaxTokens:   maxTokens,
 	}
 
 	response, err := RunBashScript(req)
@@ -64,7 +68,7 @@ func HandleCli(args []string) error {
 
 	if response.Output != "" {
 		fmt.Println()
-		fmt.Println("Output:")
+		fmt.Printf("Output (%d tokens):\n", response.Tokens)
 		fmt.Println(response.Output)
 	}
 
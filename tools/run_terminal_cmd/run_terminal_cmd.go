@@ -15,6 +15,7 @@ import (
 
 	"github.com/xhd2015/llm-tools/jsonschema"
 	"github.com/xhd2015/llm-tools/tools/defs"
+	"github.com/xhd2015/llm-tools/tools/tokenizer"
 )
 
 // RunTerminalCmdRequest represents the input parameters for the run_terminal_cmd tool
@@ -23,12 +24,16 @@ type RunTerminalCmdRequest struct {
 	Command       string `json:"command"`
 	IsBackground  bool   `json:"is_background"`
 	Explanation   string `json:"explanation,omitempty"`
+	// MaxTokens caps the tokens of the command output, 0 means no limit
+	MaxTokens int `json:"max_tokens,omitempty"`
 }
 
 // RunTerminalCmdResponse represents the output of the run_terminal_cmd tool
 type RunTerminalCmdResponse struct {
 	ExitCode      int    `json:"exit_code"`
 	CommandOutput string `json:"command_output"`
+	Tokens        int    `json:"tokens"`
+	Truncated     bool   `json:"truncated,omitempty"`
 	ShellInfo     string `json:"shell_info"`
 	Command       string `json:"command"`
 	IsBackground  bool   `json:"is_background"`
@@ -70,6 +75,10 @@ In using these tools, adhere to the following guidelines:
 					Type:        jsonschema.ParamTypeString,
 					Description: "One sentence explanation as to why this command needs to be run and how it contributes to the goal.",
 				},
+				"max_tokens": {
+					Type:        jsonschema.ParamTypeNumber,
+					Description: "Maximum number of tokens of the command output. The output is cut at a line boundary when exceeded. Defaults to no limit.",
+				},
 			},
 			Required: []string{"command", "is_background"},
 		},
@@ -136,12 +145,25 @@ func RunTerminalCmd(req RunTerminalCmdRequest) (*RunTerminalCmdResponse, error)
 	duration := time.Since(startTime)
 	response.Duration = duration.String()
 
+	limitOutput(response, req.MaxTokens)
+
 	// Generate shell info
 	response.ShellInfo = generateShellInfo(workingDir, shell, req.IsBackground)
 
 	return response, nil
 }
 
+// limitOutput truncates the command output to maxTokens and records its token count
+func limitOutput(response *RunTerminalCmdResponse, maxTokens int) {
+	output, truncated := tokenizer.Truncate(response.CommandOutput, maxTokens)
+	if truncated {
+		output += "\n... (output truncated to max_tokens)"
+	}
+	response.CommandOutput = output
+	response.Truncated = truncated
+	response.Tokens = tokenizer.Count(output)
+}
+
 // runForegroundCommand executes a command in the foreground and captures output
 func runForegroundCommand(cmd *exec.Cmd, response *RunTerminalCmdResponse) error {
 	// Create pipes for stdout and stderr
@@ -309,6 +331,8 @@ func RunTerminalCmdWithTimeout(req RunTerminalCmdRequest, timeout time.Duration)
 		response.ExitCode = 0
 	}
 
+	limitOutput(response, req.MaxTokens)
+
 	// Generate shell info
 	response.ShellInfo = generateShellInfo(workingDir, shell, req.IsBackground)
 
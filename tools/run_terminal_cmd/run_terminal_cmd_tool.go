@@ -14,6 +14,7 @@ Usage: llm-tools run_terminal_cmd <command> [OPTIONS]
 
 Options:
   --background                 run command in background
+  --max-tokens <num>           maximum tokens of the output (0 = no limit)
   --explanation <text>         explanation for the operation
 
 Examples:
@@ -24,9 +25,11 @@ Examples:
 
 func HandleCli(args []string) error {
 	var isBackground bool
+	var maxTokens int
 	var explanation string
 
 	args, err := flags.Bool("--background", &isBackground).
+		Int("--max-tokens", &maxTokens).
 		String("--explanation", &explanation).
 		Help("-h,--help", help).
 		Parse(args)
@@ -45,6 +48,7 @@ func HandleCli(args []string) error {
 		Command:      command,
 		IsBackground: isBackground,
 		Explanation:  explanation,
+		MaxTokens:    maxTokens,
 	}
 
 	response, err := RunTerminalCmd(req)
@@ -67,7 +71,7 @@ func HandleCli(args []string) error {
 
 	if response.CommandOutput != "" {
 		fmt.Println()
-		fmt.Println("Output:")
+		fmt.Printf("Output (%d tokens):\n", response.Tokens)
 		fmt.Println(response.CommandOutput)
 	}
 
@@ -9,6 +9,7 @@ import (
 
 	"github.com/xhd2015/llm-tools/jsonschema"
 	"github.com/xhd2015/llm-tools/tools/defs"
+	"github.com/xhd2015/llm-tools/tools/watch/notify"
 )
 
 // SearchReplaceRequest represents the input parameters for the search_replace tool
@@ -110,6 +111,7 @@ func SearchReplace(req SearchReplaceRequest, workspaceRoot string) (*SearchRepla
 	if err != nil {
 		return nil, fmt.Errorf("failed to write file: %w", err)
 	}
+	notify.Changed(actualFilePath)
 
 	suffix := "replaced"
 	if req.New == "" {
@@ -0,0 +1,132 @@
+package tokenizer
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// BPE is a byte-level byte-pair-encoding tokenizer compatible with tiktoken rank tables
+type BPE struct {
+	name  string
+	ranks map[string]int
+	split func(text string) []string
+}
+
+// NewBPE creates a tokenizer from a rank table and a pre-tokenizer such as SplitCL100K
+func NewBPE(name string, ranks map[string]int, split func(text string) []string) *BPE {
+	return &BPE{
+		name:  name,
+		ranks: ranks,
+		split: split,
+	}
+}
+
+// Name returns the encoding name, e.g. cl100k_base
+func (b *BPE) Name() string {
+	return b.name
+}
+
+// Encode returns the token ids of text. Special tokens are treated as plain text.
+func (b *BPE) Encode(text string) []int {
+	var tokens []int
+	for _, piece := range b.split(text) {
+		tokens = append(tokens, b.encodePiece(piece)...)
+	}
+	return tokens
+}
+
+// CountTokens returns the number of tokens in text
+func (b *BPE) CountTokens(text string) int {
+	count := 0
+	for _, piece := range b.split(text) {
+		if _, ok := b.ranks[piece]; ok {
+			count++
+			continue
+		}
+		count += len(bytePairMerge([]byte(piece), b.ranks))
+	}
+	return count
+}
+
+func (b *BPE) encodePiece(piece string) []int {
+	if rank, ok := b.ranks[piece]; ok {
+		return []int{rank}
+	}
+	parts := bytePairMerge([]byte(piece), b.ranks)
+	ids := make([]int, 0, len(parts))
+	for _, part := range parts {
+		rank, ok := b.ranks[string(part)]
+		if !ok {
+			// incomplete tables lack some single bytes, keep the count right anyway
+			rank = -1
+		}
+		ids = append(ids, rank)
+	}
+	return ids
+}
+
+// bytePairMerge repeatedly merges the adjacent pair with the lowest rank
+// until no mergeable pair is left, returning the resulting parts
+func bytePairMerge(piece []byte, ranks map[string]int) [][]byte {
+	// bounds[i] is the start of part i, the last element is len(piece)
+	bounds := make([]int, len(piece)+1)
+	for i := range bounds {
+		bounds[i] = i
+	}
+	for len(bounds) > 2 {
+		minRank := math.MaxInt32
+		minIdx := -1
+		for i := 0; i+2 < len(bounds); i++ {
+			if rank, ok := ranks[string(piece[bounds[i]:bounds[i+2]])]; ok && rank < minRank {
+				minRank = rank
+				minIdx = i
+			}
+		}
+		if minIdx < 0 {
+			break
+		}
+		bounds = append(bounds[:minIdx+1], bounds[minIdx+2:]...)
+	}
+	parts := make([][]byte, 0, len(bounds)-1)
+	for i := 0; i+1 < len(bounds); i++ {
+		parts = append(parts, piece[bounds[i]:bounds[i+1]])
+	}
+	return parts
+}
+
+// LoadRanks parses a rank table in the tiktoken format: one "<base64 token> <rank>" per line
+func LoadRanks(r io.Reader) (map[string]int, error) {
+	ranks := make(map[string]int)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected '<token> <rank>', got %q", lineNum, line)
+		}
+		token, err := base64.StdEncoding.DecodeString(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid base64 token: %w", lineNum, err)
+		}
+		rank, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid rank: %w", lineNum, err)
+		}
+		ranks[string(token)] = rank
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ranks, nil
+}
@@ -0,0 +1,69 @@
+//go:build ignore
+
+// gen_tables downloads the tiktoken rank tables and writes them gzipped to
+// data/, where they are embedded. Run it with go generate.
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/xhd2015/llm-tools/tools/tokenizer"
+)
+
+const baseURL = "https://openaipublic.blob.core.windows.net/encodings/"
+
+// tables maps each encoding to the SHA-256 of its table, as pinned by tiktoken
+var tables = map[string]string{
+	tokenizer.CL100K_BASE: "223921b76ee99bde995b7ff738513eef100fb51d18c93597a113bcffe865b2a7",
+	tokenizer.O200K_BASE:  "446a9538cb6c348e3516120d7c08b09f57c36495e2acfffe59a5bf8b0cfb1a2d",
+}
+
+func main() {
+	for name, hash := range tables {
+		if err := fetch(name, hash); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			os.Exit(1)
+		}
+	}
+}
+
+func fetch(name string, hash string) error {
+	resp, err := http.Get(baseURL + name + ".tiktoken")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download: %s", resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	if got := hex.EncodeToString(sum[:]); got != hash {
+		return fmt.Errorf("sha256 %s, want %s", got, hash)
+	}
+	if _, err := tokenizer.LoadRanks(bytes.NewReader(data)); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	gz, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return err
+	}
+	gz.Write(data)
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join("data", name+".tiktoken.gz"), buf.Bytes(), 0644)
+}
@@ -0,0 +1,216 @@
+package tokenizer
+
+import "unicode"
+
+// The pre-tokenizers below split text into pieces exactly like the regular
+// expressions used by tiktoken. Go's regexp has no lookahead, which the
+// `\s+(?!\S)` alternative requires, so the alternatives are matched by hand,
+// in order, reproducing the backtracking of the original patterns.
+
+// SplitCL100K splits text the way the cl100k_base pattern does:
+//
+//	(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+
+func SplitCL100K(text string) []string {
+	return split(text, matchCL100K)
+}
+
+// SplitO200K splits text the way the o200k_base pattern does:
+//
+//	[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]*[\p{Ll}\p{Lm}\p{Lo}\p{M}]+(?i:'s|'t|'re|'ve|'m|'ll|'d)?
+//	|[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]+[\p{Ll}\p{Lm}\p{Lo}\p{M}]*(?i:'s|'t|'re|'ve|'m|'ll|'d)?
+//	|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n/]*|\s*[\r\n]+|\s+(?!\S)|\s+
+func SplitO200K(text string) []string {
+	return split(text, matchO200K)
+}
+
+// split repeatedly applies match at the current position, match returns the end of the piece
+func split(text string, match func(r []rune, i int) int) []string {
+	if text == "" {
+		return nil
+	}
+	runes := []rune(text)
+	var pieces []string
+	i := 0
+	for i < len(runes) {
+		end := match(runes, i)
+		if end <= i {
+			// cannot happen for valid patterns, guard against an infinite loop
+			end = i + 1
+		}
+		pieces = append(pieces, string(runes[i:end]))
+		i = end
+	}
+	return pieces
+}
+
+func matchCL100K(r []rune, i int) int {
+	if end := matchContraction(r, i); end > 0 {
+		return end
+	}
+	// [^\r\n\p{L}\p{N}]?\p{L}+
+	if isPrefixChar(r[i]) && i+1 < len(r) && unicode.IsLetter(r[i+1]) {
+		return countRun(r, i+1, unicode.IsLetter)
+	}
+	if unicode.IsLetter(r[i]) {
+		return countRun(r, i, unicode.IsLetter)
+	}
+	if end := matchNumbers(r, i); end > 0 {
+		return end
+	}
+	if end := matchPunct(r, i, isNewline); end > 0 {
+		return end
+	}
+	return matchSpaces(r, i)
+}
+
+func matchO200K(r []rune, i int) int {
+	// try each case alternative with the optional prefix consumed first, as the regex would
+	if isPrefixChar(r[i]) && i+1 < len(r) {
+		if end := matchUpperLower(r, i+1); end > 0 {
+			return end
+		}
+	}
+	if end := matchUpperLower(r, i); end > 0 {
+		return end
+	}
+	if isPrefixChar(r[i]) && i+1 < len(r) {
+		if end := matchUpperThenLower(r, i+1); end > 0 {
+			return end
+		}
+	}
+	if end := matchUpperThenLower(r, i); end > 0 {
+		return end
+	}
+	if end := matchNumbers(r, i); end > 0 {
+		return end
+	}
+	if end := matchPunct(r, i, func(c rune) bool { return isNewline(c) || c == '/' }); end > 0 {
+		return end
+	}
+	return matchSpaces(r, i)
+}
+
+// matchUpperLower matches [U]*[L]+contraction?
+func matchUpperLower(r []rune, p int) int {
+	maxU := countRun(r, p, isUpperClass) - p
+	for k := maxU; k >= 0; k-- {
+		j := p + k
+		if j < len(r) && isLowerClass(r[j]) {
+			end := countRun(r, j, isLowerClass)
+			if c := matchContraction(r, end); c > 0 {
+				return c
+			}
+			return end
+		}
+	}
+	return -1
+}
+
+// matchUpperThenLower matches [U]+[L]*contraction?
+func matchUpperThenLower(r []rune, p int) int {
+	end := countRun(r, p, isUpperClass)
+	if end == p {
+		return -1
+	}
+	end = countRun(r, end, isLowerClass)
+	if c := matchContraction(r, end); c > 0 {
+		return c
+	}
+	return end
+}
+
+// matchContraction matches (?i:'s|'t|'re|'ve|'m|'ll|'d)
+func matchContraction(r []rune, i int) int {
+	if i >= len(r) || r[i] != '\'' || i+1 >= len(r) {
+		return -1
+	}
+	c1 := unicode.ToLower(r[i+1])
+	switch c1 {
+	case 's', 't', 'm', 'd':
+		return i + 2
+	}
+	if i+2 >= len(r) {
+		return -1
+	}
+	c2 := unicode.ToLower(r[i+2])
+	switch {
+	case c1 == 'r' && c2 == 'e', c1 == 'v' && c2 == 'e', c1 == 'l' && c2 == 'l':
+		return i + 3
+	}
+	return -1
+}
+
+// matchNumbers matches \p{N}{1,3}
+func matchNumbers(r []rune, i int) int {
+	end := i
+	for end < len(r) && end-i < 3 && unicode.IsNumber(r[end]) {
+		end++
+	}
+	if end == i {
+		return -1
+	}
+	return end
+}
+
+// matchPunct matches ` ?[^\s\p{L}\p{N}]+[trailing]*`
+func matchPunct(r []rune, i int, trailing func(c rune) bool) int {
+	j := i
+	if r[j] == ' ' && j+1 < len(r) && isPunct(r[j+1]) {
+		j++
+	}
+	if !isPunct(r[j]) {
+		return -1
+	}
+	end := countRun(r, j, isPunct)
+	return countRun(r, end, trailing)
+}
+
+// matchSpaces matches \s*[\r\n]+|\s+(?!\S)|\s+
+func matchSpaces(r []rune, i int) int {
+	end := countRun(r, i, unicode.IsSpace)
+	if end == i {
+		return -1
+	}
+	// \s*[\r\n]+ ends right after the last newline of the whitespace run
+	for k := end - 1; k >= i; k-- {
+		if isNewline(r[k]) {
+			return k + 1
+		}
+	}
+	// \s+(?!\S) leaves the last space to prefix the following word
+	if end < len(r) && end-1 > i {
+		return end - 1
+	}
+	return end
+}
+
+func countRun(r []rune, i int, pred func(c rune) bool) int {
+	for i < len(r) && pred(r[i]) {
+		i++
+	}
+	return i
+}
+
+func isNewline(c rune) bool {
+	return c == '\r' || c == '\n'
+}
+
+// isPrefixChar matches [^\r\n\p{L}\p{N}]
+func isPrefixChar(c rune) bool {
+	return !isNewline(c) && !unicode.IsLetter(c) && !unicode.IsNumber(c)
+}
+
+// isPunct matches [^\s\p{L}\p{N}]
+func isPunct(c rune) bool {
+	return !unicode.IsSpace(c) && !unicode.IsLetter(c) && !unicode.IsNumber(c)
+}
+
+// isUpperClass matches [\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]
+func isUpperClass(c rune) bool {
+	return unicode.In(c, unicode.Lu, unicode.Lt, unicode.Lm, unicode.Lo, unicode.M)
+}
+
+// isLowerClass matches [\p{Ll}\p{Lm}\p{Lo}\p{M}]
+func isLowerClass(c rune) bool {
+	return unicode.In(c, unicode.Ll, unicode.Lm, unicode.Lo, unicode.M)
+}
@@ -0,0 +1,270 @@
+// Package tokenizer counts the tokens a model sees for a piece of text with
+// a BPE tokenizer compatible with tiktoken. The cl100k_base and o200k_base
+// rank tables are embedded from data/, see data/README.md; a table missing
+// from the build falls back to the Heuristic estimator.
+package tokenizer
+
+//go:generate go run gen_tables.go
+
+import (
+	"bytes"
+	"compress/gzip"
+	"embed"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Estimator counts the tokens a model would see for a piece of text
+type Estimator interface {
+	Name() string
+	CountTokens(text string) int
+}
+
+const (
+	CL100K_BASE = "cl100k_base"
+	O200K_BASE  = "o200k_base"
+	HEURISTIC   = "heuristic"
+)
+
+// ENV_TOKENIZER selects the default encoding, e.g. o200k_base
+const ENV_TOKENIZER = "LLM_TOOLS_TOKENIZER"
+
+// ENV_TOKENIZER_DIR points to a directory with <encoding>.tiktoken rank tables,
+// consulted before the tables embedded at build time
+const ENV_TOKENIZER_DIR = "LLM_TOOLS_TOKENIZER_DIR"
+
+// data holds the <encoding>.tiktoken.gz rank tables written by go generate
+//
+//go:embed data
+var dataFS embed.FS
+
+// tablesFS is where the embedded tables are read from
+var tablesFS fs.FS = dataFS
+
+var splitters = map[string]func(text string) []string{
+	CL100K_BASE: SplitCL100K,
+	O200K_BASE:  SplitO200K,
+}
+
+var (
+	mutex      sync.Mutex
+	estimators = map[string]Estimator{
+		HEURISTIC: Heuristic{},
+	}
+	defaultEstimator Estimator
+)
+
+// Register makes an estimator available to Get under its name
+func Register(e Estimator) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	estimators[e.Name()] = e
+}
+
+// Get returns the estimator for an encoding name. BPE encodings are loaded
+// on first use; an error is returned if their rank table is not available.
+func Get(name string) (Estimator, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return getLocked(name)
+}
+
+func getLocked(name string) (Estimator, error) {
+	if e, ok := estimators[name]; ok {
+		return e, nil
+	}
+	split, ok := splitters[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown tokenizer: %s", name)
+	}
+	ranks, err := loadEncoding(name)
+	if err != nil {
+		return nil, err
+	}
+	e := NewBPE(name, ranks, split)
+	estimators[name] = e
+	return e, nil
+}
+
+// loadEncoding reads the rank table of an encoding from LLM_TOOLS_TOKENIZER_DIR
+// if it is there, otherwise from the embedded tables
+func loadEncoding(name string) (map[string]int, error) {
+	fileName := name + ".tiktoken"
+	var r io.Reader
+	if dir := os.Getenv(ENV_TOKENIZER_DIR); dir != "" {
+		if file, err := os.Open(filepath.Join(dir, fileName)); err == nil {
+			defer file.Close()
+			r = file
+		}
+	}
+	if r == nil {
+		data, err := fs.ReadFile(tablesFS, "data/"+fileName+".gz")
+		if err != nil {
+			return nil, fmt.Errorf("rank table for %s is not available, run go generate in tools/tokenizer or set %s", name, ENV_TOKENIZER_DIR)
+		}
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("load %s.gz: %w", fileName, err)
+		}
+		r = gz
+	}
+	ranks, err := LoadRanks(r)
+	if err != nil {
+		return nil, fmt.Errorf("load %s: %w", fileName, err)
+	}
+	return ranks, nil
+}
+
+// Default returns the estimator used by the tools: the encoding named by
+// LLM_TOOLS_TOKENIZER, otherwise cl100k_base, otherwise the heuristic estimator
+// when no rank table is available
+func Default() Estimator {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if defaultEstimator != nil {
+		return defaultEstimator
+	}
+	name := os.Getenv(ENV_TOKENIZER)
+	if name == "" {
+		name = CL100K_BASE
+	}
+	e, err := getLocked(name)
+	if err != nil {
+		e = Heuristic{}
+	}
+	defaultEstimator = e
+	return e
+}
+
+// SetDefault overrides the estimator returned by Default
+func SetDefault(e Estimator) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	defaultEstimator = e
+}
+
+// Count returns the number of tokens in text according to the default estimator
+func Count(text string) int {
+	if text == "" {
+		return 0
+	}
+	return Default().CountTokens(text)
+}
+
+// CountLines returns the tokens of lines joined by newlines
+func CountLines(lines []string) int {
+	return Count(strings.Join(lines, "\n"))
+}
+
+// FitLines returns how many leading lines fit within maxTokens when joined
+// with newlines. A non-positive maxTokens means unlimited.
+func FitLines(lines []string, maxTokens int) int {
+	if maxTokens <= 0 {
+		return len(lines)
+	}
+	e := Default()
+	total := 0
+	for i, line := range lines {
+		tokens := e.CountTokens(line)
+		if i > 0 {
+			// the joining newline
+			tokens++
+		}
+		if total+tokens > maxTokens {
+			return i
+		}
+		total += tokens
+	}
+	return len(lines)
+}
+
+// Truncate cuts text so that it fits within maxTokens, preferring to cut at a
+// line boundary. It reports whether text was cut. A non-positive maxTokens means unlimited.
+func Truncate(text string, maxTokens int) (string, bool) {
+	if maxTokens <= 0 || Count(text) <= maxTokens {
+		return text, false
+	}
+	lines := strings.Split(text, "\n")
+	n := FitLines(lines, maxTokens)
+	if n > 0 {
+		return strings.Join(lines[:n], "\n"), true
+	}
+	// the first line alone is too long, binary search the longest fitting prefix
+	first := []rune(lines[0])
+	lo, hi := 0, len(first)
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if Count(string(first[:mid])) <= maxTokens {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return string(first[:lo]), true
+}
+
+// Heuristic estimates tokens without rank tables. It splits text with the
+// cl100k pre-tokenizer and charges each piece by its length, which is
+// usually within 10-20% of the real count for code and English prose.
+type Heuristic struct{}
+
+// Name implements Estimator
+func (Heuristic) Name() string {
+	return HEURISTIC
+}
+
+// CountTokens implements Estimator
+func (Heuristic) CountTokens(text string) int {
+	count := 0
+	for _, piece := range SplitCL100K(text) {
+		count += estimatePiece(piece)
+	}
+	return count
+}
+
+func estimatePiece(piece string) int {
+	if !isASCII(piece) {
+		// non-latin scripts take roughly one token per character
+		return utf8.RuneCountInString(piece)
+	}
+	trimmed := strings.TrimPrefix(piece, " ")
+	if trimmed == "" {
+		return 1
+	}
+	var perToken int
+	switch c := trimmed[0]; {
+	case isASCIILetter(c):
+		// common words are single tokens, long identifiers split every ~5 chars
+		perToken = 5
+	case c >= '0' && c <= '9':
+		perToken = 3
+	case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+		perToken = 8
+	default:
+		perToken = 2
+	}
+	n := (len(trimmed) + perToken - 1) / perToken
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+func isASCIILetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
@@ -0,0 +1,163 @@
+package tokenizer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestSplitCL100K(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"hello world", []string{"hello", " world"}},
+		{"I'm here", []string{"I", "'m", " here"}},
+		{"12345", []string{"123", "45"}},
+		{"a  b", []string{"a", " ", " b"}},
+		{"x\n\ny", []string{"x", "\n\n", "y"}},
+		{"func main() {", []string{"func", " main", "()", " {"}},
+		{"trailing  ", []string{"trailing", "  "}},
+	}
+	for _, tt := range tests {
+		if got := SplitCL100K(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SplitCL100K(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestSplitO200K(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"HelloWorld", []string{"Hello", "World"}},
+		{"don't", []string{"don't"}},
+		{"a/b//\n", []string{"a", "/b", "//\n"}},
+	}
+	for _, tt := range tests {
+		if got := SplitO200K(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SplitO200K(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestBPE(t *testing.T) {
+	var table strings.Builder
+	rank := 0
+	add := func(token string) {
+		fmt.Fprintf(&table, "%s %d\n", base64.StdEncoding.EncodeToString([]byte(token)), rank)
+		rank++
+	}
+	for c := 0; c < 256; c++ {
+		add(string([]byte{byte(c)}))
+	}
+	add("ab")
+	add("abc")
+	add(" a")
+
+	ranks, err := LoadRanks(strings.NewReader(table.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bpe := NewBPE("test", ranks, SplitCL100K)
+	if got := bpe.Encode("abc"); !reflect.DeepEqual(got, []int{257}) {
+		t.Errorf("Encode(abc) = %v, want [257]", got)
+	}
+	if got := bpe.Encode("abd"); !reflect.DeepEqual(got, []int{256, 'd'}) {
+		t.Errorf("Encode(abd) = %v, want [256 100]", got)
+	}
+	if got := bpe.CountTokens("abc abd"); got != 4 {
+		t.Errorf("CountTokens = %d, want 4", got)
+	}
+}
+
+func TestLoadRanksInvalid(t *testing.T) {
+	if _, err := LoadRanks(strings.NewReader("YQ== notanumber\n")); err == nil {
+		t.Error("expected error for invalid rank")
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	SetDefault(Heuristic{})
+	defer SetDefault(nil)
+
+	text := "first line\nsecond line\nthird line"
+	got, truncated := Truncate(text, Count("first line\nsecond line"))
+	if !truncated || got != "first line\nsecond line" {
+		t.Errorf("Truncate = %q, %v", got, truncated)
+	}
+	if got, truncated := Truncate(text, 1000); truncated || got != text {
+		t.Errorf("Truncate with large budget = %q, %v", got, truncated)
+	}
+	got, truncated = Truncate(strings.Repeat("word ", 100), 10)
+	if !truncated || Count(got) > 10 || got == "" {
+		t.Errorf("Truncate of single line = %q, %v", got, truncated)
+	}
+}
+
+// byteTable is a rank table of the 256 single bytes followed by extra tokens
+func byteTable(extra ...string) string {
+	var table strings.Builder
+	for c := 0; c < 256; c++ {
+		fmt.Fprintf(&table, "%s %d\n", base64.StdEncoding.EncodeToString([]byte{byte(c)}), c)
+	}
+	for i, token := range extra {
+		fmt.Fprintf(&table, "%s %d\n", base64.StdEncoding.EncodeToString([]byte(token)), 256+i)
+	}
+	return table.String()
+}
+
+func TestLoadEncoding(t *testing.T) {
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte(byteTable("ab")))
+	w.Close()
+	embedded := tablesFS
+	tablesFS = fstest.MapFS{"data/cl100k_base.tiktoken.gz": {Data: gz.Bytes()}}
+	defer func() { tablesFS = embedded }()
+
+	t.Setenv(ENV_TOKENIZER_DIR, "")
+	ranks, err := loadEncoding(CL100K_BASE)
+	if err != nil || len(ranks) != 257 {
+		t.Fatalf("embedded table: %d ranks, %v, want 257", len(ranks), err)
+	}
+	if _, err := loadEncoding(O200K_BASE); err == nil {
+		t.Errorf("missing embedded table loaded, want error")
+	}
+
+	// the directory takes precedence, and falls back to the embedded tables
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "cl100k_base.tiktoken"), []byte(byteTable("ab", "abc")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(ENV_TOKENIZER_DIR, dir)
+	if ranks, err := loadEncoding(CL100K_BASE); err != nil || len(ranks) != 258 {
+		t.Errorf("table from %s: %d ranks, %v, want 258", ENV_TOKENIZER_DIR, len(ranks), err)
+	}
+}
+
+func TestEmbeddedTables(t *testing.T) {
+	if _, err := fs.Stat(tablesFS, "data/cl100k_base.tiktoken.gz"); err != nil {
+		t.Skip("rank tables not generated, run go generate")
+	}
+	t.Setenv(ENV_TOKENIZER_DIR, "")
+	for _, name := range []string{CL100K_BASE, O200K_BASE} {
+		ranks, err := loadEncoding(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bpe := NewBPE(name, ranks, splitters[name])
+		if got := bpe.CountTokens("hello world"); got != 2 {
+			t.Errorf("%s: CountTokens(hello world) = %d, want 2", name, got)
+		}
+	}
+}
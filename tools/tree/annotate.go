@@ -0,0 +1,201 @@
+package tree
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/xhd2015/llm-tools/tools/units"
+)
+
+// Git status markers
+const (
+	GIT_MODIFIED  = "M"
+	GIT_ADDED     = "A"
+	GIT_DELETED   = "D"
+	GIT_UNTRACKED = "?"
+)
+
+// gitStatus holds the git status of the working tree around a directory
+type gitStatus struct {
+	// markers are keyed by absolute path
+	markers map[string]string
+	// deleted holds the names of deleted files keyed by their absolute parent directory
+	deleted map[string][]string
+}
+
+// loadGitStatus runs git status in dir. Outside a repository, or without
+// git, the status is empty.
+func loadGitStatus(dir string) *gitStatus {
+	status := &gitStatus{
+		markers: make(map[string]string),
+		deleted: make(map[string][]string),
+	}
+	top, err := exec.Command("git", "-C", dir, "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return status
+	}
+	repoRoot := filepath.FromSlash(strings.TrimSpace(string(top)))
+	output, err := exec.Command("git", "-C", dir, "status", "--porcelain=v1", "-z", "--untracked-files=all", "--", ".").Output()
+	if err != nil {
+		return status
+	}
+	// entries are "XY path", renames and copies are followed by the original path
+	fields := strings.Split(string(output), "\x00")
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+		if len(field) < 4 {
+			continue
+		}
+		xy := field[:2]
+		path := filepath.Join(repoRoot, filepath.FromSlash(field[3:]))
+		if xy[0] == 'R' || xy[0] == 'C' {
+			i++
+		}
+		marker := gitMarker(xy)
+		status.markers[path] = marker
+		if marker == GIT_DELETED {
+			parent := filepath.Dir(path)
+			status.deleted[parent] = append(status.deleted[parent], filepath.Base(path))
+		}
+	}
+	return status
+}
+
+// gitMarker reduces the two letter status of git status --porcelain to a marker
+func gitMarker(xy string) string {
+	switch {
+	case xy == "??":
+		return GIT_UNTRACKED
+	case strings.ContainsAny(xy, "ARC"):
+		return GIT_ADDED
+	case strings.Contains(xy, "D"):
+		return GIT_DELETED
+	}
+	return GIT_MODIFIED
+}
+
+// changesUnder counts the changed files under the absolute directory dir
+func (c *gitStatus) changesUnder(dir string) int {
+	prefix := dir + string(filepath.Separator)
+	n := 0
+	for path := range c.markers {
+		if strings.HasPrefix(path, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+// annotateFile sets the annotations of the file item found at path
+func annotateFile(item *Item, path string, entry os.DirEntry, opts TreeOptions) {
+	if opts.ShowSizes || opts.ShowLines {
+		if info, err := entry.Info(); err == nil {
+			if opts.ShowSizes {
+				item.Size = info.Size()
+			}
+			if opts.ShowLines && info.Mode().IsRegular() {
+				item.Lines = countLines(path)
+			}
+		}
+	}
+	if opts.git != nil {
+		item.Git = opts.git.markers[path]
+	}
+}
+
+// measureDir sums the sizes and lines of the files under a directory that is
+// not expanded, with the same filters as the tree
+func measureDir(dir string, opts TreeOptions, includePatterns []*regexp.Regexp, excludePatterns []*regexp.Regexp) Item {
+	var total Item
+	entries, err := readDir(dir, opts)
+	if err != nil {
+		return total
+	}
+	for _, entry := range entries {
+		if !matchPatterns(entry.Name(), includePatterns, excludePatterns) {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		if entry.IsDir() {
+			addTotals(&total, measureDir(path, opts, includePatterns, excludePatterns))
+			continue
+		}
+		var file Item
+		annotateFile(&file, path, entry, opts)
+		addTotals(&total, file)
+	}
+	return total
+}
+
+// addTotals adds the sizes and lines of src to dst
+func addTotals(dst *Item, src Item) {
+	dst.Size += src.Size
+	dst.Lines += src.Lines
+}
+
+// countLines counts the lines of a text file, binary files, judged by a NUL
+// byte in their first block like git does, have none
+func countLines(path string) int {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	buffer := make([]byte, 32*1024)
+	lines := 0
+	first := true
+	var last byte
+	for {
+		n, err := file.Read(buffer)
+		if n > 0 {
+			if first && bytes.IndexByte(buffer[:n], 0) >= 0 {
+				return 0
+			}
+			first = false
+			lines += bytes.Count(buffer[:n], []byte{'\n'})
+			last = buffer[n-1]
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return lines
+		}
+	}
+	if !first && last != '\n' {
+		// the last line has no newline
+		lines++
+	}
+	return lines
+}
+
+// addAnnotations renders the annotations of an item after its name, such as
+// main.go [M, 1.2KB, 40 lines] or src [12KB, 340 lines, 3 changed]
+func addAnnotations(name string, item Item) string {
+	var parts []string
+	if item.Git != "" {
+		parts = append(parts, item.Git)
+	}
+	if item.Size > 0 {
+		parts = append(parts, units.FormatSize(item.Size))
+	}
+	if item.Lines == 1 {
+		parts = append(parts, "1 line")
+	} else if item.Lines > 0 {
+		parts = append(parts, fmt.Sprintf("%d lines", item.Lines))
+	}
+	if item.Changes > 0 {
+		parts = append(parts, fmt.Sprintf("%d changed", item.Changes))
+	}
+	if len(parts) == 0 {
+		return name
+	}
+	return name + " [" + strings.Join(parts, ", ") + "]"
+}
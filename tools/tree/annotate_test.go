@@ -0,0 +1,114 @@
+package tree
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xhd2015/llm-tools/tools/internal/testfiles"
+)
+
+func TestTreeAnnotations(t *testing.T) {
+	root := t.TempDir()
+	testfiles.Write(t, root, map[string]string{
+		"main.go":             "package main\n\nfunc main() {}\n",
+		"data.bin":            "\x00\x01\x02",
+		"steps/1_step/run.sh": "a\nb",
+		"steps/2_step/run.sh": "a\nb\nc\n",
+		"steps/3_step/run.sh": "a\n",
+		"deep/a/b/c.txt":      "1\n2\n3\n4\n",
+	})
+
+	item, err := TreeItem(root, TreeOptions{
+		CollapseRepeated: true,
+		Depth:            2,
+		ShowSizes:        true,
+		ShowLines:        true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := PrintItem(item)
+	for _, want := range []string{
+		// 3 lines of main.go, none of the binary file, 3 of the steps and 4 of c.txt
+		" [51B, 13 lines]\n",
+		"├── deep [8B, 4 lines]\n",
+		// directories cut by depth still count what is under them
+		"│   └── a [8B, 4 lines]\n",
+		"├── steps [11B, 6 lines]\n",
+		// the collapsed repetitions add up
+		"│   └── 1_step (3 times) [11B, 6 lines]\n",
+		"├── data.bin [3B]\n",
+		"└── main.go [29B, 3 lines]\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("tree does not contain %q:\n%s", want, got)
+		}
+	}
+
+	// directory totals include files hidden by DirectoriesOnly
+	item, err = TreeItem(root, TreeOptions{DirectoriesOnly: true, ShowLines: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Lines != 13 || strings.Contains(PrintItem(item), "main.go") {
+		t.Errorf("dir only tree:\n%s", PrintItem(item))
+	}
+}
+
+func TestTreeGitStatus(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	root := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = root
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com", "GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com", "HOME="+root)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, output)
+		}
+	}
+	testfiles.Write(t, root, map[string]string{
+		"keep.go":      "package a\n",
+		"edit.go":      "package a\n",
+		"gone.go":      "package a\n",
+		"pkg/lib.go":   "package pkg\n",
+		"pkg/other.go": "package pkg\n",
+	})
+	git("init", "-q")
+	git("add", ".")
+	git("commit", "-q", "-m", "init")
+	testfiles.Write(t, root, map[string]string{
+		"edit.go":    "package a\n\nvar x int\n",
+		"pkg/new.go": "package pkg\n",
+		"staged.go":  "package a\n",
+	})
+	git("add", "staged.go")
+	if err := os.Remove(filepath.Join(root, "gone.go")); err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := TreeItem(root, TreeOptions{GitStatus: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := PrintItem(item)
+	for _, want := range []string{
+		" [4 changed]\n",
+		"├── pkg [1 changed]\n",
+		"│   ├── lib.go\n",
+		"│   ├── new.go [?]\n",
+		"├── edit.go [M]\n",
+		"├── gone.go [D]\n",
+		"├── keep.go\n",
+		"└── staged.go [A]\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("tree does not contain %q:\n%s", want, got)
+		}
+	}
+}
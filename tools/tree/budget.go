@@ -0,0 +1,326 @@
+package tree
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/xhd2015/llm-tools/tools/tokenizer"
+)
+
+// Budget bounds the rendering of a tree, zero fields mean no limit
+type Budget struct {
+	MaxTokens int
+	MaxChars  int
+}
+
+// Limited reports whether the budget bounds anything
+func (c Budget) Limited() bool {
+	return c.MaxTokens > 0 || c.MaxChars > 0
+}
+
+// Fits reports whether a rendering is within the budget
+func (c Budget) Fits(output string) bool {
+	if c.MaxChars > 0 && utf8.RuneCountInString(output) > c.MaxChars {
+		return false
+	}
+	if c.MaxTokens > 0 && tokenizer.Count(output) > c.MaxTokens {
+		return false
+	}
+	return true
+}
+
+// FitOptions controls how FitItem shrinks a tree
+type FitOptions struct {
+	// Render renders a tree the way it is measured against the budget
+	Render func(item Item) string
+	// Collapse is the collapsing requested, FitItem enables more when needed
+	Collapse CollapseOptions
+	// MaxEntriesPerDir is applied after collapsing like Tree does, 0 means no limit
+	MaxEntriesPerDir int
+	// ExpandDirs are slash separated paths relative to the root that are
+	// never elided, nor are the directories on the way to them
+	ExpandDirs []string
+}
+
+// FitItem shrinks a tree built without collapsing until its rendering fits
+// the budget. It enables CollapseRepeated, CollapsePattern and CollapseLeaf
+// in turn, then removes the deepest level of the largest subtrees until
+// each is one level deep, then shortens the longest directories below the
+// top level, then removes the children of the top level directories, largest
+// first, and finally shortens the top level itself. The entries of the root
+// are thus the last thing cut. Directories whose children were removed have
+// Elided set, see ElidedDirs. ok is false if even the last step does not fit.
+func FitItem(raw Item, budget Budget, opts FitOptions) (item Item, ok bool) {
+	expandDirs := cleanExpandDirs(opts.ExpandDirs)
+	// expand dirs and everything below them are left as they are
+	var p protection
+	p.frozen = func(path string) bool {
+		for _, expandDir := range expandDirs {
+			if path == expandDir || strings.HasPrefix(path, expandDir+"/") {
+				return true
+			}
+		}
+		return false
+	}
+	// and the directories on the way to them stay visible
+	p.kept = func(path string) bool {
+		if p.frozen(path) {
+			return true
+		}
+		for _, expandDir := range expandDirs {
+			if strings.HasPrefix(expandDir, path+"/") {
+				return true
+			}
+		}
+		return false
+	}
+	fits := func(item Item) bool {
+		return budget.Fits(opts.Render(item))
+	}
+	shape := func(collapse CollapseOptions) Item {
+		item := Collapse(raw, collapse)
+		if !reflect.DeepEqual(collapse, opts.Collapse) {
+			restoreKept(&item, raw, "", p)
+		}
+		if opts.MaxEntriesPerDir > 0 {
+			applyMaxEntriesLimit(&item, opts.MaxEntriesPerDir)
+		}
+		return item
+	}
+
+	collapse := opts.Collapse
+	item = shape(collapse)
+	if !budget.Limited() || fits(item) {
+		return item, true
+	}
+
+	// collapse more
+	for _, enable := range []*bool{&collapse.CollapseRepeated, &collapse.CollapsePattern, &collapse.CollapseLeaf} {
+		if *enable {
+			continue
+		}
+		*enable = true
+		item = shape(collapse)
+		if fits(item) {
+			return item, true
+		}
+	}
+
+	// reduce the depth of the largest subtrees
+	item = cloneItem(item)
+	for foldDeepest(&item, 2, p) {
+		if fits(item) {
+			return item, true
+		}
+	}
+
+	// shorten the longest directories below the top level
+	if shorten(&item, 1, p, fits) {
+		return item, true
+	}
+
+	// keep the top level directories without their children
+	for foldDeepest(&item, 1, p) {
+		if fits(item) {
+			return item, true
+		}
+	}
+
+	// shorten the top level
+	return item, shorten(&item, 0, p, fits)
+}
+
+// shorten elides the tails of the longest directories at least minDepth
+// below the root, keeping fewer entries each round, until the tree fits.
+// It reports whether it does.
+func shorten(item *Item, minDepth int, p protection, fits func(item Item) bool) bool {
+	for limit := maxChildren(*item, "", 0, minDepth, p); limit > 1; {
+		limit = limit * 2 / 3
+		if limit < 1 {
+			limit = 1
+		}
+		elideEntries(item, "", 0, minDepth, limit, p)
+		if fits(*item) {
+			return true
+		}
+	}
+	return fits(*item)
+}
+
+// ElidedDirs returns the slash separated paths, relative to the root, of the
+// directories below the root whose children FitItem removed. They can be
+// passed back as expand_dirs.
+func ElidedDirs(item Item) []string {
+	var dirs []string
+	var walk func(prefix string, item Item)
+	walk = func(prefix string, item Item) {
+		for _, child := range item.Children {
+			path := prefix + getName(child)
+			if child.Elided > 0 {
+				dirs = append(dirs, path)
+			}
+			walk(path+"/", child)
+		}
+	}
+	walk("", item)
+	return dirs
+}
+
+// protection tells which paths, relative to the root, FitItem must not elide
+type protection struct {
+	// kept paths stay visible
+	kept func(path string) bool
+	// frozen paths keep all their children
+	frozen func(path string) bool
+}
+
+// foldDeepest removes the deepest level of the largest subtree under the root
+// that is at least minHeight levels deep. It reports false when there is none.
+func foldDeepest(root *Item, minHeight int, p protection) bool {
+	var candidates []int
+	for i, child := range root.Children {
+		if height(child) >= minHeight && !p.frozen(getName(child)) {
+			candidates = append(candidates, i)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return countItems(root.Children[candidates[i]]) > countItems(root.Children[candidates[j]])
+	})
+	for _, i := range candidates {
+		subtree := &root.Children[i]
+		level := height(*subtree) - 1
+		var foldAt func(item *Item, path string, depth int) bool
+		foldAt = func(item *Item, path string, depth int) bool {
+			if depth == level {
+				if len(item.Children) == 0 || p.kept(path) {
+					return false
+				}
+				fold(item)
+				return true
+			}
+			folded := false
+			for i := range item.Children {
+				child := &item.Children[i]
+				if foldAt(child, path+"/"+getName(*child), depth+1) {
+					folded = true
+				}
+			}
+			return folded
+		}
+		if foldAt(subtree, getName(*subtree), 0) {
+			return true
+		}
+		// everything at that level is protected
+		if !p.kept(getName(*subtree)) {
+			fold(subtree)
+			return true
+		}
+	}
+	return false
+}
+
+// restoreKept brings back from raw the kept paths that collapsing removed,
+// and the frozen ones in full
+func restoreKept(item *Item, raw Item, path string, p protection) {
+	for _, rawChild := range raw.Children {
+		childPath := joinPath(path, getName(rawChild))
+		if !p.kept(childPath) {
+			continue
+		}
+		found := false
+		for i := range item.Children {
+			child := &item.Children[i]
+			if getName(*child) != getName(rawChild) {
+				continue
+			}
+			found = true
+			if p.frozen(childPath) {
+				*child = rawChild
+			} else {
+				restoreKept(child, rawChild, childPath, p)
+			}
+		}
+		if found {
+			continue
+		}
+		restored := rawChild
+		if !p.frozen(childPath) {
+			// only the way to the frozen paths
+			restored.Children = nil
+			restoreKept(&restored, rawChild, childPath, p)
+			restored.Elided = len(rawChild.Children) - len(restored.Children)
+		}
+		item.Children = append(item.Children, restored)
+	}
+}
+
+// fold removes the children of a directory, counting them as elided
+func fold(item *Item) {
+	item.Elided += len(item.Children)
+	item.Children = nil
+}
+
+// elideEntries keeps the first limit children of each directory at least
+// minDepth below the root, and the kept ones after them
+func elideEntries(item *Item, path string, depth int, minDepth int, limit int, p protection) {
+	if depth >= minDepth && len(item.Children) > limit && (path == "" || !p.frozen(path)) {
+		kept := item.Children[:limit:limit]
+		for _, child := range item.Children[limit:] {
+			if p.kept(joinPath(path, getName(child))) {
+				kept = append(kept, child)
+			}
+		}
+		item.Elided += len(item.Children) - len(kept)
+		item.Children = kept
+	}
+	for i := range item.Children {
+		child := &item.Children[i]
+		elideEntries(child, joinPath(path, getName(*child)), depth+1, minDepth, limit, p)
+	}
+}
+
+// maxChildren is the largest number of children of a directory at least
+// minDepth below the root that may be shortened
+func maxChildren(item Item, path string, depth int, minDepth int, p protection) int {
+	max := 0
+	if depth >= minDepth && (path == "" || !p.frozen(path)) {
+		max = len(item.Children)
+	}
+	for _, child := range item.Children {
+		if n := maxChildren(child, joinPath(path, getName(child)), depth+1, minDepth, p); n > max {
+			max = n
+		}
+	}
+	return max
+}
+
+func joinPath(prefix string, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "/" + name
+}
+
+// height is the number of levels below an item
+func height(item Item) int {
+	h := 0
+	for _, child := range item.Children {
+		if ch := height(child) + 1; ch > h {
+			h = ch
+		}
+	}
+	return h
+}
+
+func cloneItem(item Item) Item {
+	if item.Children != nil {
+		children := make([]Item, len(item.Children))
+		for i, child := range item.Children {
+			children[i] = cloneItem(child)
+		}
+		item.Children = children
+	}
+	return item
+}
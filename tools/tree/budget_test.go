@@ -0,0 +1,159 @@
+package tree
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func budgetTestDir(t *testing.T) string {
+	tempDir := t.TempDir()
+	for i := 0; i < 30; i++ {
+		dir := filepath.Join(tempDir, fmt.Sprintf("pkg%02d", i), "internal", "deep")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		file := filepath.Join(dir, fmt.Sprintf("file%02d.go", i))
+		if err := os.WriteFile(file, []byte("package deep\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return tempDir
+}
+
+func TestFitItem(t *testing.T) {
+	raw, err := TreeItem(budgetTestDir(t), TreeOptions{MaxEntriesPerDir: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := FitOptions{Render: PrintItem}
+
+	// no budget keeps the tree
+	if item, ok := FitItem(raw, Budget{}, opts); !ok || !reflect.DeepEqual(item, raw) {
+		t.Errorf("no budget should keep the tree")
+	}
+
+	for _, budget := range []Budget{{MaxTokens: 200}, {MaxChars: 300}} {
+		item, ok := FitItem(raw, budget, opts)
+		output := PrintItem(item)
+		if !ok || !budget.Fits(output) {
+			t.Errorf("%+v: does not fit, got %d chars:\n%s", budget, utf8.RuneCountInString(output), output)
+		}
+		if !strings.Contains(output, "elided)") || len(ElidedDirs(item)) == 0 {
+			t.Errorf("%+v: expected elided directories:\n%s", budget, output)
+		}
+		// the raw tree is left untouched
+		if countItems(raw) != 1+30*4 {
+			t.Fatalf("raw tree modified, %d items", countItems(raw))
+		}
+	}
+
+	// expand dirs survive, with the way to them
+	item, _ := FitItem(raw, Budget{MaxTokens: 200}, FitOptions{Render: PrintItem, ExpandDirs: []string{"pkg29/internal"}})
+	output := PrintItem(item)
+	if !strings.Contains(output, "pkg29") || !strings.Contains(output, "file29.go") {
+		t.Errorf("expand dir elided:\n%s", output)
+	}
+	for _, dir := range ElidedDirs(item) {
+		if strings.HasPrefix(dir, "pkg29/internal") {
+			t.Errorf("expand dir %s listed as elided", dir)
+		}
+	}
+}
+
+func TestFitItemKeepsTopLevel(t *testing.T) {
+	tempDir := t.TempDir()
+	names := []string{"archive", "bufio", "bytes", "cmd", "compress", "container", "context", "crypto", "database", "debug", "embed", "encoding", "errors", "expvar", "flag", "fmt", "go", "hash", "html", "image"}
+	for i, name := range names {
+		dir := filepath.Join(tempDir, name, "internal")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for j := 0; j < 8; j++ {
+			if err := os.WriteFile(filepath.Join(tempDir, name, fmt.Sprintf("%s_%c.go", name, 'a'+j)), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("impl%d.go", i)), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	raw, err := TreeItem(tempDir, TreeOptions{MaxEntriesPerDir: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// room for one line per top level directory
+	folded := cloneItem(raw)
+	for i := range folded.Children {
+		fold(&folded.Children[i])
+	}
+	budget := Budget{MaxChars: utf8.RuneCountInString(PrintItem(folded))}
+
+	item, ok := FitItem(raw, budget, FitOptions{Render: PrintItem})
+	output := PrintItem(item)
+	if !ok {
+		t.Fatalf("does not fit:\n%s", output)
+	}
+	if item.Elided != 0 || len(item.Children) != len(names) {
+		t.Errorf("top level cut to %d entries, %d elided:\n%s", len(item.Children), item.Elided, output)
+	}
+	for _, name := range names {
+		if !strings.Contains(output, name) {
+			t.Errorf("%s missing:\n%s", name, output)
+		}
+	}
+}
+
+func TestTreeToolBudget(t *testing.T) {
+	tempDir := budgetTestDir(t)
+
+	response, err := ExecuteTree(TreeRequest{
+		WorkspaceRoot:         tempDir,
+		RelativeWorkspacePath: ".",
+		MaxTokens:             150,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !response.Truncated || len(response.ElidedDirs) == 0 || response.Tokens > 150 {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+	if strings.Contains(response.Tree, "more lines truncated") {
+		t.Errorf("tree should fit without cutting lines:\n%s", response.Tree)
+	}
+
+	// passing an elided dir back shows it in full
+	elided := response.ElidedDirs[0]
+	response, err = ExecuteTree(TreeRequest{
+		WorkspaceRoot:         tempDir,
+		RelativeWorkspacePath: ".",
+		MaxTokens:             150,
+		ExpandDirs:            []string{elided},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range response.ElidedDirs {
+		if dir == elided || strings.HasPrefix(dir, elided+"/") {
+			t.Errorf("%s still elided: %v", elided, response.ElidedDirs)
+		}
+	}
+
+	// a budget large enough changes nothing
+	response, err = ExecuteTree(TreeRequest{
+		WorkspaceRoot:         tempDir,
+		RelativeWorkspacePath: ".",
+		MaxChars:              100000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Truncated || response.ElidedDirs != nil || strings.Contains(response.Tree, "elided") {
+		t.Errorf("unexpected shrinking: %+v", response)
+	}
+}
@@ -10,7 +10,8 @@ import (
 // collapse try to minimize the output size
 // while maximizing the uniqueness of each entry
 //
-// test tokenize: https://platform.openai.com/tokenizer
+// token counts are measured with tools/tokenizer (cl100k_base),
+// e.g. via the tokens field of the tree tool response
 //
 // some test data:
 //   base: 263173 chars, 84099 tokens
@@ -66,6 +67,8 @@ func recomputePatterns(items []internalItem) {
 	compute = func(item *internalItem) string {
 		h := md5.New()
 		h.Write([]byte(item.item.Name))
+		// entries with different diff markers never collapse together
+		h.Write([]byte(item.item.Diff))
 		// Include CollapsedPatternChildren in the pattern
 		h.Write([]byte(fmt.Sprintf("%d", item.item.CollapsedPatternChildren)))
 		for i := 0; i < len(item.children); i++ {
@@ -264,10 +267,16 @@ func collapseRepeatedItems(items []internalItem) []internalItem {
 				result = append(result, items[i])
 			}
 
-			// Add the pattern items with repeat counts
+			// Add the pattern items with repeat counts, the annotations of
+			// the repetitions add up in the item shown
 			for j := 0; j < p.length; j++ {
 				item := items[p.start+j]
 				item.item.SubsequentRepeated = p.repeatCount - 1
+				for k := 1; k < p.repeatCount; k++ {
+					repeated := items[p.start+k*p.length+j].item
+					addTotals(&item.item, repeated)
+					item.item.Changes += repeated.Changes
+				}
 				result = append(result, item)
 			}
 
@@ -400,7 +409,7 @@ func collapseLeafItems(items []internalItem) []internalItem {
 					isLeaf = true
 				}
 			}
-			itemName := item.item.Name
+			itemName := item.item.Diff + item.item.Name
 			shouldKeep := true
 			if isLeaf {
 				if leafPatterns[itemName] {
@@ -0,0 +1,269 @@
+package tree
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Diff markers of an item compared to an earlier tree
+const (
+	DIFF_ADDED   = "+"
+	DIFF_REMOVED = "-"
+	DIFF_RENAMED = "~"
+)
+
+// DiffStats counts the entries that differ between two trees, entries under
+// an added or removed directory included
+type DiffStats struct {
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+	Renamed int `json:"renamed"`
+}
+
+// String summarizes the stats, e.g. "3 added, 1 removed, 0 renamed"
+func (c DiffStats) String() string {
+	return fmt.Sprintf("%d added, %d removed, %d renamed", c.Added, c.Removed, c.Renamed)
+}
+
+// DiffItem compares a tree to an earlier one. The result holds the added,
+// removed and renamed entries, marked with Diff, and the directories leading
+// to them; the other entries are only counted in Unchanged. Files are compared
+// by name, a removed and an added sibling are a rename when they are the only
+// ones with the same content: the same names in a non-empty subtree for directories, the
+// same size and lines for files when they are known. The root names are not
+// compared.
+func DiffItem(old Item, new Item) (Item, DiffStats) {
+	var stats DiffStats
+	result := new
+	result.Children, result.Unchanged = diffChildren(old.Children, new.Children, &stats)
+	sortItems(result.Children)
+	return result, stats
+}
+
+func diffChildren(oldChildren []Item, newChildren []Item, stats *DiffStats) ([]Item, int) {
+	key := func(item Item) string {
+		return fmt.Sprintf("%t/%s", item.Dir, getName(item))
+	}
+	olds := make(map[string]Item, len(oldChildren))
+	for _, child := range oldChildren {
+		olds[key(child)] = child
+	}
+
+	var children []Item
+	var added []Item
+	unchanged := 0
+	matched := make(map[string]bool, len(newChildren))
+	for _, child := range newChildren {
+		oldChild, ok := olds[key(child)]
+		if !ok {
+			added = append(added, child)
+			continue
+		}
+		matched[key(child)] = true
+		if !child.Dir {
+			unchanged++
+			continue
+		}
+		changed, unchangedChildren := diffChildren(oldChild.Children, child.Children, stats)
+		if len(changed) == 0 {
+			unchanged++
+			continue
+		}
+		child.Children = changed
+		child.Unchanged = unchangedChildren
+		children = append(children, child)
+	}
+	var removed []Item
+	for _, child := range oldChildren {
+		if !matched[key(child)] {
+			removed = append(removed, child)
+		}
+	}
+
+	// pair the renames, an entry matching several others is left alone
+	removedSignatures := make(map[string]int)
+	for _, item := range removed {
+		removedSignatures[diffSignature(item)]++
+	}
+	addedSignatures := make(map[string]int)
+	for _, item := range added {
+		addedSignatures[diffSignature(item)]++
+	}
+	renamedFrom := make(map[string]Item)
+	var removedLeft []Item
+	for _, item := range removed {
+		sig := diffSignature(item)
+		if sig != "" && removedSignatures[sig] == 1 && addedSignatures[sig] == 1 {
+			renamedFrom[sig] = item
+			continue
+		}
+		removedLeft = append(removedLeft, item)
+	}
+	for _, item := range added {
+		if from, ok := renamedFrom[diffSignature(item)]; ok {
+			item.Diff = DIFF_RENAMED
+			item.RenamedFrom = getName(from)
+			item.Children = nil
+			item.Unchanged = len(from.Children)
+			stats.Renamed++
+			children = append(children, item)
+			continue
+		}
+		children = append(children, markDiff(item, DIFF_ADDED, &stats.Added))
+	}
+	for _, item := range removedLeft {
+		children = append(children, markDiff(item, DIFF_REMOVED, &stats.Removed))
+	}
+	return children, unchanged
+}
+
+// diffSignature identifies the content of an entry for rename detection, it
+// is empty when the content is unknown. The signature of a directory is the
+// names and structure under it, so that trees with and without sizes, lines
+// or git status still pair.
+func diffSignature(item Item) string {
+	if item.Dir {
+		if len(item.Children) == 0 {
+			return ""
+		}
+		return "dir:" + PrintItemCompact(Item{Children: unannotated(item.Children), MissingIndex: true})
+	}
+	if item.Size == 0 {
+		return ""
+	}
+	return fmt.Sprintf("file:%d:%d", item.Size, item.Lines)
+}
+
+// unannotated returns a copy of items without sizes, lines and git status
+func unannotated(items []Item) []Item {
+	result := make([]Item, len(items))
+	for i, item := range items {
+		item.Size = 0
+		item.Lines = 0
+		item.Git = ""
+		item.Changes = 0
+		if item.Children != nil {
+			item.Children = unannotated(item.Children)
+		}
+		result[i] = item
+	}
+	return result
+}
+
+// markDiff marks an entry and everything under it, counting them
+func markDiff(item Item, diff string, count *int) Item {
+	item.Diff = diff
+	*count++
+	if item.Children != nil {
+		children := make([]Item, len(item.Children))
+		for i, child := range item.Children {
+			children[i] = markDiff(child, diff, count)
+		}
+		item.Children = children
+	}
+	return item
+}
+
+// gitTreeItem builds the tree of dir as it is at a git revision, from the
+// files git tracks, with the filters and depth of opts
+func gitTreeItem(dir string, rev string, opts TreeOptions) (Item, error) {
+	// a revision starting with - would be taken as an option of git ls-tree
+	if rev == "" || strings.HasPrefix(rev, "-") {
+		return Item{}, fmt.Errorf("invalid revision: %q", rev)
+	}
+	includePatterns, excludePatterns, err := compilePatterns(opts)
+	if err != nil {
+		return Item{}, err
+	}
+	if opts.Depth == 0 {
+		opts.Depth = DEFAULT_MAX_DEPTH
+	}
+	opts.ExpandDirs = cleanExpandDirs(opts.ExpandDirs)
+
+	// run from dir, paths are relative to it and limited to it
+	cmd := exec.Command("git", "ls-tree", "-r", "-z", "--name-only", rev)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return Item{}, fmt.Errorf("git ls-tree %s: %w: %s", rev, err, strings.TrimSpace(stderr.String()))
+	}
+
+	dirs := map[string]bool{"": true}
+	children := make(map[string][]string)
+	addChild := func(parent string, rel string) {
+		if !dirs[rel] {
+			children[parent] = append(children[parent], rel)
+		}
+	}
+	for _, file := range strings.Split(string(out), "\x00") {
+		if file == "" {
+			continue
+		}
+		parts := strings.Split(file, "/")
+		parent := ""
+		for i, part := range parts {
+			if !matchPatterns(part, includePatterns, excludePatterns) {
+				break
+			}
+			rel := joinPath(parent, part)
+			if i == len(parts)-1 {
+				if !opts.DirectoriesOnly {
+					addChild(parent, rel)
+				}
+				break
+			}
+			addChild(parent, rel)
+			dirs[rel] = true
+			if !withinDepth(rel, opts) {
+				break
+			}
+			parent = rel
+		}
+	}
+
+	var build func(rel string) Item
+	build = func(rel string) Item {
+		index, hasIndex, name := parseNameWithIndexLocal(filepath.Base(rel))
+		item := Item{Name: name, Index: index, MissingIndex: !hasIndex, Dir: dirs[rel]}
+		for _, child := range children[rel] {
+			item.Children = append(item.Children, build(child))
+		}
+		return item
+	}
+	root := build("")
+	index, hasIndex, name := parseNameWithIndexLocal(filepath.Base(dir))
+	root.Name, root.Index, root.MissingIndex = name, index, !hasIndex
+	sortItems(root.Children)
+	return root, nil
+}
+
+// compilePatterns compiles the include and exclude regex patterns of opts
+func compilePatterns(opts TreeOptions) ([]*regexp.Regexp, []*regexp.Regexp, error) {
+	var includePatterns []*regexp.Regexp
+	var excludePatterns []*regexp.Regexp
+	for _, pattern := range opts.IncludePatterns {
+		if pattern != "" {
+			regex, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid include pattern '%s': %v", pattern, err)
+			}
+			includePatterns = append(includePatterns, regex)
+		}
+	}
+	for _, pattern := range opts.ExcludePatterns {
+		if pattern != "" {
+			regex, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid exclude pattern '%s': %v", pattern, err)
+			}
+			excludePatterns = append(excludePatterns, regex)
+		}
+	}
+	return includePatterns, excludePatterns, nil
+}
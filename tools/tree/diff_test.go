@@ -0,0 +1,261 @@
+package tree
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xhd2015/llm-tools/tools/internal/testfiles"
+)
+
+func TestDiffItem(t *testing.T) {
+	old, err := Parse(`root
+├── gen
+│   ├── 1_step
+│   │   └── a.go
+│   └── 2_step
+│       └── a.go
+├── old_name
+│   ├── x.go
+│   └── y.go
+├── api
+├── keep.go
+└── gone.go
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cur, err := Parse(`root
+├── gen
+│   ├── 1_step
+│   │   └── a.go
+│   ├── 2_step
+│   │   └── a.go
+│   └── 3_step
+│       └── a.go
+├── new_name
+│   ├── x.go
+│   └── y.go
+├── api
+│   └── handler.go
+├── keep.go
+└── added.go
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Parse does not know which leaves are directories
+	markDirs(&old)
+	markDirs(&cur)
+
+	diff, stats := DiffItem(old, cur)
+	want := `root (...1 unchanged)
+├── api
+│   └── + handler.go
+├── gen (...2 unchanged)
+│   └── + 3_step
+│       └── + a.go
+├── ~ new_name (renamed from old_name) (...2 unchanged)
+├── + added.go
+└── - gone.go
+`
+	if got := PrintItem(diff); got != want {
+		t.Errorf("diff:\n%s\nwant:\n%s", got, want)
+	}
+	if stats != (DiffStats{Added: 4, Removed: 1, Renamed: 1}) {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	// collapsing keeps added and removed entries apart
+	collapsed := Collapse(Item{Name: "root", MissingIndex: true, Dir: true, Children: []Item{
+		{Name: "a.go", MissingIndex: true, Diff: DIFF_ADDED},
+		{Name: "a.go", MissingIndex: true, Diff: DIFF_REMOVED},
+	}}, CollapseOptions{CollapseRepeated: true, CollapseLeaf: true})
+	if len(collapsed.Children) != 2 {
+		t.Errorf("added and removed entries collapsed together: %+v", collapsed.Children)
+	}
+
+	// nothing changed
+	diff, stats = DiffItem(old, old)
+	if len(diff.Children) != 0 || diff.Unchanged != len(old.Children) || stats != (DiffStats{}) {
+		t.Errorf("unexpected diff of a tree with itself: %+v, %+v", diff, stats)
+	}
+
+	// a snapshot with sizes, lines and git status still pairs renames
+	annotated := cloneItem(old)
+	for i := range annotated.Children {
+		if child := &annotated.Children[i]; child.Name == "old_name" {
+			child.Size, child.Lines, child.Changes = 12, 2, 1
+			for j := range child.Children {
+				child.Children[j].Size, child.Children[j].Lines, child.Children[j].Git = 6, 1, "M"
+			}
+		}
+	}
+	if _, stats := DiffItem(annotated, cur); stats.Renamed != 1 {
+		t.Errorf("renames of an annotated snapshot = %d, want 1", stats.Renamed)
+	}
+}
+
+// markDirs makes the entries without an extension directories
+func markDirs(item *Item) {
+	item.Dir = !strings.Contains(item.Name, ".")
+	for i := range item.Children {
+		markDirs(&item.Children[i])
+	}
+}
+
+func TestTreeToolDiff(t *testing.T) {
+	tempDir := t.TempDir()
+	oldDir := filepath.Join(tempDir, "old")
+	newDir := filepath.Join(tempDir, "new")
+	testfiles.Write(t, oldDir, map[string]string{
+		"gen/1_step/a.go": "package a\n",
+		"lib/util.go":     "package lib\n",
+		"main.go":         "package main\n",
+	})
+	testfiles.Write(t, newDir, map[string]string{
+		"gen/1_step/a.go": "package a\n",
+		"gen/2_step/a.go": "package a\n",
+		"gen/3_step/a.go": "package a\n",
+		"pkg/util.go":     "package lib\n",
+		"README.md":       "",
+	})
+
+	req := TreeRequest{
+		WorkspaceRoot:         tempDir,
+		RelativeWorkspacePath: "new",
+		IncludeFiles:          true,
+		DiffDir:               "old",
+		CollapseRepeated:      true,
+	}
+	response, err := ExecuteTree(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `new
+├── gen (...1 unchanged)
+│   └── + 2_step (2 times)
+│       └── + a.go
+├── ~ pkg (renamed from lib) (...1 unchanged)
+├── + README.md
+└── - main.go
+`
+	if response.Tree != want {
+		t.Errorf("tree:\n%s\nwant:\n%s", response.Tree, want)
+	}
+	if *response.Diff != (DiffStats{Added: 5, Removed: 1, Renamed: 1}) {
+		t.Errorf("diff = %+v", *response.Diff)
+	}
+	if !strings.HasSuffix(response.ToLLMOutput(), "5 added, 1 removed, 1 renamed\n") {
+		t.Errorf("ToLLMOutput() = %q", response.ToLLMOutput())
+	}
+
+	// a json snapshot of old gives the same diff
+	snapshot, err := ExecuteTree(TreeRequest{WorkspaceRoot: tempDir, RelativeWorkspacePath: "old", IncludeFiles: true, Format: FORMAT_JSON})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(snapshot.Root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "tree.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	req.DiffDir = ""
+	req.DiffSnapshot = "tree.json"
+	response, err = ExecuteTree(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Tree != want {
+		t.Errorf("snapshot diff:\n%s\nwant:\n%s", response.Tree, want)
+	}
+
+	// collapsed snapshots would report what they hide as removed
+	snapshot, err = ExecuteTree(TreeRequest{WorkspaceRoot: tempDir, RelativeWorkspacePath: "new", IncludeFiles: true, Format: FORMAT_JSON, CollapseRepeated: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ = json.Marshal(snapshot.Root)
+	if err := os.WriteFile(filepath.Join(tempDir, "collapsed.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	req.DiffSnapshot = "collapsed.json"
+	if _, err := ExecuteTree(req); err == nil {
+		t.Errorf("expected an error for a collapsed snapshot")
+	}
+
+	req.DiffDir = "old"
+	if _, err := ExecuteTree(req); err == nil {
+		t.Errorf("expected an error for two diff bases")
+	}
+	if _, err := ExecuteDiffFromJSON(`{"workspace_root": "` + tempDir + `", "relative_workspace_path": "new"}`); err == nil {
+		t.Errorf("expected an error without a diff base")
+	}
+}
+
+func TestTreeToolDiffRevision(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	root := t.TempDir()
+	testfiles.Write(t, root, map[string]string{
+		"src/a.go":    "package src\n",
+		"src/b.go":    "package src\n",
+		"docs/old.md": "",
+	})
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = root
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com", "GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	git("init", "-q")
+	git("add", "-A")
+	git("commit", "-q", "-m", "init")
+	if err := os.RemoveAll(filepath.Join(root, "docs")); err != nil {
+		t.Fatal(err)
+	}
+	testfiles.Write(t, root, map[string]string{"src/c.go": ""})
+
+	response, err := ExecuteTree(TreeRequest{
+		WorkspaceRoot:         root,
+		RelativeWorkspacePath: "src",
+		IncludeFiles:          true,
+		DiffRevision:          "HEAD",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// paths are relative to the diffed directory, docs is outside of it
+	want := "src (...2 unchanged)\n└── + c.go\n"
+	if response.Tree != want {
+		t.Errorf("tree:\n%s\nwant:\n%s", response.Tree, want)
+	}
+
+	response, err = ExecuteTree(TreeRequest{
+		WorkspaceRoot:         root,
+		RelativeWorkspacePath: ".",
+		DiffRevision:          "HEAD",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Base(root) + " (...1 unchanged)\n└── - docs\n"; response.Tree != want {
+		t.Errorf("tree:\n%s\nwant:\n%s", response.Tree, want)
+	}
+
+	if _, err := ExecuteTree(TreeRequest{WorkspaceRoot: root, RelativeWorkspacePath: ".", DiffRevision: "no-such-rev"}); err == nil {
+		t.Errorf("expected an error for an unknown revision")
+	}
+	if _, err := ExecuteTree(TreeRequest{WorkspaceRoot: root, RelativeWorkspacePath: ".", DiffRevision: "--output=x"}); err == nil || !strings.Contains(err.Error(), "invalid revision") {
+		t.Errorf("option-like revision: got %v, want an invalid revision error", err)
+	}
+}
@@ -0,0 +1,65 @@
+package tree
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/xhd2015/llm-tools/jsonschema"
+	"github.com/xhd2015/llm-tools/tools/defs"
+)
+
+// GetDiffToolDefinition returns the JSON schema definition for the tree_diff
+// tool, the tree tool comparing to an earlier tree
+func GetDiffToolDefinition() defs.ToolDefinition {
+	def := GetToolDefinition()
+	properties := make(map[string]*jsonschema.JsonSchema, len(def.Parameters.Properties)+3)
+	for name, property := range def.Parameters.Properties {
+		if name != "find_path" {
+			properties[name] = property
+		}
+	}
+	properties["diff_snapshot"] = &jsonschema.JsonSchema{
+		Type:        jsonschema.ParamTypeString,
+		Description: "A tree saved earlier, the output of the tree tool with format json (preferred) or text, without collapsing or max_tokens. Relative to the workspace root.",
+	}
+	properties["diff_dir"] = &jsonschema.JsonSchema{
+		Type:        jsonschema.ParamTypeString,
+		Description: "Another directory to compare to, e.g. the previous output of a code generator. Relative to the workspace root.",
+	}
+	properties["diff_revision"] = &jsonschema.JsonSchema{
+		Type:        jsonschema.ParamTypeString,
+		Description: "A git revision to compare to, e.g. HEAD or main, using the files git tracks in it.",
+	}
+	return defs.ToolDefinition{
+		Description: "Compare the directory tree to a saved snapshot, another directory or a git revision. Added entries are marked +, removed ones -, and renamed ones ~ with the name they had; unchanged entries are left out and counted as (...N unchanged). Useful to summarize what a code generator or a refactor changed structurally. Takes the filtering, collapsing and budget options of the tree tool; exactly one of diff_snapshot, diff_dir and diff_revision is required.",
+		Name:        "tree_diff",
+		Parameters: &jsonschema.JsonSchema{
+			Type:       jsonschema.ParamTypeObject,
+			Properties: properties,
+			Required:   def.Parameters.Required,
+		},
+	}
+}
+
+// ExecuteDiffFromJSON executes the tree_diff tool from JSON input
+func ExecuteDiffFromJSON(jsonInput string) (string, error) {
+	req, err := ParseJSONRequest(jsonInput)
+	if err != nil {
+		return "", err
+	}
+	if req.DiffSnapshot == "" && req.DiffDir == "" && req.DiffRevision == "" {
+		return "", fmt.Errorf("requires one of diff_snapshot, diff_dir and diff_revision")
+	}
+
+	response, err := ExecuteTree(req)
+	if err != nil {
+		return "", err
+	}
+
+	jsonOutput, err := json.Marshal(response)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal response: %w", err)
+	}
+
+	return string(jsonOutput), nil
+}
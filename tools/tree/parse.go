@@ -1,6 +1,7 @@
 package tree
 
 import (
+	"encoding/json"
 	"fmt"
 	"regexp"
 	"strings"
@@ -17,15 +18,128 @@ type Item struct {
 	// Number of children that were collapsed due to pattern matching (0 = no pattern collapse)
 	CollapsedPatternChildren int
 	CollapsedLeafChildren    int
+	// Number of children removed to fit a budget, see FitItem
+	Elided int
 
 	Star     bool // show the *
 	Children []Item
+
+	// Size is the size in bytes of a file, or of the files under a directory, when sizes are shown
+	Size int64
+	// Lines counts the lines of a text file, or of the text files under a directory, when lines are shown
+	Lines int
+	// Git is the git status marker of a file: M, A, D or ?
+	Git string
+	// Changes counts the files with a git status under a directory
+	Changes int
+	// Target is the target of a symlink as it was written
+	Target string
+	// Loop marks a symlinked directory that leads back to a directory on the
+	// way to it, it is not expanded
+	Loop bool
+
+	// Diff marks an entry added, removed or renamed since an earlier tree, see DiffItem
+	Diff string
+	// RenamedFrom is the earlier name of a renamed entry
+	RenamedFrom string
+	// Unchanged counts the children left out of a diff as they did not change
+	Unchanged int
+}
+
+// jsonItem is the JSON form of Item: the index is only present when the
+// name had a numeric prefix, and zero fields are left out
+type jsonItem struct {
+	Name                     string `json:"name"`
+	Index                    *int   `json:"index,omitempty"`
+	Dir                      bool   `json:"dir,omitempty"`
+	SubsequentRepeated       int    `json:"subsequent_repeated,omitempty"`
+	CollapsedPatternChildren int    `json:"collapsed_pattern_children,omitempty"`
+	CollapsedLeafChildren    int    `json:"collapsed_leaf_children,omitempty"`
+	Elided                   int    `json:"elided,omitempty"`
+	Star                     bool   `json:"star,omitempty"`
+	Size                     int64  `json:"size,omitempty"`
+	Lines                    int    `json:"lines,omitempty"`
+	Git                      string `json:"git,omitempty"`
+	Changes                  int    `json:"changes,omitempty"`
+	Target                   string `json:"target,omitempty"`
+	Loop                     bool   `json:"loop,omitempty"`
+	Diff                     string `json:"diff,omitempty"`
+	RenamedFrom              string `json:"renamed_from,omitempty"`
+	Unchanged                int    `json:"unchanged,omitempty"`
+	Children                 []Item `json:"children,omitempty"`
+}
+
+func (c Item) MarshalJSON() ([]byte, error) {
+	item := jsonItem{
+		Name:                     c.Name,
+		Dir:                      c.Dir,
+		SubsequentRepeated:       c.SubsequentRepeated,
+		CollapsedPatternChildren: c.CollapsedPatternChildren,
+		CollapsedLeafChildren:    c.CollapsedLeafChildren,
+		Elided:                   c.Elided,
+		Star:                     c.Star,
+		Size:                     c.Size,
+		Lines:                    c.Lines,
+		Git:                      c.Git,
+		Changes:                  c.Changes,
+		Target:                   c.Target,
+		Loop:                     c.Loop,
+		Diff:                     c.Diff,
+		RenamedFrom:              c.RenamedFrom,
+		Unchanged:                c.Unchanged,
+		Children:                 c.Children,
+	}
+	if !c.MissingIndex {
+		index := c.Index
+		item.Index = &index
+	}
+	return json.Marshal(item)
 }
 
+func (c *Item) UnmarshalJSON(data []byte) error {
+	var item jsonItem
+	if err := json.Unmarshal(data, &item); err != nil {
+		return err
+	}
+	*c = Item{
+		Name:                     item.Name,
+		MissingIndex:             item.Index == nil,
+		Dir:                      item.Dir,
+		SubsequentRepeated:       item.SubsequentRepeated,
+		CollapsedPatternChildren: item.CollapsedPatternChildren,
+		CollapsedLeafChildren:    item.CollapsedLeafChildren,
+		Elided:                   item.Elided,
+		Star:                     item.Star,
+		Size:                     item.Size,
+		Lines:                    item.Lines,
+		Git:                      item.Git,
+		Changes:                  item.Changes,
+		Target:                   item.Target,
+		Loop:                     item.Loop,
+		Diff:                     item.Diff,
+		RenamedFrom:              item.RenamedFrom,
+		Unchanged:                item.Unchanged,
+		Children:                 item.Children,
+	}
+	if item.Index != nil {
+		c.Index = *item.Index
+	}
+	return nil
+}
+
+// Parse parses a tree rendered by PrintItem, or the JSON form of an Item as
+// returned by the tree tool with format json
 func Parse(tree string) (Item, error) {
 	if tree == "" {
 		return Item{}, fmt.Errorf("empty tree string")
 	}
+	if strings.HasPrefix(strings.TrimSpace(tree), "{") {
+		var item Item
+		if err := json.Unmarshal([]byte(tree), &item); err != nil {
+			return Item{}, fmt.Errorf("invalid tree json: %w", err)
+		}
+		return item, nil
+	}
 
 	lines := strings.Split(tree, "\n")
 	if len(lines) == 0 {
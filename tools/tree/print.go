@@ -42,6 +42,30 @@ func PrintItem(item Item) string {
 	return result.String()
 }
 
+// addDiff marks an entry that changed since an earlier tree
+func addDiff(name string, item Item) string {
+	if item.Diff == "" {
+		return name
+	}
+	name = item.Diff + " " + name
+	if item.RenamedFrom != "" {
+		name += " (renamed from " + item.RenamedFrom + ")"
+	}
+	return name
+}
+
+// addTarget shows where a symlink points to, and whether it loops
+func addTarget(name string, item Item) string {
+	if item.Target == "" {
+		return name
+	}
+	name = name + " -> " + item.Target
+	if item.Loop {
+		name += " (loop)"
+	}
+	return name
+}
+
 func addCollapsedInfo(name string, item Item) string {
 	totalCollapsed := item.CollapsedPatternChildren + item.CollapsedLeafChildren
 	if totalCollapsed > 0 {
@@ -51,6 +75,12 @@ func addCollapsedInfo(name string, item Item) string {
 		}
 		name = fmt.Sprintf("%s (...%d %s)", name, totalCollapsed, word)
 	}
+	if item.Elided > 0 {
+		name = fmt.Sprintf("%s (...%d elided)", name, item.Elided)
+	}
+	if item.Unchanged > 0 {
+		name = fmt.Sprintf("%s (...%d unchanged)", name, item.Unchanged)
+	}
 	return name
 }
 
@@ -68,7 +98,10 @@ func printItem(item Item, prefix string, isLast bool, result *strings.Builder) {
 		name = fmt.Sprintf("%s (%d times)", name, item.SubsequentRepeated+1)
 	}
 
+	name = addDiff(name, item)
+	name = addTarget(name, item)
 	name = addCollapsedInfo(name, item)
+	name = addAnnotations(name, item)
 
 	// Determine the connector symbol
 	var connector string
@@ -118,7 +151,10 @@ func printItemWithConnector(item Item, prefix string, isLast bool, result *strin
 		name = fmt.Sprintf("%s (%d times)", name, item.SubsequentRepeated+1)
 	}
 
+	name = addDiff(name, item)
+	name = addTarget(name, item)
 	name = addCollapsedInfo(name, item)
+	name = addAnnotations(name, item)
 
 	// Always use connectors
 	var connector string
@@ -175,7 +211,10 @@ func PrintItemCompact(item Item) string {
 		name = fmt.Sprintf("%s (%d times)", name, item.SubsequentRepeated+1)
 	}
 
+	name = addDiff(name, item)
+	name = addTarget(name, item)
 	name = addCollapsedInfo(name, item)
+	name = addAnnotations(name, item)
 
 	if len(item.Children) > 0 {
 		var childNames []string
@@ -3,11 +3,14 @@ package tree
 import (
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+
+	"github.com/xhd2015/llm-tools/tools/walk"
 )
 
 type TreeCollapseOptions struct {
@@ -35,18 +38,60 @@ type TreeOptions struct {
 	// CollapseLeaf collapses duplicate leaf items by adding to parent's CollapsedPatternChildren
 	CollapseLeaf  bool
 	CollapsedDirs []string
-	// Depth is the maximum depth of the directory tree to traverse (default 8)
+	// Depth is the maximum depth of the directory tree to traverse (default DEFAULT_MAX_DEPTH)
 	Depth int
-	// MaxEntriesPerDir is the maximum number of entries to display in each directory (default 48)
+	// MaxEntriesPerDir is the maximum number of entries to display in each directory (default DEFAULT_MAX_ENTRIES_PER_DIR)
 	MaxEntriesPerDir int
-	// ExpandDirs are directories that should be expanded with additional depth
+	// ExpandDirs are slash separated paths relative to the tree root that are
+	// traversed regardless of Depth and get Depth more levels of their own
 	ExpandDirs []string
+	// NoGitignore shows .git and the entries ignored by .gitignore or
+	// .llm-toolsignore, which are skipped by default
+	NoGitignore bool
+	// FollowSymlinks expands symlinked directories, except those leading back
+	// to a directory on the way to them which are marked as loops
+	FollowSymlinks bool
+	// ShowSizes annotates files with their size and directories with the size of the files under them
+	ShowSizes bool
+	// ShowLines annotates text files with their line count and directories with the sum
+	ShowLines bool
+	// GitStatus annotates files with their git status and directories with the number of changed files
+	GitStatus bool
+
+	// filter is created from NoGitignore when the traversal starts
+	filter *walk.Filter
+	// git is loaded from GitStatus when the traversal starts
+	git *gitStatus
+	// ancestors are the real paths of the directories on the way to the one
+	// being read, tracked with FollowSymlinks
+	ancestors []string
+}
+
+// readDir reads the entries of dir, applying the gitignore filter if enabled
+func readDir(dir string, opts TreeOptions) ([]os.DirEntry, error) {
+	if opts.filter != nil {
+		return opts.filter.ReadDir(dir)
+	}
+	return walk.ReadDir(dir)
+}
+
+// withFilter prepares the gitignore filter for a traversal rooted at dir
+func withFilter(dir string, opts TreeOptions) TreeOptions {
+	if !opts.NoGitignore && opts.filter == nil {
+		opts.filter = walk.NewFilter(dir, walk.Options{})
+	}
+	return opts
 }
 
 func Tree(dir string, opts TreeOptions) (string, error) {
 	return traverseTree(dir, opts)
 }
 
+// TreeItem builds the tree of dir as Items, the structure Tree renders
+func TreeItem(dir string, opts TreeOptions) (Item, error) {
+	return traverseTreeItem(dir, opts)
+}
+
 func TreeCollapsed(dir string, opts TreeCollapseOptions) (string, error) {
 	return traverseTree(dir, TreeOptions{
 		IncludePatterns:  opts.IncludePatterns,
@@ -185,28 +230,9 @@ func sortItems(items []Item) {
 
 // buildTreeAsItem recursively builds a tree structure as Items
 func buildTreeAsItem(dir string, opts TreeOptions) (Item, error) {
-	// Compile regex patterns if provided
-	var includePatterns []*regexp.Regexp
-	var excludePatterns []*regexp.Regexp
-
-	for _, pattern := range opts.IncludePatterns {
-		if pattern != "" {
-			regex, err := regexp.Compile(pattern)
-			if err != nil {
-				return Item{}, fmt.Errorf("invalid include pattern '%s': %v", pattern, err)
-			}
-			includePatterns = append(includePatterns, regex)
-		}
-	}
-
-	for _, pattern := range opts.ExcludePatterns {
-		if pattern != "" {
-			regex, err := regexp.Compile(pattern)
-			if err != nil {
-				return Item{}, fmt.Errorf("invalid exclude pattern '%s': %v", pattern, err)
-			}
-			excludePatterns = append(excludePatterns, regex)
-		}
+	includePatterns, excludePatterns, err := compilePatterns(opts)
+	if err != nil {
+		return Item{}, err
 	}
 
 	// Set default values if not specified
@@ -217,53 +243,90 @@ func buildTreeAsItem(dir string, opts TreeOptions) (Item, error) {
 		opts.MaxEntriesPerDir = DEFAULT_MAX_ENTRIES_PER_DIR
 	}
 
-	return buildTreeAsItemRecursive(dir, opts, includePatterns, excludePatterns, 0)
-}
+	opts.ExpandDirs = cleanExpandDirs(opts.ExpandDirs)
+	if opts.GitStatus && opts.git == nil {
+		absDir, err := filepath.Abs(dir)
+		if err != nil {
+			return Item{}, err
+		}
+		dir = absDir
+		opts.git = loadGitStatus(dir)
+	}
+
+	if opts.FollowSymlinks {
+		realDir, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			return Item{}, err
+		}
+		opts.ancestors = []string{realDir}
+	}
 
-// buildTreeAsItemRecursive recursively builds tree structure as Items
-func buildTreeAsItemRecursive(dir string, opts TreeOptions, includePatterns []*regexp.Regexp, excludePatterns []*regexp.Regexp, currentDepth int) (Item, error) {
-	entries, err := os.ReadDir(dir)
+	item, err := buildTreeAsItemRecursive(dir, "", withFilter(dir, opts), includePatterns, excludePatterns)
 	if err != nil {
 		return Item{}, err
 	}
+	if opts.git != nil {
+		item.Changes = opts.git.changesUnder(dir)
+	}
+	return item, nil
+}
 
-	// Filter entries based on options
-	var filteredEntries []os.DirEntry
-	for _, entry := range entries {
-		// Handle file filtering - use DirectoriesOnly if set, otherwise use IncludeFiles
-		if opts.DirectoriesOnly && !entry.IsDir() {
+// cleanExpandDirs turns expand dirs into clean slash separated paths relative
+// to the tree root
+func cleanExpandDirs(expandDirs []string) []string {
+	var cleaned []string
+	for _, expandDir := range expandDirs {
+		expandDir = path.Clean(filepath.ToSlash(expandDir))
+		expandDir = strings.TrimPrefix(expandDir, "./")
+		expandDir = strings.TrimSuffix(expandDir, "/")
+		if expandDir == "" || expandDir == "." {
 			continue
 		}
+		cleaned = append(cleaned, expandDir)
+	}
+	return cleaned
+}
 
-		// Apply include patterns first (whitelist)
-		if len(includePatterns) > 0 {
-			matched := false
-			for _, pattern := range includePatterns {
-				if pattern.MatchString(entry.Name()) {
-					matched = true
-					break
-				}
-			}
-			if !matched {
-				continue
-			}
+// withinDepth reports whether the directory at rel, slash separated and
+// relative to the tree root, is expanded: it is less than opts.Depth levels
+// deep, lies on the way to one of opts.ExpandDirs, or is less than opts.Depth
+// levels below one of them
+func withinDepth(rel string, opts TreeOptions) bool {
+	if opts.Depth <= 0 {
+		return true
+	}
+	depth := strings.Count(rel, "/") + 1
+	if depth < opts.Depth {
+		return true
+	}
+	for _, expandDir := range opts.ExpandDirs {
+		if strings.HasPrefix(expandDir, rel+"/") {
+			return true
 		}
-
-		// Apply exclude patterns (blacklist)
-		if len(excludePatterns) > 0 {
-			excluded := false
-			for _, pattern := range excludePatterns {
-				if pattern.MatchString(entry.Name()) {
-					excluded = true
-					break
-				}
-			}
-			if excluded {
-				continue
+		if rel == expandDir || strings.HasPrefix(rel, expandDir+"/") {
+			if depth-(strings.Count(expandDir, "/")+1) < opts.Depth {
+				return true
 			}
 		}
+	}
+	return false
+}
 
-		filteredEntries = append(filteredEntries, entry)
+// buildTreeAsItemRecursive recursively builds tree structure as Items, rel is
+// the slash separated path of dir relative to the tree root
+func buildTreeAsItemRecursive(dir string, rel string, opts TreeOptions, includePatterns []*regexp.Regexp, excludePatterns []*regexp.Regexp) (Item, error) {
+	entries, err := readDir(dir, opts)
+	if err != nil {
+		return Item{}, err
+	}
+
+	// Filter entries based on options, files hidden by DirectoriesOnly are
+	// kept for now as they still count in the annotations of dir
+	var filteredEntries []os.DirEntry
+	for _, entry := range entries {
+		if matchPatterns(entry.Name(), includePatterns, excludePatterns) {
+			filteredEntries = append(filteredEntries, entry)
+		}
 	}
 
 	// Sort entries: directories first, then files, both alphabetically
@@ -292,57 +355,123 @@ func buildTreeAsItemRecursive(dir string, opts TreeOptions, includePatterns []*r
 		index, hasIndex, name := parseNameWithIndexLocal(entryName)
 
 		isDir := entry.IsDir()
+		subDir := filepath.Join(dir, entryName)
+		var target string
+		if entry.Type()&os.ModeSymlink != 0 {
+			target, _ = os.Readlink(subDir)
+			// a symlink to a directory is listed as one
+			if info, err := os.Stat(subDir); err == nil && info.IsDir() {
+				isDir = true
+			}
+		}
 		child := Item{
 			Name:         name,
 			Index:        index,
 			MissingIndex: !hasIndex,
 			Dir:          isDir,
+			Target:       target,
 		}
 
 		// If it's a directory, recursively process it
 		if isDir {
-			subDir := filepath.Join(dir, entryName)
-
-			// Check depth limit (but allow expansion for directories in ExpandDirs)
-			shouldExpand := false
-			if opts.Depth > 0 {
-				// Check if this directory should be expanded
-				for _, expandDir := range opts.ExpandDirs {
-					if strings.Contains(subDir, expandDir) || strings.Contains(expandDir, subDir) {
-						shouldExpand = true
-						break
-					}
-				}
-
-				if currentDepth+1 >= opts.Depth && !shouldExpand {
-					// Don't recurse further, but mark as directory
-					child.Dir = true
-				} else {
-					subItem, err := buildTreeAsItemRecursive(subDir, opts, includePatterns, excludePatterns, currentDepth+1)
-					if err != nil {
-						return Item{}, err
-					}
-					// Use the children from the recursive call
-					child.Children = subItem.Children
-				}
-			} else {
-				// No depth limit, always recurse
-				subItem, err := buildTreeAsItemRecursive(subDir, opts, includePatterns, excludePatterns, currentDepth+1)
+			childRel := entryName
+			if rel != "" {
+				childRel = rel + "/" + entryName
+			}
+			childOpts := opts
+			expand := target == "" || opts.FollowSymlinks
+			if expand && opts.FollowSymlinks {
+				childOpts.ancestors, child.Loop = followDir(opts.ancestors, entryName, subDir, target != "")
+				expand = !child.Loop
+			}
+			if expand && withinDepth(childRel, opts) {
+				subItem, err := buildTreeAsItemRecursive(subDir, childRel, childOpts, includePatterns, excludePatterns)
 				if err != nil {
 					return Item{}, err
 				}
 				// Use the children from the recursive call
 				child.Children = subItem.Children
+				addTotals(&child, subItem)
+			} else if expand && (opts.ShowSizes || opts.ShowLines) {
+				addTotals(&child, measureDir(subDir, opts, includePatterns, excludePatterns))
+			}
+			if opts.git != nil {
+				child.Changes = opts.git.changesUnder(subDir)
 			}
+		} else {
+			annotateFile(&child, filepath.Join(dir, entryName), entry, opts)
 		}
+		addTotals(&rootItem, child)
 
+		if opts.DirectoriesOnly && !isDir {
+			continue
+		}
 		children = append(children, child)
 	}
 
+	// files deleted from the working tree are listed with their git status
+	if opts.git != nil && !opts.DirectoriesOnly {
+		for _, entryName := range opts.git.deleted[dir] {
+			if !matchPatterns(entryName, includePatterns, excludePatterns) {
+				continue
+			}
+			index, hasIndex, name := parseNameWithIndexLocal(entryName)
+			children = append(children, Item{
+				Name:         name,
+				Index:        index,
+				MissingIndex: !hasIndex,
+				Git:          GIT_DELETED,
+			})
+		}
+	}
+
 	rootItem.Children = children
 	return rootItem, nil
 }
 
+// followDir returns the real paths of the directories on the way to the
+// entry name of the last one, and whether it is a symlink leading back to one
+// of them
+func followDir(ancestors []string, name string, path string, symlink bool) ([]string, bool) {
+	realPath := filepath.Join(ancestors[len(ancestors)-1], name)
+	if symlink {
+		var err error
+		realPath, err = filepath.EvalSymlinks(path)
+		if err != nil {
+			return ancestors, true
+		}
+		for _, ancestor := range ancestors {
+			if realPath == ancestor {
+				return ancestors, true
+			}
+		}
+	}
+	return append(ancestors[:len(ancestors):len(ancestors)], realPath), false
+}
+
+// matchPatterns reports whether a file or directory name passes the include
+// patterns (whitelist) and the exclude patterns (blacklist)
+func matchPatterns(name string, includePatterns []*regexp.Regexp, excludePatterns []*regexp.Regexp) bool {
+	if len(includePatterns) > 0 {
+		matched := false
+		for _, pattern := range includePatterns {
+			if pattern.MatchString(name) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	for _, pattern := range excludePatterns {
+		if pattern.MatchString(name) {
+			return false
+		}
+	}
+	return true
+}
+
 // parseNameWithIndexLocal parses a name that may have a numeric prefix
 // e.g., "1_CallA" -> index=1, name="CallA"
 // e.g., "CallA" -> index=0, name="CallA"
@@ -397,7 +526,7 @@ func doTree(dir string, opts TreeOptions) (string, error) {
 	seenPatterns := make(map[string]bool)
 
 	// Build the tree structure
-	err := buildTreeWithOptions(dir, "", true, &result, opts, includePatterns, excludePatterns, seenPatterns)
+	err := buildTreeWithOptions(dir, "", true, &result, withFilter(dir, opts), includePatterns, excludePatterns, seenPatterns)
 	if err != nil {
 		return "", err
 	}
@@ -406,7 +535,7 @@ func doTree(dir string, opts TreeOptions) (string, error) {
 }
 
 func buildTreeWithOptions(dir string, prefix string, isLast bool, result *strings.Builder, opts TreeOptions, includePatterns []*regexp.Regexp, excludePatterns []*regexp.Regexp, seenPatterns map[string]bool) error {
-	entries, err := os.ReadDir(dir)
+	entries, err := readDir(dir, opts)
 	if err != nil {
 		return err
 	}
@@ -2,7 +2,7 @@ package tree
 
 import (
 	"fmt"
-	"path/filepath"
+	"os"
 	"strings"
 
 	"github.com/xhd2015/less-gen/flags"
@@ -16,22 +16,44 @@ Usage: llm-tools tree <dir> [OPTIONS]
 Options:
   --exclude <pattern>     exclude files/directories matching the pattern
   --include <pattern>     include files/directories matching the pattern
-  --dir-only              only show directories (default)
-  --include-files         include files in the tree display
-  --depth <number>        maximum depth to traverse (default: 8)
-  --max-entries <number>  maximum entries per directory (default: 48)
+  --dir-only              only show directories
+  --include-files         include files in the tree display (default)
+  --depth <number>        maximum depth to traverse (default: 10)
+  --max-entries <number>  maximum entries per directory (default: 40)
   --expand-dirs <path>    directories to expand beyond depth limit
-  --collapse-pattern      collapse duplicate patterns (always enabled)
-  --collapse-repeated     collapse repeated entries (always enabled)
-  --collapse              collapse both patterns and repeated entries (always enabled)
+  --collapse-pattern      collapse duplicate patterns
+  --collapse-repeated     collapse repeated entries
+  --collapse-leaf         collapse duplicate leaf entries
+  --collapse              collapse patterns, repeated and leaf entries
+  --collapse-dir <path>   directories to collapse and expand
   --find-path <path>      find the path in the tree
+  --no-gitignore          show .git and entries ignored by .gitignore or .llm-toolsignore
+  --follow-symlinks       expand symlinked directories, stopping at loops
+  --sizes                 show file sizes and total directory sizes
+  --lines                 show line counts of text files and directory totals
+  --git-status            show git status markers (M, A, D, ?) and changed file counts
+  --max-tokens <number>   shrink the tree to this many tokens, eliding what does not fit
+  --max-chars <number>    shrink the tree to this many characters
+  --format <format>       text (default) or json, the collapsed tree as nested items
+  --diff <snapshot>       compare to a tree saved with --format json, marking entries +, - or ~
+  --diff-dir <dir>        compare to another directory
+  --diff-rev <rev>        compare to the files git tracks at a revision
 
 Examples:
   llm-tools tree                              current directory
   llm-tools tree --exclude .git               exclude .git directory
   llm-tools tree --depth 3 --max-entries 10   limit depth and entries
-  llm-tools tree --include-files              show files as well as directories
-  llm-tools tree --expand-dirs src,tests      expand specific directories
+  llm-tools tree --dir-only                   show directories only
+  llm-tools tree --depth 1 --expand-dirs src/api,tests
+                                              expand specific directories
+  llm-tools tree --find-path handler/user.go  where the path appears
+  llm-tools tree --dir-only --lines --git-status
+                                              where the code is and what changed
+  llm-tools tree --format json                collapsed tree as nested items
+  llm-tools tree --max-tokens 2000            overview of a large repository
+  llm-tools tree --format json > tree.json    save a snapshot, then later
+  llm-tools tree --diff tree.json             what changed since
+  llm-tools tree --diff-rev HEAD --collapse   what changed since the last commit
 `
 
 func HandleCli(args []string) error {
@@ -45,11 +67,23 @@ func HandleCli(args []string) error {
 	var exclude []string
 	var include []string
 	var dirOnly bool
+	var includeFiles bool
 	var depth int
 	var maxEntries int
 	var expandDirs []string
 
 	var findPath string
+	var maxTokens int
+	var maxChars int
+	var noGitignore bool
+	var followSymlinks bool
+	var sizes bool
+	var lines bool
+	var gitStatus bool
+	var format string
+	var diffSnapshot string
+	var diffDir string
+	var diffRev string
 	args, err := flags.Bool("--collapse-pattern", &collapsePattern).
 		Bool("--collapse-repeated", &collapseRepeated).
 		Bool("--collapse-leaf", &collapseLeaf).
@@ -58,10 +92,22 @@ func HandleCli(args []string) error {
 		StringSlice("--exclude", &exclude).
 		StringSlice("--include", &include).
 		Bool("--dir-only", &dirOnly).
+		Bool("--include-files", &includeFiles).
 		Int("--depth", &depth).
 		Int("--max-entries", &maxEntries).
 		StringSlice("--expand-dirs", &expandDirs).
 		String("--find-path", &findPath).
+		Int("--max-tokens", &maxTokens).
+		Int("--max-chars", &maxChars).
+		Bool("--no-gitignore", &noGitignore).
+		Bool("--follow-symlinks", &followSymlinks).
+		Bool("--sizes", &sizes).
+		Bool("--lines", &lines).
+		Bool("--git-status", &gitStatus).
+		String("--format", &format).
+		String("--diff", &diffSnapshot).
+		String("--diff-dir", &diffDir).
+		String("--diff-rev", &diffRev).
 		Help("-h,--help", help).
 		Parse(args)
 	if err != nil {
@@ -79,6 +125,9 @@ func HandleCli(args []string) error {
 	} else {
 		dir = "."
 	}
+	if dirOnly && includeFiles {
+		return fmt.Errorf("--dir-only and --include-files are exclusive")
+	}
 
 	if collapse {
 		collapseRepeated = true
@@ -86,53 +135,57 @@ func HandleCli(args []string) error {
 		collapseLeaf = true
 	}
 
-	// Set defaults
-	if depth == 0 {
-		depth = 8
-	}
-	if maxEntries == 0 {
-		maxEntries = 48
+	gitignore := !noGitignore
+
+	workspaceRoot, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get current working directory: %w", err)
 	}
 
-	item, err := traverseTreeItem(dir, TreeOptions{
-		IncludePatterns:  include,
-		ExcludePatterns:  exclude,
-		DirectoriesOnly:  dirOnly,
-		CollapseRepeated: collapseRepeated,
-		CollapsePattern:  collapsePattern,
-		CollapseLeaf:     collapseLeaf,
-		CollapsedDirs:    collapseDir,
-		Depth:            depth,
-		MaxEntriesPerDir: maxEntries,
-		ExpandDirs:       append(expandDirs, collapseDir...),
+	response, err := ExecuteTree(TreeRequest{
+		WorkspaceRoot:         workspaceRoot,
+		RelativeWorkspacePath: dir,
+		IncludePatterns:       include,
+		ExcludePatterns:       exclude,
+		IncludeFiles:          !dirOnly,
+		Depth:                 depth,
+		MaxEntriesPerDir:      maxEntries,
+		ExpandDirs:            splitList(expandDirs),
+		CollapseRepeated:      collapseRepeated,
+		CollapsePattern:       collapsePattern,
+		CollapseLeaf:          collapseLeaf,
+		CollapsedDirs:         splitList(collapseDir),
+		FindPath:              findPath,
+		Gitignore:             &gitignore,
+		FollowSymlinks:        followSymlinks,
+		ShowSizes:             sizes,
+		ShowLines:             lines,
+		GitStatus:             gitStatus,
+		DiffSnapshot:          diffSnapshot,
+		DiffDir:               diffDir,
+		DiffRevision:          diffRev,
+		Format:                format,
+		MaxTokens:             maxTokens,
+		MaxChars:              maxChars,
 	})
 	if err != nil {
 		return err
 	}
+	fmt.Println(strings.TrimSuffix(response.ToLLMOutput(), "\n"))
+	return nil
+}
 
-	if findPath != "" {
-		splittedPath, err := splitPath(findPath)
-		if err != nil {
-			return err
-		}
-		if len(splittedPath) == 0 {
-			return fmt.Errorf("path is empty")
-		}
-
-		paths := findPathInTree([]string{}, item, splittedPath)
-		if len(paths) == 0 {
-			return fmt.Errorf("path not found: %s", findPath)
-		}
-
-		for _, path := range paths {
-			fmt.Println(filepath.Join(path...))
+// splitList splits comma separated flag values, as in --expand-dirs src,tests
+func splitList(values []string) []string {
+	var list []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
 		}
-		return nil
 	}
-
-	output := PrintItem(item)
-	fmt.Println(output)
-	return nil
+	return list
 }
 
 func splitPath(path string) ([]string, error) {
@@ -148,30 +201,3 @@ func splitPath(path string) ([]string, error) {
 	}
 	return parts[:j], nil
 }
-
-type TreeToolRequest struct {
-	WorkspaceRoot         string `json:"workspace_root"`
-	RelativeWorkspacePath string `json:"relative_workspace_path"`
-	Explanation           string `json:"explanation"`
-}
-
-type TreeToolResponse struct {
-	Tree string `json:"tree"`
-}
-
-func (c TreeToolResponse) ToLLMOutput() string {
-	//
-
-	return ""
-}
-
-func TreeTool(req TreeToolRequest) (TreeToolResponse, error) {
-	// tree, err := TreeCollapsed(req.WorkspaceRoot, req.RelativeWorkspacePath)
-	// if err != nil {
-	// 	return TreeToolResponse{}, err
-	// }
-	// return TreeToolResponse{Tree: tree}, nil
-
-	// TODO
-	return TreeToolResponse{}, nil
-}
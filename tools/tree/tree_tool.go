@@ -4,15 +4,25 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/xhd2015/llm-tools/jsonschema"
 	"github.com/xhd2015/llm-tools/tools/defs"
 	"github.com/xhd2015/llm-tools/tools/dirs"
+	"github.com/xhd2015/llm-tools/tools/tokenizer"
 )
 
 const DEFAULT_MAX_DEPTH = 10
 const DEFAULT_MAX_ENTRIES_PER_DIR = 40
 
+// Output formats
+const (
+	FORMAT_TEXT = "text"
+	FORMAT_JSON = "json"
+)
+
 // TreeRequest represents the input parameters for the tree tool
 type TreeRequest struct {
 	WorkspaceRoot         string   `json:"workspace_root"`
@@ -23,12 +33,73 @@ type TreeRequest struct {
 	Depth                 int      `json:"depth,omitempty"`
 	MaxEntriesPerDir      int      `json:"max_entries_per_dir,omitempty"`
 	ExpandDirs            []string `json:"expand_dirs,omitempty"`
-	Explanation           string   `json:"explanation"`
+	// CollapseRepeated, CollapsePattern and CollapseLeaf enable the collapsing of TreeOptions
+	CollapseRepeated bool `json:"collapse_repeated,omitempty"`
+	CollapsePattern  bool `json:"collapse_pattern,omitempty"`
+	CollapseLeaf     bool `json:"collapse_leaf,omitempty"`
+	// CollapsedDirs are collapsed and expanded like ExpandDirs
+	CollapsedDirs []string `json:"collapsed_dirs,omitempty"`
+	// ShowSizes, ShowLines and GitStatus annotate the tree, see TreeOptions
+	ShowSizes bool `json:"show_sizes,omitempty"`
+	ShowLines bool `json:"show_lines,omitempty"`
+	GitStatus bool `json:"git_status,omitempty"`
+	// FindPath returns the paths in the tree ending with this slash separated path instead of the tree
+	FindPath string `json:"find_path,omitempty"`
+	// Gitignore hides .git and entries ignored by .gitignore or .llm-toolsignore, true when unset
+	Gitignore *bool `json:"gitignore,omitempty"`
+	// FollowSymlinks expands symlinked directories, loops are detected
+	FollowSymlinks bool `json:"follow_symlinks,omitempty"`
+	// DiffSnapshot, DiffDir and DiffRevision turn the tree into a diff against
+	// a saved tree Parse can read, another directory or a git revision
+	DiffSnapshot string `json:"diff_snapshot,omitempty"`
+	DiffDir      string `json:"diff_dir,omitempty"`
+	DiffRevision string `json:"diff_revision,omitempty"`
+	// Format is text (the default) for a rendered tree, or json for the Item hierarchy
+	Format string `json:"format,omitempty"`
+	// MaxTokens and MaxChars bound the output, which FitItem shrinks to fit, 0 means no limit
+	MaxTokens   int    `json:"max_tokens,omitempty"`
+	MaxChars    int    `json:"max_chars,omitempty"`
+	Explanation string `json:"explanation"`
 }
 
 // TreeResponse represents the output of the tree tool
 type TreeResponse struct {
-	Tree string `json:"tree"`
+	// Tree is the rendered tree, in text format
+	Tree string `json:"tree,omitempty"`
+	// Root is the collapsed tree, in json format
+	Root *Item `json:"root,omitempty"`
+	// Tokens is the token count of Tree, or of Root encoded as JSON
+	Tokens int `json:"tokens"`
+	// Truncated is set when the tree was shrunk or cut to fit max_tokens or max_chars
+	Truncated bool `json:"truncated,omitempty"`
+	// ElidedDirs are the directories whose children were elided to fit the
+	// budget, relative to the tree root, to be passed as expand_dirs
+	ElidedDirs []string `json:"elided_dirs,omitempty"`
+	// Diff counts the changed entries when diffing
+	Diff *DiffStats `json:"diff,omitempty"`
+	// OmittedItems counts the items dropped from Root when eliding was not enough
+	OmittedItems int `json:"omitted_items,omitempty"`
+	// Paths are the matches of find_path, slash separated and starting with the root name
+	Paths []string `json:"paths,omitempty"`
+}
+
+// ToLLMOutput renders the response as plain text: the found paths one per
+// line, the tree, or the JSON encoding of the root
+func (c *TreeResponse) ToLLMOutput() string {
+	if c.Paths != nil {
+		return strings.Join(c.Paths, "\n")
+	}
+	if c.Root != nil {
+		data, err := json.MarshalIndent(c.Root, "", "  ")
+		if err != nil {
+			return err.Error()
+		}
+		return string(data)
+	}
+	if c.Diff != nil {
+		return c.Tree + c.Diff.String() + "\n"
+	}
+	return c.Tree
 }
 
 // GetToolDefinition returns the JSON schema definition for the tree tool
@@ -77,11 +148,67 @@ func GetToolDefinition() defs.ToolDefinition {
 				},
 				"expand_dirs": {
 					Type:        jsonschema.ParamTypeArray,
-					Description: "List of directory paths to expand in the tree display.",
+					Description: "Directories, relative to relative_workspace_path, to expand past depth: the path to each is followed and it gets depth more levels of its own.",
 					Items: &jsonschema.JsonSchema{
 						Type: jsonschema.ParamTypeString,
 					},
 				},
+				"collapse_repeated": {
+					Type:        jsonschema.ParamTypeBoolean,
+					Description: "Collapse consecutive entries that differ only by their numeric prefix, such as 1_step and 2_step, into one entry with a count.",
+				},
+				"collapse_pattern": {
+					Type:        jsonschema.ParamTypeBoolean,
+					Description: "Show the structure of repeated directory patterns only at their first appearance.",
+				},
+				"collapse_leaf": {
+					Type:        jsonschema.ParamTypeBoolean,
+					Description: "Collapse leaf entries that repeat an earlier one into a count on their parent.",
+				},
+				"collapsed_dirs": {
+					Type:        jsonschema.ParamTypeArray,
+					Description: "Directories to collapse, which are also expanded like expand_dirs.",
+					Items: &jsonschema.JsonSchema{
+						Type: jsonschema.ParamTypeString,
+					},
+				},
+				"show_sizes": {
+					Type:        jsonschema.ParamTypeBoolean,
+					Description: "Annotate files with their size and directories with the total size of the files under them.",
+				},
+				"show_lines": {
+					Type:        jsonschema.ParamTypeBoolean,
+					Description: "Annotate text files with their line count and directories with the total, to see where the bulk of the code is.",
+				},
+				"git_status": {
+					Type:        jsonschema.ParamTypeBoolean,
+					Description: "Annotate files with their git status in the working tree (M modified, A added, D deleted, ? untracked) and directories with the number of changed files. Deleted files are listed too.",
+				},
+				"find_path": {
+					Type:        jsonschema.ParamTypeString,
+					Description: "Find where a path such as pkg/handler or a single name appears in the tree, returning the matching paths instead of the tree.",
+				},
+				"gitignore": {
+					Type:        jsonschema.ParamTypeBoolean,
+					Description: "Hide .git and entries ignored by .gitignore, .git/info/exclude, the global excludes file or .llm-toolsignore. Defaults to true, set to false to show node_modules, build output and the like.",
+				},
+				"follow_symlinks": {
+					Type:        jsonschema.ParamTypeBoolean,
+					Description: "Expand symlinked directories. Symlinks are always shown as name -> target; a symlinked directory leading back to a directory above it is marked (loop) and not expanded. Defaults to false.",
+				},
+				"format": {
+					Type:        jsonschema.ParamTypeString,
+					Description: "Output format: text for a rendered tree, json for the collapsed tree as nested items with name, dir, children and collapse counts.",
+					Default:     FORMAT_TEXT,
+				},
+				"max_tokens": {
+					Type:        jsonschema.ParamTypeNumber,
+					Description: "Maximum number of tokens of the output. The tree is shrunk to fit: repeated entries and patterns are collapsed, the deepest levels of the largest subtrees and the tails of long directories are elided, the entries of the root last, and marked with (...N elided); elided_dirs lists them for expand_dirs. max_entries_per_dir then defaults to no limit. Defaults to no limit.",
+				},
+				"max_chars": {
+					Type:        jsonschema.ParamTypeNumber,
+					Description: "Maximum number of characters of the output, shrinking the tree like max_tokens. Defaults to no limit.",
+				},
 				"explanation": {
 					Type:        jsonschema.ParamTypeString,
 					Description: defs.EXPLANATION,
@@ -94,6 +221,15 @@ func GetToolDefinition() defs.ToolDefinition {
 
 // ExecuteTree executes the tree tool with the given parameters
 func ExecuteTree(req TreeRequest) (*TreeResponse, error) {
+	format := req.Format
+	switch format {
+	case "":
+		format = FORMAT_TEXT
+	case FORMAT_TEXT, FORMAT_JSON:
+	default:
+		return nil, fmt.Errorf("unsupported format: %s, expected %s or %s", req.Format, FORMAT_TEXT, FORMAT_JSON)
+	}
+
 	targetDir, err := dirs.GetPath(req.WorkspaceRoot, req.RelativeWorkspacePath, "relative_workspace_path", true)
 	if err != nil {
 		return nil, err
@@ -117,11 +253,27 @@ func ExecuteTree(req TreeRequest) (*TreeResponse, error) {
 		depth = DEFAULT_MAX_DEPTH
 	}
 
+	budget := Budget{MaxTokens: req.MaxTokens, MaxChars: req.MaxChars}
+
+	// with a budget, long directories are elided with a marker instead
 	maxEntriesPerDir := req.MaxEntriesPerDir
-	if maxEntriesPerDir == 0 {
+	if maxEntriesPerDir == 0 && !budget.Limited() {
 		maxEntriesPerDir = DEFAULT_MAX_ENTRIES_PER_DIR
 	}
 
+	// expand dirs are relative to the tree root, absolute ones are made so
+	var expandDirs []string
+	for _, expandDir := range append(cloneList(req.ExpandDirs), req.CollapsedDirs...) {
+		if filepath.IsAbs(expandDir) {
+			rel, err := filepath.Rel(targetDir, expandDir)
+			if err != nil {
+				return nil, fmt.Errorf("invalid expand dir %s: %w", expandDir, err)
+			}
+			expandDir = rel
+		}
+		expandDirs = append(expandDirs, expandDir)
+	}
+
 	// Build tree options
 	opts := TreeOptions{
 		IncludePatterns:  req.IncludePatterns,
@@ -129,26 +281,270 @@ func ExecuteTree(req TreeRequest) (*TreeResponse, error) {
 		DirectoriesOnly:  !req.IncludeFiles,
 		Depth:            depth,
 		MaxEntriesPerDir: maxEntriesPerDir,
-		ExpandDirs:       req.ExpandDirs,
+		ExpandDirs:       expandDirs,
+		CollapseRepeated: req.CollapseRepeated,
+		CollapsePattern:  req.CollapsePattern,
+		CollapseLeaf:     req.CollapseLeaf,
+		CollapsedDirs:    req.CollapsedDirs,
+		NoGitignore:      req.Gitignore != nil && !*req.Gitignore,
+		FollowSymlinks:   req.FollowSymlinks,
+		ShowSizes:        req.ShowSizes,
+		ShowLines:        req.ShowLines,
+		GitStatus:        req.GitStatus,
+	}
+
+	diffing := 0
+	for _, base := range []string{req.DiffSnapshot, req.DiffDir, req.DiffRevision} {
+		if base != "" {
+			diffing++
+		}
+	}
+	if diffing > 1 {
+		return nil, fmt.Errorf("only one of diff_snapshot, diff_dir and diff_revision can be set")
 	}
 
-	// Generate tree
-	treeOutput, err := Tree(targetDir, opts)
+	if req.FindPath != "" {
+		if diffing > 0 {
+			return nil, fmt.Errorf("find_path cannot be combined with a diff")
+		}
+		root, err := TreeItem(targetDir, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate tree: %w", err)
+		}
+		return findPath(root, req.FindPath)
+	}
+
+	render := PrintItem
+	if format == FORMAT_JSON {
+		render = func(item Item) string {
+			data, err := json.Marshal(item)
+			if err != nil {
+				return err.Error()
+			}
+			return string(data)
+		}
+	}
+
+	// Generate tree, built without collapsing or entry limits: FitItem
+	// applies them to the tree or the diff, as far as the budget allows
+	buildOpts := opts
+	buildOpts.CollapseRepeated = false
+	buildOpts.CollapsePattern = false
+	buildOpts.CollapseLeaf = false
+	buildOpts.MaxEntriesPerDir = -1
+	root, err := TreeItem(targetDir, buildOpts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate tree: %w", err)
 	}
+	var diffStats *DiffStats
+	if diffing > 0 {
+		base, err := diffBase(req, targetDir, buildOpts)
+		if err != nil {
+			return nil, err
+		}
+		var stats DiffStats
+		root, stats = DiffItem(base, root)
+		diffStats = &stats
+	}
+	var fits bool
+	root, fits = FitItem(root, budget, FitOptions{
+		Render: render,
+		Collapse: CollapseOptions{
+			CollapseRepeated: opts.CollapseRepeated,
+			CollapsePattern:  opts.CollapsePattern,
+			CollapseLeaf:     opts.CollapseLeaf,
+			CollapsedDirs:    opts.CollapsedDirs,
+		},
+		MaxEntriesPerDir: maxEntriesPerDir,
+		ExpandDirs:       expandDirs,
+	})
+	elidedDirs := ElidedDirs(root)
+	shrunk := len(elidedDirs) > 0 || budget.Limited() && !fits
+
+	if format == FORMAT_JSON {
+		root, omitted := TruncateItem(root, budget)
+		data, err := json.Marshal(root)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal tree: %w", err)
+		}
+		return &TreeResponse{
+			Root:         &root,
+			Tokens:       tokenizer.Count(string(data)),
+			Truncated:    shrunk || omitted > 0,
+			ElidedDirs:   elidedDirs,
+			Diff:         diffStats,
+			OmittedItems: omitted,
+		}, nil
+	}
+
+	treeOutput, truncated := TruncateTree(PrintItem(root), budget)
+
+	return &TreeResponse{
+		Tree:       treeOutput,
+		Tokens:     tokenizer.Count(treeOutput),
+		Truncated:  shrunk || truncated,
+		ElidedDirs: elidedDirs,
+		Diff:       diffStats,
+	}, nil
+}
+
+// diffBase builds the tree a diff compares to: a saved snapshot, another
+// directory or the git revision of targetDir
+func diffBase(req TreeRequest, targetDir string, opts TreeOptions) (Item, error) {
+	switch {
+	case req.DiffSnapshot != "":
+		path, err := dirs.GetPath(req.WorkspaceRoot, req.DiffSnapshot, "diff_snapshot", false)
+		if err != nil {
+			return Item{}, err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return Item{}, fmt.Errorf("failed to read snapshot: %w", err)
+		}
+		item, err := Parse(string(data))
+		if err != nil {
+			return Item{}, fmt.Errorf("invalid snapshot %s: %w", req.DiffSnapshot, err)
+		}
+		// what a shaped snapshot hides would show as removed
+		if isShaped(item) {
+			return Item{}, fmt.Errorf("snapshot %s is collapsed or elided, save it with format json and without collapsing or max_tokens", req.DiffSnapshot)
+		}
+		return item, nil
+	case req.DiffDir != "":
+		path, err := dirs.GetPath(req.WorkspaceRoot, req.DiffDir, "diff_dir", false)
+		if err != nil {
+			return Item{}, err
+		}
+		item, err := TreeItem(path, opts)
+		if err != nil {
+			return Item{}, fmt.Errorf("failed to generate tree of %s: %w", req.DiffDir, err)
+		}
+		return item, nil
+	default:
+		return gitTreeItem(targetDir, req.DiffRevision, opts)
+	}
+}
+
+// isShaped reports whether entries of a tree were collapsed or elided
+func isShaped(item Item) bool {
+	if item.SubsequentRepeated > 0 || item.CollapsedPatternChildren > 0 || item.CollapsedLeafChildren > 0 || item.Elided > 0 || item.Unchanged > 0 {
+		return true
+	}
+	for _, child := range item.Children {
+		if isShaped(child) {
+			return true
+		}
+	}
+	return false
+}
 
-	// Clean up the path for display
-	displayPath := req.RelativeWorkspacePath
-	if displayPath == "" || displayPath == "." {
-		displayPath = "."
+// TruncateTree cuts a rendered tree to the budget, leaving room for a marker
+// that tells how many lines were dropped
+func TruncateTree(output string, budget Budget) (string, bool) {
+	if budget.Fits(output) {
+		return output, false
+	}
+	lines := strings.Split(output, "\n")
+	const markerTokens = 16
+	const markerChars = 80
+	n := len(lines)
+	if budget.MaxTokens > 0 {
+		n = 0
+		if tokens := budget.MaxTokens - markerTokens; tokens > 0 {
+			n = tokenizer.FitLines(lines, tokens)
+		}
 	}
+	if budget.MaxChars > 0 {
+		chars := 0
+		for i := 0; i < n; i++ {
+			chars += utf8.RuneCountInString(lines[i]) + 1
+			if chars > budget.MaxChars-markerChars {
+				n = i
+				break
+			}
+		}
+	}
+	marker := fmt.Sprintf("... (%d more lines truncated, reduce depth or add exclude_patterns)", len(lines)-n)
+	return strings.Join(append(lines[:n:n], marker), "\n"), true
+}
 
+// findPath looks for a slash separated path in the tree of root
+func findPath(root Item, findPath string) (*TreeResponse, error) {
+	splittedPath, err := splitPath(findPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(splittedPath) == 0 {
+		return nil, fmt.Errorf("find_path is empty")
+	}
+	found := findPathInTree([]string{}, root, splittedPath)
+	if len(found) == 0 {
+		return nil, fmt.Errorf("path not found: %s", findPath)
+	}
+	paths := make([]string, 0, len(found))
+	for _, path := range found {
+		paths = append(paths, strings.Join(path, "/"))
+	}
 	return &TreeResponse{
-		Tree: treeOutput,
+		Paths:  paths,
+		Tokens: tokenizer.CountLines(paths),
 	}, nil
 }
 
+// TruncateItem cuts a tree to the items whose JSON encoding fits the budget,
+// keeping them in the order they are rendered like TruncateTree keeps lines.
+// The root is always kept. It returns the number of items dropped.
+func TruncateItem(item Item, budget Budget) (Item, int) {
+	if !budget.Limited() {
+		return item, 0
+	}
+	fits := func(item Item) bool {
+		data, err := json.Marshal(item)
+		return err == nil && budget.Fits(string(data))
+	}
+	if fits(item) {
+		return item, 0
+	}
+	total := countItems(item)
+	// binary search the most items that fit, at least the root
+	lo, hi := 1, total-1
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if fits(firstItems(item, mid)) {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return firstItems(item, lo), total - lo
+}
+
+func countItems(item Item) int {
+	n := 1
+	for _, child := range item.Children {
+		n += countItems(child)
+	}
+	return n
+}
+
+// firstItems keeps the first n items of a tree in depth first order
+func firstItems(item Item, n int) Item {
+	var keep func(item Item) Item
+	keep = func(item Item) Item {
+		n--
+		children := item.Children
+		item.Children = nil
+		for _, child := range children {
+			if n <= 0 {
+				break
+			}
+			item.Children = append(item.Children, keep(child))
+		}
+		return item
+	}
+	return keep(item)
+}
+
 // ParseJSONRequest parses JSON input into TreeRequest
 func ParseJSONRequest(jsonInput string) (TreeRequest, error) {
 	var req TreeRequest
@@ -1,10 +1,15 @@
 package tree
 
 import (
+	"encoding/json"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+
+	"github.com/xhd2015/llm-tools/tools/internal/testfiles"
+	"github.com/xhd2015/llm-tools/tools/tokenizer"
 )
 
 func TestTreeToolDefNewFeatures(t *testing.T) {
@@ -201,3 +206,236 @@ func TestTreeToolDefDefaults(t *testing.T) {
 		t.Error("Expected testdir in output")
 	}
 }
+
+func TestTreeToolJSONFormat(t *testing.T) {
+	req := TreeRequest{
+		WorkspaceRoot:         ".",
+		RelativeWorkspacePath: "testdata/collapse_patterns",
+		IncludeFiles:          true,
+	}
+	textResponse, err := ExecuteTree(req)
+	if err != nil {
+		t.Fatalf("ExecuteTree(text) failed: %v", err)
+	}
+
+	req.Format = FORMAT_JSON
+	jsonResponse, err := ExecuteTree(req)
+	if err != nil {
+		t.Fatalf("ExecuteTree(json) failed: %v", err)
+	}
+	if jsonResponse.Root == nil || jsonResponse.Tree != "" {
+		t.Fatalf("json format should return root only, got %+v", jsonResponse)
+	}
+	if !jsonResponse.Root.Dir || jsonResponse.Root.Name != "collapse_patterns" {
+		t.Errorf("unexpected root: %+v", jsonResponse.Root)
+	}
+	if got := PrintItem(*jsonResponse.Root); got != textResponse.Tree {
+		t.Errorf("json root renders differently from text:\n%s\nwant:\n%s", got, textResponse.Tree)
+	}
+
+	// Parse round-trips the json form exactly
+	data, err := json.Marshal(jsonResponse.Root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := Parse(string(data))
+	if err != nil {
+		t.Fatalf("Parse(json) failed: %v", err)
+	}
+	if !reflect.DeepEqual(parsed, *jsonResponse.Root) {
+		t.Errorf("Parse(json) = %+v, want %+v", parsed, *jsonResponse.Root)
+	}
+
+	req.Format = "yaml"
+	if _, err := ExecuteTree(req); err == nil {
+		t.Errorf("expected an error for an unsupported format")
+	}
+}
+
+func TestTruncateItem(t *testing.T) {
+	root := Item{Name: "root", MissingIndex: true, Dir: true}
+	for i := 0; i < 50; i++ {
+		root.Children = append(root.Children, Item{
+			Name:     "dir",
+			Index:    i,
+			Dir:      true,
+			Children: []Item{{Name: "file.txt", MissingIndex: true}},
+		})
+	}
+
+	if item, omitted := TruncateItem(root, Budget{}); omitted != 0 || !reflect.DeepEqual(item, root) {
+		t.Errorf("no limit should keep the tree, omitted %d", omitted)
+	}
+
+	item, omitted := TruncateItem(root, Budget{MaxTokens: 100})
+	if omitted == 0 {
+		t.Fatalf("expected items to be omitted")
+	}
+	if kept := countItems(item); kept+omitted != countItems(root) {
+		t.Errorf("kept %d + omitted %d != %d", kept, omitted, countItems(root))
+	}
+	data, _ := json.Marshal(item)
+	if tokens := tokenizer.Count(string(data)); tokens > 100 {
+		t.Errorf("truncated tree has %d tokens, want at most 100", tokens)
+	}
+	// items are kept in rendering order
+	for i, child := range item.Children[:len(item.Children)-1] {
+		if child.Index != i || len(child.Children) != 1 {
+			t.Errorf("child %d = %+v, want dir %d with its file", i, child, i)
+		}
+	}
+}
+
+func TestTreeToolExpandDirsAndFindPath(t *testing.T) {
+	tempDir := t.TempDir()
+	for _, dir := range []string{
+		"a/b/c/d/e",
+		"a/x/y",
+		"ab/b/c",
+	} {
+		if err := os.MkdirAll(filepath.Join(tempDir, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	response, err := ExecuteTree(TreeRequest{
+		WorkspaceRoot:         tempDir,
+		RelativeWorkspacePath: ".",
+		Depth:                 1,
+		ExpandDirs:            []string{"./a/b/"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := Parse(response.Tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the way to a/b is followed, a/b gets one level of its own, and ab is
+	// not mistaken for a prefix of it
+	want := "[a[b[c], x], ab]"
+	if got := PrintItemCompact(root); got != filepath.Base(tempDir)+want {
+		t.Errorf("tree = %s, want %s", got, filepath.Base(tempDir)+want)
+	}
+
+	response, err = ExecuteTree(TreeRequest{
+		WorkspaceRoot:         tempDir,
+		RelativeWorkspacePath: ".",
+		FindPath:              "b/c",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := filepath.Base(tempDir)
+	wantPaths := []string{base + "/a/b/c", base + "/ab/b/c"}
+	if !reflect.DeepEqual(response.Paths, wantPaths) || response.Tree != "" {
+		t.Errorf("paths = %v, tree = %q, want %v", response.Paths, response.Tree, wantPaths)
+	}
+	if got := response.ToLLMOutput(); got != strings.Join(wantPaths, "\n") {
+		t.Errorf("ToLLMOutput() = %q", got)
+	}
+
+	if _, err := ExecuteTree(TreeRequest{WorkspaceRoot: tempDir, RelativeWorkspacePath: ".", FindPath: "missing"}); err == nil {
+		t.Errorf("expected an error for a path not in the tree")
+	}
+}
+
+func TestTreeToolGitignore(t *testing.T) {
+	tempDir := t.TempDir()
+	testfiles.Write(t, tempDir, map[string]string{
+		".gitignore":               "node_modules/\n*.log\n",
+		"src/index.js":             "",
+		"debug.log":                "",
+		"node_modules/pkg/main.js": "",
+	})
+	if err := os.MkdirAll(filepath.Join(tempDir, ".git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := ExecuteTree(TreeRequest{WorkspaceRoot: tempDir, RelativeWorkspacePath: ".", IncludeFiles: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, hidden := range []string{"node_modules", "debug.log", ".git\n"} {
+		if strings.Contains(response.Tree, hidden) {
+			t.Errorf("%q should be ignored by default:\n%s", hidden, response.Tree)
+		}
+	}
+	if !strings.Contains(response.Tree, "index.js") {
+		t.Errorf("expected index.js:\n%s", response.Tree)
+	}
+
+	gitignore := false
+	response, err = ExecuteTree(TreeRequest{WorkspaceRoot: tempDir, RelativeWorkspacePath: ".", IncludeFiles: true, Gitignore: &gitignore})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, shown := range []string{"main.js", "debug.log", ".git\n"} {
+		if !strings.Contains(response.Tree, shown) {
+			t.Errorf("%q should be shown with gitignore false:\n%s", shown, response.Tree)
+		}
+	}
+}
+
+func TestTreeToolSymlinks(t *testing.T) {
+	tempDir := t.TempDir()
+	testfiles.Write(t, tempDir, map[string]string{
+		"a/b/file.go": "",
+	})
+	for link, target := range map[string]string{
+		"a/b/up":   "..",
+		"c":        "a",
+		"dangling": "missing",
+	} {
+		if err := os.Symlink(target, filepath.Join(tempDir, filepath.FromSlash(link))); err != nil {
+			t.Skipf("symlinks not supported: %v", err)
+		}
+	}
+	base := filepath.Base(tempDir)
+
+	// symlinks are shown with their target, directories are not followed
+	response, err := ExecuteTree(TreeRequest{WorkspaceRoot: tempDir, RelativeWorkspacePath: ".", IncludeFiles: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := base + `
+├── a
+│   └── b
+│       ├── up -> ..
+│       └── file.go
+├── c -> a
+└── dangling -> missing
+`
+	if response.Tree != want {
+		t.Errorf("tree:\n%s\nwant:\n%s", response.Tree, want)
+	}
+
+	// followed, links back to a directory above stop as loops
+	response, err = ExecuteTree(TreeRequest{WorkspaceRoot: tempDir, RelativeWorkspacePath: ".", IncludeFiles: true, FollowSymlinks: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = base + `
+├── a
+│   └── b
+│       ├── up -> .. (loop)
+│       └── file.go
+├── c -> a
+│   └── b
+│       ├── up -> .. (loop)
+│       └── file.go
+└── dangling -> missing
+`
+	if response.Tree != want {
+		t.Errorf("tree:\n%s\nwant:\n%s", response.Tree, want)
+	}
+
+	response, err = ExecuteTree(TreeRequest{WorkspaceRoot: tempDir, RelativeWorkspacePath: ".", FollowSymlinks: true, Format: FORMAT_JSON})
+	if err != nil {
+		t.Fatal(err)
+	}
+	up := response.Root.Children[0].Children[0].Children[0]
+	if up.Name != "up" || up.Target != ".." || !up.Loop || !up.Dir {
+		t.Errorf("unexpected item: %+v", up)
+	}
+}
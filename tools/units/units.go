@@ -0,0 +1,19 @@
+// Package units formats quantities for the output of the tools
+package units
+
+import "fmt"
+
+// FormatSize renders a size in bytes with a binary unit, e.g. 3B, 1.5KB or 20MB
+func FormatSize(size int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 || value >= 10 {
+		return fmt.Sprintf("%d%s", int64(value), units[unit])
+	}
+	return fmt.Sprintf("%.1f%s", value, units[unit])
+}
@@ -0,0 +1,130 @@
+package walk
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xhd2015/llm-tools/tools/watch/notify"
+)
+
+// MAX_CACHED_DIRS bounds the directory cache, it is emptied when full
+const MAX_CACHED_DIRS = 100000
+
+// racyWindow is how long after being listed a directory is not trusted by
+// its mtime alone: a change within the mtime granularity of the filesystem
+// would go unnoticed
+const racyWindow = 2 * time.Second
+
+type cachedDir struct {
+	modTime  time.Time
+	listedAt time.Time
+	entries  []fs.DirEntry
+}
+
+var cache = struct {
+	sync.Mutex
+	enabled     bool
+	unsubscribe func()
+	dirs        map[string]*cachedDir
+}{dirs: make(map[string]*cachedDir)}
+
+// EnableCache turns the directory cache on or off. Long running processes
+// such as the MCP server enable it: directory listings are then reused by
+// every walk, tree and list_dir as long as the directory's mtime is
+// unchanged, which costs one stat instead of reading the directory. Files
+// changed through notify.Changed are invalidated at once.
+func EnableCache(enabled bool) {
+	cache.Lock()
+	defer cache.Unlock()
+	if enabled == cache.enabled {
+		return
+	}
+	cache.enabled = enabled
+	cache.dirs = make(map[string]*cachedDir)
+	if enabled {
+		cache.unsubscribe = notify.Subscribe(func(paths []string) {
+			Invalidate(paths...)
+		})
+	} else {
+		cache.unsubscribe()
+		cache.unsubscribe = nil
+	}
+}
+
+// ReadDir reads dir like os.ReadDir, from the cache when it is enabled and
+// the directory did not change. The returned slice must not be modified.
+func ReadDir(dir string) ([]fs.DirEntry, error) {
+	cache.Lock()
+	enabled := cache.enabled
+	cache.Unlock()
+	if !enabled {
+		return os.ReadDir(dir)
+	}
+	// keys are absolute, as are the paths given to Invalidate
+	key, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	cache.Lock()
+	cached := cache.dirs[key]
+	cache.Unlock()
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		Invalidate(key)
+		return nil, err
+	}
+	if cached != nil && cached.modTime.Equal(info.ModTime()) && cached.listedAt.Sub(info.ModTime()) > racyWindow {
+		return cached.entries, nil
+	}
+
+	listedAt := time.Now()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return entries, err
+	}
+	cache.Lock()
+	defer cache.Unlock()
+	if cache.enabled {
+		if len(cache.dirs) >= MAX_CACHED_DIRS {
+			cache.dirs = make(map[string]*cachedDir)
+		}
+		cache.dirs[key] = &cachedDir{modTime: info.ModTime(), listedAt: listedAt, entries: entries}
+	}
+	return entries, nil
+}
+
+// Invalidate drops the cached listings of paths, of the directories
+// containing them and, for cached directories, of every directory under
+// them. Without paths it empties the cache.
+func Invalidate(paths ...string) {
+	cache.Lock()
+	defer cache.Unlock()
+	if len(cache.dirs) == 0 {
+		return
+	}
+	if len(paths) == 0 {
+		cache.dirs = make(map[string]*cachedDir)
+		return
+	}
+	for _, path := range paths {
+		path = filepath.Clean(path)
+		delete(cache.dirs, filepath.Dir(path))
+		if _, ok := cache.dirs[path]; !ok {
+			// walks list parents before children: a directory that is not
+			// cached has no cached descendants worth scanning for
+			continue
+		}
+		delete(cache.dirs, path)
+		prefix := path + string(filepath.Separator)
+		for dir := range cache.dirs {
+			if strings.HasPrefix(dir, prefix) {
+				delete(cache.dirs, dir)
+			}
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package walk
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/xhd2015/llm-tools/tools/glob"
+)
+
+// IGNORE_FILE is the project ignore file read in addition to .gitignore.
+// It uses gitignore syntax and takes precedence over .gitignore in the same directory.
+const IGNORE_FILE = ".llm-toolsignore"
+
+// rule is a single pattern of an ignore file
+type rule struct {
+	pattern *glob.Pattern
+	negate  bool
+	dirOnly bool
+}
+
+// Ignore evaluates gitignore rules for paths under a root directory.
+// Rules are read lazily per directory and cached, so an Ignore can be
+// shared by concurrent walkers.
+type Ignore struct {
+	// root is the repository root if one is found, otherwise the walk root
+	root string
+	// global holds the global excludes and .git/info/exclude, relative to root
+	global []rule
+
+	mutex sync.RWMutex
+	dirs  map[string][]rule
+}
+
+// NewIgnore creates the matcher for walking dir. The enclosing git repository,
+// if any, determines which ignore files apply, so nested walks see the same
+// rules as a walk from the repository root.
+func NewIgnore(dir string) *Ignore {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		absDir = dir
+	}
+	ig := &Ignore{
+		root: absDir,
+		dirs: make(map[string][]rule),
+	}
+	if repoRoot := findRepoRoot(absDir); repoRoot != "" {
+		ig.root = repoRoot
+		if file := globalExcludesFile(); file != "" {
+			ig.global = append(ig.global, readRules(file)...)
+		}
+		ig.global = append(ig.global, readRules(filepath.Join(repoRoot, ".git", "info", "exclude"))...)
+	}
+	return ig
+}
+
+// Root returns the directory ignore rules are evaluated from
+func (ig *Ignore) Root() string {
+	return ig.root
+}
+
+// Ignored reports whether the absolute path is ignored, either by itself
+// or because one of its parent directories is
+func (ig *Ignore) Ignored(path string, isDir bool) bool {
+	rel, ok := ig.rel(path)
+	if !ok || rel == "" {
+		return false
+	}
+	parts := strings.Split(rel, "/")
+	current := ig.root
+	for _, part := range parts[:len(parts)-1] {
+		current = filepath.Join(current, part)
+		if ig.match(current, true) {
+			return true
+		}
+	}
+	return ig.match(path, isDir)
+}
+
+// match reports whether path itself is ignored, assuming none of its
+// parent directories are. The last matching rule wins, and rules from
+// deeper directories are evaluated after those of their parents.
+func (ig *Ignore) match(path string, isDir bool) bool {
+	rel, ok := ig.rel(path)
+	if !ok || rel == "" {
+		return false
+	}
+	ignored := false
+	apply := func(rules []rule, relPath string) {
+		for _, r := range rules {
+			if r.dirOnly && !isDir {
+				continue
+			}
+			if r.pattern.Match(relPath) {
+				ignored = !r.negate
+			}
+		}
+	}
+	apply(ig.global, rel)
+
+	dir := ig.root
+	remaining := rel
+	for {
+		apply(ig.dirRules(dir), remaining)
+		idx := strings.Index(remaining, "/")
+		if idx < 0 {
+			break
+		}
+		dir = filepath.Join(dir, remaining[:idx])
+		remaining = remaining[idx+1:]
+	}
+	return ignored
+}
+
+// rel returns path relative to root in slash form, ok is false if path is outside root
+func (ig *Ignore) rel(path string) (string, bool) {
+	rel, err := filepath.Rel(ig.root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	if rel == "." {
+		return "", true
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// dirRules returns the rules of dir/.gitignore followed by dir/.llm-toolsignore
+func (ig *Ignore) dirRules(dir string) []rule {
+	ig.mutex.RLock()
+	rules, ok := ig.dirs[dir]
+	ig.mutex.RUnlock()
+	if ok {
+		return rules
+	}
+
+	rules = append(readRules(filepath.Join(dir, ".gitignore")), readRules(filepath.Join(dir, IGNORE_FILE))...)
+
+	ig.mutex.Lock()
+	ig.dirs[dir] = rules
+	ig.mutex.Unlock()
+	return rules
+}
+
+// readRules parses an ignore file, a missing file has no rules
+func readRules(file string) []rule {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var rules []rule
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if r, ok := parseRule(scanner.Text()); ok {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+// parseRule parses a line of gitignore syntax, see gitignore(5)
+func parseRule(line string) (rule, bool) {
+	line = strings.TrimSuffix(line, "\r")
+	line = trimTrailingSpaces(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return rule{}, false
+	}
+
+	var r rule
+	if strings.HasPrefix(line, "!") {
+		r.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return rule{}, false
+	}
+	// a slash at the beginning or in the middle anchors the pattern to the
+	// directory of the ignore file, otherwise it matches at any depth
+	if strings.Contains(line, "/") && !strings.HasPrefix(line, "/") {
+		line = "/" + line
+	}
+	pattern, err := glob.Compile(line)
+	if err != nil {
+		return rule{}, false
+	}
+	r.pattern = pattern
+	return r, true
+}
+
+// trimTrailingSpaces removes trailing spaces unless they are escaped with a backslash
+func trimTrailingSpaces(line string) string {
+	end := len(line)
+	for end > 0 && line[end-1] == ' ' {
+		if end >= 2 && line[end-2] == '\\' {
+			break
+		}
+		end--
+	}
+	return line[:end]
+}
+
+// findRepoRoot returns the closest parent of dir containing .git, or "" if none
+func findRepoRoot(dir string) string {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// globalExcludesFile returns core.excludesFile from the user's git config,
+// defaulting to $XDG_CONFIG_HOME/git/ignore like git does
+func globalExcludesFile() string {
+	home, _ := os.UserHomeDir()
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" && home != "" {
+		configHome = filepath.Join(home, ".config")
+	}
+
+	var configFiles []string
+	if configHome != "" {
+		configFiles = append(configFiles, filepath.Join(configHome, "git", "config"))
+	}
+	if home != "" {
+		configFiles = append(configFiles, filepath.Join(home, ".gitconfig"))
+	}
+	// ~/.gitconfig is read last by git, so it wins
+	var excludesFile string
+	for _, configFile := range configFiles {
+		if value := readCoreExcludesFile(configFile); value != "" {
+			excludesFile = value
+		}
+	}
+	if excludesFile == "" {
+		if configHome == "" {
+			return ""
+		}
+		return filepath.Join(configHome, "git", "ignore")
+	}
+	if strings.HasPrefix(excludesFile, "~/") && home != "" {
+		excludesFile = filepath.Join(home, excludesFile[2:])
+	}
+	return excludesFile
+}
+
+// readCoreExcludesFile extracts core.excludesFile from a git config file
+func readCoreExcludesFile(configFile string) string {
+	f, err := os.Open(configFile)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	var value string
+	inCore := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			section := strings.ToLower(strings.TrimSpace(strings.Trim(line, "[]")))
+			inCore = section == "core"
+			continue
+		}
+		if !inCore {
+			continue
+		}
+		key, val, ok := strings.Cut(line, "=")
+		if !ok || !strings.EqualFold(strings.TrimSpace(key), "excludesfile") {
+			continue
+		}
+		value = strings.Trim(strings.TrimSpace(val), `"`)
+	}
+	return value
+}
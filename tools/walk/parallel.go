@@ -0,0 +1,97 @@
+package walk
+
+import (
+	"io/fs"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// ParallelFunc is called by WalkParallel for every entry that is not skipped.
+// It is called concurrently and must be safe for that. Returning
+// filepath.SkipDir for a directory skips it, any other error stops the walk.
+type ParallelFunc func(path string, d fs.DirEntry) error
+
+// WalkParallel walks root like Walk, reading directories and calling fn from up
+// to workers goroutines, runtime.NumCPU() if workers is not positive. Entries are
+// visited in no particular order. A directory that cannot be read is skipped,
+// unless it is root itself.
+func WalkParallel(root string, opts Options, workers int, fn ParallelFunc) error {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+	filter := NewFilter(absRoot, opts)
+	entries, err := filter.ReadDir(absRoot)
+	if err != nil {
+		return err
+	}
+
+	var (
+		wg       sync.WaitGroup
+		sem      = make(chan struct{}, workers)
+		mutex    sync.Mutex
+		firstErr error
+	)
+	failed := func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return firstErr != nil
+	}
+	fail := func(err error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	var visit func(dir string, entries []fs.DirEntry)
+	visit = func(dir string, entries []fs.DirEntry) {
+		var subdirs []string
+		for _, entry := range entries {
+			if failed() {
+				return
+			}
+			path := filepath.Join(dir, entry.Name())
+			err := fn(path, entry)
+			if err == filepath.SkipDir && entry.IsDir() {
+				continue
+			}
+			if err != nil {
+				fail(err)
+				return
+			}
+			if entry.IsDir() {
+				subdirs = append(subdirs, path)
+			}
+		}
+		// subdirectories are read by new goroutines, which take a worker slot
+		// only while they run, so waiting for a slot never holds another one
+		for _, subdir := range subdirs {
+			wg.Add(1)
+			go func(subdir string) {
+				defer wg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+				if failed() {
+					return
+				}
+				entries, err := filter.ReadDir(subdir)
+				if err != nil {
+					return
+				}
+				visit(subdir, entries)
+			}(subdir)
+		}
+	}
+
+	sem <- struct{}{}
+	visit(root, entries)
+	<-sem
+	wg.Wait()
+	return firstErr
+}
@@ -0,0 +1,155 @@
+// Package walk lists files the way git sees them: .git is never visited and
+// entries excluded by .gitignore files (nested ones included), .git/info/exclude,
+// the global excludes file or .llm-toolsignore are skipped.
+package walk
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Options controls which entries a walk skips
+type Options struct {
+	// NoIgnore disables all ignore files, only .git is skipped
+	NoIgnore bool
+	// SkipHidden skips entries whose name starts with a dot, like ripgrep does by default
+	SkipHidden bool
+}
+
+// Filter decides which entries under a root are visited
+type Filter struct {
+	opts   Options
+	ignore *Ignore
+}
+
+// NewFilter creates a filter for walking root
+func NewFilter(root string, opts Options) *Filter {
+	f := &Filter{opts: opts}
+	if !opts.NoIgnore {
+		f.ignore = NewIgnore(root)
+	}
+	return f
+}
+
+// Ignore returns the underlying gitignore matcher, nil when ignore files are disabled
+func (f *Filter) Ignore() *Ignore {
+	return f.ignore
+}
+
+// Skip reports whether the entry at the absolute path should not be visited.
+// Parent directories are assumed to have passed Skip already.
+func (f *Filter) Skip(path string, isDir bool) bool {
+	name := filepath.Base(path)
+	if isDir && name == ".git" {
+		return true
+	}
+	if f.opts.SkipHidden && strings.HasPrefix(name, ".") && name != "." && name != ".." {
+		return true
+	}
+	if f.ignore != nil && f.ignore.match(path, isDir) {
+		return true
+	}
+	return false
+}
+
+// ReadDir reads the entries of dir that are not skipped, sorted by name
+func (f *Filter) ReadDir(dir string) ([]os.DirEntry, error) {
+	entries, err := ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	// entries may be cached, filter into a new slice
+	kept := make([]os.DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		// like git, a symlink to a directory is not matched by dir-only patterns
+		if f.Skip(filepath.Join(absDir, entry.Name()), entry.IsDir()) {
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	return kept, nil
+}
+
+// Walk walks root like filepath.WalkDir, skipping ignored entries and never
+// descending into ignored directories. Paths passed to fn are rooted at root
+// exactly as filepath.WalkDir would pass them.
+func Walk(root string, opts Options, fn fs.WalkDirFunc) error {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+	filter := NewFilter(absRoot, opts)
+	fn = func(fn fs.WalkDirFunc) fs.WalkDirFunc {
+		return func(path string, d fs.DirEntry, err error) error {
+			if err != nil || path == root {
+				return fn(path, d, err)
+			}
+			absPath := path
+			if !filepath.IsAbs(absPath) {
+				rel, relErr := filepath.Rel(root, path)
+				if relErr != nil {
+					return fn(path, d, relErr)
+				}
+				absPath = filepath.Join(absRoot, rel)
+			}
+			if filter.Skip(absPath, d.IsDir()) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			return fn(path, d, nil)
+		}
+	}(fn)
+
+	info, err := os.Lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walkDir(root, fs.FileInfoToDirEntry(info), fn)
+	}
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+// walkDir is filepath.WalkDir's recursion, reading directories through
+// ReadDir so that walks use the directory cache
+func walkDir(path string, d fs.DirEntry, fn fs.WalkDirFunc) error {
+	if err := fn(path, d, nil); err != nil || !d.IsDir() {
+		if err == filepath.SkipDir && d.IsDir() {
+			// successfully skipped directory
+			err = nil
+		}
+		return err
+	}
+
+	entries, err := ReadDir(path)
+	if err != nil {
+		// second call, to report the ReadDir error
+		err = fn(path, d, err)
+		if err != nil {
+			if err == filepath.SkipDir && d.IsDir() {
+				err = nil
+			}
+			return err
+		}
+	}
+
+	for _, entry := range entries {
+		if err := walkDir(filepath.Join(path, entry.Name()), entry, fn); err != nil {
+			if err == filepath.SkipDir {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,296 @@
+package walk
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xhd2015/llm-tools/tools/internal/testfiles"
+	"github.com/xhd2015/llm-tools/tools/watch/notify"
+)
+
+func isolateGitConfig(t *testing.T) string {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	return home
+}
+
+func walkFiles(t *testing.T, root string, opts Options) []string {
+	t.Helper()
+	var files []string
+	err := Walk(root, opts, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(root, path)
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+func TestWalkGitignore(t *testing.T) {
+	home := isolateGitConfig(t)
+	testfiles.Write(t, home, map[string]string{
+		".config/git/ignore": "*.swp\n",
+	})
+
+	root := t.TempDir()
+	testfiles.Write(t, root, map[string]string{
+		".git/HEAD":         "ref: refs/heads/master\n",
+		".git/info/exclude": "local.txt\n",
+		".gitignore":        "# build output\nbuild/\n*.log\n!keep.log\n/root_only.txt\ndocs/*.tmp\n",
+		".llm-toolsignore":  "generated/\n",
+		"main.go":           "package main\n",
+		"debug.log":         "",
+		"keep.log":          "",
+		"local.txt":         "",
+		"edit.swp":          "",
+		"root_only.txt":     "",
+		"sub/root_only.txt": "",
+		"build/out.bin":     "",
+		"sub/build/x.go":    "",
+		"docs/a.tmp":        "",
+		"docs/b/c.tmp":      "",
+		"generated/gen.go":  "",
+		"sub/.gitignore":    "*.go\n!keep.go\n",
+		"sub/drop.go":       "",
+		"sub/keep.go":       "",
+		"sub/readme.md":     "",
+		"nested/.gitignore": "!*.log\n",
+		"nested/trace.log":  "",
+	})
+
+	got := walkFiles(t, root, Options{})
+	want := []string{
+		".gitignore",
+		".llm-toolsignore",
+		"docs/b/c.tmp",
+		"keep.log",
+		"main.go",
+		"nested/.gitignore",
+		"nested/trace.log",
+		"sub/.gitignore",
+		"sub/keep.go",
+		"sub/readme.md",
+		"sub/root_only.txt",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Walk() = %v\nwant %v", got, want)
+	}
+}
+
+func TestWalkOptions(t *testing.T) {
+	isolateGitConfig(t)
+	root := t.TempDir()
+	testfiles.Write(t, root, map[string]string{
+		".gitignore":    "*.log\n",
+		".hidden/a.txt": "",
+		"a.log":         "",
+		"b.txt":         "",
+	})
+
+	got := walkFiles(t, root, Options{SkipHidden: true})
+	if want := []string{"b.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SkipHidden: got %v, want %v", got, want)
+	}
+	got = walkFiles(t, root, Options{NoIgnore: true})
+	if want := []string{".gitignore", ".hidden/a.txt", "a.log", "b.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("NoIgnore: got %v, want %v", got, want)
+	}
+}
+
+func TestIgnoreFromSubdirectory(t *testing.T) {
+	isolateGitConfig(t)
+	root := t.TempDir()
+	testfiles.Write(t, root, map[string]string{
+		".git/HEAD":    "",
+		".gitignore":   "pkg/*.gen.go\n",
+		"pkg/a.go":     "",
+		"pkg/a.gen.go": "",
+	})
+
+	// walking a subdirectory still applies the repository root's rules
+	got := walkFiles(t, filepath.Join(root, "pkg"), Options{})
+	if want := []string{"a.go"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	ig := NewIgnore(filepath.Join(root, "pkg"))
+	if !ig.Ignored(filepath.Join(root, "pkg", "a.gen.go"), false) {
+		t.Errorf("expected pkg/a.gen.go to be ignored")
+	}
+}
+
+func TestIgnoredParentDirectory(t *testing.T) {
+	isolateGitConfig(t)
+	root := t.TempDir()
+	testfiles.Write(t, root, map[string]string{
+		".gitignore":           "node_modules/\n!node_modules/keep.js\n",
+		"node_modules/x.js":    "",
+		"node_modules/keep.js": "",
+	})
+	ig := NewIgnore(root)
+	// a file cannot be re-included when its parent directory is excluded
+	if !ig.Ignored(filepath.Join(root, "node_modules", "keep.js"), false) {
+		t.Errorf("expected node_modules/keep.js to be ignored")
+	}
+}
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		line    string
+		ok      bool
+		negate  bool
+		dirOnly bool
+		pattern string
+	}{
+		{"", false, false, false, ""},
+		{"# comment", false, false, false, ""},
+		{`\#file`, true, false, false, "#file"},
+		{`\!file`, true, false, false, "!file"},
+		{"!keep", true, true, false, "keep"},
+		{"dir/", true, false, true, "dir"},
+		{"a/b", true, false, false, "/a/b"},
+		{"trailing   ", true, false, false, "trailing"},
+		{`space\ `, true, false, false, `space\ `},
+	}
+	for _, tt := range tests {
+		r, ok := parseRule(tt.line)
+		if ok != tt.ok {
+			t.Errorf("parseRule(%q) ok = %v, want %v", tt.line, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if r.negate != tt.negate || r.dirOnly != tt.dirOnly || r.pattern.String() != tt.pattern {
+			t.Errorf("parseRule(%q) = {negate:%v dirOnly:%v pattern:%q}, want {%v %v %q}", tt.line, r.negate, r.dirOnly, r.pattern.String(), tt.negate, tt.dirOnly, tt.pattern)
+		}
+	}
+}
+
+func TestWalkParallel(t *testing.T) {
+	isolateGitConfig(t)
+	root := t.TempDir()
+	testfiles.Write(t, root, map[string]string{
+		".gitignore":      "*.log\nskip/\n",
+		"a.go":            "",
+		"a.log":           "",
+		"skip/x.go":       "",
+		"pkg/b.go":        "",
+		"pkg/sub/c.go":    "",
+		"pkg/sub/d/e.go":  "",
+		"vendor/lib/f.go": "",
+	})
+
+	var mutex sync.Mutex
+	var files []string
+	err := WalkParallel(root, Options{}, 4, func(path string, d fs.DirEntry) error {
+		if d.IsDir() {
+			if d.Name() == "vendor" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, _ := filepath.Rel(root, path)
+		mutex.Lock()
+		files = append(files, filepath.ToSlash(rel))
+		mutex.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	want := []string{".gitignore", "a.go", "pkg/b.go", "pkg/sub/c.go", "pkg/sub/d/e.go"}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("WalkParallel() = %v\nwant %v", files, want)
+	}
+}
+
+func TestCache(t *testing.T) {
+	EnableCache(true)
+	defer EnableCache(false)
+
+	root := t.TempDir()
+	testfiles.Write(t, root, map[string]string{"a.go": "", "sub/b.go": ""})
+	names := func() []string {
+		t.Helper()
+		entries, err := ReadDir(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		return names
+	}
+	// age the cached listing past the racy window, and restore the mtime
+	// of root after changing it behind the cache's back
+	age := func() {
+		cache.Lock()
+		for _, dir := range cache.dirs {
+			dir.listedAt = dir.modTime.Add(time.Minute)
+		}
+		cache.Unlock()
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := names(), []string{"a.go", "sub"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("names = %v, want %v", got, want)
+	}
+	age()
+	testfiles.Write(t, root, map[string]string{"c.go": ""})
+	if err := os.Chtimes(root, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := names(), []string{"a.go", "sub"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("cached names = %v, want %v", got, want)
+	}
+
+	// our own writes invalidate at once
+	notify.Changed(filepath.Join(root, "c.go"))
+	if got, want := names(), []string{"a.go", "c.go", "sub"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("names after notify = %v, want %v", got, want)
+	}
+
+	// other writes are caught by the mtime
+	age()
+	testfiles.Write(t, root, map[string]string{"d.go": ""})
+	if err := os.Chtimes(root, info.ModTime(), info.ModTime().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := names(), []string{"a.go", "c.go", "d.go", "sub"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("names after change = %v, want %v", got, want)
+	}
+
+	// walks go through the cache too
+	if got, want := walkFiles(t, root, Options{}), []string{"a.go", "c.go", "d.go", "sub/b.go"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("walk = %v, want %v", got, want)
+	}
+	if _, ok := cache.dirs[filepath.Join(root, "sub")]; !ok {
+		t.Fatalf("sub was not cached by the walk")
+	}
+	Invalidate(root)
+	if len(cache.dirs) != 0 {
+		t.Fatalf("cache not emptied by invalidating root: %d dirs left", len(cache.dirs))
+	}
+}
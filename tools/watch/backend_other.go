@@ -0,0 +1,7 @@
+//go:build !linux
+
+package watch
+
+func newBackend(root string) (backend, error) {
+	return newPoller(root, POLL_INTERVAL)
+}
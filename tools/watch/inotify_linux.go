@@ -0,0 +1,155 @@
+package watch
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/xhd2015/llm-tools/tools/walk"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE |
+	syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_ONLYDIR
+
+// inotify watches every directory of the tree with one inotify watch,
+// adding watches for directories as they are created
+type inotify struct {
+	// file wraps the non-blocking inotify descriptor so that reads go
+	// through the runtime poller and Close interrupts them
+	file    *os.File
+	fd      int
+	events  chan []event
+	closing chan struct{}
+	once    sync.Once
+
+	mutex sync.Mutex
+	dirs  map[int32]string
+}
+
+func newBackend(root string) (backend, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	in := &inotify{
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		fd:      fd,
+		events:  make(chan []event, 16),
+		closing: make(chan struct{}),
+		dirs:    make(map[int32]string),
+	}
+	if err := in.Add(root); err != nil {
+		in.file.Close()
+		return nil, err
+	}
+	go in.read()
+	return in, nil
+}
+
+func (in *inotify) Add(dir string) error {
+	return walk.Walk(dir, walk.Options{SkipHidden: true}, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// the directory may be gone already
+			if path == dir {
+				return err
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		wd, err := syscall.InotifyAddWatch(in.fd, path, inotifyMask)
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			return nil
+		}
+		in.mutex.Lock()
+		in.dirs[int32(wd)] = path
+		in.mutex.Unlock()
+		return nil
+	})
+}
+
+func (in *inotify) Events() <-chan []event {
+	return in.events
+}
+
+func (in *inotify) Close() error {
+	var err error
+	in.once.Do(func() {
+		close(in.closing)
+		err = in.file.Close()
+	})
+	return err
+}
+
+func (in *inotify) read() {
+	defer close(in.events)
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := in.file.Read(buf)
+		if err != nil {
+			return
+		}
+		var events []event
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			offset = nameStart + int(raw.Len)
+			if raw.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				events = append(events, event{overflow: true})
+				continue
+			}
+			in.mutex.Lock()
+			dir, ok := in.dirs[raw.Wd]
+			if raw.Mask&syscall.IN_IGNORED != 0 {
+				delete(in.dirs, raw.Wd)
+			}
+			in.mutex.Unlock()
+			name := strings.TrimRight(string(buf[nameStart:offset]), "\x00")
+			if !ok || name == "" {
+				continue
+			}
+			path := filepath.Join(dir, name)
+			isDir := raw.Mask&syscall.IN_ISDIR != 0
+			if isDir {
+				if raw.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+					// files created before the watch was added are found
+					// by indexing the directory as a whole
+					in.Add(path)
+				} else if raw.Mask&syscall.IN_MOVED_FROM != 0 {
+					in.remove(path)
+				}
+			}
+			events = append(events, event{path: path, isDir: isDir})
+		}
+		if len(events) == 0 {
+			continue
+		}
+		select {
+		case in.events <- events:
+		case <-in.closing:
+			return
+		}
+	}
+}
+
+// remove drops the watches of a directory moved away and of those under it,
+// their events would otherwise be reported at the old paths
+func (in *inotify) remove(dir string) {
+	in.mutex.Lock()
+	defer in.mutex.Unlock()
+	prefix := dir + string(filepath.Separator)
+	for wd, path := range in.dirs {
+		if path == dir || strings.HasPrefix(path, prefix) {
+			syscall.InotifyRmWatch(in.fd, uint32(wd))
+			delete(in.dirs, wd)
+		}
+	}
+}
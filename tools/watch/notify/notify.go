@@ -0,0 +1,57 @@
+// Package notify tells indexes kept by a running watcher about files changed
+// by the tools themselves, so that a search right after an edit sees it
+// without waiting for the filesystem event and its debounce.
+package notify
+
+import (
+	"path/filepath"
+	"sync"
+)
+
+// Listener receives the absolute paths of changed files
+type Listener func(paths []string)
+
+var listeners = struct {
+	sync.Mutex
+	next int
+	byID map[int]Listener
+}{byID: make(map[int]Listener)}
+
+// Subscribe calls listener on every change until the returned function is called
+func Subscribe(listener Listener) (unsubscribe func()) {
+	listeners.Lock()
+	defer listeners.Unlock()
+	id := listeners.next
+	listeners.next++
+	listeners.byID[id] = listener
+	return func() {
+		listeners.Lock()
+		defer listeners.Unlock()
+		delete(listeners.byID, id)
+	}
+}
+
+// Changed reports files or directories that were created, written, renamed
+// or removed. Listeners are called synchronously, so indexes are up to date
+// when it returns. Without listeners, as in one-shot CLI commands, it does nothing.
+func Changed(paths ...string) {
+	listeners.Lock()
+	current := make([]Listener, 0, len(listeners.byID))
+	for _, listener := range listeners.byID {
+		current = append(current, listener)
+	}
+	listeners.Unlock()
+	if len(current) == 0 {
+		return
+	}
+
+	absPaths := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if absPath, err := filepath.Abs(path); err == nil {
+			absPaths = append(absPaths, absPath)
+		}
+	}
+	for _, listener := range current {
+		listener(absPaths)
+	}
+}
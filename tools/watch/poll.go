@@ -0,0 +1,113 @@
+package watch
+
+import (
+	"io/fs"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/xhd2015/llm-tools/tools/walk"
+)
+
+// POLL_INTERVAL is how often the polling backend scans the tree
+const POLL_INTERVAL = 2 * time.Second
+
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// poller finds changes by scanning the tree periodically and comparing the
+// size and mtime of files, for systems without inotify
+type poller struct {
+	root    string
+	events  chan []event
+	closing chan struct{}
+	once    sync.Once
+	files   map[string]fileState
+}
+
+func newPoller(root string, interval time.Duration) (*poller, error) {
+	p := &poller{
+		root:    root,
+		events:  make(chan []event, 16),
+		closing: make(chan struct{}),
+	}
+	files, err := p.scan()
+	if err != nil {
+		return nil, err
+	}
+	p.files = files
+	go p.run(interval)
+	return p, nil
+}
+
+// Add does nothing, every scan covers the whole tree
+func (p *poller) Add(dir string) error {
+	return nil
+}
+
+func (p *poller) Events() <-chan []event {
+	return p.events
+}
+
+func (p *poller) Close() error {
+	p.once.Do(func() { close(p.closing) })
+	return nil
+}
+
+func (p *poller) run(interval time.Duration) {
+	defer close(p.events)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.closing:
+			return
+		case <-ticker.C:
+		}
+		files, err := p.scan()
+		if err != nil {
+			continue
+		}
+		var events []event
+		for path, state := range files {
+			if old, ok := p.files[path]; !ok || old != state {
+				events = append(events, event{path: path})
+			}
+		}
+		for path := range p.files {
+			if _, ok := files[path]; !ok {
+				events = append(events, event{path: path})
+			}
+		}
+		p.files = files
+		if len(events) == 0 {
+			continue
+		}
+		select {
+		case p.events <- events:
+		case <-p.closing:
+			return
+		}
+	}
+}
+
+func (p *poller) scan() (map[string]fileState, error) {
+	var mutex sync.Mutex
+	files := make(map[string]fileState)
+	err := walk.WalkParallel(p.root, walk.Options{SkipHidden: true}, 0, func(path string, d fs.DirEntry) error {
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		mutex.Lock()
+		files[filepath.Clean(path)] = fileState{size: info.Size(), modTime: info.ModTime()}
+		mutex.Unlock()
+		return nil
+	})
+	return files, err
+}
@@ -0,0 +1,438 @@
+// Package watch keeps the indexes of a workspace up to date while files
+// change: it watches the workspace (inotify on Linux, polling elsewhere),
+// debounces events and passes the changed paths to each Indexer, which
+// updates incrementally. Files changed by the tools themselves are reported
+// through the notify package and indexed synchronously.
+package watch
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xhd2015/llm-tools/tools/codebase_search"
+	"github.com/xhd2015/llm-tools/tools/grep_search/index_search"
+	"github.com/xhd2015/llm-tools/tools/walk"
+	"github.com/xhd2015/llm-tools/tools/watch/notify"
+)
+
+// DEFAULT_DEBOUNCE is how long the workspace must be quiet before changes are indexed
+const DEFAULT_DEBOUNCE = 200 * time.Millisecond
+
+// MAX_DELAY bounds how long changes wait for the workspace to be quiet
+const MAX_DELAY = 5 * time.Second
+
+// DEFAULT_RESYNC is the interval of full incremental updates, which catch
+// changes whose events were lost and make the watcher own the indexes again
+// after a failed update
+const DEFAULT_RESYNC = 15 * time.Minute
+
+// Indexer is an index kept up to date by a Watcher
+type Indexer interface {
+	Name() string
+	// Update indexes the given paths of root, slash separated and relative
+	// to it, each a file or a directory that was created, changed or removed.
+	// With no paths, the whole of root is brought up to date.
+	Update(root string, paths []string) error
+}
+
+// Owner is implemented by indexers whose index is trusted as is while a
+// watcher keeps it up to date, and checked against the files otherwise
+type Owner interface {
+	// Own is called after each successful Refresh and every
+	// index_search.OWNER_HEARTBEAT until an update fails
+	Own(root string) error
+	// Disown is called when an update fails and on Close
+	Disown(root string) error
+}
+
+// TrigramIndexer maintains the trigram index of grep_search, building it if missing
+type TrigramIndexer struct{}
+
+func (TrigramIndexer) Name() string {
+	return "trigram"
+}
+
+func (TrigramIndexer) Update(root string, paths []string) error {
+	_, err := index_search.Update(root, paths)
+	return err
+}
+
+func (TrigramIndexer) Own(root string) error {
+	return index_search.Own(root)
+}
+
+func (TrigramIndexer) Disown(root string) error {
+	return index_search.Disown(root)
+}
+
+// EmbeddingIndexer embeds the chunks of codebase_search when an embedder is
+// configured, and does nothing otherwise
+type EmbeddingIndexer struct{}
+
+func (EmbeddingIndexer) Name() string {
+	return "embeddings"
+}
+
+func (EmbeddingIndexer) Update(root string, paths []string) error {
+	_, err := codebase_search.UpdateEmbeddings(root, paths)
+	return err
+}
+
+// DefaultIndexers returns the indexes of the tools
+func DefaultIndexers() []Indexer {
+	return []Indexer{TrigramIndexer{}, EmbeddingIndexer{}}
+}
+
+// Options configures a Watcher
+type Options struct {
+	// Indexers are updated on changes, DefaultIndexers if nil
+	Indexers []Indexer
+	// Debounce is DEFAULT_DEBOUNCE if zero
+	Debounce time.Duration
+	// Resync is DEFAULT_RESYNC if zero, negative disables it
+	Resync time.Duration
+	// Logf receives progress and errors, nothing is logged if nil
+	Logf func(format string, args ...interface{})
+}
+
+// event is a change reported by a backend, overflow means events were lost
+type event struct {
+	path     string
+	isDir    bool
+	overflow bool
+}
+
+// backend watches a directory tree recursively
+type backend interface {
+	// Add watches dir and the directories under it that are not ignored
+	Add(dir string) error
+	// Events is closed when the backend is closed
+	Events() <-chan []event
+	Close() error
+}
+
+// Watcher keeps the indexes of a root directory up to date
+type Watcher struct {
+	root    string
+	opts    Options
+	backend backend
+
+	mutex  sync.Mutex
+	filter *walk.Filter
+	// pending holds the changed paths not indexed yet, all is set when the
+	// whole root must be updated
+	pending map[string]bool
+	all     bool
+	since   time.Time
+
+	// updateMutex serializes index updates, owned is set from a successful
+	// Refresh until an update fails
+	updateMutex sync.Mutex
+	owned       bool
+
+	unsubscribe func()
+	closing     chan struct{}
+	done        chan struct{}
+}
+
+// New starts watching root. Changes are indexed in the background until
+// Close, files reported to notify.Changed are indexed before it returns.
+func New(root string, opts Options) (*Watcher, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Indexers == nil {
+		opts.Indexers = DefaultIndexers()
+	}
+	if opts.Debounce <= 0 {
+		opts.Debounce = DEFAULT_DEBOUNCE
+	}
+	if opts.Resync == 0 {
+		opts.Resync = DEFAULT_RESYNC
+	}
+	if opts.Logf == nil {
+		opts.Logf = func(format string, args ...interface{}) {}
+	}
+	b, err := newBackend(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch %s: %w", root, err)
+	}
+	w := &Watcher{
+		root:    root,
+		opts:    opts,
+		backend: b,
+		filter:  walk.NewFilter(root, walk.Options{SkipHidden: true}),
+		pending: make(map[string]bool),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	w.unsubscribe = notify.Subscribe(func(paths []string) {
+		if err := w.Sync(paths); err != nil {
+			w.opts.Logf("failed to index %s: %v", strings.Join(paths, ", "), err)
+		}
+	})
+	go w.run()
+	return w, nil
+}
+
+// Root returns the watched directory
+func (w *Watcher) Root() string {
+	return w.root
+}
+
+// Refresh brings every index up to date with the whole root, which catches
+// up with changes made while nothing was watching
+func (w *Watcher) Refresh() error {
+	w.mutex.Lock()
+	w.pending = make(map[string]bool)
+	w.all = false
+	w.mutex.Unlock()
+	if err := w.update(nil); err != nil {
+		return err
+	}
+	w.updateMutex.Lock()
+	defer w.updateMutex.Unlock()
+	w.owned = true
+	w.own()
+	return nil
+}
+
+// Sync indexes the given absolute paths now, ignoring those outside the
+// root or excluded by the ignore rules
+func (w *Watcher) Sync(paths []string) error {
+	var rels []string
+	w.mutex.Lock()
+	for _, path := range paths {
+		rel, ok := w.rel(path, false)
+		if !ok {
+			continue
+		}
+		delete(w.pending, rel)
+		rels = append(rels, rel)
+	}
+	w.mutex.Unlock()
+	if len(rels) == 0 {
+		return nil
+	}
+	return w.update(rels)
+}
+
+// Close stops watching, changes still pending are not indexed
+func (w *Watcher) Close() error {
+	w.unsubscribe()
+	close(w.closing)
+	err := w.backend.Close()
+	<-w.done
+	w.updateMutex.Lock()
+	defer w.updateMutex.Unlock()
+	w.disown()
+	return err
+}
+
+func (w *Watcher) run() {
+	defer close(w.done)
+	var timer *time.Timer
+	var timerC <-chan time.Time
+	var resyncC <-chan time.Time
+	if w.opts.Resync > 0 {
+		ticker := time.NewTicker(w.opts.Resync)
+		defer ticker.Stop()
+		resyncC = ticker.C
+	}
+	heartbeat := time.NewTicker(index_search.OWNER_HEARTBEAT)
+	defer heartbeat.Stop()
+	events := w.backend.Events()
+	for {
+		select {
+		case <-w.closing:
+			return
+		case batch, ok := <-events:
+			if !ok {
+				return
+			}
+			if !w.add(batch) {
+				continue
+			}
+			delay := w.opts.Debounce
+			w.mutex.Lock()
+			if wait := MAX_DELAY - time.Since(w.since); wait < delay {
+				delay = wait
+			}
+			w.mutex.Unlock()
+			if timer == nil {
+				timer = time.NewTimer(delay)
+				timerC = timer.C
+			} else {
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(delay)
+			}
+		case <-timerC:
+			w.flush()
+		case <-resyncC:
+			if err := w.Refresh(); err != nil {
+				w.opts.Logf("resync failed: %v", err)
+			}
+		case <-heartbeat.C:
+			w.updateMutex.Lock()
+			w.own()
+			w.updateMutex.Unlock()
+		}
+	}
+}
+
+// add records the changes of a batch of events, reporting whether any is to be indexed
+func (w *Watcher) add(batch []event) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	added := false
+	for _, e := range batch {
+		if e.overflow {
+			walk.Invalidate()
+			w.opts.Logf("events were lost, updating everything")
+			w.all = true
+			added = true
+			continue
+		}
+		walk.Invalidate(e.path)
+		name := filepath.Base(e.path)
+		if name == ".gitignore" || name == walk.IGNORE_FILE {
+			// ignore rules changed: directories may now be watched or
+			// not, and files indexed or not
+			w.filter = walk.NewFilter(w.root, walk.Options{SkipHidden: true})
+			if err := w.backend.Add(w.root); err != nil {
+				w.opts.Logf("failed to watch %s: %v", w.root, err)
+			}
+			w.all = true
+			added = true
+			continue
+		}
+		rel, ok := w.rel(e.path, e.isDir)
+		if !ok {
+			continue
+		}
+		w.pending[rel] = true
+		added = true
+	}
+	if added && w.since.IsZero() {
+		w.since = time.Now()
+	}
+	return added
+}
+
+// rel returns path relative to the root in slash form, ok is false if it is
+// outside the root, hidden or ignored. The caller holds the mutex.
+func (w *Watcher) rel(path string, isDir bool) (string, bool) {
+	rel, err := filepath.Rel(w.root, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	current := w.root
+	parts := strings.Split(rel, string(filepath.Separator))
+	for i, part := range parts {
+		current = filepath.Join(current, part)
+		if w.filter.Skip(current, isDir || i < len(parts)-1) {
+			return "", false
+		}
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// flush indexes the pending changes
+func (w *Watcher) flush() {
+	w.mutex.Lock()
+	all := w.all
+	paths := make([]string, 0, len(w.pending))
+	for path := range w.pending {
+		paths = append(paths, path)
+	}
+	w.pending = make(map[string]bool)
+	w.all = false
+	w.since = time.Time{}
+	w.mutex.Unlock()
+
+	if all {
+		paths = nil
+	} else if len(paths) == 0 {
+		return
+	}
+	sort.Strings(paths)
+	if err := w.update(paths); err != nil {
+		w.opts.Logf("update failed: %v", err)
+	}
+}
+
+// update runs every indexer on paths, nil meaning everything, returning the first error
+func (w *Watcher) update(paths []string) error {
+	w.updateMutex.Lock()
+	defer w.updateMutex.Unlock()
+	start := time.Now()
+	var firstErr error
+	for _, indexer := range w.opts.Indexers {
+		if err := indexer.Update(w.root, paths); err != nil {
+			err = fmt.Errorf("%s: %w", indexer.Name(), err)
+			w.opts.Logf("%v", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if firstErr != nil {
+		// the indexes may miss changes until the next successful Refresh
+		w.disown()
+	}
+	if paths == nil {
+		w.opts.Logf("updated all indexes in %v", time.Since(start).Round(time.Millisecond))
+	} else {
+		w.opts.Logf("indexed %d changed paths in %v: %s", len(paths), time.Since(start).Round(time.Millisecond), summarize(paths))
+	}
+	return firstErr
+}
+
+// summarize lists the first paths for logging
+func summarize(paths []string) string {
+	const max = 5
+	if len(paths) <= max {
+		return strings.Join(paths, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(paths[:max], ", "), len(paths)-max)
+}
+
+// own marks the indexes of the Owner indexers as kept up to date if the
+// watcher owns them. The caller holds updateMutex.
+func (w *Watcher) own() {
+	if !w.owned {
+		return
+	}
+	for _, indexer := range w.opts.Indexers {
+		if owner, ok := indexer.(Owner); ok {
+			if err := owner.Own(w.root); err != nil {
+				w.opts.Logf("%s: %v", indexer.Name(), err)
+			}
+		}
+	}
+}
+
+// disown gives up the indexes of the Owner indexers. The caller holds updateMutex.
+func (w *Watcher) disown() {
+	if !w.owned {
+		return
+	}
+	w.owned = false
+	for _, indexer := range w.opts.Indexers {
+		if owner, ok := indexer.(Owner); ok {
+			if err := owner.Disown(w.root); err != nil {
+				w.opts.Logf("%s: %v", indexer.Name(), err)
+			}
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xhd2015/llm-tools/tools/watch/notify"
+)
+
+// recorder is an Indexer that records the paths it is asked to update
+type recorder struct {
+	updates chan []string
+}
+
+func newRecorder() *recorder {
+	return &recorder{updates: make(chan []string, 100)}
+}
+
+func (r *recorder) Name() string {
+	return "recorder"
+}
+
+func (r *recorder) Update(root string, paths []string) error {
+	r.updates <- paths
+	return nil
+}
+
+// next returns the paths of the next update, failing after a timeout
+func (r *recorder) next(t *testing.T) []string {
+	t.Helper()
+	select {
+	case paths := <-r.updates:
+		return paths
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no update")
+		return nil
+	}
+}
+
+// waitFor collects updated paths until all of want were updated, and
+// returns them all
+func (r *recorder) waitFor(t *testing.T, want ...string) map[string]bool {
+	t.Helper()
+	seen := make(map[string]bool)
+	for {
+		missing := false
+		for _, path := range want {
+			if !seen[path] {
+				missing = true
+			}
+		}
+		if !missing {
+			return seen
+		}
+		for _, path := range r.next(t) {
+			seen[path] = true
+		}
+	}
+}
+
+func writeFile(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, ".gitignore"), "build/\n")
+	writeFile(t, filepath.Join(root, "a.txt"), "a\n")
+	writeFile(t, filepath.Join(root, "build", "out.txt"), "out\n")
+
+	rec := newRecorder()
+	w, err := New(root, Options{Indexers: []Indexer{rec}, Debounce: 50 * time.Millisecond, Resync: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if err := w.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if paths := rec.next(t); paths != nil {
+		t.Errorf("Refresh updated %v, want everything", paths)
+	}
+
+	// a burst of changes is indexed once, ignored and hidden files are not reported
+	writeFile(t, filepath.Join(root, "a.txt"), "a changed\n")
+	writeFile(t, filepath.Join(root, "b.txt"), "b\n")
+	writeFile(t, filepath.Join(root, "build", "out.txt"), "out changed\n")
+	writeFile(t, filepath.Join(root, ".cache"), "hidden\n")
+	if err := os.MkdirAll(filepath.Join(root, "sub", "deep"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(root, "sub", "deep", "c.txt"), "c\n")
+	// sub is reported as a whole, files may be created in it before it is watched
+	seen := rec.waitFor(t, "a.txt", "b.txt", "sub")
+	if seen["build/out.txt"] || seen[".cache"] {
+		t.Errorf("updated ignored files: %v", seen)
+	}
+
+	// the new directory is watched
+	writeFile(t, filepath.Join(root, "sub", "deep", "c.txt"), "c changed\n")
+	rec.waitFor(t, "sub/deep/c.txt")
+
+	// let events of the writes above settle before checking notified writes
+	time.Sleep(200 * time.Millisecond)
+	for len(rec.updates) > 0 {
+		<-rec.updates
+	}
+
+	// tools index their writes before returning
+	notify.Changed(filepath.Join(root, "a.txt"), filepath.Join(root, "build", "x.txt"), filepath.Join(os.TempDir(), "outside.txt"))
+	if paths := rec.next(t); !reflect.DeepEqual(paths, []string{"a.txt"}) {
+		t.Errorf("notified update %v, want a.txt", paths)
+	}
+}
+
+func TestPoller(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "a.txt"), "a\n")
+	writeFile(t, filepath.Join(root, "b.txt"), "b\n")
+	p, err := newPoller(root, 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	writeFile(t, filepath.Join(root, "a.txt"), "a changed\n")
+	if err := os.Remove(filepath.Join(root, "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(root, "c.txt"), "c\n")
+
+	seen := make(map[string]bool)
+	timeout := time.After(5 * time.Second)
+	for len(seen) < 3 {
+		select {
+		case events := <-p.Events():
+			for _, e := range events {
+				rel, _ := filepath.Rel(root, e.path)
+				seen[rel] = true
+			}
+		case <-timeout:
+			t.Fatalf("polled changes %v, want a.txt, b.txt and c.txt", seen)
+		}
+	}
+}
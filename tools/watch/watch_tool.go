@@ -0,0 +1,97 @@
+package watch
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/xhd2015/less-gen/flags"
+)
+
+const help = `
+llm-tools watch keeps the indexes of a workspace up to date while files change
+
+Usage: llm-tools watch [OPTIONS]
+
+Options:
+  --dir <dir>                  directory to watch (default: current directory)
+  --debounce <duration>        quiet time before changes are indexed (default 200ms)
+  --resync <duration>          interval of full incremental updates (default 15m, 0 = never)
+
+Indexes:
+  trigram                      the grep_search index in <dir>/.llm-tools/index, built if missing,
+                               which grep_search trusts without checking the files while watched
+  embeddings                   codebase_search embeddings, when an embedder is configured
+                               with LLM_TOOLS_EMBEDDING_URL or LLM_TOOLS_EMBEDDER
+
+Changes are watched with inotify on Linux and by polling every 2s elsewhere.
+Hidden and gitignored files are not watched. llm-tools-mcp --watch <dir> does
+the same inside the MCP server, and also indexes the files its tools write
+before they return.
+
+Examples:
+  llm-tools watch
+  llm-tools watch --dir ~/monorepo --debounce 1s
+`
+
+func HandleCli(args []string) error {
+	var dir string
+	var debounce string
+	var resync string
+
+	args, err := flags.String("--dir", &dir).
+		String("--debounce", &debounce).
+		String("--resync", &resync).
+		Help("-h,--help", help).
+		Parse(args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return fmt.Errorf("unrecognized extra arguments: %v", strings.Join(args, ","))
+	}
+
+	if dir == "" {
+		dir, err = os.Getwd()
+		if err != nil {
+			return err
+		}
+	}
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	opts := Options{Logf: logger.Printf}
+	if debounce != "" {
+		opts.Debounce, err = time.ParseDuration(debounce)
+		if err != nil {
+			return fmt.Errorf("invalid --debounce: %w", err)
+		}
+	}
+	if resync != "" {
+		opts.Resync, err = time.ParseDuration(resync)
+		if err != nil {
+			return fmt.Errorf("invalid --resync: %w", err)
+		}
+		if opts.Resync == 0 {
+			opts.Resync = -1
+		}
+	}
+
+	w, err := New(dir, opts)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	if err := w.Refresh(); err != nil {
+		return err
+	}
+	logger.Printf("watching %s for changes", w.Root())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+	return nil
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/xhd2015/llm-tools/jsonschema"
 	"github.com/xhd2015/llm-tools/tools/defs"
 	"github.com/xhd2015/llm-tools/tools/dirs"
+	"github.com/xhd2015/llm-tools/tools/watch/notify"
 )
 
 // WriteFileRequest represents the input parameters for the write_file tool
@@ -82,6 +83,7 @@ func WriteFile(req WriteFileRequest) (*WriteFileResponse, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to write file: %w", err)
 	}
+	notify.Changed(filePath)
 
 	return &WriteFileResponse{
 		Success:      true,